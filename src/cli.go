@@ -0,0 +1,144 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/RK-goldengate-co/docker-compose-manager/src/pkg/manager"
+)
+
+// dryRunCommands are the commands --dry-run previews. Other commands only
+// read state and run as usual.
+var dryRunCommands = map[string]bool{
+	"start": true, "stop": true, "restart": true, "remove": true, "kill": true,
+	"reload": true, "scale": true, "down": true, "purge": true, "build": true,
+	"pull": true, "update": true, "exec": true, "shell": true, "run": true, "compose": true,
+	"watch": true, "snapshot": true, "config": true, "guard": true,
+}
+
+// configCommands inspect or fix the configuration, so they run even when
+// the configured services do not match the compose files
+var configCommands = map[string]bool{
+	"__complete": true,
+	"bootstrap":  true,
+	"config":     true,
+	"context":    true,
+	"doctor":     true,
+	"features":   true,
+	"groups":     true,
+	"lint":       true,
+	"projects":   true,
+	"validate":   true,
+	"who":        true,
+}
+
+// printUsage prints the help of dcm: its commands and global flags
+func printUsage(w io.Writer, global *flag.FlagSet) {
+	fmt.Fprintln(w, "Usage: dcm [global flags] <command> [flags] [service...]")
+	fmt.Fprintln(w, "\nWithout a command, dcm runs interactively.")
+	fmt.Fprintln(w, "\nCommands:")
+	for _, c := range manager.Commands {
+		fmt.Fprintf(w, "  %-14s %s\n", c.Name, c.Summary)
+	}
+	fmt.Fprintln(w, "\nGlobal flags:")
+	global.SetOutput(w)
+	global.PrintDefaults()
+	fmt.Fprintln(w, "\nRun 'dcm help <command>' for the flags of a command.")
+}
+
+// printCommandHelp prints the usage line of a command, reporting whether it
+// has flags to show after it
+func printCommandHelp(w io.Writer, name string) (bool, error) {
+	c, ok := manager.FindCommand(name)
+	if !ok {
+		return false, manager.NewError(manager.ErrUsage, "unknown command %q%s", name, manager.DidYouMean(name, manager.CommandNames()))
+	}
+	fmt.Fprintf(w, "Usage: %s\n\n%s\n", strings.TrimSpace("dcm "+c.Name+" "+c.Args), strings.ToUpper(c.Summary[:1])+c.Summary[1:])
+	if c.Flags {
+		fmt.Fprintln(w, "\nFlags:")
+	}
+	return c.Flags, nil
+}
+
+// valueFlags returns the global flags taking a value, which completion
+// must skip over together with their value to find the command
+func valueFlags(global *flag.FlagSet) []string {
+	var names []string
+	global.VisitAll(func(f *flag.Flag) {
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+			return
+		}
+		prefix := "--"
+		if len(f.Name) == 1 {
+			prefix = "-"
+		}
+		names = append(names, prefix+f.Name)
+	})
+	return names
+}
+
+// completionScripts are the shell completion scripts, completing commands
+// and then service and group names, both listed by `dcm __complete`. %s is
+// the global flags taking a value.
+var completionScripts = map[string]string{
+	"bash": `# bash completion for dcm, load with: source <(dcm completion bash)
+_dcm() {
+    local cur=${COMP_WORDS[COMP_CWORD]} cmd="" i
+    for ((i = 1; i < COMP_CWORD; i++)); do
+        case ${COMP_WORDS[i]} in
+            %s) ((i++)) ;;
+            -*) ;;
+            *) cmd=${COMP_WORDS[i]}; break ;;
+        esac
+    done
+    [[ $cur == -* ]] && return
+    if [[ -z $cmd ]]; then
+        COMPREPLY=($(compgen -W "$(dcm __complete commands 2>/dev/null)" -- "$cur"))
+    else
+        # The global flags select the config the services come from
+        COMPREPLY=($(compgen -W "$(dcm "${COMP_WORDS[@]:1:i-1}" __complete services 2>/dev/null)" -- "$cur"))
+    fi
+}
+complete -F _dcm dcm
+`,
+	"zsh": `#compdef dcm
+# zsh completion for dcm, load with: source <(dcm completion zsh)
+_dcm() {
+    local cmd i
+    for ((i = 2; i < CURRENT; i++)); do
+        case ${words[i]} in
+            %s) ((i++)) ;;
+            -*) ;;
+            *) cmd=${words[i]}; break ;;
+        esac
+    done
+    if [[ -z $cmd ]]; then
+        compadd -- ${(f)"$(dcm __complete commands 2>/dev/null)"}
+    else
+        # The global flags select the config the services come from
+        compadd -- ${(f)"$(dcm ${words[2,i-1]} __complete services 2>/dev/null)"}
+    fi
+}
+compdef _dcm dcm
+`,
+	"fish": `# fish completion for dcm, load with: dcm completion fish | source
+complete -c dcm -f
+complete -c dcm -n '__fish_use_subcommand' -a '(dcm __complete commands 2>/dev/null)'
+complete -c dcm -n 'not __fish_use_subcommand' -a '(dcm __complete services 2>/dev/null)'
+`,
+}
+
+// printCompletion prints the completion script of a shell
+func printCompletion(w io.Writer, shell string, global *flag.FlagSet) error {
+	script, ok := completionScripts[shell]
+	if !ok {
+		return manager.NewError(manager.ErrUsage, "usage: completion bash|zsh|fish")
+	}
+	if shell != "fish" {
+		script = fmt.Sprintf(script, strings.Join(valueFlags(global), "|"))
+	}
+	_, err := io.WriteString(w, script)
+	return err
+}
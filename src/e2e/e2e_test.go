@@ -0,0 +1,74 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStartWaitAndStatus(t *testing.T) {
+	p := newProject(t)
+	p.dcm("start", "--wait", "--wait-timeout", "3m")
+
+	web := p.container("web")
+	if web.State != "running" || web.Health != "healthy" {
+		t.Errorf("web is %s/%s after start --wait, want running/healthy", web.State, web.Health)
+	}
+	job := p.container("job")
+	if job.State != "exited" || job.ExitCode != 0 {
+		t.Errorf("job is %s with code %d after start --wait, want exited with code 0", job.State, job.ExitCode)
+	}
+}
+
+func TestLogs(t *testing.T) {
+	p := newProject(t)
+	p.dcm("start", "--wait", "--wait-timeout", "3m")
+
+	if output := p.dcm("logs", "job"); !strings.Contains(output, "job done") {
+		t.Errorf("logs job does not show the output of the job:\n%s", output)
+	}
+	p.eventually(10*time.Second, func() error {
+		output := p.dcm("logs", "--tail", "1", "web")
+		if lines := strings.Split(strings.TrimSpace(output), "\n"); len(lines) != 1 || !strings.Contains(lines[0], "tick v1") {
+			return fmt.Errorf("logs --tail 1 web printed %q, want a single tick line", output)
+		}
+		return nil
+	})
+
+	lines := p.follow("logs", "-f", "--tail", "0", "web")
+	p.waitLine(lines, "tick v1", 15*time.Second)
+}
+
+func TestUpdateRecreatesTheServiceOnTheNewImage(t *testing.T) {
+	p := newProject(t)
+	p.dcm("start", "--wait", "--wait-timeout", "3m")
+	before := p.docker("inspect", "--format", "{{.Image}}", p.container("web").Name)
+
+	// Rebuilding moves the image tag of web to a new image
+	p.WriteFile("web/version", "v2\n")
+	p.dcm("--yes", "update", "--health-timeout", "2m", "web")
+
+	web := p.container("web")
+	if after := p.docker("inspect", "--format", "{{.Image}}", web.Name); after == before {
+		t.Fatalf("web still runs image %s after the update", before)
+	}
+	if web.State != "running" || web.Health != "healthy" {
+		t.Errorf("web is %s/%s after the update, want running/healthy", web.State, web.Health)
+	}
+	lines := p.follow("logs", "-f", "--tail", "1", "web")
+	p.waitLine(lines, "tick v2", 15*time.Second)
+}
+
+func TestDownVolumesRemovesEverything(t *testing.T) {
+	p := newProject(t)
+	p.dcm("start", "--wait", "--wait-timeout", "3m")
+	if volumes := p.docker("volume", "ls", "-q", "--filter", "label=com.docker.compose.project="+p.Name); volumes == "" {
+		t.Fatal("start created no volume")
+	}
+
+	p.dcm("--yes", "down", "--volumes")
+	p.checkRemoved()
+}
@@ -0,0 +1,355 @@
+//go:build e2e
+
+// Package e2e drives the dcm binary against a real Docker daemon, the one
+// of the environment or, with DCM_E2E_DIND=1, a disposable Docker-in-Docker
+// container. The suite is opt-in:
+//
+//	cd src && go test -tags e2e ./e2e/...
+//
+// Each test gets a copy of testdata/project under a project name of its
+// own, so tests never see each other's containers, and the project is torn
+// down and checked for leftovers when the test ends, failed or not. A new
+// case takes a few lines:
+//
+//	p := newProject(t)
+//	p.dcm("start", "--wait")
+//	if web := p.container("web"); web.Health != "healthy" { ... }
+package e2e
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	// dcmBin is the dcm binary built for the run
+	dcmBin string
+	// dockerHost is the daemon of the Docker-in-Docker container, empty to
+	// use the daemon of the environment
+	dockerHost string
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(runMain(m))
+}
+
+func runMain(m *testing.M) int {
+	dir, err := ioutil.TempDir("", "dcm-e2e-")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "e2e: %v\n", err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+
+	dcmBin = filepath.Join(dir, "dcm")
+	if output, err := exec.Command("go", "build", "-o", dcmBin, "..").CombinedOutput(); err != nil {
+		fmt.Fprintf(os.Stderr, "e2e: building dcm: %v\n%s", err, output)
+		return 1
+	}
+	if os.Getenv("DCM_E2E_DIND") == "1" {
+		stop, err := startDind()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "e2e: %v\n", err)
+			return 1
+		}
+		defer stop()
+	}
+	if output, err := dockerCommand("info").CombinedOutput(); err != nil {
+		fmt.Fprintf(os.Stderr, "e2e: no Docker daemon to test against: %v\n%s", err, output)
+		return 1
+	}
+	return m.Run()
+}
+
+// startDind runs a Docker-in-Docker daemon for the tests to use and returns
+// the function removing it
+func startDind() (func(), error) {
+	output, err := exec.Command("docker", "run", "-d", "--privileged", "-e", "DOCKER_TLS_CERTDIR=",
+		"-p", "127.0.0.1::2375", "docker:dind").Output()
+	if err != nil {
+		return nil, fmt.Errorf("starting docker:dind: %v", err)
+	}
+	id := strings.TrimSpace(string(output))
+	stop := func() { exec.Command("docker", "rm", "-f", "-v", id).Run() }
+
+	output, err = exec.Command("docker", "port", id, "2375/tcp").Output()
+	if err != nil {
+		stop()
+		return nil, fmt.Errorf("finding the port of docker:dind: %v", err)
+	}
+	dockerHost = "tcp://" + strings.TrimSpace(strings.Split(string(output), "\n")[0])
+	for deadline := time.Now().Add(time.Minute); ; time.Sleep(time.Second) {
+		if dockerCommand("info").Run() == nil {
+			return stop, nil
+		}
+		if time.Now().After(deadline) {
+			stop()
+			return nil, fmt.Errorf("docker:dind at %s did not come up", dockerHost)
+		}
+	}
+}
+
+// environ returns the environment of the commands run by the tests
+func environ(extra ...string) []string {
+	env := os.Environ()
+	if dockerHost != "" {
+		env = append(env, "DOCKER_HOST="+dockerHost)
+	}
+	return append(env, extra...)
+}
+
+func dockerCommand(args ...string) *exec.Cmd {
+	cmd := exec.Command("docker", args...)
+	cmd.Env = environ()
+	return cmd
+}
+
+// project is a copy of the fixture project under a name of its own
+type project struct {
+	t    *testing.T
+	Dir  string
+	Name string
+}
+
+// newProject copies testdata/project to a temporary directory with a config
+// naming it uniquely, and removes everything it created at the end of the
+// test
+func newProject(t *testing.T) *project {
+	t.Helper()
+	p := &project{
+		t:    t,
+		Dir:  t.TempDir(),
+		Name: fmt.Sprintf("dcm-e2e-%d-%04x", os.Getpid(), rand.Intn(0x10000)),
+	}
+	if err := copyDir(filepath.Join("testdata", "project"), p.Dir); err != nil {
+		t.Fatal(err)
+	}
+	config := fmt.Sprintf("version: 2\nproject_name: %s\ncompose_file: compose.yaml\none_shot: [job]\n", p.Name)
+	p.WriteFile("dcm.config.yml", config)
+
+	t.Cleanup(func() {
+		if t.Failed() {
+			output, _ := p.compose("logs", "--no-color").CombinedOutput()
+			t.Logf("logs of %s:\n%s", p.Name, output)
+		}
+		if output, err := p.compose("down", "--volumes", "--remove-orphans", "--rmi", "local", "--timeout", "1").CombinedOutput(); err != nil {
+			t.Errorf("tearing down %s: %v\n%s", p.Name, err, output)
+		}
+		p.checkRemoved()
+	})
+	return p
+}
+
+func copyDir(src, dest string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(target, data, info.Mode())
+	})
+}
+
+// env is the environment of the commands acting on the project. The web
+// image is named after the project so updates do not touch other tests.
+func (p *project) env() []string {
+	return environ("DCM_E2E_IMAGE=" + p.Name + "-web")
+}
+
+// WriteFile writes a file of the project
+func (p *project) WriteFile(name, content string) {
+	p.t.Helper()
+	if err := ioutil.WriteFile(filepath.Join(p.Dir, name), []byte(content), 0644); err != nil {
+		p.t.Fatal(err)
+	}
+}
+
+// compose prepares a compose command on the project that does not go
+// through dcm, for setup and teardown
+func (p *project) compose(args ...string) *exec.Cmd {
+	cmd := exec.Command("docker", append([]string{"compose", "-p", p.Name, "-f", "compose.yaml"}, args...)...)
+	cmd.Dir = p.Dir
+	cmd.Env = p.env()
+	return cmd
+}
+
+// run runs dcm in the project directory and returns its standard output
+// and the error it exited with; its standard error is logged
+func (p *project) run(args ...string) (string, error) {
+	p.t.Helper()
+	cmd := exec.Command(dcmBin, args...)
+	cmd.Dir = p.Dir
+	cmd.Env = p.env()
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	p.t.Logf("dcm %s\n%s%s", strings.Join(args, " "), stdout.String(), stderr.String())
+	return stdout.String(), err
+}
+
+// dcm runs dcm and fails the test when it fails
+func (p *project) dcm(args ...string) string {
+	p.t.Helper()
+	output, err := p.run(args...)
+	if err != nil {
+		p.t.Fatalf("dcm %s: %v", strings.Join(args, " "), err)
+	}
+	return output
+}
+
+// follow runs a dcm command that does not exit by itself, such as logs -f,
+// and returns its output lines as they come. The command is interrupted at
+// the end of the test.
+func (p *project) follow(args ...string) <-chan string {
+	p.t.Helper()
+	cmd := exec.Command(dcmBin, args...)
+	cmd.Dir = p.Dir
+	cmd.Env = p.env()
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		p.t.Fatal(err)
+	}
+	lines := make(chan string, 100)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	p.t.Cleanup(func() {
+		cmd.Process.Signal(os.Interrupt)
+		cmd.Wait()
+	})
+	return lines
+}
+
+// docker runs a docker command against the daemon of the tests and returns
+// its trimmed output
+func (p *project) docker(args ...string) string {
+	p.t.Helper()
+	output, err := dockerCommand(args...).Output()
+	if err != nil {
+		p.t.Fatalf("docker %s: %v", strings.Join(args, " "), err)
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// containerStatus is a container as dcm status --output json lists it
+type containerStatus struct {
+	Name     string `json:"name"`
+	Service  string `json:"service"`
+	ID       string `json:"id"`
+	Image    string `json:"image"`
+	State    string `json:"state"`
+	Health   string `json:"health"`
+	ExitCode int    `json:"exit_code"`
+}
+
+// status returns the containers of the project as dcm reports them
+func (p *project) status() []containerStatus {
+	p.t.Helper()
+	var statuses []containerStatus
+	if err := json.Unmarshal([]byte(p.dcm("--output", "json", "status")), &statuses); err != nil {
+		p.t.Fatalf("parsing dcm status: %v", err)
+	}
+	return statuses
+}
+
+// container returns the single container of a service, failing the test
+// when the service has none or several
+func (p *project) container(service string) containerStatus {
+	p.t.Helper()
+	var found []containerStatus
+	for _, s := range p.status() {
+		if s.Service == service {
+			found = append(found, s)
+		}
+	}
+	if len(found) != 1 {
+		p.t.Fatalf("%s has %d containers, want 1: %+v", service, len(found), found)
+	}
+	return found[0]
+}
+
+// eventually retries check until it succeeds or timeout passes, failing
+// the test with its last error
+func (p *project) eventually(timeout time.Duration, check func() error) {
+	p.t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		err := check()
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			p.t.Fatalf("after %s: %v", timeout, err)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// waitLine returns the first line of lines containing text, failing the
+// test when none arrives in time
+func (p *project) waitLine(lines <-chan string, text string, timeout time.Duration) string {
+	p.t.Helper()
+	deadline := time.After(timeout)
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				p.t.Fatalf("output ended before a line with %q", text)
+			}
+			if strings.Contains(line, text) {
+				return line
+			}
+		case <-deadline:
+			p.t.Fatalf("no line with %q after %s", text, timeout)
+		}
+	}
+}
+
+// checkRemoved fails the test when containers, volumes or networks of the
+// project are left
+func (p *project) checkRemoved() {
+	p.t.Helper()
+	label := "label=com.docker.compose.project=" + p.Name
+	for _, kind := range []string{"container", "volume", "network"} {
+		args := []string{kind, "ls", "-q", "--filter", label}
+		if kind == "container" {
+			args = append(args, "--all")
+		}
+		output, err := dockerCommand(args...).Output()
+		if err != nil {
+			p.t.Errorf("docker %s: %v", strings.Join(args, " "), err)
+			continue
+		}
+		if left := strings.Fields(string(output)); len(left) > 0 {
+			p.t.Errorf("%d %ss of %s left: %s", len(left), kind, p.Name, strings.Join(left, " "))
+		}
+	}
+}
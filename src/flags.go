@@ -0,0 +1,158 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/RK-goldengate-co/docker-compose-manager/src/pkg/manager"
+)
+
+// parseArgs parses flags that may appear before, between or after positional
+// arguments and returns the positional arguments in order.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// parseServiceArgs is parseArgs for commands whose positional arguments are
+// service names or groups, which it resolves with ResolveServices
+func parseServiceArgs(dcm *manager.Manager, fs *flag.FlagSet, args []string) ([]string, error) {
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return nil, err
+	}
+	return dcm.ResolveServices(positional)
+}
+
+// scopeFlags registers the flags selecting which services an operation may
+// act on
+func scopeFlags(fs *flag.FlagSet, dcm *manager.Manager) {
+	fs.Var(boolFunc(dcm.SetAllServices), "all", "act on every service of the project, not only the configured ones")
+	fs.Var(boolFunc(dcm.SetForce), "force", "allow services that are not in the configured list")
+}
+
+// boolFunc is a boolean flag passing its value to a setter
+type boolFunc func(bool)
+
+func (f boolFunc) String() string   { return "false" }
+func (f boolFunc) IsBoolFlag() bool { return true }
+
+func (f boolFunc) Set(s string) error {
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	f(v)
+	return nil
+}
+
+// passthroughCommands hand the arguments after the command to another
+// program, whose own -p must not be taken for the project flag
+var passthroughCommands = map[string]bool{"exec": true, "run": true, "compose": true}
+
+// extractProjectFlag removes -p/--project given after the command from args,
+// so `dcm start web -p staging` works like `dcm -p staging start web`, and
+// returns the project it named, if any
+func extractProjectFlag(args []string) (string, []string, error) {
+	if len(args) == 0 || passthroughCommands[strings.ToLower(args[0])] {
+		return "", args, nil
+	}
+	var project string
+	rest := []string{args[0]}
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			return project, append(rest, args[i:]...), nil
+		case arg == "-p" || arg == "--project" || arg == "-project":
+			if i+1 == len(args) {
+				return "", nil, manager.NewError(manager.ErrUsage, "%s needs a project name", arg)
+			}
+			project = args[i+1]
+			i++
+		case strings.HasPrefix(arg, "-p=") || strings.HasPrefix(arg, "--project=") || strings.HasPrefix(arg, "-project="):
+			project = arg[strings.Index(arg, "=")+1:]
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	return project, rest, nil
+}
+
+// containerOrService rejects a command given both --container and a service
+func containerOrService(container string, services []string) error {
+	if container != "" && len(services) > 0 {
+		return manager.NewError(manager.ErrUsage, "--container and a service name are mutually exclusive")
+	}
+	return nil
+}
+
+// parseScaleArgs reads either "<service> <count>" or any number of
+// "<service>=<count>" pairs
+func parseScaleArgs(args []string) ([]manager.ScaleTarget, error) {
+	usage := manager.NewError(manager.ErrUsage, "usage: scale <service> <count> | scale <service>=<count>...")
+	if len(args) == 2 && !strings.Contains(args[0], "=") && !strings.Contains(args[1], "=") {
+		args = []string{args[0] + "=" + args[1]}
+	}
+	if len(args) == 0 {
+		return nil, usage
+	}
+
+	var targets []manager.ScaleTarget
+	for _, arg := range args {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, usage
+		}
+		replicas, err := strconv.Atoi(parts[1])
+		if err != nil || replicas < 0 {
+			return nil, manager.NewError(manager.ErrUsage, "invalid replica count %q for %s, expected a non-negative integer", parts[1], parts[0])
+		}
+		targets = append(targets, manager.ScaleTarget{Service: parts[0], Replicas: replicas})
+	}
+	return targets, nil
+}
+
+// listFlag collects the values of a repeated flag in order
+type listFlag []string
+
+func (l *listFlag) String() string { return strings.Join(*l, ",") }
+
+func (l *listFlag) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// varFlag collects repeated --var key=value flags
+type varFlag map[string]string
+
+func (v varFlag) String() string {
+	pairs := make([]string, 0, len(v))
+	for k, val := range v {
+		pairs = append(pairs, k+"="+val)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (v varFlag) Set(s string) error {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("invalid --var %q, expected key=value", s)
+	}
+	v[parts[0]] = parts[1]
+	return nil
+}
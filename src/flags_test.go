@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/RK-goldengate-co/docker-compose-manager/src/pkg/manager"
+)
+
+func TestContainerOrService(t *testing.T) {
+	if err := containerOrService("3f2a9c", nil); err != nil {
+		t.Errorf("--container alone: %v", err)
+	}
+	if err := containerOrService("", []string{"web"}); err != nil {
+		t.Errorf("a service alone: %v", err)
+	}
+	if err := containerOrService("3f2a9c", []string{"web"}); manager.TypeOf(err) != manager.ErrUsage {
+		t.Errorf("--container with a service = %v, want a %s error", err, manager.ErrUsage)
+	}
+}
+
+func TestJSONOutputReportsFlagErrors(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := os.Stderr
+	os.Stderr = w
+	err = run([]string{"--output", "json", "start", "--project"})
+	os.Stderr = saved
+	w.Close()
+	stderr, _ := io.ReadAll(r)
+
+	if manager.TypeOf(err) != manager.ErrUsage {
+		t.Errorf("run = %v, want a %s error", err, manager.ErrUsage)
+	}
+	var envelope struct {
+		Error struct {
+			Type string `json:"type"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(stderr, &envelope); err != nil || envelope.Error.Type != string(manager.ErrUsage) {
+		t.Errorf("stderr = %q, want only the JSON envelope of the error", stderr)
+	}
+}
@@ -1,229 +1,1075 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"flag"
 	"fmt"
-	"io/ioutil"
 	"os"
-	"os/exec"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
-	"gopkg.in/yaml.v2"
+	"github.com/RK-goldengate-co/docker-compose-manager/src/pkg/manager"
 )
 
-// Config represents the Docker Compose Manager configuration
-type Config struct {
-	Services     []string `yaml:"services"`
-	ComposeFile  string   `yaml:"compose_file"`
-}
-
-// DockerComposeManager manages Docker Compose services
-type DockerComposeManager struct {
-	configPath string
-	config     Config
-}
-
-// NewDockerComposeManager creates a new instance of DockerComposeManager
-func NewDockerComposeManager(configPath string) *DockerComposeManager {
-	if configPath == "" {
-		configPath = "dcm.config.yml"
+// runInit is the init command. It runs before a config is loaded, so it
+// works in a directory without one or with a broken one.
+func runInit(args []string, yes bool) error {
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	force := fs.Bool("force", false, "overwrite an existing dcm.config.yml")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
 	}
-
-	dcm := &DockerComposeManager{
-		configPath: configPath,
+	if len(positional) > 1 {
+		return manager.NewError(manager.ErrUsage, "usage: init [directory] [--force]")
+	}
+	dir := "."
+	if len(positional) == 1 {
+		dir = positional[0]
 	}
-	dcm.loadConfig()
-	return dcm
+	return manager.InitConfig(dir, *force, !yes && manager.IsTerminal(os.Stdin), os.Stdin)
 }
 
-// loadConfig loads the configuration from the YAML file
-func (dcm *DockerComposeManager) loadConfig() {
-	if _, err := os.Stat(dcm.configPath); os.IsNotExist(err) {
-		fmt.Println("Config file not found, using defaults")
-		dcm.config = Config{
-			Services:    []string{},
-			ComposeFile: "docker-compose.yml",
-		}
-		return
-	}
+// runBootstrap handles `bootstrap <repo> [--template name] [--var k=v]... [--force]`
+func runBootstrap(args []string, opts ...manager.Option) error {
+	vars := varFlag{}
+	fs := flag.NewFlagSet("bootstrap", flag.ExitOnError)
+	template := fs.String("template", "", "template directory inside the repository")
+	force := fs.Bool("force", false, "overwrite existing files")
+	fs.Var(vars, "var", "template variable as key=value (repeatable)")
 
-	data, err := ioutil.ReadFile(dcm.configPath)
+	positional, err := parseArgs(fs, args)
 	if err != nil {
-		fmt.Printf("Error reading config file: %v\n", err)
-		return
+		return err
+	}
+	if len(positional) != 1 {
+		return manager.NewError(manager.ErrUsage, "usage: bootstrap <repo> [--template name] [--var key=value]... [--force]")
 	}
 
-	err = yaml.Unmarshal(data, &dcm.config)
+	_, err = manager.Bootstrap(manager.BootstrapOptions{
+		Repo:     positional[0],
+		Template: *template,
+		Vars:     vars,
+		Force:    *force,
+	})
 	if err != nil {
-		fmt.Printf("Error parsing config file: %v\n", err)
+		return err
 	}
-}
-
-// executeCommand executes a shell command and returns the output
-func (dcm *DockerComposeManager) executeCommand(command string) (string, error) {
-	fmt.Printf("Executing: %s\n", command)
-
-	cmd := exec.Command("sh", "-c", command)
-	output, err := cmd.CombinedOutput()
 
+	// Reload so doctor checks the freshly written config
+	dcm, err := manager.New("dcm.config.yml", opts...)
 	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		return "", err
+		return err
 	}
-
-	result := string(output)
-	fmt.Print(result)
-	return result, nil
+	defer dcm.Close()
+	return dcm.Doctor()
 }
 
-// Start starts Docker Compose services
-func (dcm *DockerComposeManager) Start(serviceName string) (string, error) {
-	var cmd string
-	if serviceName != "" {
-		cmd = fmt.Sprintf("docker-compose up -d %s", serviceName)
-	} else {
-		cmd = "docker-compose up -d"
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		os.Exit(manager.ExitCode(err))
 	}
-	fmt.Println("Starting services...")
-	return dcm.executeCommand(cmd)
 }
 
-// Stop stops Docker Compose services
-func (dcm *DockerComposeManager) Stop(serviceName string) (string, error) {
-	var cmd string
-	if serviceName != "" {
-		cmd = fmt.Sprintf("docker-compose stop %s", serviceName)
-	} else {
-		cmd = "docker-compose stop"
+// run parses the command line and dispatches the command. Errors have been
+// reported to the user by the time run returns them.
+func run(argv []string) (err error) {
+	global := flag.NewFlagSet("dcm", flag.ExitOnError)
+	composeCommand := global.String("compose-command", "", `compose invocation to use, e.g. "docker compose"`)
+	output := global.String("output", "text", "output format, text or json; json also reports errors as JSON on stderr")
+	project := global.String("project", "", "operate on this project from the projects of the config")
+	global.StringVar(project, "p", "", "shorthand for --project")
+	configPath := global.String("config", "", "config file to use instead of searching the current directory and its parents")
+	quiet := global.Bool("quiet", false, "leave out banners and progress messages")
+	verbose := global.Bool("verbose", false, "also print debug messages")
+	logFormat := global.String("log-format", manager.LogFormatText, "format of the messages on stderr, text or json (one object per line)")
+	strictServices := global.Bool("strict-services", false, "fail on any service name that is not a service of the project")
+	commandLog := global.String("command-log", "", "append every command run to this JSONL `file`, like command_log in the config")
+	backendName := global.String("backend", "", "carry out operations with `backend` shell, running compose, or api, talking to the Docker Engine API")
+	v1Compat := global.Bool("compose-v1-compat", false, "translate Compose V1 commands removed in Compose V2, with a warning")
+	var composeFiles []string
+	global.Var((*listFlag)(&composeFiles), "file", "compose `file` to use instead of those of the config (repeatable, layered in order)")
+	global.Var((*listFlag)(&composeFiles), "f", "shorthand for --file")
+	envFile := global.String("env-file", "", "env `file` to pass to compose instead of env_file from the config")
+	dryRun := global.Bool("dry-run", false, "print the commands that would change state instead of running them")
+	yes := global.Bool("yes", false, "do not ask for confirmation of destructive operations")
+	skipHooks := global.Bool("skip-hooks", false, "do not run the hooks of the config")
+	global.Usage = func() { printUsage(os.Stderr, global) }
+	global.Parse(argv)
+	if *output != "text" && *output != "json" {
+		err := manager.NewError(manager.ErrUsage, "unknown output format %q, expected text or json", *output)
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return err
+	}
+	if *output == "json" {
+		defer func() {
+			if err != nil {
+				manager.WriteErrorJSON(os.Stderr, err)
+			}
+		}()
+	}
+	// printError reports the errors found before there is a manager to
+	// report them; with --output json the envelope above does
+	printError := func(err error) {
+		if *output != "json" {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
 	}
-	fmt.Println("Stopping services...")
-	return dcm.executeCommand(cmd)
-}
 
-// Restart restarts Docker Compose services
-func (dcm *DockerComposeManager) Restart(serviceName string) (string, error) {
-	var cmd string
-	if serviceName != "" {
-		cmd = fmt.Sprintf("docker-compose restart %s", serviceName)
-	} else {
-		cmd = "docker-compose restart"
+	trailingProject, args, err := extractProjectFlag(global.Args())
+	if err != nil {
+		printError(err)
+		return err
+	}
+	if trailingProject != "" {
+		*project = trailingProject
+	}
+	if err := manager.CheckLogFormat(*logFormat); err != nil {
+		printError(err)
+		return err
 	}
-	fmt.Println("Restarting services...")
-	return dcm.executeCommand(cmd)
-}
 
-// Status checks the status of Docker Compose services
-func (dcm *DockerComposeManager) Status() (string, error) {
-	fmt.Println("Checking service status...")
-	return dcm.executeCommand("docker-compose ps")
-}
+	// Help, completion and init work without a config
+	if len(args) > 0 {
+		switch strings.ToLower(args[0]) {
+		case "help":
+			if len(args) == 1 {
+				printUsage(os.Stdout, global)
+				return nil
+			}
+			hasFlags, err := printCommandHelp(os.Stdout, strings.ToLower(args[1]))
+			if err != nil || !hasFlags {
+				if err != nil {
+					printError(err)
+				}
+				return err
+			}
+			// The flags are printed by the flag set of the command
+			args = []string{args[1], "--help"}
+			*quiet = true
+		case "completion":
+			shell := ""
+			if len(args) == 2 {
+				shell = args[1]
+			}
+			if err := printCompletion(os.Stdout, shell, global); err != nil {
+				printError(err)
+				return err
+			}
+			return nil
+		case "init":
+			if err := runInit(args[1:], *yes); err != nil {
+				printError(err)
+				return err
+			}
+			return nil
+		case "__complete":
+			if len(args) == 2 && args[1] == "commands" {
+				fmt.Println(strings.Join(manager.CommandNames(), "\n"))
+				return nil
+			}
+		}
+	}
 
-// Logs retrieves logs from Docker Compose services
-func (dcm *DockerComposeManager) Logs(serviceName string, follow bool) (string, error) {
-	var cmd string
-	followFlag := ""
-	if follow {
-		followFlag = "-f"
+	opts := []manager.Option{manager.WithOutput(*output)}
+	if *composeCommand != "" {
+		opts = append(opts, manager.WithComposeCommand(*composeCommand))
+	}
+	if *project != "" {
+		opts = append(opts, manager.WithProject(*project))
+	}
+	if *quiet {
+		opts = append(opts, manager.WithQuiet())
+	}
+	if *verbose {
+		opts = append(opts, manager.WithVerbose())
+	}
+	if *logFormat != manager.LogFormatText {
+		opts = append(opts, manager.WithLogFormat(*logFormat))
+	}
+	if *strictServices {
+		opts = append(opts, manager.WithStrictServices())
+	}
+	if *commandLog != "" {
+		opts = append(opts, manager.WithCommandLog(*commandLog))
+	}
+	if *backendName != "" {
+		opts = append(opts, manager.WithBackend(*backendName))
+	}
+	if *v1Compat {
+		opts = append(opts, manager.WithComposeV1Compat())
+	}
+	if len(composeFiles) > 0 {
+		opts = append(opts, manager.WithComposeFiles(composeFiles...))
+	}
+	if *envFile != "" {
+		opts = append(opts, manager.WithEnvFile(*envFile))
 	}
+	// --dry-run only applies to the commands changing state
+	dryRunIgnored := *dryRun && len(args) > 0 && !dryRunCommands[strings.ToLower(args[0])] && strings.ToLower(args[0]) != "up"
+	if *dryRun && !dryRunIgnored {
+		opts = append(opts, manager.WithDryRun())
+	}
+	if *yes {
+		opts = append(opts, manager.WithYes())
+	}
+	if *skipHooks {
+		opts = append(opts, manager.WithSkipHooks())
+	}
+	if *configPath == "" {
+		*configPath = manager.LocateConfig()
+	} else if _, err := os.Stat(*configPath); err != nil {
+		err = manager.NewError(manager.ErrConfig, "config file: %w", err)
+		printError(err)
+		return err
+	}
+	dcm, err := manager.New(*configPath, opts...)
+	if err != nil {
+		printError(err)
+		return err
+	}
+	defer dcm.Close()
+	// The docker commands run outside compose, such as inspect and stats,
+	// follow the environment of the process
+	dcm.ExportEndpoint()
 
-	if serviceName != "" {
-		cmd = fmt.Sprintf("docker-compose logs %s %s", followFlag, serviceName)
-	} else {
-		cmd = fmt.Sprintf("docker-compose logs %s", followFlag)
+	// Banners go to stderr so machine readable output on stdout stays clean,
+	// and are left out entirely with --quiet or when stderr carries JSON
+	// errors
+	if *output != "json" {
+		dcm.Infof("Docker Compose Manager - Go Edition\n")
+		dcm.Infof("Config loaded from: %s\n", dcm.ConfigPath())
+		if dcm.Project() != "" {
+			dcm.Infof("Project: %s\n", dcm.Project())
+		}
+		if endpoint := dcm.Config().Endpoint; endpoint.Host != "" || endpoint.Context != "" {
+			dcm.Infof("Docker: %s\n", endpoint)
+		}
 	}
-	fmt.Println("Fetching logs...")
-	return dcm.executeCommand(cmd)
-}
 
-// Remove removes Docker Compose services
-func (dcm *DockerComposeManager) Remove(serviceName string) (string, error) {
-	var cmd string
-	if serviceName != "" {
-		cmd = fmt.Sprintf("docker-compose rm -f %s", serviceName)
-	} else {
-		cmd = "docker-compose rm -f"
+	// Check for command line arguments
+	if len(args) == 0 {
+		if dcm.FeatureEnabled("tui") && manager.IsTerminal(os.Stdin) && manager.IsTerminal(os.Stdout) {
+			err := dcm.RunTUI()
+			if err != nil {
+				dcm.PrintError(err)
+			}
+			return err
+		}
+		// Without a terminal the menu is read from a pipe, most likely by
+		// a script that forgot its command, so say which there are
+		if !manager.IsTerminal(os.Stdin) {
+			printUsage(os.Stderr, global)
+		}
+		dcm.RunInteractive(os.Stdin)
+		return nil
 	}
-	fmt.Println("Removing services...")
-	return dcm.executeCommand(cmd)
-}
 
-// Build builds Docker Compose services
-func (dcm *DockerComposeManager) Build(serviceName string) (string, error) {
-	var cmd string
-	if serviceName != "" {
-		cmd = fmt.Sprintf("docker-compose build %s", serviceName)
-	} else {
-		cmd = "docker-compose build"
+	command := strings.ToLower(args[0])
+	if dryRunIgnored {
+		dcm.Warnf("--dry-run has no effect on %s, it does not change anything\n", command)
+	}
+	// up is start with --wait starting the services in dependency order
+	ordered := false
+	if command == "up" {
+		command, ordered = "start", true
+	}
+	var serviceName string
+	if len(args) > 1 {
+		serviceName = args[1]
 	}
-	fmt.Println("Building services...")
-	return dcm.executeCommand(cmd)
-}
 
-// Pull pulls Docker images
-func (dcm *DockerComposeManager) Pull(serviceName string) (string, error) {
-	var cmd string
-	if serviceName != "" {
-		cmd = fmt.Sprintf("docker-compose pull %s", serviceName)
-	} else {
-		cmd = "docker-compose pull"
+	// A typo in the configured services fails before anything runs, except
+	// for the commands used to inspect and fix the configuration
+	if !configCommands[command] {
+		// A snapshot restore brings the files with it
+		if err := dcm.CheckConfigFiles(); err != nil && command != "snapshot" {
+			dcm.PrintError(err)
+			return err
+		}
+		if err := dcm.CheckConfiguredServices(); err != nil {
+			dcm.PrintError(err)
+			return err
+		}
 	}
-	fmt.Println("Pulling images...")
-	return dcm.executeCommand(cmd)
-}
 
-// DisplayMenu displays the interactive menu
-func (dcm *DockerComposeManager) DisplayMenu() {
-	fmt.Println("\n=== Docker Compose Manager (Go) ===")
-	fmt.Println("1. Start services")
-	fmt.Println("2. Stop services")
-	fmt.Println("3. Restart services")
-	fmt.Println("4. Check status")
-	fmt.Println("5. View logs")
-	fmt.Println("6. Remove services")
-	fmt.Println("7. Build services")
-	fmt.Println("8. Pull images")
-	fmt.Println("0. Exit")
-	fmt.Println("====================================\n")
-}
+	var wait bool
+	var waitOpts manager.WaitOptions
+	if _, ok := manager.BatchCommands[command]; ok {
+		fs := flag.NewFlagSet(command, flag.ExitOnError)
+		var batch manager.BatchOptions
+		fs.DurationVar(&batch.Timeout, "keep-going-timeout", 0, "per-service timeout, e.g. 2m")
+		fs.BoolVar(&batch.ContinueOnError, "continue-on-error", false, "keep going when a service fails")
+		fs.IntVar(&batch.Workers, "parallel", 4, "number of services, or projects with --all-projects, processed at once")
+		var allProjects bool
+		if _, ok := manager.AllProjectsCommands[command]; ok {
+			fs.BoolVar(&allProjects, "all-projects", false, "run in every project of the config concurrently, reporting failures at the end")
+		}
+		var pull, pin string
+		var createNetworks, autoPorts bool
+		if command == "start" {
+			fs.BoolVar(&createNetworks, "create-networks", false, "create the external networks of the project that do not exist yet")
+			fs.BoolVar(&autoPorts, "auto-ports", false, "remap the published host ports already in use to free ones through an override file")
+			fs.StringVar(&pull, "pull", "", "pull policy: always pulls images and re-pins them before starting")
+			fs.StringVar(&pin, "pin", "", "start from the image digests pinned in lock `file`")
+			fs.BoolVar(&wait, "wait", false, "wait until the started services are running and healthy")
+			fs.DurationVar(&waitOpts.Timeout, "wait-timeout", 0, "how long --wait waits, defaults to wait_timeout from the config or 2m")
+			fs.IntVar(&waitOpts.LogLines, "wait-logs", 0, "print the last `n` log lines of services that fail to become ready")
+			fs.IntVar(&waitOpts.MaxRetries, "max-retries", 3, "fail the wait once a service is unhealthy `n` polls in a row, 0 waits out the timeout")
+			fs.BoolVar(&waitOpts.FailFast, "fail-fast", false, "end the wait at the first service that fails instead of reporting every failure at the end")
+			fs.BoolVar(&ordered, "ordered", ordered, "with --wait, start services in dependency order, each once the services it depends on are ready")
+		}
+		var summaryOnly, verboseOnError bool
+		if command == "pull" {
+			fs.BoolVar(&summaryOnly, "summary-only", false, "discard the pull output and print one line per service, for CI logs")
+			fs.BoolVar(&verboseOnError, "verbose-on-error", false, "with --summary-only, print the full output of failed services")
+		}
+		var graceful bool
+		if command == "restart" {
+			fs.BoolVar(&graceful, "graceful", false, "restart the stack one service at a time in dependency order, waiting for each to be healthy")
+			fs.DurationVar(&waitOpts.Timeout, "wait-timeout", 0, "how long --graceful waits for each service, defaults to wait_timeout from the config or 2m")
+			fs.IntVar(&waitOpts.LogLines, "wait-logs", 0, "print the last `n` log lines of a service that fails to become ready")
+			fs.IntVar(&waitOpts.MaxRetries, "max-retries", 3, "fail once a service is unhealthy `n` polls in a row, 0 waits out the timeout")
+		}
+		scopeFlags(fs, dcm)
+		positional, err := parseServiceArgs(dcm, fs, args[1:])
+		if err != nil {
+			dcm.PrintError(err)
+			return err
+		}
 
-func main() {
-	manager := NewDockerComposeManager("dcm.config.yml")
+		if allProjects {
+			err := manager.NewError(manager.ErrUsage, "--all-projects runs the whole of every project, it takes no services")
+			if len(positional) == 0 {
+				err = dcm.Track(command, nil, func() error {
+					return dcm.RunAllProjects(command, batch)
+				})
+			}
+			if err != nil {
+				dcm.PrintError(err)
+			}
+			return err
+		}
+		if summaryOnly || verboseOnError {
+			err := dcm.RunSummary(command, positional, summaryOnly, verboseOnError)
+			if err != nil {
+				dcm.PrintError(err)
+			}
+			return err
+		}
+		if graceful {
+			err := manager.NewError(manager.ErrUsage, "usage: restart --graceful [--wait-timeout d] [--all]")
+			if len(positional) == 0 {
+				err = dcm.Track(command, nil, func() error {
+					return dcm.GracefulRestart(waitOpts)
+				})
+			}
+			if err != nil {
+				dcm.PrintError(err)
+			}
+			return err
+		}
 
-	fmt.Println("Docker Compose Manager - Go Edition")
-	fmt.Printf("Config loaded from: %s\n", manager.configPath)
+		if command == "start" && (createNetworks || dcm.FeatureEnabled("network_preflight")) {
+			if _, err := dcm.CheckNetworks(createNetworks); err != nil {
+				dcm.PrintError(err)
+				return err
+			}
+		}
+		if command == "start" && (autoPorts || dcm.FeatureEnabled("port_preflight")) {
+			if _, err := dcm.CheckPorts(positional, autoPorts); err != nil {
+				dcm.PrintError(err)
+				return err
+			}
+		}
+		if pull != "" || pin != "" {
+			if err := dcm.PinImages(pull, pin, positional); err != nil {
+				dcm.PrintError(err)
+				return err
+			}
+		}
+		if ordered && wait {
+			err := dcm.Track(command, positional, func() error {
+				return dcm.StartOrdered(positional, waitOpts)
+			})
+			if err != nil {
+				dcm.PrintError(err)
+			}
+			return err
+		}
+		if len(positional) > 1 || batch.Timeout > 0 {
+			err := dcm.Track(command, positional, func() error {
+				if err := dcm.RunBatch(command, positional, batch); err != nil || !wait {
+					return err
+				}
+				return dcm.WaitReady(positional, waitOpts)
+			})
+			if err != nil {
+				dcm.PrintError(err)
+			}
+			return err
+		}
+		serviceName = ""
+		if len(positional) > 0 {
+			serviceName = positional[0]
+		}
+	}
 
-	// Check for command line arguments
-	args := os.Args[1:]
+	var services []string
+	if serviceName != "" {
+		services = []string{serviceName}
+	}
+	// mutate announces the operation to other users while it runs
+	mutate := func(op func() (string, error)) error {
+		var opErr error
+		err := dcm.Track(command, services, func() error {
+			_, opErr = op()
+			return opErr
+		})
+		// The operation printed its own errors, but not those of the
+		// middleware, such as a failing hook
+		if err != nil && err != opErr {
+			dcm.PrintError(err)
+		}
+		return err
+	}
+	// report prints an error the operation did not already print
+	report := func(err error) error {
+		if err != nil {
+			dcm.PrintError(err)
+		}
+		return err
+	}
 
-	if len(args) > 0 {
-		command := args[0]
-		var serviceName string
-		if len(args) > 1 {
-			serviceName = args[1]
-		}
-
-		switch strings.ToLower(command) {
-		case "start":
-			manager.Start(serviceName)
-		case "stop":
-			manager.Stop(serviceName)
-		case "restart":
-			manager.Restart(serviceName)
-		case "status":
-			manager.Status()
-		case "logs":
-			manager.Logs(serviceName, false)
-		case "remove":
-			manager.Remove(serviceName)
-		case "build":
-			manager.Build(serviceName)
-		case "pull":
-			manager.Pull(serviceName)
+	switch command {
+	case "bootstrap":
+		return report(runBootstrap(args[1:], opts...))
+	case "doctor":
+		return report(dcm.Doctor())
+	case "validate":
+		fs := flag.NewFlagSet("validate", flag.ExitOnError)
+		strict := fs.Bool("strict", false, "also run the lint checks and fail on their warnings and errors, for CI")
+		fs.Parse(args[1:])
+		return report(dcm.PrintValidate(*strict))
+	case "lint":
+		fs := flag.NewFlagSet("lint", flag.ExitOnError)
+		strict := fs.Bool("strict", false, "fail on every finding, info included, for CI")
+		fs.Parse(args[1:])
+		return report(dcm.PrintLint(*strict))
+	case "features":
+		dcm.PrintFeatures()
+		return nil
+	case "__complete":
+		if len(args) != 2 || args[1] != "services" {
+			return report(manager.NewError(manager.ErrUsage, "usage: __complete commands|services"))
+		}
+		return report(dcm.CompleteServices(os.Stdout))
+	case "config":
+		switch {
+		case len(args) == 2 && args[1] == "render":
+			return report(dcm.RenderConfig())
+		case len(args) == 2 && args[1] == "migrate":
+			return report(dcm.MigrateConfig())
+		}
+		return report(manager.NewError(manager.ErrUsage, "usage: config render|migrate"))
+	case "groups":
+		if len(args) > 2 || (len(args) == 2 && args[1] != "list") {
+			return report(manager.NewError(manager.ErrUsage, "usage: groups [list]"))
+		}
+		return report(dcm.PrintGroups())
+	case "projects":
+		if len(args) > 2 || (len(args) == 2 && args[1] != "list") {
+			return report(manager.NewError(manager.ErrUsage, "usage: projects [list]"))
+		}
+		return report(dcm.PrintProjects())
+	case "context":
+		switch {
+		case len(args) == 1 || (len(args) == 2 && args[1] == "list"):
+			return report(dcm.PrintContexts())
+		case len(args) == 3 && args[1] == "use":
+			return report(dcm.UseContext(args[2]))
+		}
+		return report(manager.NewError(manager.ErrUsage, "usage: context list|use <name>"))
+	case "who":
+		fs := flag.NewFlagSet("who", flag.ExitOnError)
+		since := fs.Duration("since", 24*time.Hour, "how far back to list activity")
+		fs.Parse(args[1:])
+		return report(dcm.Who(*since))
+	case "start":
+		return mutate(func() (string, error) {
+			return "", dcm.Start(context.Background(), manager.StartOptions{Services: services, Wait: wait, WaitOptions: waitOpts})
+		})
+	case "stop":
+		return mutate(func() (string, error) { return dcm.Stop(serviceName) })
+	case "restart":
+		return mutate(func() (string, error) { return dcm.RestartService(serviceName) })
+	case "status":
+		fs := flag.NewFlagSet("status", flag.ExitOnError)
+		asJSON := fs.Bool("json", false, "print service states as JSON, same as --output json")
+		var statusOpts manager.StatusOptions
+		fs.StringVar(&statusOpts.Format, "output", "", "print service states as a `format`: table, json or yaml")
+		order := fs.String("sort", manager.SortByFile, "order services by `name`, file or state")
+		fs.BoolVar(&statusOpts.Replicas, "replicas", false, "show the replica counts of each service instead of its containers")
+		scopeFlags(fs, dcm)
+		fs.Parse(args[1:])
+		if statusOpts.Format == "" && *asJSON {
+			statusOpts.Format = "json"
+		}
+		// Without --sort, --replicas or --output, text output is the table
+		// compose prints
+		fs.Visit(func(f *flag.Flag) {
+			if f.Name == "sort" {
+				statusOpts.Sort = *order
+			}
+		})
+		return dcm.PrintStatus(statusOpts)
+	case "list":
+		fs := flag.NewFlagSet("list", flag.ExitOnError)
+		order := fs.String("sort", manager.SortByFile, "order services by `name`, file or state")
+		scopeFlags(fs, dcm)
+		fs.Parse(args[1:])
+		return report(dcm.PrintServices(*order))
+	case "uptime":
+		fs := flag.NewFlagSet("uptime", flag.ExitOnError)
+		asJSON := fs.Bool("json", false, "print the uptimes as JSON")
+		window := fs.Duration("window", 10*time.Minute, "count state changes within this `duration` as flaps")
+		interval := fs.Duration("interval", 5*time.Second, "time between samples")
+		sampleFor := fs.Duration("for", 0, "stop sampling after this `duration` instead of on Ctrl-C")
+		watch := fs.Bool("watch", false, "print the uptimes after every sample")
+		scopeFlags(fs, dcm)
+		positional, err := parseServiceArgs(dcm, fs, args[1:])
+		if err != nil {
+			return report(err)
+		}
+		opts := manager.UptimeOptions{Window: *window, Interval: *interval, For: *sampleFor, Watch: *watch}
+		return report(dcm.Uptime(positional, opts, func(uptimes []manager.ServiceUptime) error {
+			if *asJSON || dcm.Output() == "json" {
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(uptimes)
+			}
+			manager.PrintUptimes(uptimes)
+			return nil
+		}))
+	case "stats":
+		fs := flag.NewFlagSet("stats", flag.ExitOnError)
+		watch := fs.Bool("watch", false, "refresh the usage until interrupted")
+		interval := fs.Duration("interval", 2*time.Second, "time between refreshes with --watch")
+		scopeFlags(fs, dcm)
+		positional, err := parseServiceArgs(dcm, fs, args[1:])
+		if err != nil {
+			return report(err)
+		}
+		redraw := *watch && dcm.Output() != "json" && manager.IsTerminal(os.Stdout)
+		return report(dcm.Stats(positional, *watch, *interval, func(stats []manager.ServiceStats) error {
+			if dcm.Output() == "json" {
+				// One document per line when watching, for collectors to read
+				encoder := json.NewEncoder(os.Stdout)
+				if !*watch {
+					encoder.SetIndent("", "  ")
+				}
+				return encoder.Encode(stats)
+			}
+			if redraw {
+				fmt.Print(manager.ANSIHome + manager.ANSIClearBelow)
+			}
+			manager.PrintStats(stats)
+			return nil
+		}))
+	case "logs":
+		fs := flag.NewFlagSet("logs", flag.ExitOnError)
+		logOpts := manager.LogOptions{}
+		fs.BoolVar(&logOpts.Follow, "f", false, "follow log output")
+		fs.BoolVar(&logOpts.Follow, "follow", false, "follow log output")
+		fs.IntVar(&logOpts.Tail, "tail", -1, "number of lines to show from the end of the logs")
+		fs.StringVar(&logOpts.Since, "since", "", "show logs since a timestamp or relative time, e.g. 10m")
+		fs.BoolVar(&logOpts.NoColor, "no-color", false, "do not color the service prefixes")
+		previous := fs.Bool("previous", false, "show logs of the container replaced by the last recreate")
+		archive := fs.String("archive", "", "write each service's logs to `dir`/<service>.log instead of the console")
+		container := fs.String("container", "", "show the logs of the container with this `id` instead of a service")
+		scopeFlags(fs, dcm)
+		positional, err := parseServiceArgs(dcm, fs, args[1:])
+		if err != nil {
+			return report(err)
+		}
+		if err := containerOrService(*container, positional); err != nil {
+			return report(err)
+		}
+		if *container != "" {
+			if *archive != "" || *previous {
+				return report(manager.NewError(manager.ErrUsage, "usage: logs --container <id> [-f] [--tail N] [--since T]"))
+			}
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			return report(dcm.ContainerLogs(ctx, *container, logOpts, os.Stdout))
+		}
+		if *archive != "" {
+			if logOpts.Follow || *previous {
+				return report(manager.NewError(manager.ErrUsage, "usage: logs --archive <dir> [service...] [--tail N] [--since T]"))
+			}
+			return report(dcm.ArchiveLogs(*archive, positional, logOpts))
+		}
+		serviceName = ""
+		if len(positional) > 0 {
+			serviceName = positional[0]
+		}
+		if *previous {
+			if serviceName == "" || logOpts.Follow {
+				return report(manager.NewError(manager.ErrUsage, "usage: logs <service> --previous [--tail N] [--since T]"))
+			}
+			_, err := dcm.PreviousLogs(serviceName, logOpts)
+			return report(err)
+		}
+
+		// Ctrl-C stops following and terminates docker-compose
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if len(positional) > 1 {
+			return dcm.ServiceLogs(ctx, positional, logOpts, os.Stdout)
+		}
+		_, err = dcm.LogsWithOptions(ctx, serviceName, logOpts, os.Stdout)
+		return err
+	case "remove":
+		target := "all services in scope"
+		if serviceName != "" {
+			target = serviceName
+		}
+		if err := dcm.ConfirmDestructive(fmt.Sprintf("Remove the stopped containers of %s?", target)); err != nil {
+			return report(err)
+		}
+		return mutate(func() (string, error) { return dcm.Remove(serviceName) })
+	case "kill":
+		fs := flag.NewFlagSet("kill", flag.ExitOnError)
+		signal := fs.String("signal", "SIGKILL", "signal to send")
+		fs.StringVar(signal, "s", "SIGKILL", "signal to send")
+		scopeFlags(fs, dcm)
+		positional, err := parseServiceArgs(dcm, fs, args[1:])
+		if err != nil {
+			return report(err)
+		}
+		if len(positional) > 1 {
+			return report(manager.NewError(manager.ErrUsage, "usage: kill [-s signal] [service]"))
+		}
+		serviceName = ""
+		if len(positional) == 1 {
+			serviceName = positional[0]
+			services = positional
+		}
+		return mutate(func() (string, error) { return dcm.Kill(serviceName, *signal) })
+	case "reload":
+		name, err := dcm.ResolveService(serviceName)
+		if err != nil {
+			return report(err)
+		}
+		serviceName, services = name, []string{name}
+		return mutate(func() (string, error) { return dcm.Reload(serviceName) })
+	case "exec", "run", "shell":
+		// Flags go before the service, everything after it is the command,
+		// optionally behind --
+		fs := flag.NewFlagSet(command, flag.ExitOnError)
+		var execOpts manager.ExecOptions
+		fs.StringVar(&execOpts.User, "user", "", "run the command as this user")
+		fs.Var((*listFlag)(&execOpts.Env), "env", "set an environment variable, KEY=VALUE (repeatable)")
+		fs.BoolVar(&execOpts.NoTTY, "no-tty", false, "do not allocate a terminal, for scripts")
+		if command != "shell" {
+			fs.BoolVar(&execOpts.Privileged, "privileged", false, "give the command extended privileges, for debugging tools (exec only)")
+		}
+		var container string
+		if command == "exec" {
+			fs.StringVar(&container, "container", "", "run in the container with this `id`, every argument is then the command")
+		}
+		scopeFlags(fs, dcm)
+		fs.Parse(args[1:])
+		var err error
+		var service string
+		if container == "" && fs.NArg() > 0 {
+			if service, err = dcm.ResolveService(fs.Arg(0)); err != nil {
+				return report(err)
+			}
+		}
+		var commandArgs []string
+		if fs.NArg() > 1 {
+			commandArgs = fs.Args()[1:]
+			if commandArgs[0] == "--" {
+				commandArgs = commandArgs[1:]
+			}
+		}
+		switch {
+		case container != "":
+			err = dcm.ContainerExec(container, fs.Args(), execOpts)
+		case command == "shell" && fs.NArg() != 1:
+			return report(manager.NewError(manager.ErrUsage, "usage: shell [--user u] [--env KEY=VAL]... <service>"))
+		case fs.NArg() == 0:
+			return report(manager.NewError(manager.ErrUsage, "usage: %s [--user u] [--env KEY=VAL]... [--no-tty] [--privileged] <service> [--] [command...]", command))
+		case command == "run":
+			err = dcm.Run(service, commandArgs, execOpts)
 		default:
-			fmt.Println("Unknown command. Available: start, stop, restart, status, logs, remove, build, pull")
+			// shell is exec without a command
+			err = dcm.Exec(service, commandArgs, execOpts)
+		}
+		if manager.TypeOf(err) == manager.ErrCommandFailed {
+			// The command reported its own failure, only pass its exit code on
+			return err
+		}
+		return report(err)
+	case "compose":
+		// Everything after the command goes to compose as is, against the
+		// configured files and project
+		if len(args) < 2 {
+			return report(manager.NewError(manager.ErrUsage, "usage: compose <compose arguments...>"))
+		}
+		return report(dcm.Compose(args[1:]...))
+	case "inspect":
+		fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+		container := fs.String("container", "", "inspect the container with this `id` instead of a service")
+		scopeFlags(fs, dcm)
+		positional, err := parseServiceArgs(dcm, fs, args[1:])
+		if err != nil {
+			return report(err)
+		}
+		if err := containerOrService(*container, positional); err != nil {
+			return report(err)
+		}
+		if len(positional) > 1 {
+			return report(manager.NewError(manager.ErrUsage, "usage: inspect [service] | inspect --container <id>"))
+		}
+		if *container != "" {
+			_, err := dcm.InspectContainer(*container)
+			return err
+		}
+		serviceName = ""
+		if len(positional) == 1 {
+			serviceName = positional[0]
+		}
+		_, err = dcm.Inspect(serviceName)
+		return err
+	case "tui":
+		return report(dcm.RunTUI())
+	case "monitor":
+		fs := flag.NewFlagSet("monitor", flag.ExitOnError)
+		tui := fs.Bool("tui", false, "show a live status and logs view in the terminal")
+		interval := fs.Duration("interval", 2*time.Second, "how often the view refreshes")
+		scopeFlags(fs, dcm)
+		positional, err := parseServiceArgs(dcm, fs, args[1:])
+		if err != nil {
+			return report(err)
+		}
+		if !*tui {
+			return report(manager.NewError(manager.ErrUsage, "usage: monitor --tui [--interval 2s] [service...]"))
+		}
+		return report(dcm.Monitor(positional, *interval))
+	case "snapshot":
+		fs := flag.NewFlagSet("snapshot", flag.ExitOnError)
+		live := fs.Bool("live", false, "with create, read the volumes without stopping the services")
+		positional, _ := parseArgs(fs, args[1:])
+		usage := manager.NewError(manager.ErrUsage, "usage: snapshot create [--live] <name> | restore <name|file> | list")
+		if len(positional) == 0 {
+			return report(usage)
+		}
+		switch {
+		case positional[0] == "list" && len(positional) == 1:
+			return report(dcm.PrintSnapshots())
+		case positional[0] == "create" && len(positional) == 2:
+			var path string
+			err := dcm.Track("snapshot", nil, func() (err error) {
+				path, err = dcm.CreateSnapshot(positional[1], *live)
+				return err
+			})
+			if err == nil && !dcm.DryRun() {
+				fmt.Printf("Snapshot written to %s\n", path)
+			}
+			return report(err)
+		case positional[0] == "restore" && len(positional) == 2:
+			return report(dcm.Track("snapshot", nil, func() error {
+				return dcm.RestoreSnapshot(positional[1])
+			}))
+		}
+		return report(usage)
+	case "watch":
+		fs := flag.NewFlagSet("watch", flag.ExitOnError)
+		debounce := fs.Duration("debounce", 0, "how long changes must settle before acting (default watch.debounce from the config, or 500ms)")
+		scopeFlags(fs, dcm)
+		positional, err := parseServiceArgs(dcm, fs, args[1:])
+		if err != nil {
+			return report(err)
+		}
+		return report(dcm.Watch(positional, *debounce))
+	case "guard":
+		fs := flag.NewFlagSet("guard", flag.ExitOnError)
+		interval := fs.Duration("interval", 0, "how often to check the services besides docker events (default guard.interval from the config, or 30s)")
+		maxBackoff := fs.Duration("max-backoff", 0, "longest wait between attempts at a failing service (default guard.max_backoff from the config, or 5m)")
+		var exclude []string
+		fs.Var((*listFlag)(&exclude), "exclude", "`service` to leave alone (repeatable, or comma-separated)")
+		once := fs.Bool("once", false, "check the services once and exit")
+		scopeFlags(fs, dcm)
+		positional, err := parseServiceArgs(dcm, fs, args[1:])
+		if err != nil {
+			return report(err)
+		}
+		var excluded []string
+		for _, value := range exclude {
+			for _, name := range strings.Split(value, ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					excluded = append(excluded, name)
+				}
+			}
+		}
+		return report(dcm.Guard(manager.GuardOptions{
+			Services:   positional,
+			Exclude:    excluded,
+			Interval:   *interval,
+			MaxBackoff: *maxBackoff,
+			Once:       *once,
+		}))
+	case "serve":
+		fs := flag.NewFlagSet("serve", flag.ExitOnError)
+		listen := fs.String("listen", manager.DefaultServeAddr, "`address` to serve the API on")
+		tokenFile := fs.String("token-file", "", "read the API token from `file` instead of "+manager.ServeTokenEnv)
+		metrics := fs.String("metrics", "", "also serve Prometheus metrics on `address`, e.g. :9120")
+		scopeFlags(fs, dcm)
+		if positional, _ := parseArgs(fs, args[1:]); len(positional) > 0 {
+			return report(manager.NewError(manager.ErrUsage, "usage: serve [--listen addr] [--token-file file] [--metrics addr]"))
+		}
+		token, err := dcm.ServeToken(*tokenFile)
+		if err != nil {
+			return report(err)
+		}
+		return report(dcm.Serve(manager.ServeOptions{Addr: *listen, Token: token, Metrics: *metrics}))
+	case "metrics":
+		fs := flag.NewFlagSet("metrics", flag.ExitOnError)
+		listen := fs.String("listen", manager.DefaultMetricsAddr, "`address` to serve /metrics on, e.g. :9120")
+		scopeFlags(fs, dcm)
+		if positional, _ := parseArgs(fs, args[1:]); len(positional) > 0 {
+			return report(manager.NewError(manager.ErrUsage, "usage: metrics [--listen addr]"))
+		}
+		return report(dcm.ServeMetrics(*listen))
+	case "scale":
+		fs := flag.NewFlagSet("scale", flag.ExitOnError)
+		apply := fs.Bool("apply", false, "scale the services that drifted from the scale section of the config")
+		dryRun := fs.Bool("dry-run", false, "with --apply, only show the services that drifted")
+		scopeFlags(fs, dcm)
+		positional, _ := parseArgs(fs, args[1:])
+		if *apply {
+			if len(positional) > 0 {
+				return report(manager.NewError(manager.ErrUsage, "usage: scale --apply [--dry-run]"))
+			}
+			var changes []manager.ScaleChange
+			var err error
+			if *dryRun {
+				changes, err = dcm.PlanScale()
+			} else {
+				err = dcm.Track(command, nil, func() (err error) {
+					changes, err = dcm.ReconcileScale()
+					return err
+				})
+			}
+			if err != nil {
+				return report(err)
+			}
+			if dcm.Output() == "json" {
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(changes)
+			}
+			manager.PrintScaleChanges(changes, *dryRun)
+			return nil
+		}
+		targets, err := parseScaleArgs(positional)
+		if err != nil {
+			return report(err)
+		}
+		for i := range targets {
+			if targets[i].Service, err = dcm.ResolveService(targets[i].Service); err != nil {
+				return report(err)
+			}
+		}
+		var names []string
+		for _, t := range targets {
+			names = append(names, t.Service)
+		}
+		return dcm.Track(command, names, func() error {
+			_, err := dcm.ScaleServices(targets)
+			return err
+		})
+	case "down":
+		fs := flag.NewFlagSet("down", flag.ExitOnError)
+		volumes := fs.Bool("volumes", false, "also remove named volumes")
+		fs.BoolVar(volumes, "v", false, "also remove named volumes")
+		orphans := fs.Bool("remove-orphans", dcm.FeatureEnabled("remove_orphans"), "also remove containers of services no longer defined")
+		fs.Parse(args[1:])
+		question := fmt.Sprintf("Remove the containers and networks of project %s?", dcm.ProjectName())
+		if *volumes {
+			question = fmt.Sprintf("Remove the containers, networks and volumes of project %s? Volume data will be lost", dcm.ProjectName())
+		}
+		if err := dcm.ConfirmDestructive(question); err != nil {
+			return report(err)
+		}
+		return mutate(func() (string, error) { return dcm.Down(*volumes, *orphans) })
+	case "purge":
+		fs := flag.NewFlagSet("purge", flag.ExitOnError)
+		var purgeOpts manager.PurgeOptions
+		fs.BoolVar(&purgeOpts.DryRun, "dry-run", dcm.DryRun(), "list what would be removed without removing it")
+		fs.BoolVar(&purgeOpts.Yes, "yes", dcm.Yes(), "do not ask for confirmation")
+		fs.Parse(args[1:])
+		var purged manager.PurgeReport
+		run := func() (err error) {
+			purged, err = dcm.Purge(purgeOpts)
+			return err
+		}
+		var err error
+		if purgeOpts.DryRun {
+			err = run()
+		} else {
+			err = dcm.Track(command, nil, run)
+		}
+		if err != nil {
+			return report(err)
+		}
+		if dcm.Output() == "json" {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(purged)
+		}
+		return nil
+	case "build":
+		fs := flag.NewFlagSet("build", flag.ExitOnError)
+		graph := fs.Bool("graph", false, "print the detected build dependency graph and exit")
+		cacheStats := fs.Bool("cache-stats", false, "report how many build steps of each service were cached")
+		detached := fs.Bool("detached", false, "build in the background, follow it with build-status and build-wait")
+		summaryOnly := fs.Bool("summary-only", false, "discard the build output and print one line per service, for CI logs")
+		verboseOnError := fs.Bool("verbose-on-error", false, "with --summary-only, print the full output of failed services")
+		allProjects := fs.Bool("all-projects", false, "build every project of the config concurrently, reporting failures at the end")
+		workers := fs.Int("parallel", 4, "with --all-projects, number of projects built at once")
+		scopeFlags(fs, dcm)
+		positional, err := parseServiceArgs(dcm, fs, args[1:])
+		if err != nil {
+			return report(err)
+		}
+		if *allProjects {
+			if len(positional) > 0 {
+				return report(manager.NewError(manager.ErrUsage, "--all-projects builds the whole of every project, it takes no services"))
+			}
+			return report(dcm.Track(command, nil, func() error {
+				return dcm.RunAllProjects(command, manager.BatchOptions{Workers: *workers})
+			}))
+		}
+		if *graph {
+			return report(dcm.PrintBuildGraph())
+		}
+		serviceName, services = "", nil
+		if len(positional) > 0 {
+			serviceName = positional[0]
+			services = positional[:1]
+		}
+		if len(positional) > 1 && !*summaryOnly && !*verboseOnError {
+			// A group builds its services one after the other
+			if *detached || *cacheStats {
+				return report(manager.NewError(manager.ErrUsage, "--detached and --cache-stats take a single service"))
+			}
+			return dcm.Track(command, positional, func() error {
+				for _, name := range positional {
+					if _, err := dcm.Build(name); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		}
+		if *detached {
+			job, err := dcm.StartDetachedBuild(serviceName)
+			if err != nil {
+				return report(err)
+			}
+			fmt.Printf("Build started in the background (pid %d), logging to %s\n", job.PID, job.Log)
+			fmt.Println("Check it with: dcm build-status, wait for it with: dcm build-wait")
+			return nil
+		}
+		if *summaryOnly || *verboseOnError {
+			return report(dcm.RunSummary(command, positional, *summaryOnly, *verboseOnError))
+		}
+		if *cacheStats {
+			var results []manager.BuildResult
+			err := dcm.Track(command, services, func() (err error) {
+				results, err = dcm.BuildCacheStats(serviceName)
+				return err
+			})
+			if dcm.Output() == "json" {
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				encoder.Encode(results)
+			} else if len(results) > 0 {
+				manager.PrintBuildResults(results)
+			}
+			return report(err)
+		}
+		return mutate(func() (string, error) { return dcm.Build(serviceName) })
+	case manager.BuildRunnerCommand:
+		fs := flag.NewFlagSet(command, flag.ExitOnError)
+		scopeFlags(fs, dcm)
+		positional, err := parseServiceArgs(dcm, fs, args[1:])
+		if err != nil {
+			return report(err)
+		}
+		serviceName = ""
+		if len(positional) > 0 {
+			serviceName = positional[0]
+			services = positional[:1]
+		}
+		return dcm.Track("build", services, func() error {
+			return dcm.RunDetachedBuild(serviceName)
+		})
+	case "build-status":
+		fs := flag.NewFlagSet("build-status", flag.ExitOnError)
+		lines := fs.Int("lines", 10, "number of log lines to show")
+		fs.Parse(args[1:])
+		return report(dcm.PrintBuildStatus(*lines))
+	case "build-wait":
+		fs := flag.NewFlagSet("build-wait", flag.ExitOnError)
+		timeout := fs.Duration("timeout", 0, "give up after this long, 0 waits as long as the build runs")
+		fs.Parse(args[1:])
+		return report(dcm.WaitBuild(*timeout))
+	case "pull":
+		return mutate(func() (string, error) { return dcm.Pull(serviceName) })
+	case "update":
+		fs := flag.NewFlagSet("update", flag.ExitOnError)
+		var updateOpts manager.UpdateOptions
+		fs.BoolVar(&updateOpts.Prune, "prune", false, "remove the images replaced by the update")
+		fs.BoolVar(&updateOpts.DryRun, "dry-run", dcm.DryRun(), "print what the update would do without doing it")
+		fs.BoolVar(&updateOpts.NoRollback, "no-rollback", false, "leave services that are not ready after the update on their new image")
+		fs.DurationVar(&updateOpts.HealthTimeout, "health-timeout", 0, "how long each service may take to be ready after it is recreated (default wait_timeout)")
+		rollback := fs.Bool("rollback", false, "recreate the services of the last update from the images they ran before it")
+		scopeFlags(fs, dcm)
+		positional, err := parseServiceArgs(dcm, fs, args[1:])
+		if err != nil {
+			return report(err)
+		}
+		if len(positional) > 1 {
+			return report(manager.NewError(manager.ErrUsage, "usage: update [--prune] [--dry-run] [--no-rollback] [--rollback] [service]"))
+		}
+		serviceName = ""
+		if len(positional) == 1 {
+			serviceName = positional[0]
+			services = positional
+		}
+		if *rollback {
+			return report(dcm.Track(command, services, func() error {
+				return dcm.RollbackUpdate(serviceName, updateOpts.HealthTimeout)
+			}))
+		}
+		if updateOpts.DryRun {
+			return report(dcm.Update(serviceName, updateOpts))
+		}
+		return report(dcm.Track(command, services, func() error {
+			return dcm.Update(serviceName, updateOpts)
+		}))
+	default:
+		hint := manager.DidYouMean(command, manager.CommandNames())
+		if hint == "" {
+			hint = ", run 'dcm help' for the list of commands"
 		}
-	} else {
-		manager.DisplayMenu()
-		fmt.Println("Usage: go run main.go <command> [service]")
-		fmt.Println("Example: go run main.go start web")
+		return report(manager.NewError(manager.ErrUsage, "unknown command %q%s", args[0], hint))
 	}
 }
@@ -0,0 +1,128 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// AllProjectsCommands maps the verbs that can run across every project of
+// the config to the compose arguments run in each
+var AllProjectsCommands = map[string][]string{
+	"start":   nil, // upArgs, with the scale of the project
+	"stop":    {"stop"},
+	"restart": {"restart"},
+	"pull":    {"pull"},
+	"build":   {"build"},
+}
+
+// RunAllProjects runs verb against every project defined in the config
+// through a bounded worker pool of opts.Workers projects. The output of the
+// projects is interleaved line by line, prefixed with the project name, and
+// a line reports each project as it finishes. A failing project does not
+// stop the others; the summary at the end lists which ones failed.
+// opts.Timeout bounds each project's operation.
+func (dcm *Manager) RunAllProjects(verb string, opts BatchOptions) error {
+	if _, ok := AllProjectsCommands[verb]; !ok {
+		return NewError(ErrUsage, "%s cannot run across all projects", verb)
+	}
+	names := dcm.fileConfig.projectNames()
+	if len(names) == 0 {
+		return NewError(ErrConfig, "--all-projects: no projects are defined in %s", dcm.configPath)
+	}
+	projects := make([]*Manager, len(names))
+	for i, name := range names {
+		project, err := dcm.forProject(name)
+		if err != nil {
+			return err
+		}
+		if err := project.CheckConfigFiles(); err != nil {
+			return fmt.Errorf("project %s: %w", name, err)
+		}
+		projects[i] = project
+	}
+	if opts.Workers < 1 {
+		opts.Workers = 1
+	}
+
+	mux := newLogMux(os.Stdout, names, logColor(os.Stdout, LogOptions{}))
+	results := make([]batchResult, len(names))
+	var mu sync.Mutex
+	finished := 0
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < opts.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				started := time.Now()
+				results[i] = projects[i].runProjectItem(verb, names[i], mux, opts.Timeout)
+
+				mu.Lock()
+				finished++
+				outcome := "done"
+				if results[i].TimedOut {
+					outcome = "timed out"
+				} else if results[i].Err != nil {
+					outcome = "failed"
+				}
+				dcm.Infof("[%d/%d] %s: %s %s in %s\n", finished, len(names), names[i], verb, outcome,
+					time.Since(started).Round(100*time.Millisecond))
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := range names {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return reportBatch(verb, "projects", results)
+}
+
+// runProjectItem runs verb against the whole project under its own timeout,
+// writing the output of compose through mux
+func (dcm *Manager) runProjectItem(verb, name string, mux *logMux, timeout time.Duration) batchResult {
+	result := batchResult{Service: name}
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var argv []string
+	var err error
+	if verb == "start" {
+		argv, err = dcm.upCommand(nil)
+	} else {
+		argv, err = dcm.composeArgs(append(append([]string(nil), AllProjectsCommands[verb]...), dcm.scopedServices()...)...)
+	}
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	r, w := io.Pipe()
+	copied := make(chan struct{})
+	go func() {
+		mux.copy(name, r)
+		// Keep compose from blocking on a line too long to scan
+		io.Copy(ioutil.Discard, r)
+		close(copied)
+	}()
+	err = dcm.executeStreaming(ctx, argv, w, w)
+	w.Close()
+	<-copied
+	if err != nil {
+		result.Err = err
+		result.TimedOut = ctx.Err() == context.DeadlineExceeded
+	}
+	return result
+}
@@ -0,0 +1,139 @@
+package manager
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// archiveManifestFile lists the archived services in the archive directory
+const archiveManifestFile = "manifest.json"
+
+// ArchiveManifest describes a log archive written by ArchiveLogs
+type ArchiveManifest struct {
+	Created  time.Time         `json:"created"`
+	Project  string            `json:"project"`
+	Services []ArchivedService `json:"services"`
+}
+
+// ArchivedService is the log file of one service in an archive
+type ArchivedService struct {
+	Service string `json:"service"`
+	File    string `json:"file"`
+	Lines   int    `json:"lines"`
+	Error   string `json:"error,omitempty"`
+}
+
+// lineCounter counts the lines written through it
+type lineCounter struct {
+	w     *os.File
+	lines int
+}
+
+func (c *lineCounter) Write(p []byte) (int, error) {
+	c.lines += bytes.Count(p, []byte("\n"))
+	return c.w.Write(p)
+}
+
+// ArchiveLogs writes the timestamped logs of each service to
+// <dir>/<service>.log, fetching the services concurrently, and records them
+// in a manifest. Without services it archives the configured services, or
+// every service of the compose files.
+func (dcm *Manager) ArchiveLogs(dir string, services []string, opts LogOptions) error {
+	if len(services) == 0 {
+		services = dcm.scopedServices()
+	}
+	if len(services) == 0 {
+		all, err := dcm.composeServices()
+		if err != nil {
+			return err
+		}
+		services = all
+	}
+	for _, service := range services {
+		if err := dcm.checkService(service); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(dir, 0775); err != nil {
+		return err
+	}
+
+	dcm.Infof("Archiving logs of %d services to %s...\n", len(services), dir)
+	manifest := ArchiveManifest{
+		Created:  time.Now().UTC(),
+		Project:  dcm.ProjectName(),
+		Services: make([]ArchivedService, len(services)),
+	}
+	var wg sync.WaitGroup
+	for i, service := range services {
+		wg.Add(1)
+		go func(i int, service string) {
+			defer wg.Done()
+			manifest.Services[i] = dcm.archiveService(dir, service, opts)
+		}(i, service)
+	}
+	wg.Wait()
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, archiveManifestFile), append(data, '\n'), 0664); err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, s := range manifest.Services {
+		if s.Error != "" {
+			dcm.Warnf("could not archive %s: %s\n", s.Service, s.Error)
+			failed++
+			continue
+		}
+		fmt.Printf("  %-20s %6d lines  %s\n", s.Service, s.Lines, s.File)
+	}
+	if failed > 0 {
+		return fmt.Errorf("archived logs of %d of %d services", len(services)-failed, len(services))
+	}
+	return nil
+}
+
+// archiveService writes the logs of one service to its file in dir
+func (dcm *Manager) archiveService(dir, service string, opts LogOptions) ArchivedService {
+	result := ArchivedService{Service: service, File: service + ".log"}
+
+	args := []string{"logs", "--no-color", "--timestamps"}
+	if opts.Tail >= 0 {
+		args = append(args, "--tail", strconv.Itoa(opts.Tail))
+	}
+	if opts.Since != "" {
+		args = append(args, "--since", opts.Since)
+	}
+	argv, err := dcm.composeArgs(append(args, service)...)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	f, err := os.Create(filepath.Join(dir, result.File))
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer f.Close()
+
+	counter := &lineCounter{w: f}
+	var stderr bytes.Buffer
+	if err := dcm.executeStreaming(context.Background(), argv, counter, &stderr); err != nil {
+		result.Error = fmt.Sprintf("%v: %s", err, firstLine(stderr.String()))
+	}
+	result.Lines = counter.lines
+	return result
+}
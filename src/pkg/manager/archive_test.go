@@ -0,0 +1,92 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeLogs answers compose logs of each service with lines of its own
+func fakeLogs(runner *fakeRunner, lines map[string]int) {
+	runner.handle(" logs ", func(_ context.Context, cmd *Cmd) error {
+		service := cmd.Args[len(cmd.Args)-1]
+		for i := 1; i <= lines[service]; i++ {
+			fmt.Fprintf(cmd.Stdout, "2024-05-01T10:00:0%dZ %s line %d\n", i, service, i)
+		}
+		return nil
+	})
+}
+
+func readManifest(t *testing.T, dir string) ArchiveManifest {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, archiveManifestFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest ArchiveManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		t.Fatalf("parsing the manifest: %v", err)
+	}
+	return manifest
+}
+
+func TestArchiveLogs(t *testing.T) {
+	runner := &fakeRunner{}
+	fakeLogs(runner, map[string]int{"web": 3, "worker": 1})
+	dcm := newTestManager(t, "", "", runner)
+	dir := filepath.Join(t.TempDir(), "archive")
+
+	if err := dcm.ArchiveLogs(dir, nil, LogOptions{Tail: -1}); err != nil {
+		t.Fatalf("ArchiveLogs: %v", err)
+	}
+
+	manifest := readManifest(t, dir)
+	if manifest.Project != "test" || len(manifest.Services) != 3 {
+		t.Fatalf("manifest = %+v, want the 3 services of project test", manifest)
+	}
+	want := map[string]int{"web": 3, "worker": 1, "db": 0}
+	for _, s := range manifest.Services {
+		if s.Lines != want[s.Service] || s.File != s.Service+".log" || s.Error != "" {
+			t.Errorf("manifest entry %+v, want %d lines in %s.log", s, want[s.Service], s.Service)
+		}
+		data, err := os.ReadFile(filepath.Join(dir, s.File))
+		if err != nil {
+			t.Errorf("reading %s: %v", s.File, err)
+			continue
+		}
+		if got := strings.Count(string(data), "\n"); got != s.Lines {
+			t.Errorf("%s has %d lines, the manifest says %d", s.File, got, s.Lines)
+		}
+	}
+	for _, command := range runner.ran(" logs ") {
+		if !strings.Contains(command, "--timestamps") || !strings.Contains(command, "--no-color") {
+			t.Errorf("logs command %q, want --timestamps and --no-color", command)
+		}
+	}
+}
+
+func TestArchiveLogsRecordsFailures(t *testing.T) {
+	runner := &fakeRunner{}
+	runner.fail("--tail 100 db", 1, "no such service: db\n")
+	fakeLogs(runner, map[string]int{"web": 2})
+	dcm := newTestManager(t, "", "", runner)
+	dir := t.TempDir()
+
+	if err := dcm.ArchiveLogs(dir, []string{"web", "db"}, LogOptions{Tail: 100}); err == nil {
+		t.Error("ArchiveLogs succeeded with a failing service")
+	}
+	manifest := readManifest(t, dir)
+	if len(manifest.Services) != 2 {
+		t.Fatalf("manifest = %+v", manifest)
+	}
+	if web := manifest.Services[0]; web.Lines != 2 || web.Error != "" {
+		t.Errorf("web = %+v, want 2 lines", web)
+	}
+	if db := manifest.Services[1]; !strings.Contains(db.Error, "no such service") {
+		t.Errorf("db = %+v, want the error of compose", db)
+	}
+}
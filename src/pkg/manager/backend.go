@@ -0,0 +1,540 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/compose-spec/compose-go/v2/cli"
+	"github.com/compose-spec/compose-go/v2/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// Backends selected with backend in the config or --backend
+const (
+	backendShell = "shell"
+	backendAPI   = "api"
+)
+
+// Backend carries out the operations that have typed results. ShellBackend
+// runs the compose command; APIBackend talks to the Docker Engine API.
+type Backend interface {
+	// Status returns the state of every container of the project
+	Status(ctx context.Context) ([]ServiceStatus, error)
+	// Logs writes the logs of the containers of services to out, of the
+	// services in scope when none are given. When following, it returns
+	// once ctx is done.
+	Logs(ctx context.Context, services []string, opts LogOptions, out io.Writer) error
+	// Start starts services, those in scope when none are given
+	Start(ctx context.Context, services []string) error
+}
+
+// backend returns the backend selected by --backend or the config
+func (dcm *Manager) backend() (Backend, error) {
+	name := dcm.backendName
+	if name == "" {
+		name = dcm.config.Backend
+	}
+	switch name {
+	case "", backendShell:
+		return &ShellBackend{dcm: dcm}, nil
+	case backendAPI:
+		return newAPIBackend(dcm)
+	}
+	return nil, NewError(ErrConfig, "unknown backend %q, expected %s or %s", name, backendShell, backendAPI)
+}
+
+// ShellBackend runs the compose command
+type ShellBackend struct {
+	dcm *Manager
+}
+
+// Status uses the JSON output of newer compose versions and falls back to
+// parsing the table printed by older ones
+func (b *ShellBackend) Status(ctx context.Context) ([]ServiceStatus, error) {
+	output, err := b.dcm.composeOutputContext(ctx, "ps", "-a", "--format", "json")
+	if err == nil {
+		return parseComposePsJSON(output)
+	}
+
+	table, tableErr := b.dcm.composeOutputContext(ctx, "ps", "-a")
+	if tableErr != nil {
+		return nil, tableErr
+	}
+	return parseComposePsTable(table, b.dcm.ProjectName()), nil
+}
+
+// Logs streams compose logs. With Compose V2 each service is followed by a
+// compose process of its own and the lines are multiplexed here, prefixed
+// with the service name; docker-compose v1 cannot leave out its own prefix,
+// so its output is passed through.
+func (b *ShellBackend) Logs(ctx context.Context, services []string, opts LogOptions, out io.Writer) error {
+	args := []string{"logs"}
+	if opts.Follow {
+		args = append(args, "-f")
+	}
+	if opts.Tail >= 0 {
+		args = append(args, "--tail", strconv.Itoa(opts.Tail))
+	}
+	if opts.Since != "" {
+		args = append(args, "--since", opts.Since)
+	}
+	if len(services) == 0 {
+		services = b.dcm.scopedServices()
+	}
+	if b.dcm.composeMajorVersion() >= 2 {
+		if len(services) == 0 {
+			var err error
+			if services, err = b.dcm.composeServices(); err != nil {
+				return err
+			}
+		}
+		err := b.multiplexLogs(ctx, services, append(args, "--no-color", "--no-log-prefix"), out, logColor(out, opts))
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+
+	if opts.NoColor {
+		args = append(args, "--no-color")
+	}
+	argv, err := b.dcm.composeArgs(append(args, services...)...)
+	if err != nil {
+		return err
+	}
+	err = b.dcm.executeStreaming(ctx, argv, out, os.Stderr)
+	if ctx.Err() != nil {
+		// Stopped following on purpose
+		return nil
+	}
+	return err
+}
+
+// multiplexLogs runs compose logs args for each service at once, reading
+// their output through pipes, and writes the lines to out as they arrive
+func (b *ShellBackend) multiplexLogs(ctx context.Context, services, args []string, out io.Writer, color bool) error {
+	argvs := make([][]string, len(services))
+	for i, name := range services {
+		argv, err := b.dcm.composeArgs(append(append([]string(nil), args...), name)...)
+		if err != nil {
+			return err
+		}
+		argvs[i] = argv
+	}
+
+	// A service that cannot be followed stops the others
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	mux := newLogMux(out, services, color)
+	var wg sync.WaitGroup
+	errs := make(chan error, len(services))
+	for i, name := range services {
+		argv := argvs[i]
+		b.dcm.Infof("Executing: %s\n", quoteArgs(argv))
+		cmd := b.dcm.command(ctx, argv)
+		cmd.Stderr = os.Stderr
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			errs <- err
+			cancel()
+			break
+		}
+		started := time.Now()
+		if err := cmd.Start(); err != nil {
+			b.dcm.logCommand(argv, started, err)
+			errs <- err
+			cancel()
+			break
+		}
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			// Wait closes the pipe, so it comes after reading everything
+			readErr := mux.copy(name, stdout)
+			err := cmd.Wait()
+			b.dcm.logCommand(argv, started, err)
+			switch {
+			case err != nil:
+				errs <- fmt.Errorf("logs of %s: %w", name, err)
+			case readErr != nil:
+				errs <- fmt.Errorf("reading logs of %s: %v", name, readErr)
+			}
+		}(name)
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+// Start runs compose up with the replica counts of the config
+func (b *ShellBackend) Start(ctx context.Context, services []string) error {
+	argv, err := b.dcm.upCommand(services)
+	if err != nil {
+		return err
+	}
+	return b.dcm.executeStreaming(ctx, argv, os.Stdout, os.Stderr)
+}
+
+// The labels compose sets on the containers of a project
+const (
+	composeProjectLabel = "com.docker.compose.project"
+	composeServiceLabel = "com.docker.compose.service"
+)
+
+// APIBackend talks to the Docker Engine API with the Docker SDK and reads
+// the compose files with compose-go. The containers of the project are found
+// by the labels compose sets on them.
+type APIBackend struct {
+	dcm    *Manager
+	client *client.Client
+}
+
+// newAPIBackend connects to the daemon of the docker_host of the config or
+// else of DOCKER_HOST, the default local socket when neither is set
+func newAPIBackend(dcm *Manager) (*APIBackend, error) {
+	host, err := dcm.dockerHost()
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(host, "ssh://") {
+		return nil, NewError(ErrConfig, "DOCKER_HOST %s: the api backend does not support ssh:// hosts, use the shell backend", host)
+	}
+	endpoint := dcm.config.Endpoint
+	endpoint.Host = host
+	docker, err := dcm.dockerClients.client(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return &APIBackend{dcm: dcm, client: docker}, nil
+}
+
+// dockerClients keeps the Engine API clients of the api backend, one per
+// endpoint, so every operation reuses the connections of the first rather
+// than opening its own. The copies of the manager for other projects share
+// it; Close closes the clients.
+type dockerClients struct {
+	mu      sync.Mutex
+	clients map[DockerEndpoint]*client.Client
+}
+
+// client returns the client of an endpoint whose Host is resolved, empty for
+// the default local socket, connecting on first use
+func (c *dockerClients) client(endpoint DockerEndpoint) (*client.Client, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if docker, ok := c.clients[endpoint]; ok {
+		return docker, nil
+	}
+	var opts []client.Opt
+	if endpoint.Host != "" {
+		if endpoint.TLSVerify {
+			config, err := endpoint.tlsConfig()
+			if err != nil {
+				return nil, err
+			}
+			// WithHost sets the dialer of this transport up for the host
+			opts = append(opts, client.WithHTTPClient(&http.Client{
+				Transport: &http.Transport{TLSClientConfig: config},
+			}))
+		}
+		opts = append(opts, client.WithHost(endpoint.Host))
+	}
+	docker, err := client.NewClientWithOpts(append(opts, client.WithAPIVersionNegotiation())...)
+	if err != nil {
+		return nil, NewError(ErrConfig, "DOCKER_HOST: %v", err)
+	}
+	if c.clients == nil {
+		c.clients = make(map[DockerEndpoint]*client.Client)
+	}
+	c.clients[endpoint] = docker
+	return docker, nil
+}
+
+// close closes the clients and forgets them
+func (c *dockerClients) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var first error
+	for endpoint, docker := range c.clients {
+		if err := docker.Close(); err != nil && first == nil {
+			first = err
+		}
+		delete(c.clients, endpoint)
+	}
+	return first
+}
+
+// Close releases the connections the manager keeps open, those of the api
+// backend to the Docker Engine. The manager connects again if used after.
+func (dcm *Manager) Close() error {
+	return dcm.dockerClients.close()
+}
+
+// project loads the compose files of the config with compose-go, with
+// variables substituted from the environment compose commands get
+func (b *APIBackend) project(ctx context.Context) (*types.Project, error) {
+	env := b.dcm.env
+	if env == nil {
+		env = os.Environ()
+	}
+	fns := []cli.ProjectOptionsFn{cli.WithEnv(env)}
+	if name := b.dcm.ProjectName(); name != "" {
+		fns = append(fns, cli.WithName(name))
+	}
+	if dir := b.dcm.config.WorkingDir; dir != "" {
+		fns = append(fns, cli.WithWorkingDirectory(dir))
+	}
+	if file := b.dcm.config.EnvFile; file != "" {
+		fns = append(fns, cli.WithEnvFiles(file))
+	}
+	fns = append(fns, cli.WithDotEnv)
+
+	files := append(append([]string(nil), b.dcm.config.composeFiles()...), b.dcm.overlays...)
+	if b.dcm.portsOverride != "" {
+		files = append(files, b.dcm.portsOverride)
+	}
+	opts, err := cli.NewProjectOptions(files, fns...)
+	if err != nil {
+		return nil, NewError(ErrConfig, "%v", err)
+	}
+	project, err := opts.LoadProject(ctx)
+	if err != nil {
+		return nil, NewError(ErrConfig, "loading the compose files: %v", err)
+	}
+	return project, nil
+}
+
+// containers lists the containers of the project, running or not
+func (b *APIBackend) containers(ctx context.Context) ([]container.Summary, error) {
+	containers, err := b.client.ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", composeProjectLabel+"="+b.dcm.ProjectName())),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("docker engine API: %w", err)
+	}
+	return containers, nil
+}
+
+var (
+	// apiHealth finds the health in a container status, "Up 2 minutes (healthy)"
+	apiHealth = regexp.MustCompile(`\((healthy|unhealthy|health: starting)\)`)
+	// apiExitCode finds the exit code in a container status, "Exited (1) 3 minutes ago"
+	apiExitCode = regexp.MustCompile(`^Exited \((\d+)\)`)
+)
+
+// containerName returns the name of a listed container, without the slash
+// the API puts in front
+func containerName(c container.Summary) string {
+	if len(c.Names) == 0 {
+		return shortID(c.ID)
+	}
+	return strings.TrimPrefix(c.Names[0], "/")
+}
+
+// containerStatus converts a listed container into the status compose
+// reports
+func containerStatus(c container.Summary) ServiceStatus {
+	s := ServiceStatus{
+		Service: c.Labels[composeServiceLabel],
+		Name:    containerName(c),
+		ID:      shortID(c.ID),
+		Image:   c.Image,
+		State:   string(c.State),
+		Uptime:  uptimeFromStatus(c.Status),
+	}
+	if m := apiHealth.FindStringSubmatch(c.Status); m != nil {
+		s.Health = strings.TrimPrefix(m[1], "health: ")
+	}
+	if m := apiExitCode.FindStringSubmatch(c.Status); m != nil {
+		s.ExitCode, _ = strconv.Atoi(m[1])
+	}
+	for _, p := range c.Ports {
+		if p.PublicPort == 0 {
+			s.Ports = append(s.Ports, fmt.Sprintf("%d/%s", p.PrivatePort, p.Type))
+			continue
+		}
+		s.Ports = append(s.Ports, fmt.Sprintf("%s:%d->%d/%s", p.IP, p.PublicPort, p.PrivatePort, p.Type))
+	}
+	return s
+}
+
+// Status lists the containers of the project
+func (b *APIBackend) Status(ctx context.Context) ([]ServiceStatus, error) {
+	containers, err := b.containers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]ServiceStatus, 0, len(containers))
+	for _, c := range containers {
+		statuses = append(statuses, containerStatus(c))
+	}
+	return statuses, nil
+}
+
+// serviceContainers returns the containers of the given services, or of the
+// services in scope, or of the whole project
+func (b *APIBackend) serviceContainers(ctx context.Context, services []string) ([]container.Summary, error) {
+	if len(services) == 0 {
+		services = b.dcm.scopedServices()
+	}
+	containers, err := b.containers(ctx)
+	if err != nil || len(services) == 0 {
+		return containers, err
+	}
+	wanted := make(map[string]bool)
+	for _, name := range services {
+		wanted[name] = true
+	}
+	var selected []container.Summary
+	for _, c := range containers {
+		if wanted[c.Labels[composeServiceLabel]] {
+			selected = append(selected, c)
+		}
+	}
+	return selected, nil
+}
+
+// Logs reads the logs of every container concurrently, prefixing each line
+// with the container name like compose does
+func (b *APIBackend) Logs(ctx context.Context, services []string, opts LogOptions, out io.Writer) error {
+	containers, err := b.serviceContainers(ctx, services)
+	if err != nil {
+		return err
+	}
+	logOpts := container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     opts.Follow,
+		// The SDK takes durations such as 10m as well as timestamps
+		Since: opts.Since,
+	}
+	if opts.Tail >= 0 {
+		logOpts.Tail = strconv.Itoa(opts.Tail)
+	}
+
+	names := make([]string, len(containers))
+	for i, c := range containers {
+		names[i] = containerName(c)
+	}
+	mux := newLogMux(out, names, logColor(out, opts))
+	var wg sync.WaitGroup
+	errs := make(chan error, len(containers))
+	for i, c := range containers {
+		wg.Add(1)
+		go func(c container.Summary, name string) {
+			defer wg.Done()
+			logs, err := b.containerLogs(ctx, c.ID, logOpts)
+			if err != nil {
+				errs <- fmt.Errorf("logs of %s: %w", name, err)
+				return
+			}
+			defer logs.Close()
+			if err := mux.copy(name, logs); err != nil && ctx.Err() == nil {
+				errs <- fmt.Errorf("reading logs of %s: %v", name, err)
+			}
+		}(c, names[i])
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+// containerLogs returns the logs of a container. Without a terminal the
+// API frames stdout and stderr, which are split apart again here.
+func (b *APIBackend) containerLogs(ctx context.Context, id string, opts container.LogsOptions) (io.ReadCloser, error) {
+	info, err := b.client.ContainerInspect(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	logs, err := b.client.ContainerLogs(ctx, id, opts)
+	if err != nil || (info.Config != nil && info.Config.Tty) {
+		return logs, err
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := stdcopy.StdCopy(pw, pw, logs)
+		logs.Close()
+		pw.CloseWithError(err)
+	}()
+	return pr, nil
+}
+
+// Start starts the existing containers of services through the API, each
+// service with the replica count of the scale section of the config, or
+// else of its compose file. Creating or removing containers needs compose,
+// so the services without the right number of containers are started with
+// compose instead.
+func (b *APIBackend) Start(ctx context.Context, services []string) error {
+	project, err := b.project(ctx)
+	if err != nil {
+		return err
+	}
+	if len(services) == 0 {
+		services = b.dcm.scopedServices()
+	}
+	if len(services) == 0 {
+		services = project.ServiceNames()
+	}
+	replicas := make(map[string]int)
+	for _, name := range services {
+		// A service of a profile that is not enabled is left to compose
+		if service, ok := project.Services[name]; ok {
+			replicas[name] = service.GetScale()
+		}
+	}
+	scaled, err := b.dcm.configuredScale(services)
+	if err != nil {
+		return err
+	}
+	for _, t := range scaled {
+		replicas[t.Service] = t.Replicas
+	}
+
+	containers, err := b.serviceContainers(ctx, services)
+	if err != nil {
+		return err
+	}
+	byService := make(map[string][]container.Summary)
+	for _, c := range containers {
+		service := c.Labels[composeServiceLabel]
+		byService[service] = append(byService[service], c)
+	}
+	var viaCompose []string
+	for _, service := range services {
+		want, ok := replicas[service]
+		if !ok || len(byService[service]) != want {
+			viaCompose = append(viaCompose, service)
+			continue
+		}
+		for _, c := range byService[service] {
+			if c.State == container.StateRunning {
+				continue
+			}
+			name := containerName(c)
+			if b.dcm.dryRun {
+				fmt.Printf("Would start container %s\n", name)
+				continue
+			}
+			b.dcm.Infof("Starting container %s via the Docker Engine API\n", name)
+			if err := b.client.ContainerStart(ctx, c.ID, container.StartOptions{}); err != nil {
+				return fmt.Errorf("starting %s: %w", name, err)
+			}
+		}
+	}
+	if len(viaCompose) == 0 {
+		return nil
+	}
+	return (&ShellBackend{dcm: b.dcm}).Start(ctx, viaCompose)
+}
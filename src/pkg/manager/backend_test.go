@@ -0,0 +1,103 @@
+package manager
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeDaemon answers the Engine API calls of the api backend: it lists
+// containers and records the ones started
+type fakeDaemon struct {
+	containers string
+	mu         sync.Mutex
+	started    []string
+}
+
+func (d *fakeDaemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Api-Version", "1.47")
+	switch path := r.URL.Path; {
+	case path == "/_ping":
+		w.Write([]byte("OK"))
+	case strings.HasSuffix(path, "/containers/json"):
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(d.containers))
+	case r.Method == "POST" && strings.HasSuffix(path, "/start"):
+		parts := strings.Split(path, "/")
+		d.mu.Lock()
+		d.started = append(d.started, parts[len(parts)-2])
+		d.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestAPIBackendStartAppliesScale(t *testing.T) {
+	daemon := &fakeDaemon{containers: `[
+		{"Id":"aaa","Names":["/test-web-1"],"State":"exited","Labels":{"com.docker.compose.project":"test","com.docker.compose.service":"web"}},
+		{"Id":"bbb","Names":["/test-worker-1"],"State":"exited","Labels":{"com.docker.compose.project":"test","com.docker.compose.service":"worker"}},
+		{"Id":"ccc","Names":["/test-db-1"],"State":"running","Labels":{"com.docker.compose.project":"test","com.docker.compose.service":"db"}}
+	]`}
+	server := httptest.NewServer(daemon)
+	defer server.Close()
+
+	runner := &fakeRunner{}
+	config := "backend: api\ndocker_host: tcp://" + strings.TrimPrefix(server.URL, "http://") + "\nscale:\n  worker: 2\n"
+	dcm := newTestManager(t, config, "", runner)
+
+	if err := dcm.Start(context.Background(), StartOptions{}); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	// web has its one container and db is running already; worker needs
+	// a second replica, which only compose can create
+	if want := []string{"aaa"}; !reflect.DeepEqual(daemon.started, want) {
+		t.Errorf("started %q through the API, want %q", daemon.started, want)
+	}
+	ups := runner.ran(" up ")
+	if len(ups) != 1 || !strings.HasSuffix(ups[0], " up -d --scale worker=2 worker") {
+		t.Errorf("ran %q, want compose up scaling worker to 2", ups)
+	}
+}
+
+func TestAPIBackendReusesItsClient(t *testing.T) {
+	server := httptest.NewServer(&fakeDaemon{containers: "[]"})
+	defer server.Close()
+	config := "backend: api\ndocker_host: tcp://" + strings.TrimPrefix(server.URL, "http://") + "\n"
+	dcm := newTestManager(t, config, "", &fakeRunner{})
+
+	clients := make(map[interface{}]bool)
+	for i := 0; i < 3; i++ {
+		backend, err := dcm.backend()
+		if err != nil {
+			t.Fatal(err)
+		}
+		clients[backend.(*APIBackend).client] = true
+	}
+	project, err := dcm.forProject("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend, err := project.backend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients[backend.(*APIBackend).client] = true
+	if len(clients) != 1 {
+		t.Errorf("backend created %d clients, want one shared", len(clients))
+	}
+
+	if err := dcm.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if n := len(dcm.dockerClients.clients); n != 0 {
+		t.Errorf("%d clients left after Close", n)
+	}
+	if _, err := dcm.Status(context.Background()); err != nil {
+		t.Errorf("Status after Close: %v", err)
+	}
+}
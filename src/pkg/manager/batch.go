@@ -0,0 +1,180 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BatchCommands maps the verbs that can run as a batch to the docker-compose
+// arguments applied to each service
+var BatchCommands = map[string][]string{
+	"start":   {"up", "-d"},
+	"stop":    {"stop"},
+	"restart": {"restart"},
+	"remove":  {"rm", "-f"},
+	"pull":    {"pull"},
+}
+
+// BatchOptions controls how an operation runs across several services
+type BatchOptions struct {
+	// Timeout bounds each service's operation, zero means no limit
+	Timeout time.Duration
+	// ContinueOnError keeps going after a service fails or times out
+	// instead of skipping the services not started yet
+	ContinueOnError bool
+	// Workers is the number of services processed concurrently
+	Workers int
+}
+
+// batchResult is the outcome of a batch operation for one service
+type batchResult struct {
+	Service  string
+	Err      error
+	TimedOut bool
+	// Aborted is set for services skipped or interrupted after another
+	// service failed
+	Aborted bool
+}
+
+// RunBatch runs verb against each service through a bounded worker pool,
+// giving every service its own timeout so a stuck one does not hold up the
+// rest. With no services given it runs against the configured services, or
+// against every service of the compose files when none are configured or
+// --all is set.
+func (dcm *Manager) RunBatch(verb string, services []string, opts BatchOptions) error {
+	args, ok := BatchCommands[verb]
+	if !ok {
+		return fmt.Errorf("%s cannot run as a batch", verb)
+	}
+	if verb == "start" {
+		args = dcm.upArgs()
+	}
+	if len(services) == 0 {
+		services = dcm.scopedServices()
+	}
+	if len(services) == 0 {
+		all, err := dcm.composeServices()
+		if err != nil {
+			return err
+		}
+		services = all
+	}
+	for _, service := range services {
+		if err := dcm.checkService(service); err != nil {
+			return err
+		}
+	}
+	// Each service gets a compose command of its own, so starting one
+	// applies its replica count from the scale section of the config
+	serviceArgs := make([][]string, len(services))
+	for i, service := range services {
+		serviceArgs[i] = append([]string(nil), args...)
+		if verb == "start" {
+			scaled, err := dcm.configuredScale([]string{service})
+			if err != nil {
+				return err
+			}
+			for _, t := range scaled {
+				serviceArgs[i] = append(serviceArgs[i], "--scale", fmt.Sprintf("%s=%d", t.Service, t.Replicas))
+			}
+		}
+	}
+	if opts.Workers < 1 {
+		opts.Workers = 1
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	results := make([]batchResult, len(services))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < opts.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = dcm.runBatchItem(ctx, services[i], serviceArgs[i], opts.Timeout)
+				if results[i].Err != nil && !opts.ContinueOnError {
+					cancel()
+				}
+			}
+		}()
+	}
+	for i := range services {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return reportBatch(verb, "services", results)
+}
+
+// runBatchItem runs one service's operation under its own timeout
+func (dcm *Manager) runBatchItem(ctx context.Context, service string, args []string, timeout time.Duration) batchResult {
+	result := batchResult{Service: service}
+	if ctx.Err() != nil {
+		result.Aborted = true
+		return result
+	}
+
+	itemCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		itemCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	argv, err := dcm.composeArgs(append(append([]string(nil), args...), service)...)
+	if err == nil {
+		_, err = dcm.executeCommandContext(itemCtx, argv)
+	}
+	if err != nil {
+		result.Err = err
+		result.TimedOut = itemCtx.Err() == context.DeadlineExceeded
+		result.Aborted = ctx.Err() == context.Canceled
+	}
+	return result
+}
+
+// reportBatch prints which services, or other items named by noun,
+// succeeded, timed out, failed or were aborted, and returns an error when
+// any of them did not succeed.
+func reportBatch(verb, noun string, results []batchResult) error {
+	var ok, timedOut, failed, aborted []string
+	for _, r := range results {
+		switch {
+		case r.Aborted:
+			aborted = append(aborted, r.Service)
+		case r.TimedOut:
+			timedOut = append(timedOut, r.Service)
+		case r.Err != nil:
+			failed = append(failed, fmt.Sprintf("%s (%v)", r.Service, r.Err))
+		default:
+			ok = append(ok, r.Service)
+		}
+	}
+
+	fmt.Printf("\n%s summary:\n", verb)
+	for _, group := range []struct {
+		label    string
+		services []string
+	}{
+		{"succeeded", ok},
+		{"timed out", timedOut},
+		{"failed", failed},
+		{"aborted", aborted},
+	} {
+		if len(group.services) > 0 {
+			fmt.Printf("  %-10s %s\n", group.label+":", strings.Join(group.services, ", "))
+		}
+	}
+
+	if incomplete := len(timedOut) + len(failed) + len(aborted); incomplete > 0 {
+		return fmt.Errorf("%s did not complete for %d of %d %s", verb, incomplete, len(results), noun)
+	}
+	return nil
+}
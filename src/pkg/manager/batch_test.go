@@ -0,0 +1,119 @@
+package manager
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowService makes the compose commands of service hang until cancelled
+func slowService(runner *fakeRunner, service string) {
+	runner.handle(" "+service, func(ctx context.Context, _ *Cmd) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+}
+
+func TestBatchSlowServiceDoesNotBlockOthers(t *testing.T) {
+	for _, workers := range []int{1, 3} {
+		runner := &fakeRunner{}
+		slowService(runner, "web")
+		dcm := newTestManager(t, "", "", runner)
+
+		started := time.Now()
+		err := dcm.RunBatch("stop", []string{"web", "worker", "db"}, BatchOptions{
+			Timeout:         100 * time.Millisecond,
+			ContinueOnError: true,
+			Workers:         workers,
+		})
+		if err == nil || !strings.Contains(err.Error(), "1 of 3") {
+			t.Errorf("%d workers: error = %v, want the batch incomplete for 1 of 3 services", workers, err)
+		}
+		if elapsed := time.Since(started); elapsed > 5*time.Second {
+			t.Errorf("%d workers: batch took %s", workers, elapsed)
+		}
+		for _, service := range []string{"worker", "db"} {
+			if len(runner.ran("stop "+service)) != 1 {
+				t.Errorf("%d workers: %s was not stopped: %q", workers, service, runner.commands())
+			}
+		}
+	}
+}
+
+func TestBatchItemTimeoutIsReportedApart(t *testing.T) {
+	runner := &fakeRunner{}
+	slowService(runner, "web")
+	runner.fail(" db", 1, "")
+	dcm := newTestManager(t, "", "", runner)
+
+	slow := dcm.runBatchItem(context.Background(), "web", []string{"stop"}, 50*time.Millisecond)
+	if !slow.TimedOut || slow.Aborted {
+		t.Errorf("slow service = %+v, want timed out", slow)
+	}
+	failed := dcm.runBatchItem(context.Background(), "db", []string{"stop"}, time.Second)
+	if failed.Err == nil || failed.TimedOut {
+		t.Errorf("failing service = %+v, want an error without a timeout", failed)
+	}
+}
+
+func TestBatchAbortsWithoutContinueOnError(t *testing.T) {
+	runner := &fakeRunner{}
+	slowService(runner, "web")
+	dcm := newTestManager(t, "", "", runner)
+
+	err := dcm.RunBatch("stop", []string{"web", "worker", "db"}, BatchOptions{
+		Timeout: 50 * time.Millisecond,
+		Workers: 1,
+	})
+	if err == nil {
+		t.Fatal("batch succeeded")
+	}
+	if stopped := runner.ran("stop "); len(stopped) != 1 {
+		t.Errorf("commands after the timeout = %q, want the other services skipped", stopped)
+	}
+}
+
+func TestBatchStartAppliesConfiguredScale(t *testing.T) {
+	runner := &fakeRunner{}
+	var mu sync.Mutex
+	ups := make(map[string]string)
+	runner.handle(" up ", func(_ context.Context, cmd *Cmd) error {
+		mu.Lock()
+		defer mu.Unlock()
+		ups[cmd.Args[len(cmd.Args)-1]] = strings.Join(cmd.Args, " ")
+		return nil
+	})
+	dcm := newTestManager(t, "scale:\n  worker: 2\n  db: 3\n", "", runner)
+
+	if err := dcm.RunBatch("start", []string{"web", "worker"}, BatchOptions{Workers: 2}); err != nil {
+		t.Fatalf("RunBatch: %v", err)
+	}
+	if !strings.Contains(ups["worker"], "--scale worker=2 worker") {
+		t.Errorf("worker started with %q, want --scale worker=2", ups["worker"])
+	}
+	if strings.Contains(ups["web"], "--scale") {
+		t.Errorf("web started with %q, want no --scale", ups["web"])
+	}
+}
+
+func TestRunProjectItemStartAppliesScale(t *testing.T) {
+	runner := &fakeRunner{}
+	dcm := newTestManager(t, "scale:\n  worker: 2\n  db: 1\n", "", runner)
+	mux := newLogMux(io.Discard, []string{"test"}, false)
+
+	if result := dcm.runProjectItem("start", "test", mux, 0); result.Err != nil {
+		t.Fatalf("runProjectItem: %v", result.Err)
+	}
+	ups := runner.ran(" up ")
+	if len(ups) != 1 || !strings.HasSuffix(ups[0], " up -d --scale db=1 --scale worker=2") {
+		t.Errorf("ran %q, want compose up with the scale of the config", ups)
+	}
+
+	dcm = newTestManager(t, "scale:\n  worker: -1\n", "", runner)
+	if result := dcm.runProjectItem("start", "test", mux, 0); TypeOf(result.Err) != ErrConfig {
+		t.Errorf("runProjectItem with a negative scale = %v, want a %s error", result.Err, ErrConfig)
+	}
+}
@@ -0,0 +1,253 @@
+package manager
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v2"
+)
+
+// templateManifestFile is the file a template directory must contain to
+// declare its variables.
+const templateManifestFile = "dcm-template.yml"
+
+// TemplateVariable describes a variable a template expects
+type TemplateVariable struct {
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+	Default     string `yaml:"default"`
+	Required    bool   `yaml:"required"`
+}
+
+// TemplateManifest is the manifest shipped with every bootstrap template
+type TemplateManifest struct {
+	Name        string             `yaml:"name"`
+	Description string             `yaml:"description"`
+	Variables   []TemplateVariable `yaml:"variables"`
+}
+
+// BootstrapOptions controls how a project is bootstrapped from a template
+type BootstrapOptions struct {
+	Repo     string
+	Template string
+	Vars     map[string]string
+	Force    bool
+	DestDir  string
+	Input    io.Reader
+}
+
+// Bootstrap clones a template repository and renders the selected template
+// into the destination directory.
+func Bootstrap(opts BootstrapOptions) ([]string, error) {
+	if opts.Repo == "" {
+		return nil, fmt.Errorf("no template repository given")
+	}
+	if strings.Contains(opts.Template, "..") {
+		return nil, fmt.Errorf("invalid template name %q", opts.Template)
+	}
+	if opts.DestDir == "" {
+		opts.DestDir = "."
+	}
+	if opts.Input == nil {
+		opts.Input = os.Stdin
+	}
+
+	cloneDir, err := ioutil.TempDir("", "dcm-bootstrap-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(cloneDir)
+
+	fmt.Printf("Cloning %s...\n", opts.Repo)
+	// -- keeps a repository starting with a dash from being read as an option
+	cmd := exec.Command("git", "clone", "--depth", "1", "--", opts.Repo, cloneDir)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("cloning %s: %v\n%s", opts.Repo, err, output)
+	}
+
+	templateDir := filepath.Join(cloneDir, opts.Template)
+	// A template that is a symlink could point anywhere on this machine
+	info, err := os.Lstat(templateDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("template %s not found in %s", opts.Template, opts.Repo)
+		}
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("template %s is not a directory", opts.Template)
+	}
+	manifest, err := loadTemplateManifest(templateDir)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := resolveTemplateVars(manifest, opts.Vars, opts.Input)
+	if err != nil {
+		return nil, err
+	}
+
+	rendered, err := renderTemplateDir(templateDir, values)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check every destination before writing anything so a conflict never
+	// leaves a half-bootstrapped directory behind
+	names := make([]string, 0, len(rendered))
+	for name := range rendered {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if !opts.Force {
+		for _, name := range names {
+			dest := filepath.Join(opts.DestDir, name)
+			if _, err := os.Stat(dest); err == nil {
+				return nil, fmt.Errorf("%s already exists (use --force to overwrite)", dest)
+			}
+		}
+	}
+
+	for _, name := range names {
+		dest := filepath.Join(opts.DestDir, name)
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(dest, rendered[name], 0644); err != nil {
+			return nil, err
+		}
+		fmt.Printf("Wrote %s\n", dest)
+	}
+	return names, nil
+}
+
+// loadTemplateManifest reads and validates the manifest of a template directory
+func loadTemplateManifest(dir string) (*TemplateManifest, error) {
+	path := filepath.Join(dir, templateManifestFile)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("template has no %s manifest", templateManifestFile)
+		}
+		return nil, err
+	}
+
+	var manifest TemplateManifest
+	if err := yaml.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", templateManifestFile, err)
+	}
+
+	seen := make(map[string]bool)
+	for _, v := range manifest.Variables {
+		if v.Name == "" {
+			return nil, fmt.Errorf("%s declares a variable without a name", templateManifestFile)
+		}
+		if seen[v.Name] {
+			return nil, fmt.Errorf("%s declares variable %s twice", templateManifestFile, v.Name)
+		}
+		seen[v.Name] = true
+	}
+	return &manifest, nil
+}
+
+// resolveTemplateVars checks the given values against the manifest and
+// prompts for any declared variable that was not provided.
+func resolveTemplateVars(manifest *TemplateManifest, given map[string]string, input io.Reader) (map[string]string, error) {
+	declared := make(map[string]bool)
+	for _, v := range manifest.Variables {
+		declared[v.Name] = true
+	}
+	for name := range given {
+		if !declared[name] {
+			return nil, fmt.Errorf("template does not declare variable %s", name)
+		}
+	}
+
+	values := make(map[string]string)
+	reader := bufio.NewReader(input)
+	for _, v := range manifest.Variables {
+		if val, ok := given[v.Name]; ok {
+			values[v.Name] = val
+			continue
+		}
+
+		prompt := v.Name
+		if v.Description != "" {
+			prompt = fmt.Sprintf("%s (%s)", v.Name, v.Description)
+		}
+		if v.Default != "" {
+			prompt = fmt.Sprintf("%s [%s]", prompt, v.Default)
+		}
+		fmt.Printf("%s: ", prompt)
+
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		val := strings.TrimSpace(line)
+		if val == "" {
+			val = v.Default
+		}
+		if val == "" && v.Required {
+			return nil, fmt.Errorf("variable %s is required", v.Name)
+		}
+		values[v.Name] = val
+	}
+	return values, nil
+}
+
+// renderTemplateDir renders every file of the template directory except the
+// manifest. A trailing .tmpl extension is dropped from the output name.
+// Symlinks are refused, a template must not read files outside its
+// repository.
+func renderTemplateDir(dir string, values map[string]string) (map[string][]byte, error) {
+	rendered := make(map[string][]byte)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			rel, _ := filepath.Rel(dir, path)
+			return fmt.Errorf("template file %s is a symlink, which is not supported", rel)
+		}
+		if info.IsDir() {
+			if info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if rel == templateManifestFile {
+			return nil
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		tmpl, err := template.New(rel).Option("missingkey=error").Parse(string(data))
+		if err != nil {
+			return fmt.Errorf("parsing template %s: %v", rel, err)
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, values); err != nil {
+			return fmt.Errorf("rendering template %s: %v", rel, err)
+		}
+		rendered[strings.TrimSuffix(rel, ".tmpl")] = buf.Bytes()
+		return nil
+	})
+	return rendered, err
+}
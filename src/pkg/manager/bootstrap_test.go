@@ -0,0 +1,30 @@
+package manager
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRenderTemplateDirRejectsSymlinks(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(secret, []byte("password"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "compose.yaml.tmpl"), []byte("name: {{.name}}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	rendered, err := renderTemplateDir(dir, map[string]string{"name": "demo"})
+	if err != nil || string(rendered["compose.yaml"]) != "name: demo\n" {
+		t.Fatalf("renderTemplateDir = %q, %v", rendered, err)
+	}
+
+	if err := os.Symlink(secret, filepath.Join(dir, ".env")); err != nil {
+		t.Skipf("cannot create symlinks: %v", err)
+	}
+	if _, err := renderTemplateDir(dir, nil); err == nil || !strings.Contains(err.Error(), ".env is a symlink") {
+		t.Errorf("renderTemplateDir = %v, want the symlink refused", err)
+	}
+}
@@ -0,0 +1,135 @@
+package manager
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// BuildResult is the outcome of building one service, with the BuildKit
+// cache usage of its steps
+type BuildResult struct {
+	Service string `json:"service"`
+	// Steps counts the Dockerfile steps, Cached those served from the
+	// cache and Executed those that ran
+	Steps    int     `json:"steps"`
+	Cached   int     `json:"cached"`
+	Executed int     `json:"executed"`
+	HitRatio float64 `json:"cache_hit_ratio"`
+	Error    string  `json:"error,omitempty"`
+}
+
+var (
+	// buildkitStep matches the line starting a Dockerfile step in plain
+	// progress output, e.g. "#7 [builder 2/5] RUN go mod download"
+	buildkitStep = regexp.MustCompile(`^#(\d+) \[[^\]]*\d+/\d+\] `)
+	// buildkitStatus matches the line ending a step, "#7 CACHED" or "#7 DONE 1.2s"
+	buildkitStatus = regexp.MustCompile(`^#(\d+) (CACHED|DONE|ERROR)\b`)
+)
+
+// buildkitParser counts cached and executed steps in BuildKit plain progress
+// output written through it
+type buildkitParser struct {
+	steps   map[string]bool
+	status  map[string]string
+	partial []byte
+}
+
+func newBuildkitParser() *buildkitParser {
+	return &buildkitParser{steps: make(map[string]bool), status: make(map[string]string)}
+}
+
+func (p *buildkitParser) Write(data []byte) (int, error) {
+	p.partial = append(p.partial, data...)
+	for {
+		i := bytes.IndexByte(p.partial, '\n')
+		if i < 0 {
+			break
+		}
+		p.parseLine(strings.TrimRight(string(p.partial[:i]), "\r"))
+		p.partial = p.partial[i+1:]
+	}
+	return len(data), nil
+}
+
+func (p *buildkitParser) parseLine(line string) {
+	if m := buildkitStep.FindStringSubmatch(line); m != nil {
+		p.steps[m[1]] = true
+		return
+	}
+	// A step reported CACHED keeps that status even if DONE follows
+	if m := buildkitStatus.FindStringSubmatch(line); m != nil && p.status[m[1]] != "CACHED" {
+		p.status[m[1]] = m[2]
+	}
+}
+
+// result summarizes the steps seen so far
+func (p *buildkitParser) result(service string) BuildResult {
+	if len(p.partial) > 0 {
+		p.parseLine(string(p.partial))
+		p.partial = nil
+	}
+	r := BuildResult{Service: service, Steps: len(p.steps)}
+	for id := range p.steps {
+		switch p.status[id] {
+		case "CACHED":
+			r.Cached++
+		case "DONE":
+			r.Executed++
+		}
+	}
+	if r.Steps > 0 {
+		r.HitRatio = float64(r.Cached) / float64(r.Steps)
+	}
+	return r
+}
+
+// BuildCacheStats builds services like Build, one at a time with BuildKit
+// plain progress, and reports how many of the steps of each were cached
+func (dcm *Manager) BuildCacheStats(serviceName string) ([]BuildResult, error) {
+	dcm.Infof("Building services...\n")
+	if serviceName != "" {
+		if err := dcm.checkService(serviceName); err != nil {
+			return nil, err
+		}
+	}
+	plan, _, err := dcm.buildPlan(serviceName)
+	if err != nil {
+		return nil, err
+	}
+	if scope := dcm.scopedServices(); serviceName == "" && len(scope) > 0 {
+		plan = filterServices(plan, scope)
+	}
+
+	var results []BuildResult
+	for _, name := range plan {
+		argv, err := dcm.composeArgs("build", "--progress", "plain", name)
+		if err != nil {
+			return results, err
+		}
+		parser := newBuildkitParser()
+		// BuildKit writes its progress to stderr
+		err = dcm.executeStreaming(context.Background(), argv, os.Stdout, io.MultiWriter(os.Stderr, parser))
+		result := parser.result(name)
+		if err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+		if err != nil {
+			return results, fmt.Errorf("building %s: %w", name, err)
+		}
+	}
+	return results, nil
+}
+
+// PrintBuildResults prints the cache usage of each built service
+func PrintBuildResults(results []BuildResult) {
+	fmt.Printf("%-20s %6s %7s %9s %s\n", "SERVICE", "STEPS", "CACHED", "EXECUTED", "HIT RATIO")
+	for _, r := range results {
+		fmt.Printf("%-20s %6d %7d %9d %8.0f%%\n", r.Service, r.Steps, r.Cached, r.Executed, r.HitRatio*100)
+	}
+}
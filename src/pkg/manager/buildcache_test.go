@@ -0,0 +1,106 @@
+package manager
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBuildkitParser(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   BuildResult
+	}{
+		{
+			"cached and executed",
+			`#1 [internal] load build definition from Dockerfile
+#1 DONE 0.0s
+#5 [1/3] FROM docker.io/library/golang:1.22
+#5 CACHED
+#6 [2/3] COPY go.mod go.sum ./
+#6 CACHED
+#7 [3/3] RUN go build ./...
+#7 0.512 go: downloading example.com/dep v1.0.0
+#7 DONE 12.3s
+`,
+			BuildResult{Service: "web", Steps: 3, Cached: 2, Executed: 1, HitRatio: 2.0 / 3},
+		},
+		{
+			"interleaved steps of several stages",
+			`#4 [builder 1/2] FROM docker.io/library/golang:1.22
+#8 [stage-1 1/2] FROM docker.io/library/alpine:3.19
+#4 CACHED
+#9 [builder 2/2] RUN go build -o /app ./cmd/app
+#8 DONE 0.1s
+#10 [stage-1 2/2] COPY --from=builder /app /app
+#9 1.022 compiling
+#9 DONE 8.0s
+#10 DONE 0.2s
+`,
+			BuildResult{Service: "web", Steps: 4, Cached: 1, Executed: 3, HitRatio: 0.25},
+		},
+		{
+			"a failed step",
+			`#5 [1/2] FROM docker.io/library/alpine:3.19
+#5 CACHED
+#6 [2/2] RUN false
+#6 ERROR: process "/bin/sh -c false" did not complete successfully: exit code: 1
+`,
+			BuildResult{Service: "web", Steps: 2, Cached: 1, HitRatio: 0.5},
+		},
+		{
+			"cached stays cached when done follows",
+			"#5 [1/1] FROM docker.io/library/alpine:3.19\r\n#5 CACHED\r\n#5 DONE 0.0s",
+			BuildResult{Service: "web", Steps: 1, Cached: 1, HitRatio: 1},
+		},
+		{
+			"no steps",
+			"#1 [internal] load .dockerignore\n#1 DONE 0.0s\n",
+			BuildResult{Service: "web"},
+		},
+	}
+	for _, tt := range tests {
+		p := newBuildkitParser()
+		// Written in small chunks, as BuildKit flushes partial lines
+		for rest := tt.output; rest != ""; {
+			n := min(7, len(rest))
+			p.Write([]byte(rest[:n]))
+			rest = rest[n:]
+		}
+		if got := p.result("web"); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: result = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBuildCacheStats(t *testing.T) {
+	compose := `services:
+  web:
+    build: .
+  db:
+    image: postgres
+`
+	runner := &fakeRunner{}
+	runner.handle("build --progress plain web", func(_ context.Context, cmd *Cmd) error {
+		io.WriteString(cmd.Stderr, "#5 [1/2] FROM docker.io/library/alpine\n#5 CACHED\n#6 [2/2] RUN make\n#6 DONE 1.0s\n")
+		return nil
+	})
+	dcm := newTestManager(t, "", compose, runner)
+
+	var results []BuildResult
+	var err error
+	captureStderr(t, func() { results, err = dcm.BuildCacheStats("") })
+	if err != nil {
+		t.Fatalf("BuildCacheStats: %v", err)
+	}
+	want := []BuildResult{{Service: "web", Steps: 2, Cached: 1, Executed: 1, HitRatio: 0.5}}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("results = %+v, want %+v", results, want)
+	}
+	if builds := runner.ran(" build "); len(builds) != 1 || !strings.HasSuffix(builds[0], " build --progress plain web") {
+		t.Errorf("ran %q, want only web built", builds)
+	}
+}
@@ -0,0 +1,275 @@
+package manager
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// buildGraph maps each service with a build section to the sibling services
+// whose images it builds FROM
+type buildGraph map[string][]string
+
+// dockerfileBases returns the base images referenced by FROM instructions of
+// a Dockerfile, skipping references to earlier build stages and images
+// computed from build arguments.
+func dockerfileBases(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	stages := make(map[string]bool)
+	var bases []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || !strings.EqualFold(fields[0], "FROM") {
+			continue
+		}
+
+		args := fields[1:]
+		for len(args) > 0 && strings.HasPrefix(args[0], "--") {
+			args = args[1:]
+		}
+		if len(args) == 0 {
+			continue
+		}
+		image := args[0]
+		isStage := stages[strings.ToLower(image)]
+		if len(args) >= 3 && strings.EqualFold(args[1], "AS") {
+			stages[strings.ToLower(args[2])] = true
+		}
+		if isStage || strings.Contains(image, "$") {
+			continue
+		}
+		bases = append(bases, image)
+	}
+	return bases, scanner.Err()
+}
+
+// normalizeImage reduces an image reference to a canonical repo:tag form so
+// references written differently can be compared
+func normalizeImage(ref string) string {
+	ref = strings.TrimPrefix(ref, "docker.io/")
+	ref = strings.TrimPrefix(ref, "library/")
+	if strings.Contains(ref, "@") {
+		return ref
+	}
+	if !strings.Contains(ref[strings.LastIndex(ref, "/")+1:], ":") {
+		ref += ":latest"
+	}
+	return ref
+}
+
+// serviceImages returns the image names a service's build produces. Without
+// an explicit image compose names it after the project and the service.
+func (dcm *Manager) serviceImages(project *composeProject, name string) []string {
+	service := project.Services[name]
+	if service.Image != "" {
+		return []string{normalizeImage(service.Image)}
+	}
+
+	ProjectName := dcm.ProjectName()
+	return []string{
+		normalizeImage(ProjectName + "-" + name),
+		normalizeImage(ProjectName + "_" + name),
+	}
+}
+
+// buildGraph detects which services build FROM images produced by other
+// services, and adds the ordering declared with build_order.
+func (dcm *Manager) buildGraph(project *composeProject) (buildGraph, error) {
+	producers := make(map[string]string)
+	for _, name := range project.Names {
+		if project.Services[name].Build == nil {
+			continue
+		}
+		for _, image := range dcm.serviceImages(project, name) {
+			producers[image] = name
+		}
+	}
+
+	graph := make(buildGraph)
+	for _, name := range project.Names {
+		service := project.Services[name]
+		if service.Build == nil {
+			continue
+		}
+		graph[name] = nil
+
+		dockerfile := service.Build.Dockerfile
+		if dockerfile == "" {
+			dockerfile = "Dockerfile"
+		}
+		path := filepath.Join(service.dir, service.Build.Context, dockerfile)
+		bases, err := dockerfileBases(path)
+		if err != nil {
+			// The build itself will report a missing Dockerfile
+			dcm.verbosef("Skipping dependency detection for %s: %v\n", name, err)
+			continue
+		}
+		for _, base := range bases {
+			if producer, ok := producers[normalizeImage(base)]; ok && producer != name {
+				graph.addEdge(name, producer)
+			}
+		}
+	}
+
+	for i := 1; i < len(dcm.config.BuildOrder); i++ {
+		name, previous := dcm.config.BuildOrder[i], dcm.config.BuildOrder[i-1]
+		for _, s := range []string{name, previous} {
+			if _, ok := graph[s]; !ok {
+				return nil, fmt.Errorf("build_order: %s is not a service with a build section", s)
+			}
+		}
+		graph.addEdge(name, previous)
+	}
+	return graph, nil
+}
+
+// addEdge records that service depends on base, ignoring duplicates
+func (g buildGraph) addEdge(service, base string) {
+	for _, existing := range g[service] {
+		if existing == base {
+			return
+		}
+	}
+	g[service] = append(g[service], base)
+}
+
+// hasEdges reports whether any service depends on another
+func (g buildGraph) hasEdges() bool {
+	for _, deps := range g {
+		if len(deps) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// topoSort orders the services so every service comes after the services it
+// depends on, keeping the given order otherwise. A dependency cycle is
+// reported with the services forming it.
+func topoSort(names []string, deps map[string][]string) ([]string, error) {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make(map[string]int)
+	var order, stack []string
+
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case done:
+			return nil
+		case visiting:
+			for i, s := range stack {
+				if s == name {
+					cycle := append(append([]string(nil), stack[i:]...), name)
+					return fmt.Errorf("dependency cycle: %s", strings.Join(cycle, " -> "))
+				}
+			}
+		}
+		state[name] = visiting
+		stack = append(stack, name)
+		for _, dep := range deps[name] {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[name] = done
+		order = append(order, name)
+		return nil
+	}
+
+	for _, name := range names {
+		if err := visit(name); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// buildOrder returns the services with a build section in dependency order,
+// along with their build graph
+func (dcm *Manager) buildOrder() ([]string, buildGraph, error) {
+	project, err := dcm.composeProject()
+	if err != nil {
+		return nil, nil, err
+	}
+	graph, err := dcm.buildGraph(project)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var buildable []string
+	for _, name := range project.Names {
+		if _, ok := graph[name]; ok {
+			buildable = append(buildable, name)
+		}
+	}
+	order, err := topoSort(buildable, graph)
+	if err != nil {
+		return nil, nil, err
+	}
+	return order, graph, nil
+}
+
+// buildPlan returns the services to build, in dependency order, and whether
+// any build dependency exists between them. Building a single service also
+// rebuilds the services that build FROM it so they never use a stale base.
+func (dcm *Manager) buildPlan(serviceName string) ([]string, bool, error) {
+	order, graph, err := dcm.buildOrder()
+	if err != nil {
+		return nil, false, err
+	}
+	if serviceName == "" {
+		return order, graph.hasEdges(), nil
+	}
+
+	affected := map[string]bool{serviceName: true}
+	var plan []string
+	for _, name := range order {
+		for _, dep := range graph[name] {
+			if affected[dep] {
+				affected[name] = true
+			}
+		}
+		if affected[name] {
+			plan = append(plan, name)
+		}
+	}
+	if len(plan) == 0 {
+		// Not a build service, let docker-compose report it
+		plan = []string{serviceName}
+	}
+	return plan, len(plan) > 1, nil
+}
+
+// PrintBuildGraph prints the detected build dependencies in build order
+func (dcm *Manager) PrintBuildGraph() error {
+	order, graph, err := dcm.buildOrder()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Build dependency graph (in build order):")
+	for _, name := range order {
+		deps := append([]string(nil), graph[name]...)
+		sort.Strings(deps)
+		if len(deps) == 0 {
+			fmt.Printf("  %s\n", name)
+			continue
+		}
+		fmt.Printf("  %s <- %s\n", name, strings.Join(deps, ", "))
+	}
+	return nil
+}
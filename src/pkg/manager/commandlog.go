@@ -0,0 +1,115 @@
+package manager
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// CommandRecord is an executed command as recorded in the command log
+type CommandRecord struct {
+	Time time.Time `json:"time"`
+	User string    `json:"user"`
+	Argv []string  `json:"argv"`
+	// ExitCode is -1 when the command could not be run or was killed
+	ExitCode int `json:"exit_code"`
+	// Duration is in seconds
+	Duration float64 `json:"duration_seconds"`
+	Error    string  `json:"error,omitempty"`
+	// Prev is the SHA-256 of the previous line of the log, chaining the
+	// records so that editing or removing one shows
+	Prev string `json:"prev"`
+}
+
+// commandLogTail is how much of the end of the command log is read to find
+// its last line
+const commandLogTail = 64 * 1024
+
+// commandLogPath returns the command log file, empty when the log is off.
+// A relative command_log is relative to the config file.
+func (dcm *Manager) commandLogPath() string {
+	path := dcm.commandLog
+	if path == "" {
+		path = dcm.config.CommandLog
+		if path != "" && !filepath.IsAbs(path) {
+			path = filepath.Join(filepath.Dir(dcm.configPath), path)
+		}
+	}
+	return path
+}
+
+// lastLineHash returns the SHA-256 of the last line of f, empty for an
+// empty file
+func lastLineHash(f *os.File) (string, error) {
+	info, err := f.Stat()
+	if err != nil || info.Size() == 0 {
+		return "", err
+	}
+	offset := info.Size() - commandLogTail
+	if offset < 0 {
+		offset = 0
+	}
+	data := make([]byte, info.Size()-offset)
+	if _, err := f.ReadAt(data, offset); err != nil && err != io.EOF {
+		return "", err
+	}
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	sum := sha256.Sum256([]byte(lines[len(lines)-1]))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// logCommand appends a command that ran from started to the command log.
+// A log that cannot be written only warns, the command already ran.
+func (dcm *Manager) logCommand(argv []string, started time.Time, err error) {
+	path := dcm.commandLogPath()
+	if path == "" {
+		return
+	}
+	record := CommandRecord{
+		Time:     started,
+		User:     currentUser(),
+		Argv:     argv,
+		Duration: time.Since(started).Seconds(),
+	}
+	var exitErr exitCoder
+	switch {
+	case errors.As(err, &exitErr):
+		record.ExitCode = exitErr.ExitCode()
+		if record.ExitCode < 0 {
+			record.Error = err.Error()
+		}
+	case err != nil:
+		record.ExitCode, record.Error = -1, err.Error()
+	}
+	if logErr := appendCommandRecord(path, record); logErr != nil {
+		dcm.Warnf("could not write command log %s: %v\n", path, logErr)
+	}
+}
+
+// appendCommandRecord appends a record to the log at path, chained to the
+// last line already there
+func appendCommandRecord(path string, record CommandRecord) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0775); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0640)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if record.Prev, err = lastLineHash(f); err != nil {
+		return err
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
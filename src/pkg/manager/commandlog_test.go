@@ -0,0 +1,72 @@
+package manager
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCommandLogEntry(t *testing.T) {
+	runner := &fakeRunner{}
+	runner.fail(" logs", 3, "no such service")
+	dcm := newTestManager(t, "command_log: logs/commands.jsonl\n", "", runner)
+
+	before := time.Now()
+	if _, err := dcm.compose("ps"); err != nil {
+		t.Fatalf("ps: %v", err)
+	}
+	dcm.composeOutput("logs")
+
+	data, err := os.ReadFile(filepath.Join(filepath.Dir(dcm.ConfigPath()), "logs", "commands.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("command log has %d lines, want 2:\n%s", len(lines), data)
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &fields); err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if want := []string{"argv", "duration_seconds", "exit_code", "prev", "time", "user"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("entry keys = %q, want %q", keys, want)
+	}
+
+	var first, second CommandRecord
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &second); err != nil {
+		t.Fatal(err)
+	}
+	if argv := strings.Join(first.Argv, " "); !strings.HasPrefix(argv, "docker compose ") || !strings.HasSuffix(argv, " ps") {
+		t.Errorf("argv = %q, want the full compose command", first.Argv)
+	}
+	if first.Time.Before(before.Truncate(time.Second)) || first.Time.After(time.Now()) {
+		t.Errorf("time = %s, want when the command ran", first.Time)
+	}
+	if first.User == "" || first.ExitCode != 0 || first.Duration < 0 || first.Prev != "" {
+		t.Errorf("first entry = %+v, want a user, exit code 0 and no previous line", first)
+	}
+	if second.ExitCode != 3 || second.Error != "" {
+		t.Errorf("failed entry = %+v, want exit code 3 and no error text", second)
+	}
+	sum := sha256.Sum256([]byte(lines[0]))
+	if second.Prev != hex.EncodeToString(sum[:]) {
+		t.Errorf("prev = %q, want the SHA-256 of the first line", second.Prev)
+	}
+}
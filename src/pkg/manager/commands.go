@@ -0,0 +1,84 @@
+package manager
+
+import "sort"
+
+// Command describes a dcm command for help, shell completion and the
+// hook events of the config
+type Command struct {
+	Name    string
+	Args    string
+	Summary string
+	// Flags is set when the command has flags of its own, shown by
+	// `dcm <command> --help`
+	Flags bool
+}
+
+// Commands lists the commands in the order help shows them. Add new
+// commands here as well as to the switch in the run of the CLI.
+var Commands = []Command{
+	{"start", "[service...]", "start services, with --wait until they are ready", true},
+	{"up", "[service...]", "start, with --wait in dependency order", true},
+	{"stop", "[service...]", "stop services", true},
+	{"restart", "[service...]", "restart services, with --graceful one at a time", true},
+	{"remove", "[service...]", "remove stopped service containers", true},
+	{"kill", "[service]", "send a signal to service containers", true},
+	{"reload", "<service>", "send the reload signal of a service to its containers", false},
+	{"scale", "<service>=<replicas>...", "set the number of containers of services", true},
+	{"down", "", "stop and remove the containers and networks of the project", true},
+	{"purge", "", "remove the project with its volumes and images", true},
+	{"status", "", "show the state of the service containers", true},
+	{"list", "", "list the services of the compose files with their state", true},
+	{"uptime", "[service...]", "show how long services have been in their state", true},
+	{"stats", "[service...]", "show the CPU, memory, network and block I/O usage of services", true},
+	{"logs", "[service...]", "show or follow service logs", true},
+	{"exec", "<service> [--] [command...]", "run a command in a running service container", true},
+	{"shell", "<service>", "open the configured shell of a service in its running container", true},
+	{"run", "<service> [command...]", "run a one-off container of a service", true},
+	{"compose", "[args...]", "run a compose command with the configured files", false},
+	{"inspect", "[service]", "show the details of service containers", true},
+	{"monitor", "[service...]", "show a live status and logs view", true},
+	{"watch", "[service...]", "rebuild and restart services when their files change", true},
+	{"guard", "[service...]", "restart crashed services and fix drift from the desired state", true},
+	{"serve", "", "serve start, stop, restart, status and logs over an HTTP API", true},
+	{"metrics", "", "serve Prometheus metrics about the services and dcm operations", true},
+	{"tui", "", "open the full screen interactive mode", false},
+	{"build", "[service]", "build service images in dependency order", true},
+	{"build-status", "", "show the state of a detached build", true},
+	{"build-wait", "", "wait for a detached build to finish", true},
+	{"pull", "[service...]", "pull service images", true},
+	{"update", "[service]", "pull newer images and recreate the services using them", true},
+	{"snapshot", "create|restore|list [name]", "save or restore the compose files, image digests and volumes of the stack", true},
+	{"doctor", "", "check the environment and the configuration", false},
+	{"lint", "", "check the compose files for common mistakes", true},
+	{"validate", "", "validate the compose files", true},
+	{"bootstrap", "<repository>", "clone a repository and set up its stack", true},
+	{"who", "", "show who is operating on the project", true},
+	{"init", "[directory]", "write a dcm.config.yml for the compose files of a directory", true},
+	{"config", "render|migrate", "show the config with its placeholders resolved and secrets masked, or upgrade it to the current format", false},
+	{"features", "", "list the feature flags and their state", false},
+	{"groups", "[list]", "list the service groups of the config", false},
+	{"projects", "[list]", "list the projects of the config", false},
+	{"context", "list|use <name>", "list the docker contexts or pick the one the project runs on", false},
+	{"help", "[command]", "show this help or the help of a command", false},
+	{"completion", "bash|zsh|fish", "print a shell completion script", false},
+}
+
+// FindCommand returns the description of a command
+func FindCommand(name string) (Command, bool) {
+	for _, c := range Commands {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return Command{}, false
+}
+
+// CommandNames returns the names of the commands, sorted
+func CommandNames() []string {
+	names := make([]string, 0, len(Commands))
+	for _, c := range Commands {
+		names = append(names, c.Name)
+	}
+	sort.Strings(names)
+	return names
+}
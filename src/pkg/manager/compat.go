@@ -0,0 +1,133 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// composeVersionPattern finds the version in the output of compose version,
+// "docker-compose version 1.29.2, build 5becea4c" or "v2.20.2"
+var composeVersionPattern = regexp.MustCompile(`v?(\d+)\.\d+`)
+
+// composeVersionCache holds the major version of the compose command, looked
+// up once even when operations on several projects ask at the same time
+type composeVersionCache struct {
+	once  sync.Once
+	major int
+}
+
+// composeMajorVersion returns the major version of the compose command, 0
+// when it cannot be told. It runs compose once and remembers the answer.
+func (dcm *Manager) composeMajorVersion() int {
+	cache := dcm.composeVersion
+	cache.once.Do(func() {
+		argv := append(append([]string(nil), dcm.composeCmd...), "version", "--short")
+		started := time.Now()
+		output, err := dcm.command(context.Background(), argv).Output()
+		dcm.logCommand(argv, started, err)
+		if err == nil {
+			if m := composeVersionPattern.FindStringSubmatch(string(output)); m != nil {
+				cache.major, _ = strconv.Atoi(m[1])
+			}
+		}
+	})
+	return cache.major
+}
+
+// composeGlobalValueFlags are the compose global flags taking a value, needed
+// to find the subcommand after them
+var composeGlobalValueFlags = map[string]bool{
+	"-f": true, "--file": true, "-p": true, "--project-name": true,
+	"--project-directory": true, "--env-file": true, "--profile": true,
+	"--ansi": true, "-H": true, "--host": true, "--context": true, "-c": true,
+	"--log-level": true,
+}
+
+// v1Translation rewrites a Compose V1 command that V2 removed or renamed.
+// translate gets the arguments after the subcommand and returns the V2
+// arguments that replace the subcommand and them, or an error when V2 has
+// no equivalent.
+type v1Translation func(args []string) ([]string, error)
+
+// v1GlobalFlags maps the V1 global flags V2 dropped to their replacement
+var v1GlobalFlags = map[string][]string{
+	"--no-ansi": {"--ansi", "never"},
+}
+
+// v1Commands maps the V1 subcommands V2 dropped to their translation
+var v1Commands = map[string]v1Translation{
+	// scale SERVICE=NUM... became up --scale
+	"scale": func(args []string) ([]string, error) {
+		v2 := []string{"up", "-d", "--no-recreate"}
+		var services []string
+		for i := 0; i < len(args); i++ {
+			switch arg := args[i]; {
+			case arg == "-t" || arg == "--timeout":
+				if i+1 == len(args) {
+					return nil, fmt.Errorf("%s needs a value", arg)
+				}
+				v2 = append(v2, "--timeout", args[i+1])
+				i++
+			case strings.HasPrefix(arg, "--timeout="):
+				v2 = append(v2, arg)
+			case strings.Contains(arg, "="):
+				v2 = append(v2, "--scale", arg)
+				services = append(services, arg[:strings.Index(arg, "=")])
+			default:
+				return nil, fmt.Errorf("unexpected scale argument %q, expected SERVICE=NUM", arg)
+			}
+		}
+		if len(services) == 0 {
+			return nil, fmt.Errorf("scale needs at least one SERVICE=NUM")
+		}
+		return append(v2, services...), nil
+	},
+	"bundle": func([]string) ([]string, error) {
+		return nil, fmt.Errorf("bundle was removed in Compose V2 with no equivalent")
+	},
+	"migrate-to-labels": func([]string) ([]string, error) {
+		return nil, fmt.Errorf("migrate-to-labels was removed in Compose V2 with no equivalent")
+	},
+}
+
+// translateV1 rewrites the arguments of a compose command written for
+// Compose V1 into their V2 equivalent, warning about each translation
+func (dcm *Manager) translateV1(args []string) ([]string, error) {
+	var out []string
+	i := 0
+	for ; i < len(args) && strings.HasPrefix(args[i], "-"); i++ {
+		if replacement, ok := v1GlobalFlags[args[i]]; ok {
+			dcm.warnV1(args[i], replacement)
+			out = append(out, replacement...)
+			continue
+		}
+		out = append(out, args[i])
+		if composeGlobalValueFlags[args[i]] && i+1 < len(args) {
+			i++
+			out = append(out, args[i])
+		}
+	}
+	if i == len(args) {
+		return out, nil
+	}
+	translation, ok := v1Commands[args[i]]
+	if !ok {
+		return append(out, args[i:]...), nil
+	}
+	v2, err := translation(args[i+1:])
+	if err != nil {
+		return nil, NewError(ErrUsage, "compose V1 compatibility: %v", err)
+	}
+	dcm.warnV1(strings.Join(args[i:], " "), v2)
+	return append(out, v2...), nil
+}
+
+// warnV1 tells the user a V1 form was translated, so scripts get updated
+func (dcm *Manager) warnV1(v1 string, v2 []string) {
+	dcm.Warnf("%q is deprecated Compose V1 syntax, running %q instead\n", v1, strings.Join(v2, " "))
+}
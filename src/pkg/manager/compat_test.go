@@ -0,0 +1,110 @@
+package manager
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestTranslateV1(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{
+			"scale",
+			[]string{"scale", "web=3", "worker=2"},
+			[]string{"up", "-d", "--no-recreate", "--scale", "web=3", "--scale", "worker=2", "web", "worker"},
+		},
+		{
+			"scale with a timeout",
+			[]string{"scale", "-t", "5", "web=3"},
+			[]string{"up", "-d", "--no-recreate", "--timeout", "5", "--scale", "web=3", "web"},
+		},
+		{
+			"no-ansi before the subcommand",
+			[]string{"--no-ansi", "-p", "other", "ps"},
+			[]string{"--ansi", "never", "-p", "other", "ps"},
+		},
+		{
+			"a V2 command is left alone",
+			[]string{"up", "-d", "--scale", "web=3"},
+			[]string{"up", "-d", "--scale", "web=3"},
+		},
+	}
+	dcm := newTestManager(t, "", "", &fakeRunner{})
+	for _, tt := range tests {
+		var got []string
+		var err error
+		warnings := captureStderr(t, func() { got, err = dcm.translateV1(tt.args) })
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: translateV1(%q) = %q, want %q", tt.name, tt.args, got, tt.want)
+		}
+		translated := !reflect.DeepEqual(tt.args, tt.want)
+		if warned := strings.Contains(warnings, "deprecated Compose V1 syntax"); warned != translated {
+			t.Errorf("%s: warnings %q, want a deprecation warning only for translations", tt.name, warnings)
+		}
+	}
+}
+
+func TestTranslateV1Rejects(t *testing.T) {
+	dcm := newTestManager(t, "", "", &fakeRunner{})
+	for _, args := range [][]string{{"bundle"}, {"migrate-to-labels"}, {"scale"}, {"scale", "web"}, {"scale", "-t"}} {
+		if _, err := dcm.translateV1(args); TypeOf(err) != ErrUsage {
+			t.Errorf("translateV1(%q) = %v, want a %s error", args, err, ErrUsage)
+		}
+	}
+}
+
+func TestComposeV1Compat(t *testing.T) {
+	for _, version := range []string{"v2.20.2", "1.29.2"} {
+		runner := &fakeRunner{}
+		runner.stdout("version --short", version+"\n")
+		dcm := newTestManager(t, "", "", runner, WithComposeV1Compat())
+
+		var err error
+		captureStderr(t, func() { err = dcm.Compose("scale", "web=2") })
+		if err != nil {
+			t.Fatalf("compose %s: %v", version, err)
+		}
+		want := " scale web=2"
+		if strings.HasPrefix(version, "v2") {
+			want = " up -d --no-recreate --scale web=2 web"
+		}
+		commands := runner.commands()
+		if last := commands[len(commands)-1]; !strings.HasSuffix(last, want) {
+			t.Errorf("compose %s ran %q, want it to end with %q", version, last, want)
+		}
+	}
+}
+
+func TestComposeMajorVersionRunsOnce(t *testing.T) {
+	runner := &fakeRunner{}
+	runner.stdout("version --short", "v2.20.2\n")
+	dcm := newTestManager(t, "", "", runner)
+
+	var wg sync.WaitGroup
+	majors := make([]int, 8)
+	for i := range majors {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			majors[i] = dcm.composeMajorVersion()
+		}(i)
+	}
+	wg.Wait()
+	for _, major := range majors {
+		if major != 2 {
+			t.Fatalf("composeMajorVersion = %v, want 2 every time", majors)
+		}
+	}
+	if n := len(runner.ran("version --short")); n != 1 {
+		t.Errorf("compose version ran %d times, want once", n)
+	}
+}
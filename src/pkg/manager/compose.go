@@ -0,0 +1,501 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// composeCommandCandidates are the compose invocations probed for, in order
+// of preference
+var composeCommandCandidates = [][]string{
+	{"docker", "compose"},
+	{"docker-compose"},
+}
+
+// composeFileNames are the compose file names docker compose looks for when
+// none is given, in its order of precedence
+var composeFileNames = []string{"compose.yaml", "compose.yml", "docker-compose.yaml", "docker-compose.yml"}
+
+// discoverComposeFiles finds the compose files of dir the way docker compose
+// does when none is given: the first of composeFileNames present, layered
+// with its override file, e.g. compose.override.yaml, if there is one. The
+// names are relative to dir; none found is not an error.
+func discoverComposeFiles(dir string) ([]string, error) {
+	exists := func(name string) (bool, error) {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return err == nil && !info.IsDir(), err
+	}
+	for _, name := range composeFileNames {
+		found, err := exists(name)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			continue
+		}
+		files := []string{name}
+		stem := strings.TrimSuffix(name, filepath.Ext(name))
+		for _, ext := range []string{".yaml", ".yml"} {
+			override := stem + ".override" + ext
+			if found, err := exists(override); err != nil {
+				return nil, err
+			} else if found {
+				files = append(files, override)
+				break
+			}
+		}
+		return files, nil
+	}
+	return nil, nil
+}
+
+// findComposeDir returns the nearest of dir and its parents with a compose
+// file of a standard name
+func findComposeDir(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for {
+		if files, err := discoverComposeFiles(dir); err == nil && len(files) > 0 {
+			return dir, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", os.ErrNotExist
+		}
+		dir = parent
+	}
+}
+
+// resolveComposeCommand returns the compose invocation to use: the
+// --compose-command flag, the DCM_COMPOSE_BIN environment variable, the
+// compose_command config key, then the first candidate whose `version`
+// subcommand succeeds. An override naming a program that is not installed
+// fails here rather than at the first compose command.
+func (dcm *Manager) resolveComposeCommand() ([]string, error) {
+	overrides := []struct{ source, value string }{
+		{"--compose-command", dcm.composeOverride},
+		{"DCM_COMPOSE_BIN", os.Getenv("DCM_COMPOSE_BIN")},
+		{"compose_command in " + dcm.configPath, dcm.config.ComposeCommand},
+	}
+	for _, o := range overrides {
+		fields := strings.Fields(o.value)
+		if len(fields) == 0 {
+			continue
+		}
+		// Only a process needs the binary, not a Runner of WithRunner
+		_, isExec := dcm.runner.(ExecRunner)
+		if _, err := exec.LookPath(fields[0]); isExec && err != nil {
+			return nil, NewError(ErrComposeNotFound, "%s is set to %q, but %s is not installed or not in PATH",
+				o.source, o.value, fields[0])
+		}
+		dcm.verbosef("Using compose command from %s: %s\n", o.source, o.value)
+		return fields, nil
+	}
+
+	for _, candidate := range composeCommandCandidates {
+		args := append(append([]string(nil), candidate[1:]...), "version")
+		if err := dcm.hostCommand(context.Background(), append(candidate[:1:1], args...)...).Run(); err == nil {
+			dcm.verbosef("Using compose command: %s\n", strings.Join(candidate, " "))
+			return candidate, nil
+		}
+	}
+	return nil, NewError(ErrComposeNotFound, "neither 'docker compose' nor 'docker-compose' is available; "+
+		"install the Docker Compose plugin, or set DCM_COMPOSE_BIN or compose_command in the config")
+}
+
+// composeTopLevelKeys are the keys of a version 1 compose file that are not
+// service definitions
+var composeTopLevelKeys = map[string]bool{
+	"version":  true,
+	"networks": true,
+	"volumes":  true,
+	"secrets":  true,
+	"configs":  true,
+}
+
+// composeBuild is the build section of a compose service, which may be
+// given either as a context path or as a mapping
+type composeBuild struct {
+	Context    string `yaml:"context"`
+	Dockerfile string `yaml:"dockerfile"`
+}
+
+// UnmarshalYAML accepts both the short and the long build syntax
+func (b *composeBuild) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var context string
+	if err := unmarshal(&context); err == nil {
+		b.Context = context
+		return nil
+	}
+	type plain composeBuild
+	return unmarshal((*plain)(b))
+}
+
+// composePort is a port mapping of a service, given in the short
+// "[ip:][host:]container[/protocol]" syntax or as a mapping
+type composePort struct {
+	// HostIP is the address the port is published on, empty for all
+	HostIP string
+	// Published is the host port, empty when docker picks one
+	Published string
+	// Target is the container port
+	Target string
+	// Protocol is tcp unless the mapping says otherwise
+	Protocol string
+}
+
+// UnmarshalYAML accepts both the short and the long port syntax
+func (p *composePort) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	p.Protocol = "tcp"
+	var short string
+	if err := unmarshal(&short); err == nil {
+		if i := strings.Index(short, "/"); i >= 0 {
+			short, p.Protocol = short[:i], short[i+1:]
+		}
+		// An IPv6 address is written in brackets
+		if strings.HasPrefix(short, "[") {
+			if i := strings.Index(short, "]:"); i >= 0 {
+				p.HostIP, short = short[1:i], short[i+2:]
+			}
+		}
+		parts := strings.Split(short, ":")
+		p.Target = parts[len(parts)-1]
+		if len(parts) > 1 {
+			p.Published = parts[len(parts)-2]
+		}
+		if len(parts) > 2 {
+			p.HostIP = strings.Join(parts[:len(parts)-2], ":")
+		}
+		return nil
+	}
+	var long struct {
+		HostIP    string `yaml:"host_ip"`
+		Published string `yaml:"published"`
+		Target    string `yaml:"target"`
+		Protocol  string `yaml:"protocol"`
+	}
+	if err := unmarshal(&long); err != nil {
+		return err
+	}
+	p.HostIP, p.Published, p.Target = long.HostIP, long.Published, long.Target
+	if long.Protocol != "" {
+		p.Protocol = long.Protocol
+	}
+	return nil
+}
+
+// fixedHostPort returns the first host port of a service that is a single
+// port rather than a range, which only one container can bind
+func (s *composeService) fixedHostPort() string {
+	for _, p := range s.Ports {
+		if p.Published != "" && !strings.Contains(p.Published, "-") {
+			return p.Published
+		}
+	}
+	return ""
+}
+
+// dependsOn lists the services a service depends on, given as a list or as
+// a mapping of service names to conditions
+type dependsOn []string
+
+// UnmarshalYAML accepts both the short and the long depends_on syntax
+func (d *dependsOn) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var short []string
+	if err := unmarshal(&short); err == nil {
+		*d = short
+		return nil
+	}
+	var long yaml.MapSlice
+	if err := unmarshal(&long); err != nil {
+		return err
+	}
+	*d = nil
+	for _, item := range long {
+		*d = append(*d, fmt.Sprint(item.Key))
+	}
+	return nil
+}
+
+// composeService is the part of a compose service definition the manager
+// inspects
+type composeService struct {
+	Image         string        `yaml:"image"`
+	Build         *composeBuild `yaml:"build"`
+	ContainerName string        `yaml:"container_name"`
+	Ports         []composePort `yaml:"ports"`
+	DependsOn     dependsOn     `yaml:"depends_on"`
+
+	// dir is the directory of the compose file defining the service, which
+	// relative paths in the definition are resolved against
+	dir string
+}
+
+// composeProject is the merged view of the configured compose files
+type composeProject struct {
+	// Names lists the services in the order they are first defined
+	Names    []string
+	Services map[string]*composeService
+}
+
+// parseComposeFile reads the service definitions of a compose file, keeping
+// the order they are declared in. Files without a services section are
+// treated as the legacy layout where services are declared at the top level.
+func parseComposeFile(path string) (yaml.MapSlice, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc yaml.MapSlice
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+
+	var services yaml.MapSlice
+	legacy := true
+	for _, item := range doc {
+		key := fmt.Sprint(item.Key)
+		if key != "services" {
+			continue
+		}
+		legacy = false
+		if item.Value == nil {
+			break
+		}
+		m, ok := item.Value.(yaml.MapSlice)
+		if !ok {
+			return nil, fmt.Errorf("%s: services must be a mapping", path)
+		}
+		services = m
+	}
+	if legacy {
+		for _, item := range doc {
+			key := fmt.Sprint(item.Key)
+			if !composeTopLevelKeys[key] && !strings.HasPrefix(key, "x-") {
+				services = append(services, item)
+			}
+		}
+	}
+	return services, nil
+}
+
+// loadComposeFiles merges the service definitions of the given compose
+// files. Later files override the fields they set, as with docker-compose.
+func loadComposeFiles(files []string) (*composeProject, error) {
+	project := &composeProject{Services: make(map[string]*composeService)}
+	for _, f := range files {
+		services, err := parseComposeFile(f)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range services {
+			name := fmt.Sprint(item.Key)
+			raw, err := yaml.Marshal(item.Value)
+			if err != nil {
+				return nil, err
+			}
+			var def composeService
+			if err := yaml.Unmarshal(raw, &def); err != nil {
+				return nil, fmt.Errorf("%s: service %s: %v", f, name, err)
+			}
+			def.dir = filepath.Dir(f)
+
+			existing, ok := project.Services[name]
+			if !ok {
+				project.Names = append(project.Names, name)
+				project.Services[name] = &def
+				continue
+			}
+			existing.merge(&def)
+		}
+	}
+	return project, nil
+}
+
+// merge overlays the fields set in an override definition
+func (s *composeService) merge(override *composeService) {
+	if override.Image != "" {
+		s.Image = override.Image
+	}
+	if override.Build != nil {
+		s.Build = override.Build
+		s.dir = override.dir
+	}
+	if override.ContainerName != "" {
+		s.ContainerName = override.ContainerName
+	}
+	// Like compose, ports and dependencies of an override are added to the
+	// ones defined
+	s.Ports = append(s.Ports, override.Ports...)
+	s.DependsOn = append(s.DependsOn, override.DependsOn...)
+}
+
+// composeProject loads the configured compose files
+func (dcm *Manager) composeProject() (*composeProject, error) {
+	return loadComposeFiles(dcm.config.composeFiles())
+}
+
+// composeServices returns the sorted names of the services defined across
+// all configured compose files. It returns nil when no compose file is
+// configured.
+func (dcm *Manager) composeServices() ([]string, error) {
+	project, err := dcm.composeProject()
+	if err != nil {
+		return nil, err
+	}
+	names := append([]string(nil), project.Names...)
+	sort.Strings(names)
+	return names, nil
+}
+
+// validateService checks that name is a plausible service name defined in
+// the compose files, so it can safely be passed to docker-compose.
+func (dcm *Manager) validateService(name string) error {
+	if strings.TrimSpace(name) == "" {
+		return NewError(ErrUsage, "empty service name")
+	}
+	if strings.HasPrefix(name, "-") || strings.ContainsAny(name, " \t\r\n") {
+		return NewError(ErrUsage, "invalid service name %q", name)
+	}
+
+	strict := dcm.strictServices || dcm.config.StrictServices
+	if !strict && !dcm.FeatureEnabled("strict_service_names") {
+		return nil
+	}
+	services, err := dcm.composeServices()
+	if err != nil {
+		return NewError(ErrConfig, "%w", err)
+	}
+	if len(services) == 0 && strict {
+		// Without compose files in the config, ask compose for the services
+		// of the project it finds
+		output, err := dcm.composeOutput("config", "--services")
+		if err != nil {
+			return NewError(ErrConfig, "strict_services: cannot list the services of the project to check %q: %v", name, err)
+		}
+		services = strings.Fields(output)
+	}
+	if len(services) == 0 {
+		if strict {
+			return NewError(ErrServiceNotFound, "unknown service %q, the project defines no services", name)
+		}
+		// Nothing to check against, let docker-compose decide
+		return nil
+	}
+	for _, s := range services {
+		if s == name {
+			return nil
+		}
+	}
+	return NewError(ErrServiceNotFound, "unknown service %q%s (defined services: %s)", name, DidYouMean(name, services), strings.Join(services, ", "))
+}
+
+// expandService expands $VAR and ${VAR} in a service name given by the
+// user against the environment, e.g. ${APP}-worker
+func expandService(name string) string {
+	return os.ExpandEnv(name)
+}
+
+// ResolveService expands a service name and, when it contained variables,
+// checks the result is a service of the compose files, so a mistyped or
+// unset variable does not reach docker-compose as a different name
+func (dcm *Manager) ResolveService(name string) (string, error) {
+	if !strings.Contains(name, "$") {
+		return name, nil
+	}
+	expanded := expandService(name)
+	if expanded == "" {
+		return "", NewError(ErrUsage, "service name %q expands to nothing, are its variables set?", name)
+	}
+	services, err := dcm.composeServices()
+	if err != nil {
+		return "", NewError(ErrConfig, "%w", err)
+	}
+	if len(services) == 0 {
+		return expanded, nil
+	}
+	for _, s := range services {
+		if s == expanded {
+			return expanded, nil
+		}
+	}
+	return "", NewError(ErrServiceNotFound, "unknown service %q, expanded from %q%s (defined services: %s)",
+		expanded, name, DidYouMean(expanded, services), strings.Join(services, ", "))
+}
+
+// ResolveServices expands the groups among names and resolves every name
+// with ResolveService, for commands taking services as arguments
+func (dcm *Manager) ResolveServices(names []string) ([]string, error) {
+	names, err := dcm.expandGroups(names)
+	if err != nil {
+		return nil, err
+	}
+	for i, name := range names {
+		if names[i], err = dcm.ResolveService(name); err != nil {
+			return nil, err
+		}
+	}
+	return names, nil
+}
+
+// sanitizeProjectName normalizes a project name the way compose does:
+// lowercased, reduced to [a-z0-9_-] and without leading '_' or '-'.
+func sanitizeProjectName(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '_' || r == '-' {
+			b.WriteRune(r)
+		}
+	}
+	return strings.TrimLeft(b.String(), "_-")
+}
+
+// ProjectName returns the compose project name with the precedence compose
+// uses: the configured one, COMPOSE_PROJECT_NAME, or the name compose
+// derives from the directory of the first compose file.
+func (dcm *Manager) ProjectName() string {
+	if dcm.config.ProjectName != "" {
+		return dcm.config.ProjectName
+	}
+	// compose reads the variable itself, it only matters for lookups here
+	if name := os.Getenv("COMPOSE_PROJECT_NAME"); name != "" {
+		return sanitizeProjectName(name)
+	}
+	dir := "."
+	if files := dcm.config.composeFiles(); len(files) > 0 {
+		dir = filepath.Dir(files[0])
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	return sanitizeProjectName(filepath.Base(abs))
+}
+
+// quoteArgs renders an argv the way a POSIX shell would need it typed, so
+// the echoed command line is unambiguous.
+func quoteArgs(argv []string) string {
+	quoted := make([]string, len(argv))
+	for i, arg := range argv {
+		if arg != "" && !strings.ContainsAny(arg, " \t\n'\"\\$`;&|<>()*?[]{}#~!") {
+			quoted[i] = arg
+			continue
+		}
+		quoted[i] = "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
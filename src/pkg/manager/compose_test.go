@@ -0,0 +1,304 @@
+package manager
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateService(t *testing.T) {
+	tests := []struct {
+		name    string
+		service string
+		want    ErrorType
+	}{
+		{"defined", "web", ""},
+		{"empty", "", ErrUsage},
+		{"blank", "  ", ErrUsage},
+		{"leading dash", "-f", ErrUsage},
+		{"flag with value", "--file=/etc/passwd", ErrUsage},
+		{"space", "web worker", ErrUsage},
+		{"tab", "web\tworker", ErrUsage},
+		{"newline", "web\nrm", ErrUsage},
+		{"not in the compose file", "api", ErrServiceNotFound},
+		{"case differs", "Web", ErrServiceNotFound},
+	}
+	dcm := newTestManager(t, "", "", &fakeRunner{})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := dcm.validateService(tt.service)
+			switch {
+			case tt.want == "" && err != nil:
+				t.Errorf("validateService(%q) = %v, want nil", tt.service, err)
+			case tt.want != "" && TypeOf(err) != tt.want:
+				t.Errorf("validateService(%q) = %v, want a %s error", tt.service, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateServiceWithoutStrictNames(t *testing.T) {
+	dcm := newTestManager(t, "features:\n  strict_service_names: false\n", "", &fakeRunner{})
+
+	if err := dcm.validateService("api"); err != nil {
+		t.Errorf("undefined service rejected without strict_service_names: %v", err)
+	}
+	// Names that would reach compose as flags or several arguments are
+	// still rejected
+	for _, name := range []string{"", "-d", "web worker"} {
+		if err := dcm.validateService(name); TypeOf(err) != ErrUsage {
+			t.Errorf("validateService(%q) = %v, want a %s error", name, err, ErrUsage)
+		}
+	}
+}
+
+func TestInvalidServiceRunsNoCommand(t *testing.T) {
+	runner := &fakeRunner{}
+	dcm := newTestManager(t, "", "", runner)
+
+	for _, name := range []string{"-v", "web; rm -rf /", "api"} {
+		if _, err := dcm.Stop(name); err == nil {
+			t.Errorf("Stop(%q) succeeded", name)
+		}
+		if err := dcm.Start(context.Background(), StartOptions{Services: []string{name}}); err == nil {
+			t.Errorf("Start(%q) succeeded", name)
+		}
+	}
+	if commands := runner.ran("compose "); len(commands) > 0 {
+		t.Errorf("compose ran for invalid services: %q", commands)
+	}
+}
+
+func TestQuoteArgs(t *testing.T) {
+	tests := []struct {
+		argv []string
+		want string
+	}{
+		{[]string{"docker", "compose", "up", "-d", "web"}, "docker compose up -d web"},
+		{[]string{"docker", "compose", "-f", "/my stacks/compose.yaml", "ps"}, "docker compose -f '/my stacks/compose.yaml' ps"},
+		{[]string{"echo", ""}, "echo ''"},
+		{[]string{"echo", "it's"}, `echo 'it'\''s'`},
+		{[]string{"echo", "$HOME"}, "echo '$HOME'"},
+	}
+	for _, tt := range tests {
+		if got := quoteArgs(tt.argv); got != tt.want {
+			t.Errorf("quoteArgs(%q) = %s, want %s", tt.argv, got, tt.want)
+		}
+	}
+}
+
+func TestSanitizeProjectName(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{"myapp", "myapp"},
+		{"MyApp", "myapp"},
+		{"My App", "myapp"},
+		{"my app 2", "myapp2"},
+		{"2048-game", "2048-game"},
+		{"42", "42"},
+		{"_private", "private"},
+		{"--flag", "flag"},
+		{"web.example.com", "webexamplecom"},
+		{"Ünïcode", "ncode"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := sanitizeProjectName(tt.name); got != tt.want {
+			t.Errorf("sanitizeProjectName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestProjectNameFromComposeDir(t *testing.T) {
+	t.Setenv("COMPOSE_PROJECT_NAME", "")
+	dcm := newTestManager(t, "project_name: \"\"\n", "", &fakeRunner{})
+	dir := filepath.Join(t.TempDir(), "My Stack")
+	dcm.config.ComposeFiles = []string{filepath.Join(dir, "compose.yaml")}
+	dcm.config.ComposeFile = ""
+
+	if got := dcm.ProjectName(); got != "mystack" {
+		t.Errorf("ProjectName() = %q, want mystack", got)
+	}
+	t.Setenv("COMPOSE_PROJECT_NAME", "Other Name")
+	if got := dcm.ProjectName(); got != "othername" {
+		t.Errorf("ProjectName() with COMPOSE_PROJECT_NAME = %q, want othername", got)
+	}
+}
+
+func TestExpandService(t *testing.T) {
+	t.Setenv("DCMTEST_APP", "web")
+	t.Setenv("DCMTEST_EMPTY", "")
+	tests := map[string]string{
+		"worker":                  "worker",
+		"$DCMTEST_APP":            "web",
+		"${DCMTEST_APP}":          "web",
+		"${DCMTEST_APP}-worker":   "web-worker",
+		"${DCMTEST_EMPTY}":        "",
+		"${DCMTEST_UNSET}-worker": "-worker",
+	}
+	for name, want := range tests {
+		if got := expandService(name); got != want {
+			t.Errorf("expandService(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestResolveService(t *testing.T) {
+	t.Setenv("DCMTEST_APP", "web")
+	t.Setenv("DCMTEST_ROLE", "work")
+	dcm := newTestManager(t, "", "", &fakeRunner{})
+
+	for name, want := range map[string]string{
+		"db":                "db",
+		"$DCMTEST_APP":      "web",
+		"${DCMTEST_ROLE}er": "worker",
+	} {
+		if got, err := dcm.ResolveService(name); err != nil || got != want {
+			t.Errorf("ResolveService(%q) = %q, %v, want %q", name, got, err, want)
+		}
+	}
+
+	_, err := dcm.ResolveService("${DCMTEST_APP}-api")
+	if TypeOf(err) != ErrServiceNotFound || !strings.Contains(err.Error(), `"web-api", expanded from "${DCMTEST_APP}-api"`) {
+		t.Errorf("ResolveService of an unknown expansion = %v, want a %s error naming both", err, ErrServiceNotFound)
+	}
+	if _, err := dcm.ResolveService("${DCMTEST_UNSET}"); TypeOf(err) != ErrUsage {
+		t.Errorf("ResolveService of an unset variable = %v, want a %s error", err, ErrUsage)
+	}
+
+	services, err := dcm.ResolveServices([]string{"${DCMTEST_APP}", "db"})
+	if err != nil || strings.Join(services, " ") != "web db" {
+		t.Errorf("ResolveServices = %q, %v, want web db", services, err)
+	}
+}
+
+func TestStrictServices(t *testing.T) {
+	lenient := "features:\n  strict_service_names: false\n"
+	for name, dcm := range map[string]*Manager{
+		"strict_services":   newTestManager(t, lenient+"strict_services: true\n", "", &fakeRunner{}),
+		"--strict-services": newTestManager(t, lenient, "", &fakeRunner{}, WithStrictServices()),
+	} {
+		runner := dcm.runner.(*fakeRunner)
+		_, err := dcm.Stop("api")
+		if TypeOf(err) != ErrServiceNotFound || ExitCode(err) != 4 {
+			t.Errorf("%s: Stop(api) = %v (exit code %d), want a %s error with exit code 4", name, err, ExitCode(err), ErrServiceNotFound)
+		}
+		if _, err := dcm.Stop("web"); err != nil {
+			t.Errorf("%s: Stop(web) = %v", name, err)
+		}
+		if stops := runner.ran(" stop "); len(stops) != 1 || !strings.HasSuffix(stops[0], " web") {
+			t.Errorf("%s: stop commands = %q, want web only", name, stops)
+		}
+	}
+}
+
+func TestStrictServicesWithoutComposeFiles(t *testing.T) {
+	// Without compose files dcm asks compose for the services
+	dir := t.TempDir()
+	path := filepath.Join(dir, "dcm.config.yml")
+	if err := os.WriteFile(path, []byte("project_name: test\nstrict_services: true\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	runner := &fakeRunner{}
+	runner.stdout("config --services", "web\nworker\n")
+	dcm, err := New(path, WithRunner(runner), WithComposeCommand("docker compose"), WithQuiet())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dcm.validateService("worker"); err != nil {
+		t.Errorf("validateService(worker) = %v", err)
+	}
+	if err := dcm.validateService("db"); TypeOf(err) != ErrServiceNotFound {
+		t.Errorf("validateService(db) = %v, want a %s error", err, ErrServiceNotFound)
+	}
+
+	failing := &fakeRunner{}
+	failing.fail("config --services", 1, "no configuration file provided")
+	dcm, err = New(path, WithRunner(failing), WithComposeCommand("docker compose"), WithQuiet())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dcm.validateService("web"); TypeOf(err) != ErrConfig || ExitCode(err) != 3 {
+		t.Errorf("validateService without a project = %v, want a %s error with exit code 3", err, ErrConfig)
+	}
+}
+
+func TestDiscoverComposeFiles(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []string
+		want  []string
+	}{
+		{"none", nil, nil},
+		{"compose.yaml", []string{"compose.yaml"}, []string{"compose.yaml"}},
+		{"compose.yaml before compose.yml", []string{"compose.yml", "compose.yaml"}, []string{"compose.yaml"}},
+		{"compose.yml", []string{"compose.yml"}, []string{"compose.yml"}},
+		{"compose.yml before docker-compose.yaml", []string{"docker-compose.yaml", "compose.yml"}, []string{"compose.yml"}},
+		{"docker-compose.yaml before docker-compose.yml", []string{"docker-compose.yml", "docker-compose.yaml"}, []string{"docker-compose.yaml"}},
+		{"docker-compose.yml", []string{"docker-compose.yml"}, []string{"docker-compose.yml"}},
+		{"override", []string{"compose.yaml", "compose.override.yaml"}, []string{"compose.yaml", "compose.override.yaml"}},
+		{"override of the other extension", []string{"compose.yaml", "compose.override.yml"}, []string{"compose.yaml", "compose.override.yml"}},
+		{"override .yaml before .yml", []string{"compose.yml", "compose.override.yml", "compose.override.yaml"}, []string{"compose.yml", "compose.override.yaml"}},
+		{"override of another name", []string{"compose.yaml", "docker-compose.override.yml"}, []string{"compose.yaml"}},
+		{"docker-compose override", []string{"docker-compose.yml", "docker-compose.override.yml"}, []string{"docker-compose.yml", "docker-compose.override.yml"}},
+		{"override alone", []string{"compose.override.yaml"}, nil},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		for _, name := range tt.files {
+			if err := os.WriteFile(filepath.Join(dir, name), []byte("services: {}\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		got, err := discoverComposeFiles(dir)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: discoverComposeFiles = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDiscoverComposeFilesSkipsDirectories(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "compose.yaml"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "docker-compose.yml"), []byte("services: {}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := discoverComposeFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"docker-compose.yml"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("discoverComposeFiles = %q, want %q", got, want)
+	}
+}
+
+func TestManagerDiscoversComposeFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"compose.yaml", "compose.override.yaml"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(testCompose), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runner := &fakeRunner{}
+	dcm, err := New(filepath.Join(dir, "dcm.config.yml"), WithRunner(runner), WithComposeCommand("docker compose"), WithQuiet())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := dcm.Compose("ps"); err != nil {
+		t.Fatalf("Compose: %v", err)
+	}
+	want := "-f " + filepath.Join(dir, "compose.yaml") + " -f " + filepath.Join(dir, "compose.override.yaml") + " ps"
+	if commands := runner.commands(); len(commands) != 1 || !strings.HasSuffix(commands[0], want) {
+		t.Errorf("ran %q, want the discovered files layered", commands)
+	}
+}
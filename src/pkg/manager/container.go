@@ -0,0 +1,120 @@
+package manager
+
+import (
+	"context"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// dockerArgs builds the argv of a docker command, for operations on a single
+// container that bypass compose service resolution
+func dockerArgs(args ...string) []string {
+	return append([]string{"docker"}, args...)
+}
+
+// dockerCommand builds a docker command and executes it, the counterpart of
+// compose for --container
+func (dcm *Manager) dockerCommand(args ...string) (string, error) {
+	return dcm.executeCommand(dockerArgs(args...))
+}
+
+// checkContainer checks that id is a plausible container ID or name
+func checkContainer(id string) error {
+	if strings.TrimSpace(id) == "" {
+		return NewError(ErrUsage, "empty container ID")
+	}
+	if strings.HasPrefix(id, "-") || strings.ContainsAny(id, " \t\r\n") {
+		return NewError(ErrUsage, "invalid container ID %q", id)
+	}
+	return nil
+}
+
+// ContainerLogs shows the logs of one container
+func (dcm *Manager) ContainerLogs(ctx context.Context, container string, opts LogOptions, out io.Writer) error {
+	if err := checkContainer(container); err != nil {
+		return err
+	}
+	args := []string{"logs"}
+	if opts.Follow {
+		args = append(args, "--follow")
+	}
+	if opts.Tail >= 0 {
+		args = append(args, "--tail", strconv.Itoa(opts.Tail))
+	}
+	if opts.Since != "" {
+		args = append(args, "--since", opts.Since)
+	}
+	dcm.Infof("Fetching logs...\n")
+	return dcm.executeStreaming(ctx, dockerArgs(append(args, container)...), out, out)
+}
+
+// dockerExecArgs returns the docker exec flags for the options. Unlike
+// compose, docker needs to be asked for stdin and a terminal.
+func (opts ExecOptions) dockerExecArgs() []string {
+	args := []string{"-i"}
+	if !opts.NoTTY && IsTerminal(os.Stdin) {
+		args = append(args, "-t")
+	}
+	if opts.User != "" {
+		args = append(args, "--user", opts.User)
+	}
+	for _, env := range opts.Env {
+		args = append(args, "-e", env)
+	}
+	if opts.Privileged {
+		args = append(args, "--privileged")
+	}
+	return args
+}
+
+// ContainerExec runs a command in one container, a shell when command is
+// empty. A command exiting non-zero returns an error whose exit code
+// ExitCode reports.
+func (dcm *Manager) ContainerExec(container string, command []string, opts ExecOptions) error {
+	if err := checkContainer(container); err != nil {
+		return err
+	}
+	if len(command) == 0 {
+		command = []string{"sh"}
+	}
+	if opts.Privileged {
+		dcm.warnPrivileged(container)
+	}
+	args := append(append([]string{"exec"}, opts.dockerExecArgs()...), container)
+	return dcm.executeInteractive(dockerArgs(append(args, command...)...))
+}
+
+// Inspect prints the docker inspect output of the containers of a service,
+// or of every service in scope when serviceName is empty
+func (dcm *Manager) Inspect(serviceName string) (string, error) {
+	args := []string{"ps", "-q"}
+	if serviceName != "" {
+		if err := dcm.checkService(serviceName); err != nil {
+			dcm.PrintError(err)
+			return "", err
+		}
+		args = append(args, serviceName)
+	} else {
+		args = append(args, dcm.scopedServices()...)
+	}
+	ids, err := dcm.composeOutput(args...)
+	if err == nil && len(strings.Fields(ids)) == 0 {
+		err = NewError(ErrServicesNotRunning, "no containers to inspect")
+	}
+	if err != nil {
+		dcm.PrintError(err)
+		return "", err
+	}
+	return dcm.dockerCommand(append([]string{"inspect"}, strings.Fields(ids)...)...)
+}
+
+// InspectContainer prints the docker inspect output of one container
+func (dcm *Manager) InspectContainer(container string) (string, error) {
+	if err := checkContainer(container); err != nil {
+		dcm.PrintError(err)
+		return "", err
+	}
+	return dcm.dockerCommand("inspect", container)
+}
@@ -0,0 +1,84 @@
+package manager
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestContainerCommands(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(dcm *Manager) error
+		want []string
+	}{
+		{
+			"logs",
+			func(dcm *Manager) error {
+				return dcm.ContainerLogs(context.Background(), "3f2a9c", LogOptions{Tail: -1}, &bytes.Buffer{})
+			},
+			[]string{"docker", "logs", "3f2a9c"},
+		},
+		{
+			"logs with options",
+			func(dcm *Manager) error {
+				opts := LogOptions{Follow: true, Tail: 50, Since: "10m"}
+				return dcm.ContainerLogs(context.Background(), "test-web-2", opts, &bytes.Buffer{})
+			},
+			[]string{"docker", "logs", "--follow", "--tail", "50", "--since", "10m", "test-web-2"},
+		},
+		{
+			"exec shell",
+			func(dcm *Manager) error { return dcm.ContainerExec("3f2a9c", nil, ExecOptions{NoTTY: true}) },
+			[]string{"docker", "exec", "-i", "3f2a9c", "sh"},
+		},
+		{
+			"exec command",
+			func(dcm *Manager) error {
+				opts := ExecOptions{User: "root", Env: []string{"A=1", "B=two words"}, NoTTY: true}
+				return dcm.ContainerExec("3f2a9c", []string{"ls", "-l", "/my dir"}, opts)
+			},
+			[]string{"docker", "exec", "-i", "--user", "root", "-e", "A=1", "-e", "B=two words", "3f2a9c", "ls", "-l", "/my dir"},
+		},
+		{
+			"inspect",
+			func(dcm *Manager) error {
+				_, err := dcm.InspectContainer("3f2a9c")
+				return err
+			},
+			[]string{"docker", "inspect", "3f2a9c"},
+		},
+	}
+	for _, tt := range tests {
+		runner := &fakeRunner{}
+		dcm := newTestManager(t, "", "", runner)
+		if err := tt.run(dcm); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if len(runner.calls) != 1 || !reflect.DeepEqual(runner.calls[0], tt.want) {
+			t.Errorf("%s ran %q, want %q", tt.name, runner.calls, tt.want)
+		}
+	}
+}
+
+func TestContainerCommandsRejectInvalidIDs(t *testing.T) {
+	runner := &fakeRunner{}
+	dcm := newTestManager(t, "", "", runner)
+
+	for _, id := range []string{"", " ", "-f", "--rm", "abc def", "abc\n"} {
+		if err := dcm.ContainerLogs(context.Background(), id, LogOptions{Tail: -1}, &bytes.Buffer{}); TypeOf(err) != ErrUsage {
+			t.Errorf("ContainerLogs(%q) = %v, want a %s error", id, err, ErrUsage)
+		}
+		if err := dcm.ContainerExec(id, nil, ExecOptions{}); TypeOf(err) != ErrUsage {
+			t.Errorf("ContainerExec(%q) = %v, want a %s error", id, err, ErrUsage)
+		}
+		if _, err := dcm.InspectContainer(id); TypeOf(err) != ErrUsage {
+			t.Errorf("InspectContainer(%q) = %v, want a %s error", id, err, ErrUsage)
+		}
+	}
+	if len(runner.calls) > 0 {
+		t.Errorf("invalid container IDs ran %q", runner.calls)
+	}
+}
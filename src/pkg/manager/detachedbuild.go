@@ -0,0 +1,254 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// BuildRunnerCommand is the hidden command a detached build runs in the
+// background process
+const BuildRunnerCommand = "build-runner"
+
+// BuildJob is a build running in the background, as recorded in its state
+// file
+type BuildJob struct {
+	PID       int       `json:"pid"`
+	Service   string    `json:"service,omitempty"`
+	Log       string    `json:"log"`
+	StartedAt time.Time `json:"started_at"`
+	// FinishedAt and ExitCode are recorded by the background process in a
+	// result file of its own when the build ends
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	ExitCode   *int       `json:"exit_code,omitempty"`
+}
+
+// buildJobResult is the end of a detached build
+type buildJobResult struct {
+	FinishedAt time.Time `json:"finished_at"`
+	ExitCode   int       `json:"exit_code"`
+}
+
+// Build job states reported by BuildJob.state
+const (
+	buildRunning   = "running"
+	buildSucceeded = "succeeded"
+	buildFailed    = "failed"
+	// buildLost is a build whose process is gone without recording its end
+	buildLost = "lost"
+)
+
+func (job *BuildJob) state() string {
+	switch {
+	case job.ExitCode != nil && *job.ExitCode == 0:
+		return buildSucceeded
+	case job.ExitCode != nil:
+		return buildFailed
+	case pidAlive(job.PID):
+		return buildRunning
+	}
+	return buildLost
+}
+
+func (dcm *Manager) buildJobPath() string {
+	return filepath.Join(dcm.stateDir(), "build.json")
+}
+
+func (dcm *Manager) buildResultPath() string {
+	return filepath.Join(dcm.stateDir(), "build-result.json")
+}
+
+// readBuildJob loads the state file of the last detached build along with
+// its result if it ended, nil when there is none
+func (dcm *Manager) readBuildJob() (*BuildJob, error) {
+	var job BuildJob
+	if err := readJSONFile(dcm.buildJobPath(), &job); os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var result buildJobResult
+	if err := readJSONFile(dcm.buildResultPath(), &result); err == nil {
+		job.FinishedAt, job.ExitCode = &result.FinishedAt, &result.ExitCode
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// readJSONFile decodes the JSON file at path into v
+func readJSONFile(path string, v interface{}) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("parsing %s: %v", path, err)
+	}
+	return nil
+}
+
+// StartDetachedBuild starts Build in a background process logging to a file
+// in the state directory, and returns without waiting for it. Only one
+// detached build runs at a time.
+func (dcm *Manager) StartDetachedBuild(serviceName string) (*BuildJob, error) {
+	if serviceName != "" {
+		if err := dcm.checkService(serviceName); err != nil {
+			return nil, err
+		}
+	}
+	if job, err := dcm.readBuildJob(); err != nil {
+		return nil, err
+	} else if job != nil && job.state() == buildRunning {
+		return nil, fmt.Errorf("a build is already running (pid %d), see: dcm build-status", job.PID)
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"--config", dcm.configPath}
+	if dcm.composeOverride != "" {
+		args = append(args, "--compose-command", dcm.composeOverride)
+	}
+	if dcm.project != "" {
+		args = append(args, "--project", dcm.project)
+	}
+	args = append(args, BuildRunnerCommand)
+	if dcm.allServices {
+		args = append(args, "--all")
+	}
+	if dcm.force {
+		args = append(args, "--force")
+	}
+	if serviceName != "" {
+		args = append(args, serviceName)
+	}
+
+	if err := os.MkdirAll(dcm.stateDir(), 0775); err != nil {
+		return nil, err
+	}
+	if err := os.Remove(dcm.buildResultPath()); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	logPath := filepath.Join(dcm.stateDir(), "build.log")
+	logFile, err := os.Create(logPath)
+	if err != nil {
+		return nil, err
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(self, args...)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	detach(cmd)
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	job := &BuildJob{PID: cmd.Process.Pid, Service: serviceName, Log: logPath, StartedAt: time.Now()}
+	if err := writeJSONFile(dcm.buildJobPath(), job); err != nil {
+		return nil, err
+	}
+	// The child records its own end, it is not waited for here
+	cmd.Process.Release()
+	return job, nil
+}
+
+// RunDetachedBuild is the background side of a detached build: it builds
+// and records the exit code in the result file
+func (dcm *Manager) RunDetachedBuild(serviceName string) error {
+	_, err := dcm.Build(serviceName)
+
+	result := buildJobResult{FinishedAt: time.Now()}
+	if err != nil {
+		result.ExitCode = ExitCode(err)
+	}
+	if writeErr := writeJSONFile(dcm.buildResultPath(), result); writeErr != nil {
+		dcm.Warnf("could not record the end of the build: %v\n", writeErr)
+	}
+	return err
+}
+
+// lastLines returns the last n lines of a file
+func lastLines(path string, n int) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// PrintBuildStatus shows the state of the last detached build and the end
+// of its log
+func (dcm *Manager) PrintBuildStatus(logLines int) error {
+	job, err := dcm.readBuildJob()
+	if err != nil {
+		return err
+	}
+	if job == nil {
+		fmt.Println("No detached build has been started")
+		return nil
+	}
+
+	target := job.Service
+	if target == "" {
+		target = "all services"
+	}
+	state := job.state()
+	switch state {
+	case buildRunning:
+		fmt.Printf("Build of %s running for %s (pid %d)\n", target, time.Since(job.StartedAt).Round(time.Second), job.PID)
+	case buildLost:
+		fmt.Printf("Build of %s stopped without recording a result (pid %d)\n", target, job.PID)
+	default:
+		fmt.Printf("Build of %s %s with exit code %d after %s\n", target, state, *job.ExitCode,
+			job.FinishedAt.Sub(job.StartedAt).Round(time.Second))
+	}
+	fmt.Printf("Log: %s\n", job.Log)
+	if logLines > 0 {
+		if tail, err := lastLines(job.Log, logLines); err == nil && tail != "" {
+			fmt.Println(tail)
+		}
+	}
+	return nil
+}
+
+// WaitBuild blocks until the last detached build ends, or timeout passes
+// when it is positive, and fails if the build failed
+func (dcm *Manager) WaitBuild(timeout time.Duration) error {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	for {
+		job, err := dcm.readBuildJob()
+		if err != nil {
+			return err
+		}
+		if job == nil {
+			return NewError(ErrUsage, "no detached build has been started")
+		}
+		switch job.state() {
+		case buildSucceeded:
+			dcm.Infof("Build finished\n")
+			return nil
+		case buildFailed:
+			return NewError(ErrGeneric, "build failed with exit code %d, see %s", *job.ExitCode, job.Log)
+		case buildLost:
+			return NewError(ErrGeneric, "build process %d stopped without recording a result, see %s", job.PID, job.Log)
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return NewError(ErrGeneric, "build still running after %s (pid %d)", timeout, job.PID)
+		}
+		time.Sleep(time.Second)
+	}
+}
@@ -0,0 +1,96 @@
+package manager
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeBuildJob records a detached build of pid as StartDetachedBuild does
+func writeBuildJob(t *testing.T, dcm *Manager, pid int) {
+	t.Helper()
+	if err := os.MkdirAll(dcm.stateDir(), 0o775); err != nil {
+		t.Fatal(err)
+	}
+	job := &BuildJob{PID: pid, Service: "web", Log: filepath.Join(dcm.stateDir(), "build.log"), StartedAt: time.Now()}
+	if err := writeJSONFile(dcm.buildJobPath(), job); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDetachedBuildLifecycle(t *testing.T) {
+	runner := &fakeRunner{}
+	dcm := newTestManager(t, "", "", runner)
+
+	if job, err := dcm.readBuildJob(); job != nil || err != nil {
+		t.Fatalf("readBuildJob before any build = %+v, %v", job, err)
+	}
+	if err := dcm.WaitBuild(0); TypeOf(err) != ErrUsage {
+		t.Errorf("WaitBuild without a build = %v, want a %s error", err, ErrUsage)
+	}
+
+	// The process of this test stands in for the background build
+	writeBuildJob(t, dcm, os.Getpid())
+	job, err := dcm.readBuildJob()
+	if err != nil || job == nil || job.state() != buildRunning {
+		t.Fatalf("started build = %+v, %v, want it running", job, err)
+	}
+	if err := dcm.WaitBuild(time.Nanosecond); err == nil || !strings.Contains(err.Error(), "still running") {
+		t.Errorf("WaitBuild on a running build = %v, want a timeout", err)
+	}
+	if _, err := dcm.StartDetachedBuild("web"); err == nil || !strings.Contains(err.Error(), "already running") {
+		t.Errorf("StartDetachedBuild during a build = %v, want it refused", err)
+	}
+
+	if err := dcm.RunDetachedBuild("web"); err != nil {
+		t.Fatalf("RunDetachedBuild: %v", err)
+	}
+	if builds := runner.ran(" build"); len(builds) != 1 || !strings.HasSuffix(builds[0], " web") {
+		t.Errorf("build commands = %q", builds)
+	}
+	job, err = dcm.readBuildJob()
+	if err != nil || job.state() != buildSucceeded || job.FinishedAt == nil {
+		t.Fatalf("finished build = %+v, %v, want it succeeded", job, err)
+	}
+	if err := dcm.WaitBuild(0); err != nil {
+		t.Errorf("WaitBuild on a finished build: %v", err)
+	}
+}
+
+func TestDetachedBuildFailure(t *testing.T) {
+	runner := &fakeRunner{}
+	runner.fail(" build", 3, "failed to solve")
+	dcm := newTestManager(t, "", "", runner)
+	writeBuildJob(t, dcm, os.Getpid())
+
+	if err := dcm.RunDetachedBuild("web"); err == nil {
+		t.Fatal("RunDetachedBuild succeeded")
+	}
+	job, err := dcm.readBuildJob()
+	if err != nil || job.state() != buildFailed || *job.ExitCode != 3 {
+		t.Fatalf("failed build = %+v, %v, want failed with code 3", job, err)
+	}
+	if err := dcm.WaitBuild(0); err == nil || !strings.Contains(err.Error(), "exit code 3") {
+		t.Errorf("WaitBuild = %v, want the exit code of the build", err)
+	}
+}
+
+func TestDetachedBuildLost(t *testing.T) {
+	dcm := newTestManager(t, "", "", &fakeRunner{})
+	exited := exec.Command(os.Args[0], "-test.run=^$")
+	if err := exited.Run(); err != nil {
+		t.Fatal(err)
+	}
+	writeBuildJob(t, dcm, exited.Process.Pid)
+
+	job, err := dcm.readBuildJob()
+	if err != nil || job.state() != buildLost {
+		t.Fatalf("build of an exited process = %+v, %v, want it lost", job, err)
+	}
+	if err := dcm.WaitBuild(0); err == nil || !strings.Contains(err.Error(), "without recording a result") {
+		t.Errorf("WaitBuild = %v, want the build reported lost", err)
+	}
+}
@@ -0,0 +1,86 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// doctorCheck is a single environment check run by Doctor
+type doctorCheck struct {
+	name string
+	run  func() error
+}
+
+// Doctor checks that the tools and files the manager relies on are in place
+// and reports every problem it finds rather than stopping at the first one.
+func (dcm *Manager) Doctor() error {
+	checks := []doctorCheck{
+		{"compose is installed", func() error {
+			args := append(append([]string(nil), dcm.composeCmd[1:]...), "version")
+			if output, err := dcm.hostCommand(context.Background(), append(dcm.composeCmd[:1:1], args...)...).CombinedOutput(); err != nil {
+				return fmt.Errorf("%s: %v: %s", strings.Join(dcm.composeCmd, " "), err, firstLine(string(output)))
+			}
+			return nil
+		}},
+		{"docker daemon is reachable", func() error {
+			if output, err := dcm.hostCommand(context.Background(), "docker", "info").CombinedOutput(); err != nil {
+				return fmt.Errorf("%v: %s", err, firstLine(string(output)))
+			}
+			return nil
+		}},
+		{"config file exists", func() error {
+			_, err := os.Stat(dcm.configPath)
+			return err
+		}},
+		{"compose files exist", func() error {
+			for _, f := range dcm.config.composeFiles() {
+				if _, err := os.Stat(f); err != nil {
+					return fmt.Errorf("%s not found", f)
+				}
+			}
+			return nil
+		}},
+		{"compose configuration is valid", func() error {
+			argv, err := dcm.composeArgs("config", "-q")
+			if err != nil {
+				return err
+			}
+			started := time.Now()
+			output, err := dcm.command(context.Background(), argv).CombinedOutput()
+			dcm.logCommand(argv, started, err)
+			if err != nil {
+				return fmt.Errorf("%v: %s", err, firstLine(string(output)))
+			}
+			return nil
+		}},
+	}
+
+	fmt.Println("Running doctor checks...")
+	failed := 0
+	for _, check := range checks {
+		if err := check.run(); err != nil {
+			failed++
+			fmt.Printf("  [FAIL] %s: %v\n", check.name, err)
+			continue
+		}
+		fmt.Printf("  [ OK ] %s\n", check.name)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d doctor checks failed", failed, len(checks))
+	}
+	return nil
+}
+
+// firstLine returns the first non-empty line of s
+func firstLine(s string) string {
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line
+		}
+	}
+	return ""
+}
@@ -0,0 +1,40 @@
+package manager
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// dryRunSkip prints the command a dry run would execute, reporting whether
+// it must be skipped
+func (dcm *Manager) dryRunSkip(argv []string) bool {
+	if !dcm.dryRun {
+		return false
+	}
+	fmt.Printf("Would run: %s\n", quoteArgs(argv))
+	return true
+}
+
+// ConfirmDestructive asks the user to confirm an operation that loses
+// state, such as removing containers. It only asks on a terminal, with the
+// confirm_destructive feature enabled and without --yes or --dry-run.
+func (dcm *Manager) ConfirmDestructive(question string) error {
+	if dcm.yes || dcm.dryRun || !dcm.FeatureEnabled("confirm_destructive") || !IsTerminal(os.Stdin) {
+		return nil
+	}
+	if dcm.input == nil {
+		dcm.input = bufio.NewScanner(os.Stdin)
+	}
+	fmt.Printf("%s [y/N]: ", question)
+	if dcm.input.Scan() {
+		answer := strings.ToLower(strings.TrimSpace(dcm.input.Text()))
+		if answer == "y" || answer == "yes" {
+			return nil
+		}
+	} else {
+		fmt.Println()
+	}
+	return NewError(ErrUsage, "cancelled, pass --yes to skip the confirmation")
+}
@@ -0,0 +1,116 @@
+package manager
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Sources of the environment of compose commands, as named in env_precedence
+const (
+	envSourceHost    = "host"
+	envSourceEnvFile = "env_file"
+	envSourceConfig  = "config"
+)
+
+// defaultEnvPrecedence lets the host environment win over the env file, as
+// compose does, and both win over the environment block of the config
+var defaultEnvPrecedence = []string{envSourceHost, envSourceEnvFile, envSourceConfig}
+
+// readEnvFile parses KEY=VALUE lines the way compose reads an env file:
+// blank lines and # comments are skipped, an export prefix is allowed and
+// matching quotes around the value are removed
+func readEnvFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	vars := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		parts := strings.SplitN(line, "=", 2)
+		key := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || key == "" {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE", path, n)
+		}
+		value := strings.TrimSpace(parts[1])
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		vars[key] = value
+	}
+	return vars, scanner.Err()
+}
+
+// hostEnv returns the environment of this process as a map
+func hostEnv() map[string]string {
+	vars := make(map[string]string)
+	for _, kv := range os.Environ() {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) == 2 {
+			vars[parts[0]] = parts[1]
+		}
+	}
+	return vars
+}
+
+// childEnv merges the host environment, the env file and the environment
+// block of the config in the order of env_precedence, the first source
+// defining a variable winning. It returns nil, meaning the host environment
+// is inherited unchanged, when the config sets neither.
+func (dcm *Manager) childEnv() ([]string, error) {
+	if len(dcm.config.Environment) == 0 && len(dcm.config.EnvPrecedence) == 0 {
+		return nil, nil
+	}
+	order := dcm.config.EnvPrecedence
+	if len(order) == 0 {
+		order = defaultEnvPrecedence
+	}
+
+	seen := make(map[string]bool)
+	var sources []map[string]string
+	for _, source := range order {
+		if seen[source] {
+			return nil, NewError(ErrConfig, "%s: env_precedence lists %s twice", dcm.configPath, source)
+		}
+		seen[source] = true
+		switch source {
+		case envSourceHost:
+			sources = append(sources, hostEnv())
+		case envSourceConfig:
+			sources = append(sources, dcm.config.Environment)
+		case envSourceEnvFile:
+			if dcm.config.EnvFile == "" {
+				continue
+			}
+			vars, err := readEnvFile(dcm.config.EnvFile)
+			if err != nil {
+				return nil, NewError(ErrConfig, "env_file: %w", err)
+			}
+			sources = append(sources, vars)
+		default:
+			return nil, NewError(ErrConfig, "%s: env_precedence: unknown source %q, expected host, env_file or config",
+				dcm.configPath, source)
+		}
+	}
+
+	merged := make(map[string]string)
+	for i := len(sources) - 1; i >= 0; i-- {
+		for k, v := range sources[i] {
+			merged[k] = v
+		}
+	}
+	env := make([]string, 0, len(merged))
+	for k, v := range merged {
+		env = append(env, k+"="+v)
+	}
+	return env, nil
+}
@@ -0,0 +1,98 @@
+package manager
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// envOf returns the variables of an environment starting with prefix
+func envOf(env []string, prefix string) map[string]string {
+	vars := make(map[string]string)
+	for _, kv := range env {
+		if parts := strings.SplitN(kv, "=", 2); len(parts) == 2 && strings.HasPrefix(parts[0], prefix) {
+			vars[parts[0]] = parts[1]
+		}
+	}
+	return vars
+}
+
+func TestEnvPrecedence(t *testing.T) {
+	t.Setenv("DCMTEST_A", "host")
+	t.Setenv("DCMTEST_B", "host")
+	envFile := filepath.Join(t.TempDir(), "test.env")
+	content := "# comment\nDCMTEST_A=file\nexport DCMTEST_C=\"file\"\n\nDCMTEST_E='quoted value'\n"
+	if err := os.WriteFile(envFile, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	config := "env_file: " + envFile + `
+environment:
+  DCMTEST_A: config
+  DCMTEST_B: config
+  DCMTEST_C: config
+  DCMTEST_D: config
+`
+	tests := []struct {
+		precedence string
+		want       map[string]string
+	}{
+		{"", map[string]string{"DCMTEST_A": "host", "DCMTEST_B": "host", "DCMTEST_C": "file", "DCMTEST_D": "config", "DCMTEST_E": "quoted value"}},
+		{"env_precedence: [config, env_file, host]\n", map[string]string{"DCMTEST_A": "config", "DCMTEST_B": "config", "DCMTEST_C": "config", "DCMTEST_D": "config", "DCMTEST_E": "quoted value"}},
+		{"env_precedence: [env_file, host, config]\n", map[string]string{"DCMTEST_A": "file", "DCMTEST_B": "host", "DCMTEST_C": "file", "DCMTEST_D": "config", "DCMTEST_E": "quoted value"}},
+		// A source left out of env_precedence is not used at all
+		{"env_precedence: [config]\n", map[string]string{"DCMTEST_A": "config", "DCMTEST_B": "config", "DCMTEST_C": "config", "DCMTEST_D": "config"}},
+	}
+	for _, tt := range tests {
+		runner := &fakeRunner{}
+		var env []string
+		runner.handle(" ps", func(_ context.Context, cmd *Cmd) error {
+			env = cmd.Env
+			return nil
+		})
+		dcm := newTestManager(t, config+tt.precedence, "", runner)
+		if _, err := dcm.composeOutput("ps"); err != nil {
+			t.Fatal(err)
+		}
+		got := envOf(env, "DCMTEST_")
+		if len(got) != len(tt.want) {
+			t.Errorf("%q: environment %v, want %v", tt.precedence, got, tt.want)
+			continue
+		}
+		for k, v := range tt.want {
+			if got[k] != v {
+				t.Errorf("%q: %s=%q, want %q", tt.precedence, k, got[k], v)
+			}
+		}
+	}
+}
+
+func TestEnvPrecedenceErrors(t *testing.T) {
+	for _, precedence := range []string{"[host, host]", "[host, shell]"} {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "dcm.config.yml")
+		config := "compose_file: compose.yaml\nenv_precedence: " + precedence + "\n"
+		if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "compose.yaml"), []byte(testCompose), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := New(path, WithRunner(&fakeRunner{}), WithComposeCommand("docker compose"), WithQuiet())
+		if TypeOf(err) != ErrConfig {
+			t.Errorf("env_precedence: %s = %v, want a %s error", precedence, err, ErrConfig)
+		}
+	}
+}
+
+func TestReadEnvFileRejectsInvalidLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bad.env")
+	if err := os.WriteFile(path, []byte("OK=1\nnot a variable\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := readEnvFile(path)
+	if err == nil || !strings.Contains(err.Error(), ":2:") {
+		t.Errorf("readEnvFile = %v, want an error on line 2", err)
+	}
+}
@@ -0,0 +1,105 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrorType classifies the failures scripts need to tell apart
+type ErrorType string
+
+// The error types, reported as the type of JSON error envelopes
+const (
+	ErrUsage                ErrorType = "Usage"
+	ErrConfig               ErrorType = "ConfigError"
+	ErrServiceNotFound      ErrorType = "ServiceNotFound"
+	ErrServiceNotConfigured ErrorType = "ServiceNotConfigured"
+	ErrComposeNotFound      ErrorType = "ComposeNotFound"
+	ErrServicesNotRunning   ErrorType = "ServicesNotRunning"
+	ErrServicesNotReady     ErrorType = "ServicesNotReady"
+	ErrLintFindings         ErrorType = "LintFindings"
+	// ErrCommandFailed is a compose or docker command exiting non-zero,
+	// reported with that command's exit code
+	ErrCommandFailed ErrorType = "CommandFailed"
+	ErrGeneric       ErrorType = "Error"
+)
+
+// errorExitCodes are the process exit codes of the typed failures
+var errorExitCodes = map[ErrorType]int{
+	ErrUsage:                2,
+	ErrConfig:               3,
+	ErrServiceNotFound:      4,
+	ErrServiceNotConfigured: 4,
+	ErrComposeNotFound:      5,
+	ErrServicesNotRunning:   6,
+	ErrServicesNotReady:     6,
+}
+
+// dcmError is a failure of a known type
+type dcmError struct {
+	Type ErrorType
+	Err  error
+}
+
+func (e *dcmError) Error() string { return e.Err.Error() }
+func (e *dcmError) Unwrap() error { return e.Err }
+
+// NewError formats an error of the given type. %w is supported.
+func NewError(t ErrorType, format string, args ...interface{}) error {
+	return &dcmError{Type: t, Err: fmt.Errorf(format, args...)}
+}
+
+// exitCoder is a command that exited non-zero, such as an *exec.ExitError
+// or the error of a fake Runner
+type exitCoder interface {
+	error
+	ExitCode() int
+}
+
+// TypeOf returns the type of an error, looking through wrapping
+func TypeOf(err error) ErrorType {
+	var typed *dcmError
+	if errors.As(err, &typed) {
+		return typed.Type
+	}
+	var exitErr exitCoder
+	if errors.As(err, &exitErr) {
+		return ErrCommandFailed
+	}
+	return ErrGeneric
+}
+
+// ExitCode returns the process exit code for an error: the code of its type,
+// or the exit code of the failed command so scripts can tell failures apart.
+func ExitCode(err error) int {
+	if code, ok := errorExitCodes[TypeOf(err)]; ok {
+		return code
+	}
+	var exitErr exitCoder
+	if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+		return exitErr.ExitCode()
+	}
+	return 1
+}
+
+// errorEnvelope is how failures are reported with --output json
+type errorEnvelope struct {
+	Error struct {
+		Type     ErrorType `json:"type"`
+		Message  string    `json:"message"`
+		ExitCode int       `json:"exit_code"`
+	} `json:"error"`
+}
+
+// WriteErrorJSON writes err to w as a JSON error envelope
+func WriteErrorJSON(w io.Writer, err error) error {
+	var envelope errorEnvelope
+	envelope.Error.Type = TypeOf(err)
+	envelope.Error.Message = err.Error()
+	envelope.Error.ExitCode = ExitCode(err)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(envelope)
+}
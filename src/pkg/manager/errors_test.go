@@ -0,0 +1,95 @@
+package manager
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestErrorEnvelope(t *testing.T) {
+	tests := []struct {
+		err      error
+		typ      ErrorType
+		exitCode int
+	}{
+		{NewError(ErrUsage, "unknown flag -x"), ErrUsage, 2},
+		{NewError(ErrConfig, "dcm.config.yml: bad yaml"), ErrConfig, 3},
+		{NewError(ErrServiceNotFound, "unknown service %q", "api"), ErrServiceNotFound, 4},
+		{NewError(ErrServiceNotConfigured, "web is not in services"), ErrServiceNotConfigured, 4},
+		{NewError(ErrComposeNotFound, "no compose"), ErrComposeNotFound, 5},
+		{NewError(ErrServicesNotRunning, "nothing running"), ErrServicesNotRunning, 6},
+		{NewError(ErrServicesNotReady, "db is unhealthy"), ErrServicesNotReady, 6},
+		{NewError(ErrLintFindings, "lint found problems"), ErrLintFindings, 1},
+		{fakeExit(7), ErrCommandFailed, 7},
+		{fmt.Errorf("starting web: %w", fakeExit(125)), ErrCommandFailed, 125},
+		{errors.New("something else"), ErrGeneric, 1},
+		// The type of the outermost typed error wins over a wrapped exit code
+		{NewError(ErrConfig, "reading config: %w", fakeExit(9)), ErrConfig, 3},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := WriteErrorJSON(&buf, tt.err); err != nil {
+			t.Fatal(err)
+		}
+		var envelope map[string]map[string]interface{}
+		if err := json.Unmarshal(buf.Bytes(), &envelope); err != nil {
+			t.Fatalf("envelope of %v is not JSON: %v\n%s", tt.err, err, buf.String())
+		}
+		if len(envelope) != 1 || envelope["error"] == nil {
+			t.Errorf("envelope of %v = %s, want a single error object", tt.err, buf.String())
+			continue
+		}
+		got := envelope["error"]
+		if got["type"] != string(tt.typ) || got["message"] != tt.err.Error() || got["exit_code"] != float64(tt.exitCode) {
+			t.Errorf("envelope of %v = %v, want type %s and exit code %d", tt.err, got, tt.typ, tt.exitCode)
+		}
+		if code := ExitCode(tt.err); code != tt.exitCode {
+			t.Errorf("ExitCode(%v) = %d, want %d", tt.err, code, tt.exitCode)
+		}
+	}
+}
+
+func TestErrorEnvelopeDoesNotEscapeHTML(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteErrorJSON(&buf, NewError(ErrUsage, "expected <service> & <replicas>")); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(buf.Bytes(), []byte("<service> & <replicas>")) {
+		t.Errorf("envelope = %s", buf.String())
+	}
+}
+
+func TestMalformedConfigIsAConfigError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dcm.config.yml")
+	if err := os.WriteFile(path, []byte("services: [web\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := New(path, WithRunner(&fakeRunner{}), WithComposeCommand("docker compose"), WithQuiet())
+	if TypeOf(err) != ErrConfig || ExitCode(err) != 3 {
+		t.Errorf("New = %v (%s, exit code %d), want a %s error exiting 3", err, TypeOf(err), ExitCode(err), ErrConfig)
+	}
+}
+
+func TestMissingComposeIsComposeNotFound(t *testing.T) {
+	t.Setenv("DCM_COMPOSE_BIN", "")
+	runner := &fakeRunner{}
+	dcm := newTestManager(t, "", "", runner)
+
+	// Neither candidate answers its version subcommand
+	dcm.composeOverride = ""
+	runner.fail("version", 127, "command not found")
+	if _, err := dcm.resolveComposeCommand(); TypeOf(err) != ErrComposeNotFound || ExitCode(err) != 5 {
+		t.Errorf("resolveComposeCommand = %v, want a %s error exiting 5", err, ErrComposeNotFound)
+	}
+
+	// An override naming a program that is not installed
+	dcm.runner = ExecRunner{}
+	dcm.composeOverride = "dcm-test-no-such-compose"
+	if _, err := dcm.resolveComposeCommand(); TypeOf(err) != ErrComposeNotFound {
+		t.Errorf("resolveComposeCommand with a missing override = %v, want a %s error", err, ErrComposeNotFound)
+	}
+}
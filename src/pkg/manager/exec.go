@@ -0,0 +1,135 @@
+package manager
+
+import (
+	"context"
+	"os"
+	"strings"
+	"time"
+)
+
+// ExecOptions controls how a command runs inside a service container
+type ExecOptions struct {
+	// User runs the command as this user instead of the image's default
+	User string
+	// Env sets environment variables, as KEY=VALUE or KEY to pass the
+	// variable through from the current environment
+	Env []string
+	// NoTTY disables the pseudo-terminal, for scripts and piped input
+	NoTTY bool
+	// Privileged gives the process extended privileges, for debugging tools
+	// such as strace. Only exec supports it.
+	Privileged bool
+}
+
+// args returns the docker-compose flags for the options
+func (opts ExecOptions) args() []string {
+	var args []string
+	// Without a terminal on our side, compose cannot allocate one either
+	if opts.NoTTY || !IsTerminal(os.Stdin) {
+		args = append(args, "-T")
+	}
+	if opts.User != "" {
+		args = append(args, "--user", opts.User)
+	}
+	for _, env := range opts.Env {
+		args = append(args, "-e", env)
+	}
+	if opts.Privileged {
+		args = append(args, "--privileged")
+	}
+	return args
+}
+
+// warnPrivileged tells the user the command runs with extended privileges
+func (dcm *Manager) warnPrivileged(target string) {
+	dcm.Warnf("running privileged in %s, the command has full access to the host\n", target)
+}
+
+// Compose runs a compose command against the configured files and project,
+// attached to the terminal
+func (dcm *Manager) Compose(args ...string) error {
+	argv, err := dcm.composeArgs(args...)
+	if err != nil {
+		return err
+	}
+	return dcm.executeInteractive(argv)
+}
+
+// executeInteractive runs a command attached to the terminal, so programs
+// such as shells can read input and draw on the screen
+func (dcm *Manager) executeInteractive(argv []string) error {
+	if dcm.dryRunSkip(argv) {
+		return nil
+	}
+	dcm.Infof("Executing: %s\n", quoteArgs(argv))
+
+	started := time.Now()
+	cmd := dcm.command(context.Background(), argv)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	dcm.logCommand(argv, started, err)
+	return err
+}
+
+// fallbackShell starts bash when the image has it and sh otherwise, for
+// services without a shell in the config
+var fallbackShell = []string{"sh", "-c", "if command -v bash >/dev/null 2>&1; then exec bash; else exec sh; fi"}
+
+// serviceShell returns the shell to open in a service: the one set in the
+// shells section of the config, or bash falling back to sh
+func (dcm *Manager) serviceShell(service string) []string {
+	if shell := strings.Fields(dcm.config.Shells[service]); len(shell) > 0 {
+		return shell
+	}
+	return fallbackShell
+}
+
+// Exec runs a command in the running container of a service, its shell when
+// command is empty, see serviceShell. A command exiting non-zero returns an
+// error whose exit code ExitCode reports.
+func (dcm *Manager) Exec(service string, command []string, opts ExecOptions) error {
+	if err := dcm.checkService(service); err != nil {
+		return err
+	}
+	statuses, err := dcm.StatusDetailed(context.Background())
+	if err != nil {
+		return err
+	}
+	if len(notRunning(statuses, []string{service})) > 0 {
+		return NewError(ErrServicesNotRunning, "%s is not running, start it first with: dcm start %s", service, service)
+	}
+	if len(command) == 0 {
+		command = dcm.serviceShell(service)
+	}
+	if opts.Privileged {
+		dcm.warnPrivileged(service)
+	}
+
+	args := append([]string{"exec"}, opts.args()...)
+	argv, err := dcm.composeArgs(append(append(args, service), command...)...)
+	if err != nil {
+		return err
+	}
+	return dcm.executeInteractive(argv)
+}
+
+// Run runs a command in a new, throwaway container of a service, removed
+// once the command exits. Without a command the service's own command runs.
+func (dcm *Manager) Run(service string, command []string, opts ExecOptions) error {
+	if err := dcm.checkService(service); err != nil {
+		return err
+	}
+	if opts.Privileged {
+		// compose run has no --privileged, unlike compose exec
+		return NewError(ErrUsage, "run does not support --privileged, use exec --privileged on a running container or set privileged: true on the service")
+	}
+
+	args := append([]string{"run", "--rm"}, opts.args()...)
+	argv, err := dcm.composeArgs(append(append(args, service), command...)...)
+	if err != nil {
+		return err
+	}
+	return dcm.executeInteractive(argv)
+}
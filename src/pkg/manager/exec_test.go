@@ -0,0 +1,77 @@
+package manager
+
+import (
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// captureStderr returns what fn writes to stderr
+func captureStderr(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := os.Stderr
+	os.Stderr = w
+	defer func() { os.Stderr = saved }()
+
+	done := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(r)
+		done <- data
+	}()
+	fn()
+	w.Close()
+	return string(<-done)
+}
+
+func TestExecPrivileged(t *testing.T) {
+	runner := &fakeRunner{}
+	runner.stdout("ps -a --format json", `{"Name":"test-web-1","Service":"web","State":"running"}`+"\n")
+	dcm := newTestManager(t, "", "", runner)
+
+	var err error
+	warning := captureStderr(t, func() {
+		err = dcm.Exec("web", []string{"strace", "-p", "1"}, ExecOptions{NoTTY: true, Privileged: true})
+	})
+	if err != nil {
+		t.Fatalf("Exec: %v", err)
+	}
+	execs := runner.ran(" exec ")
+	if len(execs) != 1 || !strings.HasSuffix(execs[0], " exec -T --privileged web strace -p 1") {
+		t.Errorf("ran %q, want one compose exec with --privileged", execs)
+	}
+	if !strings.Contains(warning, "Warning: running privileged in web") {
+		t.Errorf("stderr = %q, want a warning about the privileges", warning)
+	}
+}
+
+func TestRunRejectsPrivileged(t *testing.T) {
+	runner := &fakeRunner{}
+	dcm := newTestManager(t, "", "", runner)
+
+	err := dcm.Run("web", []string{"strace"}, ExecOptions{Privileged: true})
+	if TypeOf(err) != ErrUsage {
+		t.Errorf("Run = %v, want a %s error", err, ErrUsage)
+	}
+	if calls := runner.commands(); len(calls) != 0 {
+		t.Errorf("ran %q, want nothing", calls)
+	}
+}
+
+func TestContainerExecPrivileged(t *testing.T) {
+	runner := &fakeRunner{}
+	dcm := newTestManager(t, "", "", runner)
+
+	if err := dcm.ContainerExec("3f2a9c", []string{"strace"}, ExecOptions{NoTTY: true, Privileged: true}); err != nil {
+		t.Fatalf("ContainerExec: %v", err)
+	}
+	want := []string{"docker", "exec", "-i", "--privileged", "3f2a9c", "strace"}
+	if len(runner.calls) != 1 || !reflect.DeepEqual(runner.calls[0], want) {
+		t.Errorf("ran %q, want %q", runner.calls, want)
+	}
+}
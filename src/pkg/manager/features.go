@@ -0,0 +1,134 @@
+package manager
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// featureEnvPrefix prefixes the environment variables overriding feature
+// flags, e.g. DCM_FEATURE_REMOVE_ORPHANS=1
+const featureEnvPrefix = "DCM_FEATURE_"
+
+// featureFlag declares a behavior that can be switched on or off while it
+// is being trialled
+type featureFlag struct {
+	Name        string
+	Description string
+	Default     bool
+}
+
+// featureRegistry lists every feature flag. Add new flags here; names not
+// listed are rejected when found in the config.
+var featureRegistry = []featureFlag{
+	{
+		Name:        "strict_service_names",
+		Description: "reject service names not defined in the compose files",
+		Default:     true,
+	},
+	{
+		Name:        "restart_preserves_scale",
+		Description: "re-apply a service's replica count after restarting it",
+		Default:     true,
+	},
+	{
+		Name:        "network_preflight",
+		Description: "check that external networks exist before starting services",
+		Default:     true,
+	},
+	{
+		Name:        "port_preflight",
+		Description: "check that the published host ports are free before starting services",
+		Default:     true,
+	},
+	{
+		Name:        "tui",
+		Description: "run the full screen TUI instead of the numbered menu when started without a command on a terminal",
+		Default:     true,
+	},
+	{
+		Name:        "confirm_destructive",
+		Description: "ask before remove and down on a terminal, --yes skips the question",
+		Default:     true,
+	},
+	{
+		Name:        "remove_orphans",
+		Description: "pass --remove-orphans when starting services",
+		Default:     false,
+	},
+}
+
+// featureState is the resolved value of a feature flag and where it came from
+type featureState struct {
+	Enabled bool
+	Source  string
+}
+
+// resolveFeatures computes every flag from its default, the features config
+// map and DCM_FEATURE_* environment variables, in increasing precedence.
+func (dcm *Manager) resolveFeatures() error {
+	known := make(map[string]bool)
+	var names []string
+	dcm.features = make(map[string]featureState)
+	for _, flag := range featureRegistry {
+		known[flag.Name] = true
+		names = append(names, flag.Name)
+		dcm.features[flag.Name] = featureState{Enabled: flag.Default, Source: "default"}
+	}
+
+	var unknown []string
+	for name, enabled := range dcm.config.Features {
+		if !known[name] {
+			unknown = append(unknown, name)
+			continue
+		}
+		dcm.features[name] = featureState{Enabled: enabled, Source: "config"}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return NewError(ErrConfig, "%s: unknown features: %s (known features: %s)",
+			dcm.configPath, strings.Join(unknown, ", "), strings.Join(names, ", "))
+	}
+
+	for _, env := range os.Environ() {
+		parts := strings.SplitN(env, "=", 2)
+		if !strings.HasPrefix(parts[0], featureEnvPrefix) {
+			continue
+		}
+		name := strings.ToLower(strings.TrimPrefix(parts[0], featureEnvPrefix))
+		if !known[name] {
+			dcm.Warnf("%s does not match any feature\n", parts[0])
+			continue
+		}
+		enabled, err := strconv.ParseBool(parts[1])
+		if err != nil {
+			return NewError(ErrConfig, "%s: expected a boolean, got %q", parts[0], parts[1])
+		}
+		dcm.features[name] = featureState{Enabled: enabled, Source: "env"}
+	}
+	return nil
+}
+
+// FeatureEnabled reports whether a feature flag is on
+func (dcm *Manager) FeatureEnabled(name string) bool {
+	state, ok := dcm.features[name]
+	if !ok {
+		for _, flag := range featureRegistry {
+			if flag.Name == name {
+				return flag.Default
+			}
+		}
+	}
+	return state.Enabled
+}
+
+// PrintFeatures lists every feature flag with its state and its source
+func (dcm *Manager) PrintFeatures() {
+	fmt.Printf("%-26s %-5s %-8s %s\n", "FEATURE", "ON", "SOURCE", "DESCRIPTION")
+	for _, flag := range featureRegistry {
+		state := dcm.features[flag.Name]
+		fmt.Printf("%-26s %-5t %-8s %s\n", flag.Name, state.Enabled, state.Source, flag.Description)
+	}
+}
@@ -0,0 +1,123 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ServiceGroup is a named set of services from the groups section of the
+// config. A group name given where a service is expected stands for its
+// services, so `dcm start backend` starts every service of backend.
+type ServiceGroup struct {
+	Name     string   `json:"name"`
+	Services []string `json:"services"`
+}
+
+// checkGroups validates the groups section of the config
+func (dcm *Manager) checkGroups() error {
+	for _, name := range dcm.groupNames() {
+		members := dcm.config.Groups[name]
+		if len(members) == 0 {
+			return NewError(ErrConfig, "%s: group %s lists no services", dcm.configPath, name)
+		}
+		for _, member := range members {
+			if _, nested := dcm.config.Groups[member]; nested {
+				return NewError(ErrConfig, "%s: group %s contains group %s, groups cannot be nested", dcm.configPath, name, member)
+			}
+		}
+	}
+	return nil
+}
+
+// groupNames returns the names of the configured groups, sorted
+func (dcm *Manager) groupNames() []string {
+	names := make([]string, 0, len(dcm.config.Groups))
+	for name := range dcm.config.Groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// expandGroups replaces the group names among names with their services,
+// keeping the order and dropping repeats. A group may not share its name
+// with a service, as it would be unclear which is meant.
+func (dcm *Manager) expandGroups(names []string) ([]string, error) {
+	if len(dcm.config.Groups) == 0 {
+		return names, nil
+	}
+	var expanded []string
+	seen := make(map[string]bool)
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			expanded = append(expanded, name)
+		}
+	}
+	for _, name := range names {
+		members, ok := dcm.config.Groups[name]
+		if !ok {
+			add(name)
+			continue
+		}
+		if services, err := dcm.composeServices(); err == nil {
+			for _, s := range services {
+				if s == name {
+					return nil, NewError(ErrConfig, "%s: group %s has the name of a service, rename one of them", dcm.configPath, name)
+				}
+			}
+		}
+		for _, member := range members {
+			add(member)
+		}
+	}
+	return expanded, nil
+}
+
+// Groups returns the configured groups sorted by name
+func (dcm *Manager) Groups() []ServiceGroup {
+	var groups []ServiceGroup
+	for _, name := range dcm.groupNames() {
+		groups = append(groups, ServiceGroup{Name: name, Services: dcm.config.Groups[name]})
+	}
+	return groups
+}
+
+// PrintGroups lists the configured groups and their services
+func (dcm *Manager) PrintGroups() error {
+	groups := dcm.Groups()
+	if dcm.output == "json" {
+		if groups == nil {
+			groups = []ServiceGroup{}
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(groups)
+	}
+	if len(groups) == 0 {
+		fmt.Printf("No groups are defined in %s\n", dcm.configPath)
+		return nil
+	}
+	fmt.Printf("%-20s %s\n", "GROUP", "SERVICES")
+	for _, g := range groups {
+		fmt.Printf("%-20s %s\n", g.Name, strings.Join(g.Services, ", "))
+	}
+	return nil
+}
+
+// CompleteServices prints the services of the compose files and the groups
+// of the config, one per line, for shell completion
+func (dcm *Manager) CompleteServices(w io.Writer) error {
+	services, err := dcm.composeServices()
+	if err != nil {
+		return err
+	}
+	for _, name := range append(services, dcm.groupNames()...) {
+		fmt.Fprintln(w, name)
+	}
+	return nil
+}
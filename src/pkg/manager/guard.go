@@ -0,0 +1,493 @@
+package manager
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	defaultGuardInterval   = 30 * time.Second
+	defaultGuardMaxBackoff = 5 * time.Minute
+	// guardBaseBackoff is the wait before the second attempt at a service,
+	// doubled at each further attempt
+	guardBaseBackoff = 5 * time.Second
+	// guardSettle is how long the guard lets events settle after a
+	// container died before checking the services
+	guardSettle = 2 * time.Second
+)
+
+// guardStopVerbs are the operations after which a service is meant to stay
+// down, and guardStartVerbs those bringing it back
+var (
+	guardStopVerbs  = map[string]bool{"stop": true, "remove": true, "kill": true, "down": true, "purge": true}
+	guardStartVerbs = map[string]bool{"start": true, "restart": true, "update": true, "scale": true}
+)
+
+// GuardConfig configures `dcm guard`
+type GuardConfig struct {
+	// Exclude lists the services the guard leaves alone
+	Exclude []string `yaml:"exclude"`
+	// Interval is how often the services are checked besides reacting to
+	// docker events, e.g. "30s"
+	Interval string `yaml:"interval"`
+	// MaxBackoff caps the wait between attempts at a service that keeps
+	// failing, e.g. "5m"
+	MaxBackoff string `yaml:"max_backoff"`
+}
+
+// GuardOptions are the settings of a guard run
+type GuardOptions struct {
+	// Services are the services to guard, those in scope when empty
+	Services   []string
+	Exclude    []string
+	Interval   time.Duration
+	MaxBackoff time.Duration
+	// Once checks the services a single time instead of running until
+	// interrupted
+	Once bool
+}
+
+// GuardAction is a reconciliation carried out by the guard, as reported
+// and logged to .dcm/guard.jsonl
+type GuardAction struct {
+	Time    time.Time `json:"time"`
+	Service string    `json:"service"`
+	// Reason is how the service drifted from its desired state, such as
+	// "p-web-1 exited with code 1"
+	Reason string `json:"reason"`
+	// Attempt counts the attempts at the service since it was last fine
+	Attempt int    `json:"attempt"`
+	Error   string `json:"error,omitempty"`
+	// NextAttempt is the earliest time the service is acted on again
+	NextAttempt time.Time `json:"next_attempt"`
+}
+
+// dockerEvent is a line of docker events --format '{{json .}}'
+type dockerEvent struct {
+	Action string `json:"Action"`
+	Actor  struct {
+		Attributes map[string]string `json:"Attributes"`
+	} `json:"Actor"`
+}
+
+// guardBackoff tracks the attempts at a service
+type guardBackoff struct {
+	attempts int
+	last     time.Time
+	next     time.Time
+}
+
+// guard reconciles the services of a project with their desired state
+type guard struct {
+	dcm     *Manager
+	opts    GuardOptions
+	backoff map[string]*guardBackoff
+	// held are the services stopped from outside dcm while the guard runs,
+	// left down until they start again
+	held map[string]bool
+	// failed counts the attempts that failed
+	failed int
+}
+
+// guardDurations returns the interval and maximum backoff of the config
+func (dcm *Manager) guardDurations() (time.Duration, time.Duration, error) {
+	parse := func(key, value string, def time.Duration) (time.Duration, error) {
+		if value == "" {
+			return def, nil
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return 0, NewError(ErrConfig, "%s: guard.%s: expected a duration such as 30s, got %q", dcm.configPath, key, value)
+		}
+		return d, nil
+	}
+	interval, err := parse("interval", dcm.config.Guard.Interval, defaultGuardInterval)
+	if err != nil {
+		return 0, 0, err
+	}
+	maxBackoff, err := parse("max_backoff", dcm.config.Guard.MaxBackoff, defaultGuardMaxBackoff)
+	return interval, maxBackoff, err
+}
+
+// checkGuard validates the guard section of the config
+func (dcm *Manager) checkGuard() error {
+	_, _, err := dcm.guardDurations()
+	return err
+}
+
+func (dcm *Manager) guardLogPath() string {
+	return filepath.Join(dcm.stateDir(), "guard.jsonl")
+}
+
+// Guard keeps the services of the project in their desired state until
+// interrupted: running, with the replica count of the scale section and
+// the image of the compose files. It reacts to the containers docker
+// reports dying and checks every interval. A container that exited with a
+// non-zero code, a missing container and a container running another image
+// are fixed with up; containers that exited with code 0 are finished tasks.
+// Services stopped on purpose are left down: through dcm, as the activity
+// log tells, or through docker while the guard runs. The attempts at a
+// service back off exponentially up to MaxBackoff.
+func (dcm *Manager) Guard(opts GuardOptions) error {
+	interval, maxBackoff, err := dcm.guardDurations()
+	if err != nil {
+		return err
+	}
+	if opts.Interval == 0 {
+		opts.Interval = interval
+	}
+	if opts.MaxBackoff == 0 {
+		opts.MaxBackoff = maxBackoff
+	}
+	if opts.Interval < 0 || opts.MaxBackoff < 0 {
+		return NewError(ErrUsage, "--interval and --max-backoff must be positive")
+	}
+	opts.Exclude = append(opts.Exclude, dcm.config.Guard.Exclude...)
+	for _, name := range append(append([]string(nil), opts.Services...), opts.Exclude...) {
+		if err := dcm.checkService(name); err != nil {
+			return err
+		}
+	}
+	g := &guard{dcm: dcm, opts: opts, backoff: make(map[string]*guardBackoff), held: make(map[string]bool)}
+	if opts.Once {
+		if err := g.reconcile(); err != nil {
+			return err
+		}
+		if g.failed > 0 {
+			return NewError(ErrCommandFailed, "%d services could not be brought up", g.failed)
+		}
+		return nil
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	events := make(chan dockerEvent)
+	go func() {
+		if err := g.watchEvents(ctx, events); err != nil && ctx.Err() == nil {
+			dcm.Warnf("docker events: %v, checking every %s only\n", err, opts.Interval)
+		}
+	}()
+	dcm.Infof("Guarding %s, checking every %s, press Ctrl-C to stop\n", dcm.ProjectName(), opts.Interval)
+
+	if err := g.reconcile(); err != nil {
+		dcm.Warnf("%v\n", err)
+	}
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+	var settle <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case e := <-events:
+			if g.handleEvent(e) && settle == nil {
+				settle = time.After(guardSettle)
+			}
+			continue
+		case <-settle:
+			settle = nil
+		case <-ticker.C:
+		}
+		if err := g.reconcile(); err != nil {
+			dcm.Warnf("%v\n", err)
+		}
+	}
+}
+
+// watchEvents sends the container events of the project until ctx is done
+// or the stream fails
+func (g *guard) watchEvents(ctx context.Context, events chan<- dockerEvent) error {
+	argv := []string{"docker", "events", "--filter", "type=container", "--filter", g.dcm.projectFilter(), "--format", "{{json .}}"}
+	cmd := g.dcm.command(ctx, argv)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		var e dockerEvent
+		if json.Unmarshal(scanner.Bytes(), &e) != nil {
+			continue
+		}
+		select {
+		case events <- e:
+		case <-ctx.Done():
+		}
+	}
+	return cmd.Wait()
+}
+
+// handleEvent follows the services stopped through docker and reports
+// whether the event calls for a check: a container died on its own
+func (g *guard) handleEvent(e dockerEvent) bool {
+	attrs := e.Actor.Attributes
+	service := attrs["com.docker.compose.service"]
+	if service == "" {
+		return false
+	}
+	switch e.Action {
+	case "kill":
+		// docker stop sends SIGTERM then SIGKILL; other signals, such as
+		// that of reload, leave the container running
+		if attrs["signal"] == "9" || attrs["signal"] == "15" {
+			g.held[service] = true
+		}
+	case "stop":
+		g.held[service] = true
+	case "start":
+		delete(g.held, service)
+	case "die":
+		if g.held[service] {
+			return false
+		}
+		g.dcm.verbosef("%s exited with code %s\n", attrs["name"], attrs["exitCode"])
+		return attrs["exitCode"] != "0"
+	}
+	return false
+}
+
+// busy returns the operation another dcm process is running on the
+// project, empty when there is none
+func (g *guard) busy() string {
+	intents, err := g.dcm.activeIntents()
+	if err != nil {
+		return ""
+	}
+	host, _ := os.Hostname()
+	for _, intent := range intents {
+		if intent.Host == host && intent.PID == os.Getpid() {
+			continue
+		}
+		if intent.Project == "" || intent.Project == g.dcm.project {
+			return fmt.Sprintf("%s by %s", intent.Verb, intent.User)
+		}
+	}
+	return ""
+}
+
+// stoppedServices returns, for the services the activity log names since
+// the last operation on the whole project, whether the last one stopped
+// them, with all set when that operation on the whole project did
+func (g *guard) stoppedServices() (stopped map[string]bool, all bool) {
+	stopped = make(map[string]bool)
+	f, err := os.Open(g.dcm.activityLogPath())
+	if err != nil {
+		return stopped, false
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var a Activity
+		if json.Unmarshal(scanner.Bytes(), &a) != nil || a.Error != "" {
+			continue
+		}
+		if a.Project != "" && a.Project != g.dcm.project {
+			continue
+		}
+		if !guardStopVerbs[a.Verb] && !guardStartVerbs[a.Verb] {
+			continue
+		}
+		down := guardStopVerbs[a.Verb]
+		if len(a.Services) == 0 {
+			all = down
+			stopped = make(map[string]bool)
+			continue
+		}
+		for _, name := range a.Services {
+			stopped[name] = down
+		}
+	}
+	return stopped, all
+}
+
+// desiredImages returns the image of each service in the compose files
+func (g *guard) desiredImages() map[string]string {
+	images := make(map[string]string)
+	config, err := g.dcm.composeOutput("config")
+	if err != nil {
+		g.dcm.verbosef("Not checking images, compose config failed: %v\n", err)
+		return images
+	}
+	var rendered struct {
+		Services map[string]composeService `yaml:"services"`
+	}
+	if err := yaml.Unmarshal([]byte(config), &rendered); err != nil {
+		return images
+	}
+	for name, s := range rendered.Services {
+		images[name] = s.Image
+	}
+	return images
+}
+
+// drift returns how the containers of a service differ from its desired
+// state, empty when they do not
+func (g *guard) drift(service string, containers []ServiceStatus, image string) string {
+	desired := g.dcm.config.Scale[service]
+	if desired == 0 {
+		desired = 1
+	}
+	running, total, completed := 0, 0, 0
+	var exited *ServiceStatus
+	for i, c := range containers {
+		if c.Name == "" {
+			continue
+		}
+		total++
+		switch c.State {
+		case "running", "paused", "restarting":
+			// docker itself restarts the containers with a restart policy
+			running++
+			if image != "" && c.Image != "" && normalizeImage(c.Image) != normalizeImage(image) {
+				return fmt.Sprintf("%s runs %s instead of %s", c.Name, c.Image, image)
+			}
+		case "exited", "dead":
+			if c.ExitCode == 0 {
+				completed++
+			} else if exited == nil {
+				exited = &containers[i]
+			}
+		}
+	}
+	switch {
+	case running >= desired:
+		return ""
+	case exited != nil:
+		return fmt.Sprintf("%s exited with code %d", exited.Name, exited.ExitCode)
+	case completed > 0:
+		// A task that ran to completion
+		return ""
+	case total == 0:
+		return "no container"
+	}
+	return fmt.Sprintf("%d of %d containers running", running, desired)
+}
+
+// reconcile checks the guarded services once and brings up those that
+// drifted. The attempts that fail are reported and counted, not returned.
+func (g *guard) reconcile() error {
+	dcm := g.dcm
+	if op := g.busy(); op != "" {
+		dcm.verbosef("Not checking the services, %s is in progress\n", op)
+		return nil
+	}
+	statuses, err := dcm.Status(context.Background())
+	if err != nil {
+		return fmt.Errorf("reading the service states: %w", err)
+	}
+	byService := make(map[string][]ServiceStatus)
+	for _, s := range statuses {
+		byService[s.Service] = append(byService[s.Service], s)
+	}
+	services := g.opts.Services
+	if len(services) == 0 {
+		services = dcm.scopedServices()
+	}
+	if len(services) == 0 {
+		for name := range byService {
+			services = append(services, name)
+		}
+	}
+	excluded := make(map[string]bool)
+	for _, name := range g.opts.Exclude {
+		excluded[name] = true
+	}
+	sort.Strings(services)
+	images := g.desiredImages()
+	stopped, allStopped := g.stoppedServices()
+
+	for _, name := range services {
+		if excluded[name] {
+			continue
+		}
+		reason := g.drift(name, byService[name], images[name])
+		b := g.backoff[name]
+		if reason == "" {
+			if b != nil && time.Since(b.last) > g.opts.MaxBackoff {
+				delete(g.backoff, name)
+			}
+			continue
+		}
+		if down, ok := stopped[name]; (ok && down) || (!ok && allStopped) || g.held[name] {
+			dcm.verbosef("%s: %s, left down as it was stopped on purpose\n", name, reason)
+			continue
+		}
+		if b != nil && time.Now().Before(b.next) {
+			dcm.verbosef("%s: %s, next attempt at %s\n", name, reason, b.next.Format("15:04:05"))
+			continue
+		}
+		g.act(name, reason)
+	}
+	return nil
+}
+
+// act brings a service back to its desired state and reports it
+func (g *guard) act(service, reason string) {
+	dcm := g.dcm
+	b := g.backoff[service]
+	if b == nil {
+		b = &guardBackoff{}
+		g.backoff[service] = b
+	}
+	b.attempts++
+	args := append(dcm.upArgs(), "--no-deps")
+	if replicas, ok := dcm.config.Scale[service]; ok {
+		args = append(args, "--scale", fmt.Sprintf("%s=%d", service, replicas))
+	}
+	args = append(args, service)
+	err := dcm.Track("guard", []string{service}, func() error {
+		_, err := dcm.compose(args...)
+		return err
+	})
+	if dcm.dryRun {
+		return
+	}
+
+	delay := g.opts.MaxBackoff
+	if shift := b.attempts - 1; shift < 20 && guardBaseBackoff<<uint(shift) < delay {
+		delay = guardBaseBackoff << uint(shift)
+	}
+	b.last = time.Now()
+	b.next = b.last.Add(delay)
+	action := GuardAction{Time: b.last, Service: service, Reason: reason, Attempt: b.attempts, NextAttempt: b.next}
+	if err != nil {
+		action.Error = err.Error()
+		g.failed++
+	}
+	g.report(action)
+}
+
+// report prints an action, as a JSON line with --output json, and appends
+// it to the guard log
+func (g *guard) report(a GuardAction) {
+	dcm := g.dcm
+	if err := appendJSONLine(dcm.guardLogPath(), a); err != nil {
+		dcm.Warnf("could not write the guard log: %v\n", err)
+	}
+	if dcm.output == "json" {
+		json.NewEncoder(os.Stdout).Encode(a)
+		return
+	}
+	result := "brought up"
+	if a.Error != "" {
+		result = "failed: " + firstLine(a.Error)
+	}
+	fmt.Printf("%s %s: %s, %s (attempt %d", a.Time.Format("15:04:05"), a.Service, a.Reason, result, a.Attempt)
+	if a.Attempt > 1 || a.Error != "" {
+		fmt.Printf(", next no sooner than %s", a.NextAttempt.Format("15:04:05"))
+	}
+	fmt.Println(")")
+}
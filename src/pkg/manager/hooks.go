@@ -0,0 +1,267 @@
+package manager
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultHookTimeout bounds a hook that sets no timeout
+const defaultHookTimeout = 5 * time.Minute
+
+// Hook is a shell command or an HTTP request run before or after a command
+type Hook struct {
+	// Command runs with sh -c from the directory of the config file
+	Command string `yaml:"command"`
+	// URL is requested instead of running a command, with a JSON body
+	// describing the operation unless Body is set
+	URL     string            `yaml:"url"`
+	Method  string            `yaml:"method"`
+	Headers map[string]string `yaml:"headers"`
+	Body    string            `yaml:"body"`
+	// Timeout bounds the hook, e.g. "30s"; five minutes by default
+	Timeout string `yaml:"timeout"`
+	// Wait makes the hook wait until its services are ready first, such as
+	// a post_start hook running migrations once the database is healthy
+	Wait bool `yaml:"wait"`
+	// ContinueOnError reports a failing hook as a warning instead of
+	// failing the command
+	ContinueOnError bool `yaml:"continue_on_error"`
+}
+
+// HooksConfig maps events, the name of a command prefixed with pre_ or post_
+// such as pre_start or post_remove, to the hooks they run. Services holds
+// the hooks of single services, run for the operations acting on them.
+type HooksConfig struct {
+	Events   map[string][]Hook            `yaml:",inline"`
+	Services map[string]map[string][]Hook `yaml:"services"`
+}
+
+// hookPayload describes the operation to HTTP hooks
+type hookPayload struct {
+	Event    string   `json:"event"`
+	Command  string   `json:"command"`
+	Service  string   `json:"service,omitempty"`
+	Services []string `json:"services"`
+	Project  string   `json:"project"`
+	Files    []string `json:"compose_files"`
+}
+
+// checkHookEvents rejects unknown events and malformed hooks
+func (dcm *Manager) checkHookEvents(where string, events map[string][]Hook) error {
+	for event, hooks := range events {
+		verb := strings.TrimPrefix(strings.TrimPrefix(event, "pre_"), "post_")
+		if _, ok := FindCommand(verb); !ok || verb == event {
+			return NewError(ErrConfig, "%s: %s: unknown event %q, expected pre_<command> or post_<command>, e.g. post_start",
+				dcm.configPath, where, event)
+		}
+		for i, h := range hooks {
+			if (h.Command == "") == (h.URL == "") {
+				return NewError(ErrConfig, "%s: %s.%s[%d]: set either command or url", dcm.configPath, where, event, i)
+			}
+			if h.Timeout != "" {
+				if d, err := time.ParseDuration(h.Timeout); err != nil || d <= 0 {
+					return NewError(ErrConfig, "%s: %s.%s[%d]: timeout: expected a duration such as 30s, got %q",
+						dcm.configPath, where, event, i, h.Timeout)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// checkHooks validates the hooks section of the config
+func (dcm *Manager) checkHooks() error {
+	if err := dcm.checkHookEvents("hooks", dcm.config.Hooks.Events); err != nil {
+		return err
+	}
+	for service, events := range dcm.config.Hooks.Services {
+		if err := dcm.checkHookEvents("hooks.services."+service, events); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hookMiddleware runs the pre_ hooks of an operation before it and the
+// post_ hooks once it succeeded: the global hooks first before the
+// operation and last after it, the hooks of its services in between. A
+// failing pre_ hook stops the operation.
+func (dcm *Manager) hookMiddleware(next OperationFunc) OperationFunc {
+	return func(op Operation) error {
+		if err := dcm.runHooks("pre_", op); err != nil {
+			return err
+		}
+		if err := next(op); err != nil {
+			return err
+		}
+		return dcm.runHooks("post_", op)
+	}
+}
+
+// runHooks runs the hooks of the event prefix+op.Verb
+func (dcm *Manager) runHooks(prefix string, op Operation) error {
+	event := prefix + op.Verb
+	services := op.Services
+	if len(services) == 0 {
+		services = dcm.scopedServices()
+	}
+	type run struct {
+		service string
+		hook    Hook
+	}
+	var global, perService []run
+	for _, h := range dcm.config.Hooks.Events[event] {
+		global = append(global, run{"", h})
+	}
+	var names []string
+	if len(services) > 0 {
+		names = services
+	} else {
+		// The operation acts on the whole project
+		for name := range dcm.config.Hooks.Services {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	for _, name := range names {
+		for _, h := range dcm.config.Hooks.Services[name][event] {
+			perService = append(perService, run{name, h})
+		}
+	}
+	runs := append(global, perService...)
+	if prefix == "post_" {
+		runs = append(perService, global...)
+	}
+
+	for _, r := range runs {
+		hookServices := services
+		if r.service != "" {
+			hookServices = []string{r.service}
+		}
+		err := dcm.runHook(event, op.Verb, r.service, hookServices, r.hook)
+		if err == nil {
+			continue
+		}
+		err = fmt.Errorf("%s hook%s: %w", event, hookSubject(r.service), err)
+		if !r.hook.ContinueOnError {
+			return NewError(ErrCommandFailed, "%v", err)
+		}
+		dcm.Warnf("%v\n", err)
+	}
+	return nil
+}
+
+// hookSubject names the service of a hook in messages
+func hookSubject(service string) string {
+	if service == "" {
+		return ""
+	}
+	return " of " + service
+}
+
+// runHook runs a single hook
+func (dcm *Manager) runHook(event, verb, service string, services []string, h Hook) error {
+	if h.Wait && !dcm.dryRun {
+		if err := dcm.WaitReady(services, WaitOptions{}); err != nil {
+			return err
+		}
+	}
+	timeout := defaultHookTimeout
+	if h.Timeout != "" {
+		timeout, _ = time.ParseDuration(h.Timeout)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if h.URL != "" {
+		return dcm.runHTTPHook(ctx, event, verb, service, services, h)
+	}
+	argv := []string{"sh", "-c", h.Command}
+	if dcm.dryRunSkip(argv) {
+		return nil
+	}
+	dcm.Infof("Running %s hook%s: %s\n", event, hookSubject(service), h.Command)
+	cmd := dcm.command(ctx, argv)
+	cmd.Dir = filepath.Dir(dcm.configPath)
+	cmd.Env = append(dcm.hookEnv(), "DCM_HOOK="+event, "DCM_COMMAND="+verb,
+		"DCM_SERVICE="+service, "DCM_SERVICES="+strings.Join(services, " "))
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	started := time.Now()
+	err := cmd.Run()
+	dcm.logCommand(argv, started, err)
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+	return err
+}
+
+// hookEnv returns the environment of hook commands: that of compose, with
+// the project and its compose files
+func (dcm *Manager) hookEnv() []string {
+	env := dcm.env
+	if env == nil {
+		env = os.Environ()
+	}
+	files := dcm.config.composeFiles()
+	first := ""
+	if len(files) > 0 {
+		first = files[0]
+	}
+	return append(append([]string(nil), env...),
+		"DCM_PROJECT="+dcm.ProjectName(),
+		"DCM_COMPOSE_FILE="+first,
+		"DCM_COMPOSE_FILES="+strings.Join(files, string(filepath.ListSeparator)),
+		"DCM_CONFIG="+dcm.configPath)
+}
+
+// runHTTPHook sends the request of a hook, failing on a status other than 2xx
+func (dcm *Manager) runHTTPHook(ctx context.Context, event, verb, service string, services []string, h Hook) error {
+	method := strings.ToUpper(h.Method)
+	if method == "" {
+		method = http.MethodPost
+	}
+	if dcm.dryRun {
+		fmt.Printf("Would request: %s %s\n", method, h.URL)
+		return nil
+	}
+	body := []byte(h.Body)
+	if h.Body == "" {
+		if services == nil {
+			services = []string{}
+		}
+		body, _ = json.Marshal(hookPayload{
+			Event:    event,
+			Command:  verb,
+			Service:  service,
+			Services: services,
+			Project:  dcm.ProjectName(),
+			Files:    dcm.config.composeFiles(),
+		})
+	}
+	req, err := http.NewRequestWithContext(ctx, method, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range h.Headers {
+		req.Header.Set(name, os.ExpandEnv(value))
+	}
+	dcm.Infof("Running %s hook%s: %s %s\n", event, hookSubject(service), method, h.URL)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s %s: %s", method, h.URL, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package manager
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// initConfig is the config `dcm init` writes
+type initConfig struct {
+	Version      int      `yaml:"version"`
+	ProjectName  string   `yaml:"project_name"`
+	ComposeFiles []string `yaml:"compose_files"`
+	Services     []string `yaml:"services,omitempty"`
+}
+
+// initPrompter asks the questions of `dcm init`, or takes their defaults
+// when not interactive
+type initPrompter struct {
+	reader      *bufio.Reader
+	interactive bool
+}
+
+// ask prints a question with its default and returns the answer, the default
+// when empty
+func (p *initPrompter) ask(question, def string) (string, error) {
+	if !p.interactive {
+		return def, nil
+	}
+	fmt.Printf("%s [%s]: ", question, def)
+	line, err := p.reader.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	if answer := strings.TrimSpace(line); answer != "" {
+		return answer, nil
+	}
+	return def, nil
+}
+
+// findComposeCandidates returns the compose files of dir: those compose
+// would use without -f, else every file named like a compose file
+func findComposeCandidates(dir string) ([]string, error) {
+	files, err := discoverComposeFiles(dir)
+	if err != nil || len(files) > 0 {
+		return files, err
+	}
+	for _, pattern := range []string{"*compose*.yml", "*compose*.yaml"} {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range matches {
+			files = append(files, filepath.Base(m))
+		}
+	}
+	return files, nil
+}
+
+// pickServices parses a selection of services by number or name, all of
+// them for "all"
+func pickServices(answer string, names []string) ([]string, error) {
+	if answer == "all" {
+		return nil, nil
+	}
+	var picked []string
+	for _, field := range strings.FieldsFunc(answer, func(r rune) bool { return r == ',' || r == ' ' }) {
+		if n, err := strconv.Atoi(field); err == nil {
+			if n < 1 || n > len(names) {
+				return nil, NewError(ErrUsage, "no service number %d, pick 1-%d", n, len(names))
+			}
+			picked = append(picked, names[n-1])
+			continue
+		}
+		found := false
+		for _, name := range names {
+			found = found || name == field
+		}
+		if !found {
+			return nil, NewError(ErrServiceNotFound, "unknown service %q%s", field, DidYouMean(field, names))
+		}
+		picked = append(picked, field)
+	}
+	return picked, nil
+}
+
+// InitConfig writes a dcm.config.yml for the compose files of dir, asking
+// which files and services to use unless interactive is false
+func InitConfig(dir string, force, interactive bool, in io.Reader) error {
+	path := filepath.Join(dir, "dcm.config.yml")
+	if _, err := os.Stat(path); err == nil && !force {
+		return NewError(ErrUsage, "%s already exists, use --force to overwrite it", path)
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	candidates, err := findComposeCandidates(dir)
+	if err != nil {
+		return NewError(ErrConfig, "looking for compose files: %w", err)
+	}
+	if len(candidates) == 0 {
+		return NewError(ErrConfig, "no compose file found in %s, create one such as compose.yaml first", abs)
+	}
+
+	p := &initPrompter{reader: bufio.NewReader(in), interactive: interactive}
+	answer, err := p.ask("Compose files", strings.Join(candidates, ", "))
+	if err != nil {
+		return err
+	}
+	var files, paths []string
+	for _, f := range strings.Split(answer, ",") {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		files = append(files, f)
+		paths = append(paths, filepath.Join(dir, f))
+	}
+	project, err := loadComposeFiles(paths)
+	if err != nil {
+		return NewError(ErrConfig, "%v", err)
+	}
+
+	name, err := p.ask("Project name", sanitizeProjectName(filepath.Base(abs)))
+	if err != nil {
+		return err
+	}
+	if interactive && len(project.Names) > 0 {
+		fmt.Println("Services:")
+		for i, s := range project.Names {
+			fmt.Printf("  %d. %s\n", i+1, s)
+		}
+	}
+	var services []string
+	for {
+		answer, err := p.ask("Services to manage, by number or name", "all")
+		if err != nil {
+			return err
+		}
+		services, err = pickServices(answer, project.Names)
+		if err == nil {
+			break
+		}
+		if !interactive {
+			return err
+		}
+		fmt.Printf("%v\n", err)
+	}
+
+	out, err := yaml.Marshal(initConfig{
+		Version:      configVersion,
+		ProjectName:  name,
+		ComposeFiles: files,
+		Services:     services,
+	})
+	if err != nil {
+		return err
+	}
+	header := "# Docker Compose Manager configuration, written by `dcm init`.\n" +
+		"# Leave services out to act on every service of the compose files.\n"
+	if err := ioutil.WriteFile(path, append([]byte(header), out...), 0644); err != nil {
+		return NewError(ErrConfig, "writing %s: %w", path, err)
+	}
+	fmt.Printf("Wrote %s for %d services of %s; run `dcm doctor` to check the setup\n",
+		path, len(project.Names), strings.Join(files, ", "))
+	return nil
+}
@@ -0,0 +1,238 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Severity levels of lint findings
+const (
+	severityError   = "error"
+	severityWarning = "warning"
+	severityInfo    = "info"
+)
+
+// Finding is a problem Lint found in the compose configuration
+type Finding struct {
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	// Service is empty for findings about the whole configuration
+	Service string `json:"service,omitempty"`
+	// File and Line locate the finding when it comes from one place, Line
+	// is 0 when only the file is known
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Message string `json:"message"`
+}
+
+// subject names a finding for people: where it is, as file:line when
+// known, its rule and its service
+func (f Finding) subject() string {
+	subject := f.Rule
+	if f.Service != "" {
+		subject += " " + f.Service
+	}
+	switch {
+	case f.Line > 0:
+		subject = fmt.Sprintf("%s:%d %s", f.File, f.Line, subject)
+	case f.File != "":
+		subject = f.File + " " + subject
+	}
+	return subject
+}
+
+// LintConfig configures the lint command
+type LintConfig struct {
+	// Ignore suppresses findings, either a whole rule ("latest-tag") or a
+	// rule for one service ("latest-tag:web")
+	Ignore []string `yaml:"ignore"`
+}
+
+// renderedService is the part of a service in `docker-compose config`
+// output that lint rules look at
+type renderedService struct {
+	Image       string `yaml:"image"`
+	Privileged  bool   `yaml:"privileged"`
+	Healthcheck *struct {
+		Disable bool `yaml:"disable"`
+	} `yaml:"healthcheck"`
+}
+
+// usesLatestTag reports whether an image reference resolves to the latest tag
+func usesLatestTag(image string) bool {
+	if strings.Contains(image, "@") {
+		return false
+	}
+	return strings.HasSuffix(normalizeImage(image), ":latest")
+}
+
+// Lint validates the compose configuration and checks it for common
+// mistakes: images on the latest tag, services without a healthcheck, the
+// obsolete version key, privileged containers, variables that are not set,
+// missing build contexts, host ports published twice and services of the
+// config missing from the compose files. Findings suppressed in the lint
+// section of the config are left out.
+func (dcm *Manager) Lint() ([]Finding, error) {
+	var findings []Finding
+
+	argv, err := dcm.composeArgs("config")
+	if err != nil {
+		return nil, err
+	}
+	started := time.Now()
+	rendered, err := dcm.command(context.Background(), argv).Output()
+	dcm.logCommand(argv, started, err)
+	var exitErr *exitError
+	if errors.As(err, &exitErr) {
+		// Nothing else can be checked on a configuration compose rejects
+		return dcm.filterFindings([]Finding{{
+			Rule:     "invalid-config",
+			Severity: severityError,
+			Message:  firstLine(string(exitErr.Stderr)),
+		}}), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	for _, f := range dcm.config.composeFiles() {
+		doc, err := parseComposeDocument(f)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := doc["version"]; ok {
+			findings = append(findings, Finding{
+				Rule:     "version-key",
+				Severity: severityWarning,
+				File:     f,
+				Line:     blockLine(fileLines(f), "version", ""),
+				Message:  "the top-level version key is obsolete and ignored by Compose V2",
+			})
+		}
+	}
+	findings = append(findings, dcm.lintVariables()...)
+	project, err := dcm.composeProject()
+	if err != nil {
+		return nil, err
+	}
+	findings = append(findings, dcm.lintProject(project)...)
+	findings = append(findings, dcm.lintConfigServices(project)...)
+
+	var config struct {
+		Services map[string]renderedService `yaml:"services"`
+	}
+	if err := yaml.Unmarshal(rendered, &config); err != nil {
+		return nil, fmt.Errorf("parsing rendered config: %v", err)
+	}
+	names := make([]string, 0, len(config.Services))
+	for name := range config.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		service := config.Services[name]
+		if service.Image != "" && usesLatestTag(service.Image) {
+			findings = append(findings, Finding{
+				Rule:     "latest-tag",
+				Severity: severityWarning,
+				Service:  name,
+				Message:  fmt.Sprintf("image %s is not pinned to a tag or digest", service.Image),
+			})
+		}
+		if service.Healthcheck == nil || service.Healthcheck.Disable {
+			findings = append(findings, Finding{
+				Rule:     "missing-healthcheck",
+				Severity: severityInfo,
+				Service:  name,
+				Message:  "no healthcheck is defined, unless the image declares one",
+			})
+		}
+		if service.Privileged {
+			findings = append(findings, Finding{
+				Rule:     "privileged",
+				Severity: severityError,
+				Service:  name,
+				Message:  "runs privileged, with full access to the host",
+			})
+		}
+	}
+	return dcm.filterFindings(findings), nil
+}
+
+// parseComposeDocument reads the top-level keys of a compose file
+func parseComposeDocument(path string) (map[string]interface{}, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	doc := make(map[string]interface{})
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	return doc, nil
+}
+
+// filterFindings drops the findings suppressed in the config
+func (dcm *Manager) filterFindings(findings []Finding) []Finding {
+	ignored := make(map[string]bool)
+	for _, rule := range dcm.config.Lint.Ignore {
+		ignored[rule] = true
+	}
+	kept := make([]Finding, 0, len(findings))
+	for _, f := range findings {
+		if ignored[f.Rule] || (f.Service != "" && ignored[f.Rule+":"+f.Service]) {
+			continue
+		}
+		kept = append(kept, f)
+	}
+	return kept
+}
+
+// PrintLint prints the findings of Lint, as JSON with --output json, and
+// fails when any of them is a warning or an error, or with strict any
+// finding at all
+func (dcm *Manager) PrintLint(strict bool) error {
+	findings, err := dcm.Lint()
+	if err != nil {
+		return err
+	}
+	if dcm.output == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.SetEscapeHTML(false)
+		if err := encoder.Encode(findings); err != nil {
+			return err
+		}
+		for _, f := range findings {
+			if strict || f.Severity != severityInfo {
+				return NewError(ErrLintFindings, "lint found problems")
+			}
+		}
+		return nil
+	}
+	if len(findings) == 0 {
+		fmt.Println("No problems found")
+		return nil
+	}
+
+	failing := 0
+	for _, f := range findings {
+		fmt.Printf("[%s] %s: %s\n", f.Severity, f.subject(), f.Message)
+		if strict || f.Severity != severityInfo {
+			failing++
+		}
+	}
+	if failing > 0 {
+		return NewError(ErrLintFindings, "lint found %d problems", failing)
+	}
+	return nil
+}
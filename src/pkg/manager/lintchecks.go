@@ -0,0 +1,257 @@
+package manager
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// interpolation matches the variable references compose substitutes: $$ is
+// an escaped dollar, ${NAME...} may carry a default or an error message
+// after the name, and $NAME is the bare form
+var interpolation = regexp.MustCompile(`\$(\$|\{([A-Za-z_][A-Za-z0-9_]*)([^}]*)\}|([A-Za-z_][A-Za-z0-9_]*))`)
+
+// fileLines reads a file as lines, nil when it cannot be read
+func fileLines(path string) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines
+}
+
+// indentOf returns the number of leading spaces of a line
+func indentOf(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+// blockLine returns the 1-based line of the first line containing needle in
+// the YAML block opened by the key line "key:", or of the key line itself
+// when needle is empty. It returns 0 when there is no such line. The search
+// is textual, good enough to point at a line rather than to parse.
+func blockLine(lines []string, key, needle string) int {
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed != key+":" && !strings.HasPrefix(trimmed, key+": ") {
+			continue
+		}
+		if needle == "" || strings.Contains(strings.TrimPrefix(trimmed, key+":"), needle) {
+			return i + 1
+		}
+		indent := indentOf(line)
+		for j := i + 1; j < len(lines); j++ {
+			if strings.TrimSpace(lines[j]) == "" {
+				continue
+			}
+			if indentOf(lines[j]) <= indent {
+				break
+			}
+			if strings.Contains(lines[j], needle) {
+				return j + 1
+			}
+		}
+	}
+	return 0
+}
+
+// serviceLocation finds the compose file and line where a service sets
+// needle, or where it is defined when needle is empty
+func (dcm *Manager) serviceLocation(service, needle string) (string, int) {
+	files := dcm.config.composeFiles()
+	for _, f := range files {
+		if line := blockLine(fileLines(f), service, needle); line > 0 {
+			return f, line
+		}
+	}
+	if len(files) > 0 {
+		return files[0], 0
+	}
+	return "", 0
+}
+
+// interpolationEnv returns the variables compose can substitute: those of
+// its environment, of the env file and, without one, of the .env file of
+// the project directory
+func (dcm *Manager) interpolationEnv() map[string]bool {
+	defined := make(map[string]bool)
+	env := dcm.env
+	if env == nil {
+		env = os.Environ()
+	}
+	for _, kv := range env {
+		defined[strings.SplitN(kv, "=", 2)[0]] = true
+	}
+	envFile := dcm.config.EnvFile
+	if envFile == "" {
+		dir := dcm.config.WorkingDir
+		if files := dcm.config.composeFiles(); dir == "" && len(files) > 0 {
+			dir = filepath.Dir(files[0])
+		}
+		envFile = filepath.Join(dir, ".env")
+	}
+	if vars, err := readEnvFile(envFile); err == nil {
+		for name := range vars {
+			defined[name] = true
+		}
+	}
+	return defined
+}
+
+// lintVariables reports variables the compose files use without a default
+// that are not defined anywhere compose looks, which compose replaces with
+// an empty string
+func (dcm *Manager) lintVariables() []Finding {
+	defined := dcm.interpolationEnv()
+	var findings []Finding
+	for _, f := range dcm.config.composeFiles() {
+		reported := make(map[string]bool)
+		for i, line := range fileLines(f) {
+			if strings.HasPrefix(strings.TrimSpace(line), "#") {
+				continue
+			}
+			for _, m := range interpolation.FindAllStringSubmatch(line, -1) {
+				name, modifier := m[2], m[3]
+				if name == "" {
+					name = m[4]
+				}
+				// $$ escapes, and a default or a required message is a
+				// deliberate choice
+				if name == "" || modifier != "" || defined[name] || reported[name] {
+					continue
+				}
+				reported[name] = true
+				findings = append(findings, Finding{
+					Rule:     "undefined-variable",
+					Severity: severityWarning,
+					File:     f,
+					Line:     i + 1,
+					Message:  fmt.Sprintf("${%s} is not set and defaults to an empty string", name),
+				})
+			}
+		}
+	}
+	return findings
+}
+
+// lintProject checks the merged service definitions: build contexts that do
+// not exist and host ports published by more than one service
+func (dcm *Manager) lintProject(project *composeProject) []Finding {
+	var findings []Finding
+	owners := make(map[string][]string)
+	var ports []string
+	for _, name := range project.Names {
+		service := project.Services[name]
+		if service.Build != nil {
+			dir := filepath.Join(service.dir, service.Build.Context)
+			if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+				file, line := dcm.serviceLocation(name, service.Build.Context)
+				findings = append(findings, Finding{
+					Rule:     "missing-build-context",
+					Severity: severityError,
+					Service:  name,
+					File:     file,
+					Line:     line,
+					Message:  fmt.Sprintf("build context %s does not exist", dir),
+				})
+			}
+		}
+		seen := make(map[string]bool)
+		for _, p := range service.Ports {
+			if p.Published == "" || seen[p.Published+"/"+p.Protocol] {
+				continue
+			}
+			key := p.Published + "/" + p.Protocol
+			seen[key] = true
+			if owners[key] == nil {
+				ports = append(ports, key)
+			}
+			owners[key] = append(owners[key], name)
+		}
+	}
+	for _, key := range ports {
+		services := owners[key]
+		if len(services) < 2 {
+			continue
+		}
+		published := strings.SplitN(key, "/", 2)[0]
+		for _, name := range services[1:] {
+			file, line := dcm.serviceLocation(name, published)
+			findings = append(findings, Finding{
+				Rule:     "duplicate-port",
+				Severity: severityError,
+				Service:  name,
+				File:     file,
+				Line:     line,
+				Message:  fmt.Sprintf("host port %s is also published by %s, only one can bind it", key, services[0]),
+			})
+		}
+	}
+	return findings
+}
+
+// lintConfigServices reports the services named in the sections of the
+// config that are not defined in the compose files
+func (dcm *Manager) lintConfigServices(project *composeProject) []Finding {
+	sections := map[string][]string{
+		"services":    dcm.config.Services,
+		"build_order": dcm.config.BuildOrder,
+		"guard":       dcm.config.Guard.Exclude,
+		"one_shot":    dcm.config.OneShot,
+	}
+	for name := range dcm.config.Scale {
+		sections["scale"] = append(sections["scale"], name)
+	}
+	for name := range dcm.config.ReloadSignals {
+		sections["reload_signals"] = append(sections["reload_signals"], name)
+	}
+	for name := range dcm.config.Readiness {
+		sections["readiness"] = append(sections["readiness"], name)
+	}
+	for name := range dcm.config.Shells {
+		sections["shells"] = append(sections["shells"], name)
+	}
+	for name := range dcm.config.Hooks.Services {
+		sections["hooks"] = append(sections["hooks"], name)
+	}
+	for _, members := range dcm.config.Groups {
+		sections["groups"] = append(sections["groups"], members...)
+	}
+
+	lines := fileLines(dcm.configPath)
+	var findings []Finding
+	names := make([]string, 0, len(sections))
+	for section := range sections {
+		names = append(names, section)
+	}
+	sort.Strings(names)
+	for _, section := range names {
+		services := append([]string(nil), sections[section]...)
+		sort.Strings(services)
+		reported := make(map[string]bool)
+		for _, name := range services {
+			if _, ok := project.Services[name]; ok || reported[name] {
+				continue
+			}
+			reported[name] = true
+			findings = append(findings, Finding{
+				Rule:     "unknown-service",
+				Severity: severityError,
+				Service:  name,
+				File:     dcm.configPath,
+				Line:     blockLine(lines, section, name),
+				Message:  fmt.Sprintf("%s names %s, which is not defined in the compose files%s", section, name, DidYouMean(name, project.Names)),
+			})
+		}
+	}
+	return findings
+}
@@ -0,0 +1,112 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// logLevel orders the messages the manager writes to stderr
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var levelNames = map[logLevel]string{
+	levelDebug: "debug",
+	levelInfo:  "info",
+	levelWarn:  "warn",
+	levelError: "error",
+}
+
+// Log formats of --log-format
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// logEntry is one message of the JSON log format, written as a line
+type logEntry struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Message string    `json:"msg"`
+	Project string    `json:"project,omitempty"`
+}
+
+// CheckLogFormat rejects an unknown --log-format
+func CheckLogFormat(format string) error {
+	if format != LogFormatText && format != LogFormatJSON {
+		return NewError(ErrUsage, "unknown log format %q, expected text or json", format)
+	}
+	return nil
+}
+
+// minLevel returns the least important level written: debug messages only
+// with --verbose, and warnings and errors alone with --quiet
+func (dcm *Manager) minLevel() logLevel {
+	switch {
+	case dcm.verbose:
+		return levelDebug
+	case dcm.quiet:
+		return levelWarn
+	}
+	return levelInfo
+}
+
+// logf writes a message to stderr at a level. Text messages carry their own
+// line ending, like fmt.Printf; warnings and errors get a prefix. With the
+// JSON log format every message is one JSON line.
+func (dcm *Manager) logf(level logLevel, format string, args ...interface{}) {
+	if level < dcm.minLevel() {
+		return
+	}
+	msg := fmt.Sprintf(format, args...)
+	if dcm.logFormat == LogFormatJSON {
+		json.NewEncoder(os.Stderr).Encode(logEntry{
+			Time:    time.Now().UTC(),
+			Level:   levelNames[level],
+			Message: strings.TrimRight(msg, "\n"),
+			Project: dcm.project,
+		})
+		return
+	}
+	switch level {
+	case levelWarn:
+		msg = "Warning: " + msg
+	case levelError:
+		msg = "Error: " + msg
+	}
+	fmt.Fprint(os.Stderr, msg)
+}
+
+// Infof prints progress meant for humans, such as the operation being run.
+// It goes to stderr so stdout only carries the output of the command, and is
+// left out with --quiet.
+func (dcm *Manager) Infof(format string, args ...interface{}) {
+	dcm.logf(levelInfo, format, args...)
+}
+
+// verbosef prints a message only when verbose output is enabled
+func (dcm *Manager) verbosef(format string, args ...interface{}) {
+	dcm.logf(levelDebug, format, args...)
+}
+
+// Warnf reports a problem that does not stop the operation
+func (dcm *Manager) Warnf(format string, args ...interface{}) {
+	dcm.logf(levelWarn, format, args...)
+}
+
+// PrintError reports an error to the user, unless the output is JSON, in which
+// case the caller reports it as a JSON envelope once the command has failed.
+func (dcm *Manager) PrintError(err error) {
+	if dcm.output == "json" {
+		return
+	}
+	dcm.logf(levelError, "%v\n", err)
+}
@@ -0,0 +1,69 @@
+package manager
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// logColors are the prefix colors given to services in turn, the palette
+// compose uses
+var logColors = []string{
+	"\033[36m", "\033[33m", "\033[32m", "\033[35m", "\033[34m",
+	"\033[1;36m", "\033[1;33m", "\033[1;32m", "\033[1;35m", "\033[1;34m",
+}
+
+const ansiReset = "\033[0m"
+
+// logMux interleaves the log lines of several sources on one writer, each
+// line whole and prefixed with the padded name of its source
+type logMux struct {
+	mu     sync.Mutex
+	out    io.Writer
+	width  int
+	colors map[string]string
+}
+
+// newLogMux prepares prefixes for names, colored when color is set
+func newLogMux(out io.Writer, names []string, color bool) *logMux {
+	m := &logMux{out: out, colors: make(map[string]string)}
+	for i, name := range names {
+		if len(name) > m.width {
+			m.width = len(name)
+		}
+		if color {
+			m.colors[name] = logColors[i%len(logColors)]
+		}
+	}
+	return m
+}
+
+// line writes one line of source name
+func (m *logMux) line(name, text string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if color := m.colors[name]; color != "" {
+		fmt.Fprintf(m.out, "%s%-*s |%s %s\n", color, m.width, name, ansiReset, text)
+		return
+	}
+	fmt.Fprintf(m.out, "%-*s | %s\n", m.width, name, text)
+}
+
+// copy writes the lines read from r as lines of source name until r ends
+func (m *logMux) copy(name string, r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		m.line(name, scanner.Text())
+	}
+	return scanner.Err()
+}
+
+// logColor reports whether log prefixes written to out are colored: only on
+// a terminal, and neither --no-color nor NO_COLOR is set
+func logColor(out io.Writer, opts LogOptions) bool {
+	f, ok := out.(*os.File)
+	return ok && !opts.NoColor && os.Getenv("NO_COLOR") == "" && IsTerminal(f)
+}
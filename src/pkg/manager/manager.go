@@ -0,0 +1,1371 @@
+package manager
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Config represents the Docker Compose Manager configuration
+type Config struct {
+	// Version is the version of the config format, see schema.go
+	Version      int        `yaml:"version"`
+	Services     []string   `yaml:"services"`
+	ComposeFile  string     `yaml:"compose_file"`
+	ComposeFiles stringList `yaml:"compose_files"`
+	ProjectName  string     `yaml:"project_name"`
+	BuildOrder   []string   `yaml:"build_order"`
+	// ComposeCommand overrides the detected compose invocation, e.g.
+	// "docker compose" or "docker-compose"
+	ComposeCommand string `yaml:"compose_command"`
+	// Features overrides the defaults of feature flags, see features.go
+	Features map[string]bool `yaml:"features"`
+	// Lint configures the lint command, see lint.go
+	Lint LintConfig `yaml:"lint"`
+	// Scale is the number of containers start runs for each listed service
+	Scale map[string]int `yaml:"scale"`
+	// ReloadSignals is the signal reload sends to each listed service,
+	// SIGHUP for the others
+	ReloadSignals map[string]string `yaml:"reload_signals"`
+	// Environment sets variables for compose commands, merged with the host
+	// environment and the env file in the order of EnvPrecedence, see env.go
+	Environment   map[string]string `yaml:"environment"`
+	EnvPrecedence []string          `yaml:"env_precedence"`
+	// StrictServices makes commands fail on any service name that is not a
+	// service of the project, even when the compose files cannot be read
+	StrictServices bool `yaml:"strict_services"`
+	// CommandLog is an append-only JSONL file recording every command run,
+	// relative to the config file, see commandlog.go
+	CommandLog string `yaml:"command_log"`
+	// WaitTimeout bounds start --wait, e.g. "90s"
+	WaitTimeout string `yaml:"wait_timeout"`
+	// OneShot lists the services that run to completion, such as
+	// migrations; a wait counts them ready once they exit with code 0
+	OneShot []string `yaml:"one_shot"`
+	// Groups names sets of services that can be given in place of a
+	// service, see groups.go
+	Groups map[string][]string `yaml:"groups"`
+	// Shells is the shell `dcm shell` opens in each listed service, e.g.
+	// "bash" or "ash -l"; bash, or sh without it, for the others
+	Shells map[string]string `yaml:"shells"`
+	// Readiness holds the probes a service must pass, besides its
+	// healthcheck, before a wait counts it as ready, see readiness.go
+	Readiness map[string]ReadinessProbe `yaml:"readiness"`
+	// Watch configures which files the watch command reacts to and how
+	// long it lets changes settle, see watch.go
+	Watch WatchConfig `yaml:"watch"`
+	// Guard configures which services `dcm guard` keeps running and how
+	// often it checks them, see guard.go
+	Guard GuardConfig `yaml:"guard"`
+	// Secrets are read by running a command, such as that of a password
+	// manager, when a ${NAME} placeholder of the config references them,
+	// see template.go
+	Secrets map[string]SecretSource `yaml:"secrets"`
+	// Hooks are commands and HTTP requests run before and after commands,
+	// globally or for single services, see hooks.go
+	Hooks HooksConfig `yaml:"hooks"`
+	// WorkingDir is passed as --project-directory, relative compose and env
+	// files are resolved from it
+	WorkingDir string `yaml:"working_dir"`
+	// Backend selects how operations are carried out: "shell", the default,
+	// runs the compose command and "api" talks to the Docker Engine API,
+	// see backend.go
+	Backend string `yaml:"backend"`
+	// EnvFile is passed to docker-compose as --env-file
+	EnvFile string `yaml:"env_file"`
+	// Endpoint is the Docker engine the services run on: docker_host,
+	// docker_context, tls_verify and cert_path, see remote.go
+	Endpoint DockerEndpoint `yaml:",inline"`
+	// Projects defines several stacks in one config, selected with
+	// --project or default_project. See projects.go.
+	Projects       map[string]ProjectConfig `yaml:"projects"`
+	DefaultProject string                   `yaml:"default_project"`
+}
+
+// stringList is a config value written either as a single string or as a
+// list of strings
+type stringList []string
+
+// UnmarshalYAML accepts both a scalar and a sequence
+func (l *stringList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var single string
+	if err := unmarshal(&single); err == nil {
+		*l = stringList{single}
+		return nil
+	}
+	var list []string
+	if err := unmarshal(&list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+// composeFiles returns the compose files to pass to docker-compose, in the
+// order they should be layered: compose_file first, then compose_files.
+func (c Config) composeFiles() []string {
+	var files []string
+	if c.ComposeFile != "" {
+		files = append(files, c.ComposeFile)
+	}
+	for _, f := range c.ComposeFiles {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// Manager manages Docker Compose services
+type Manager struct {
+	configPath string
+	config     Config
+	verbose    bool
+	// composeCmd is the resolved compose invocation, such as
+	// ["docker", "compose"] for Compose V2
+	composeCmd      []string
+	composeOverride string
+	// input reads the user's answers in interactive mode
+	input    *bufio.Scanner
+	features map[string]featureState
+	// allServices makes operations without a service name act on the whole
+	// project instead of the services listed in the config
+	allServices bool
+	// force allows naming services outside the configured list
+	force bool
+	// output is the output format, "text" or "json"
+	output string
+	// quiet leaves out banners and progress messages
+	quiet bool
+	// logFormat is how messages are written to stderr, "text" or "json",
+	// see logging.go
+	logFormat string
+	// overlays are compose files passed after the configured ones, such as
+	// an image lock file
+	overlays []string
+	// fileConfig is the config as read, before a project was applied to it
+	fileConfig Config
+	// portsOverride is the override file of start --auto-ports, passed
+	// after the overlays while it exists, see ports.go
+	portsOverride string
+	// project is the name of the selected project, if any; projectOverride
+	// is the one requested with --project
+	project         string
+	projectOverride string
+	// env is the environment of compose commands, nil to inherit ours
+	env []string
+	// strictServices is set by --strict-services, strict_services in the
+	// config also enables it
+	strictServices bool
+	// middleware wraps every operation, see Use
+	middleware []Middleware
+	// v1Compat translates Compose V1 commands for Compose V2, see compat.go
+	v1Compat bool
+	// composeVersion caches composeMajorVersion. The copies of the manager
+	// for other projects share it, they run the same compose command.
+	composeVersion *composeVersionCache
+	// dockerClients are the clients of the api backend, shared like
+	// composeVersion, see backend.go
+	dockerClients *dockerClients
+	// commandLog is the command log set with --command-log, taking
+	// precedence over command_log in the config
+	commandLog string
+	// backendName is the backend set with --backend, taking precedence over
+	// backend in the config
+	backendName string
+	// dryRun prints the commands that change state instead of running
+	// them, see dryrun.go
+	dryRun bool
+	// yes skips the confirmation of destructive operations
+	yes bool
+	// skipHooks leaves out the hooks of the config
+	skipHooks bool
+	// fileOverride and envFileOverride are the compose files and env file
+	// given with --file and --env-file, replacing those of the config
+	fileOverride    []string
+	envFileOverride string
+	// runner runs the compose and docker commands, see runner.go
+	runner Runner
+}
+
+// Option customizes a Manager when it is created
+type Option func(*Manager)
+
+// WithOutput sets the output format, "text" or "json". With json, errors are
+// left for the caller to report as JSON instead of being printed.
+func WithOutput(format string) Option {
+	return func(dcm *Manager) {
+		dcm.output = format
+	}
+}
+
+// WithProject selects one of the projects defined in the config, taking
+// precedence over default_project
+func WithProject(name string) Option {
+	return func(dcm *Manager) {
+		dcm.projectOverride = name
+	}
+}
+
+// WithQuiet leaves out banners and progress messages, keeping only the output
+// of the commands run, warnings and errors
+func WithQuiet() Option {
+	return func(dcm *Manager) {
+		dcm.quiet = true
+	}
+}
+
+// WithVerbose prints debug messages, such as the details of decisions made
+// along the way, like DCM_VERBOSE
+func WithVerbose() Option {
+	return func(dcm *Manager) {
+		dcm.verbose = true
+	}
+}
+
+// WithLogFormat writes the messages on stderr as text or, with "json", as
+// one JSON object per line
+func WithLogFormat(format string) Option {
+	return func(dcm *Manager) {
+		dcm.logFormat = format
+	}
+}
+
+// WithStrictServices rejects every service name that is not a service of
+// the project, like strict_services in the config
+func WithStrictServices() Option {
+	return func(dcm *Manager) {
+		dcm.strictServices = true
+	}
+}
+
+// WithMiddleware adds middleware to the chain operations run through, like
+// Use
+func WithMiddleware(mws ...Middleware) Option {
+	return func(dcm *Manager) {
+		dcm.middleware = append(dcm.middleware, mws...)
+	}
+}
+
+// WithComposeV1Compat translates the Compose V1 commands that Compose V2
+// removed or renamed when running against V2
+func WithComposeV1Compat() Option {
+	return func(dcm *Manager) {
+		dcm.v1Compat = true
+	}
+}
+
+// WithComposeFiles uses the given compose files, layered in order, instead
+// of those of the config. Relative paths are relative to the current
+// directory.
+func WithComposeFiles(files ...string) Option {
+	return func(dcm *Manager) {
+		dcm.fileOverride = files
+	}
+}
+
+// WithEnvFile passes the given env file to compose instead of env_file from
+// the config
+func WithEnvFile(path string) Option {
+	return func(dcm *Manager) {
+		dcm.envFileOverride = path
+	}
+}
+
+// WithDryRun prints the commands and API calls that would change state
+// instead of running them
+func WithDryRun() Option {
+	return func(dcm *Manager) {
+		dcm.dryRun = true
+	}
+}
+
+// WithYes answers yes to the confirmation of destructive operations
+func WithYes() Option {
+	return func(dcm *Manager) {
+		dcm.yes = true
+	}
+}
+
+// WithSkipHooks leaves out the hooks of the config
+func WithSkipHooks() Option {
+	return func(dcm *Manager) {
+		dcm.skipHooks = true
+	}
+}
+
+// WithCommandLog records every command run in a JSONL file, like
+// command_log in the config
+func WithCommandLog(path string) Option {
+	return func(dcm *Manager) {
+		dcm.commandLog = path
+	}
+}
+
+// WithBackend selects the backend, "shell" or "api", like backend in the
+// config
+func WithBackend(name string) Option {
+	return func(dcm *Manager) {
+		dcm.backendName = name
+	}
+}
+
+// WithRunner runs the compose and docker commands with r instead of
+// starting processes
+func WithRunner(r Runner) Option {
+	return func(dcm *Manager) {
+		dcm.runner = r
+	}
+}
+
+// WithComposeCommand overrides the compose invocation, taking precedence over
+// the environment and the config file
+func WithComposeCommand(command string) Option {
+	return func(dcm *Manager) {
+		dcm.composeOverride = command
+	}
+}
+
+// New creates a Manager for a config file. It fails when the config file
+// cannot be read or is invalid, and when no compose command can be found.
+func New(configPath string, opts ...Option) (*Manager, error) {
+	if configPath == "" {
+		configPath = "dcm.config.yml"
+	}
+
+	dcm := &Manager{
+		configPath:     configPath,
+		verbose:        os.Getenv("DCM_VERBOSE") != "",
+		logFormat:      LogFormatText,
+		runner:         ExecRunner{},
+		composeVersion: &composeVersionCache{},
+		dockerClients:  &dockerClients{},
+	}
+	for _, opt := range opts {
+		opt(dcm)
+	}
+	if err := dcm.loadConfig(); err != nil {
+		return nil, err
+	}
+	dcm.fileConfig = dcm.config
+	if err := dcm.applyConfig(dcm.projectOverride); err != nil {
+		return nil, err
+	}
+	if err := dcm.checkEndpoint(); err != nil {
+		return nil, err
+	}
+	if err := dcm.checkGuard(); err != nil {
+		return nil, err
+	}
+	if err := dcm.resolveFeatures(); err != nil {
+		return nil, err
+	}
+	if _, err := dcm.backend(); err != nil {
+		return nil, err
+	}
+	if err := dcm.checkReadiness(); err != nil {
+		return nil, err
+	}
+	if err := dcm.checkGroups(); err != nil {
+		return nil, err
+	}
+	if err := dcm.checkWatch(); err != nil {
+		return nil, err
+	}
+	if err := dcm.checkHooks(); err != nil {
+		return nil, err
+	}
+	if !dcm.skipHooks {
+		dcm.Use(dcm.hookMiddleware)
+	}
+
+	composeCmd, err := dcm.resolveComposeCommand()
+	if err != nil {
+		return nil, err
+	}
+	dcm.composeCmd = composeCmd
+	return dcm, nil
+}
+
+// ConfigPath returns the path of the config file
+func (dcm *Manager) ConfigPath() string {
+	return dcm.configPath
+}
+
+// Config returns the config of the selected project
+func (dcm *Manager) Config() Config {
+	return dcm.config
+}
+
+// Project returns the name of the project selected from the projects of the
+// config, empty when it defines none
+func (dcm *Manager) Project() string {
+	return dcm.project
+}
+
+// Output returns the output format, "text" or "json"
+func (dcm *Manager) Output() string {
+	return dcm.output
+}
+
+// DryRun reports whether state changing commands are only printed
+func (dcm *Manager) DryRun() bool {
+	return dcm.dryRun
+}
+
+// Yes reports whether destructive operations run without confirmation
+func (dcm *Manager) Yes() bool {
+	return dcm.yes
+}
+
+// SetAllServices makes operations without a service name act on the whole
+// project instead of the services listed in the config
+func (dcm *Manager) SetAllServices(all bool) {
+	dcm.allServices = all
+}
+
+// SetForce allows naming services outside the configured list
+func (dcm *Manager) SetForce(force bool) {
+	dcm.force = force
+}
+
+// findConfig looks for the config file in startDir and then in each of its
+// parents up to the filesystem root, the way git finds .git. It returns
+// os.ErrNotExist when there is none.
+func findConfig(startDir string) (string, error) {
+	return findUp(startDir, "dcm.config.yml")
+}
+
+// findUp returns the path of the first file named name found in dir or one
+// of its parents
+func findUp(dir, name string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", os.ErrNotExist
+		}
+		dir = parent
+	}
+}
+
+// LocateConfig returns the config file to use when none is given: the
+// nearest dcm.config.yml in the current directory or a parent. Without one,
+// the defaults apply from the nearest directory with a compose file of a
+// standard name, see discoverComposeFiles.
+func LocateConfig() string {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "dcm.config.yml"
+	}
+	path, err := findConfig(cwd)
+	if err != nil {
+		dir, err := findComposeDir(cwd)
+		if err != nil {
+			return "dcm.config.yml"
+		}
+		path = filepath.Join(dir, "dcm.config.yml")
+	}
+	if filepath.Dir(path) == cwd {
+		return "dcm.config.yml"
+	}
+	return path
+}
+
+// loadConfig loads the configuration from the YAML file. A missing file is
+// not an error, the defaults apply; an unreadable or invalid one is.
+func (dcm *Manager) loadConfig() error {
+	data, err := ioutil.ReadFile(dcm.configPath)
+	if os.IsNotExist(err) {
+		dcm.Infof("Config file not found, using defaults\n")
+		// The compose files are discovered by applyConfig
+		dcm.config = Config{Services: []string{}}
+		return nil
+	}
+	if err != nil {
+		return NewError(ErrConfig, "reading config file: %w", err)
+	}
+	version, err := configFileVersion(dcm.configPath, data)
+	if err != nil {
+		return err
+	}
+	if err := dcm.checkConfigFile(data, version); err != nil {
+		return err
+	}
+	if data, err = dcm.templateConfig(data); err != nil {
+		return err
+	}
+	if version < configVersion {
+		if data, err = dcm.migrateConfig(data, version); err != nil {
+			return err
+		}
+	}
+
+	if err := yaml.Unmarshal(data, &dcm.config); err != nil {
+		return NewError(ErrConfig, "parsing config file %s: %w", dcm.configPath, err)
+	}
+	return nil
+}
+
+// setProjectName sets the compose project name, normalized the way compose
+// itself normalizes it so label based lookups match the real project.
+func (dcm *Manager) setProjectName(name string) {
+	sanitized := sanitizeProjectName(name)
+	if sanitized != name {
+		if sanitized == "" {
+			dcm.Warnf("project name %q has no valid characters, ignoring it\n", name)
+		} else {
+			dcm.verbosef("Project name %q normalized to %q\n", name, sanitized)
+		}
+	}
+	dcm.config.ProjectName = sanitized
+}
+
+// executeCommand runs a command without going through a shell and returns
+// its output
+func (dcm *Manager) executeCommand(argv []string) (string, error) {
+	return dcm.executeCommandContext(context.Background(), argv)
+}
+
+// executeCommandContext is executeCommand with a context that kills the
+// command when it is done
+func (dcm *Manager) executeCommandContext(ctx context.Context, argv []string) (string, error) {
+	if dcm.dryRunSkip(argv) {
+		return "", nil
+	}
+	dcm.Infof("Executing: %s\n", quoteArgs(argv))
+
+	started := time.Now()
+	cmd := dcm.command(ctx, argv)
+	output, err := cmd.CombinedOutput()
+	dcm.logCommand(argv, started, err)
+
+	if err != nil {
+		// Show what the command said before failing, it usually explains why
+		fmt.Print(string(output))
+		dcm.PrintError(err)
+		return "", err
+	}
+
+	result := string(output)
+	fmt.Print(result)
+	return result, nil
+}
+
+// executeStreaming runs a command with its output connected to the given
+// writers as it is produced, rather than buffered until it exits. The command
+// is killed when ctx is done.
+func (dcm *Manager) executeStreaming(ctx context.Context, argv []string, stdout, stderr io.Writer) error {
+	if dcm.dryRunSkip(argv) {
+		return nil
+	}
+	dcm.Infof("Executing: %s\n", quoteArgs(argv))
+
+	started := time.Now()
+	cmd := dcm.command(ctx, argv)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	err := cmd.Run()
+	dcm.logCommand(argv, started, err)
+	return err
+}
+
+// composeArgs builds the argv of a docker-compose command for the configured
+// compose files.
+func (dcm *Manager) composeArgs(args ...string) ([]string, error) {
+	argv := append([]string(nil), dcm.composeCmd...)
+	if dcm.config.ProjectName != "" {
+		argv = append(argv, "-p", dcm.config.ProjectName)
+	}
+	if dcm.config.WorkingDir != "" {
+		argv = append(argv, "--project-directory", dcm.config.WorkingDir)
+	}
+	if dcm.config.EnvFile != "" {
+		argv = append(argv, "--env-file", dcm.config.EnvFile)
+	}
+	for _, f := range dcm.config.composeFiles() {
+		if _, err := os.Stat(f); err != nil {
+			return nil, NewError(ErrConfig, "compose file %s not found", f)
+		}
+		argv = append(argv, "-f", f)
+	}
+	for _, f := range dcm.overlays {
+		argv = append(argv, "-f", f)
+	}
+	if dcm.portsOverride != "" {
+		argv = append(argv, "-f", dcm.portsOverride)
+	}
+	if dcm.v1Compat && dcm.composeMajorVersion() >= 2 {
+		translated, err := dcm.translateV1(args)
+		if err != nil {
+			return nil, err
+		}
+		args = translated
+	}
+	return append(argv, args...), nil
+}
+
+// compose builds a docker-compose command and executes it. Every operation
+// goes through here so they all act on the same stack.
+func (dcm *Manager) compose(args ...string) (string, error) {
+	argv, err := dcm.composeArgs(args...)
+	if err != nil {
+		dcm.PrintError(err)
+		return "", err
+	}
+	return dcm.executeCommand(argv)
+}
+
+// composeStreaming runs a docker-compose command with its output shown as it
+// is produced, for long running commands whose progress matters.
+func (dcm *Manager) composeStreaming(args ...string) error {
+	argv, err := dcm.composeArgs(args...)
+	if err == nil {
+		err = dcm.executeStreaming(context.Background(), argv, os.Stdout, os.Stderr)
+	}
+	if err != nil {
+		dcm.PrintError(err)
+	}
+	return err
+}
+
+// composeOutput runs a docker-compose command and returns its standard
+// output without echoing anything, for callers that parse the result.
+func (dcm *Manager) composeOutput(args ...string) (string, error) {
+	return dcm.composeOutputContext(context.Background(), args...)
+}
+
+// composeOutputContext is composeOutput with a context that kills the
+// command when it is done
+func (dcm *Manager) composeOutputContext(ctx context.Context, args ...string) (string, error) {
+	argv, err := dcm.composeArgs(args...)
+	if err != nil {
+		return "", err
+	}
+	started := time.Now()
+	output, err := dcm.command(ctx, argv).Output()
+	dcm.logCommand(argv, started, err)
+	return string(output), err
+}
+
+// scopedServices returns the services an operation without a service name
+// acts on: the services listed in the config, or nil for the whole project.
+func (dcm *Manager) scopedServices() []string {
+	if dcm.allServices {
+		return nil
+	}
+	return dcm.config.Services
+}
+
+// checkScope refuses a service that is not in the configured list, unless
+// forced. Any service is accepted when the config lists none or with --all.
+func (dcm *Manager) checkScope(name string) error {
+	if len(dcm.config.Services) == 0 || dcm.allServices {
+		return nil
+	}
+	for _, s := range dcm.config.Services {
+		if s == name {
+			return nil
+		}
+	}
+	if dcm.force {
+		dcm.Warnf("%s is not in the services configured in %s\n", name, dcm.configPath)
+		return nil
+	}
+	return NewError(ErrServiceNotConfigured, "service %q is not in the services configured in %s (%s), use --force to run it anyway",
+		name, dcm.configPath, strings.Join(dcm.config.Services, ", "))
+}
+
+// serviceCommand runs a docker-compose subcommand against one service, or
+// against the configured services when serviceName is empty.
+func (dcm *Manager) serviceCommand(serviceName string, args ...string) (string, error) {
+	args, err := dcm.serviceArgs(serviceName, args...)
+	if err != nil {
+		dcm.PrintError(err)
+		return "", err
+	}
+	return dcm.compose(args...)
+}
+
+// serviceStreaming is serviceCommand with the output shown as it is produced
+func (dcm *Manager) serviceStreaming(serviceName string, args ...string) error {
+	args, err := dcm.serviceArgs(serviceName, args...)
+	if err != nil {
+		dcm.PrintError(err)
+		return err
+	}
+	return dcm.composeStreaming(args...)
+}
+
+// serviceArgs appends the services a subcommand acts on to its arguments
+func (dcm *Manager) serviceArgs(serviceName string, args ...string) ([]string, error) {
+	if serviceName == "" {
+		return append(args, dcm.scopedServices()...), nil
+	}
+	if err := dcm.checkService(serviceName); err != nil {
+		return nil, err
+	}
+	return append(args, serviceName), nil
+}
+
+// checkService validates a service name given by the user and checks it is
+// in scope
+func (dcm *Manager) checkService(name string) error {
+	if err := dcm.validateService(name); err != nil {
+		return err
+	}
+	return dcm.checkScope(name)
+}
+
+// upArgs returns the docker-compose arguments that start services
+func (dcm *Manager) upArgs() []string {
+	args := []string{"up", "-d"}
+	if dcm.FeatureEnabled("remove_orphans") {
+		args = append(args, "--remove-orphans")
+	}
+	return args
+}
+
+// StartOptions selects the services Start starts and whether it waits for
+// them
+type StartOptions struct {
+	// Services are the services to start, those in scope when empty
+	Services []string
+	// Wait waits until the started services are running and healthy, see
+	// WaitReady
+	Wait        bool
+	WaitOptions WaitOptions
+}
+
+// Start starts services, each with the replica count given in the scale
+// section of the config. The compose command is killed when ctx is done.
+func (dcm *Manager) Start(ctx context.Context, opts StartOptions) error {
+	dcm.Infof("Starting services...\n")
+	if err := dcm.start(ctx, opts.Services); err != nil || !opts.Wait {
+		return err
+	}
+	err := dcm.WaitReady(opts.Services, opts.WaitOptions)
+	if err != nil {
+		dcm.PrintError(err)
+	}
+	return err
+}
+
+// start runs compose up for services, or with the api backend creates and
+// starts their containers, printing its errors
+func (dcm *Manager) start(ctx context.Context, services []string) error {
+	argv, api, err := dcm.startCommand(services)
+	switch {
+	case err != nil:
+		dcm.PrintError(err)
+		return err
+	case api != nil:
+		if err := api.Start(ctx, services); err != nil {
+			dcm.PrintError(err)
+			return err
+		}
+		return nil
+	}
+	_, err = dcm.executeCommandContext(ctx, argv)
+	return err
+}
+
+// startCommand returns the compose command starting services, or the api
+// backend to start them with instead
+func (dcm *Manager) startCommand(services []string) ([]string, *APIBackend, error) {
+	for _, name := range services {
+		if err := dcm.checkService(name); err != nil {
+			return nil, nil, err
+		}
+	}
+	backend, err := dcm.backend()
+	if err != nil {
+		return nil, nil, err
+	}
+	if api, ok := backend.(*APIBackend); ok {
+		return nil, api, nil
+	}
+	argv, err := dcm.upCommand(services)
+	return argv, nil, err
+}
+
+// upCommand returns the compose up command starting services, or those in
+// scope when there are none, each with its replica count from the config
+func (dcm *Manager) upCommand(services []string) ([]string, error) {
+	args := dcm.upArgs()
+	scaled, err := dcm.configuredScale(services)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range scaled {
+		args = append(args, "--scale", fmt.Sprintf("%s=%d", t.Service, t.Replicas))
+	}
+	if len(services) == 0 {
+		services = dcm.scopedServices()
+	}
+	return dcm.composeArgs(append(args, services...)...)
+}
+
+// startService is Start for the single service of the interactive menu
+func (dcm *Manager) startService(serviceName string) (string, error) {
+	var services []string
+	if serviceName != "" {
+		services = []string{serviceName}
+	}
+	return "", dcm.Start(context.Background(), StartOptions{Services: services})
+}
+
+// configuredScale returns the replica counts of the config that apply when
+// starting services, or the services in scope when there are none
+func (dcm *Manager) configuredScale(services []string) ([]ScaleTarget, error) {
+	names := make([]string, 0, len(dcm.config.Scale))
+	for name := range dcm.config.Scale {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(services) > 0 {
+		names = filterServices(names, services)
+	} else if scope := dcm.scopedServices(); len(scope) > 0 {
+		names = filterServices(names, scope)
+	}
+
+	var targets []ScaleTarget
+	for _, name := range names {
+		replicas := dcm.config.Scale[name]
+		if replicas < 0 {
+			return nil, NewError(ErrConfig, "%s: scale of %s must not be negative", dcm.configPath, name)
+		}
+		targets = append(targets, ScaleTarget{name, replicas})
+	}
+	return targets, nil
+}
+
+// Stop stops Docker Compose services
+func (dcm *Manager) Stop(serviceName string) (string, error) {
+	dcm.Infof("Stopping services...\n")
+	return dcm.serviceCommand(serviceName, "stop")
+}
+
+// Restart restarts Docker Compose services
+func (dcm *Manager) Restart(serviceName string) (string, error) {
+	dcm.Infof("Restarting services...\n")
+	return dcm.serviceCommand(serviceName, "restart")
+}
+
+// replicaCount returns the number of containers currently running for a service
+func (dcm *Manager) replicaCount(service string) (int, error) {
+	if err := dcm.checkService(service); err != nil {
+		return 0, err
+	}
+	output, err := dcm.composeOutput("ps", "-q", service)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, line := range strings.Split(output, "\n") {
+		if strings.TrimSpace(line) != "" {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// ScaleTarget is a replica count requested for a service
+type ScaleTarget struct {
+	Service  string
+	Replicas int
+}
+
+// Scale sets the number of containers running for a service
+func (dcm *Manager) Scale(serviceName string, replicas int) (string, error) {
+	return dcm.ScaleServices([]ScaleTarget{{serviceName, replicas}})
+}
+
+// ScaleServices sets the replica counts of several services in a single
+// docker-compose up
+func (dcm *Manager) ScaleServices(targets []ScaleTarget) (string, error) {
+	dcm.Infof("Scaling services...\n")
+	args := append(dcm.upArgs(), "--no-recreate")
+	var names []string
+	for _, t := range targets {
+		if err := dcm.checkScalable(t); err != nil {
+			dcm.PrintError(err)
+			return "", err
+		}
+		args = append(args, "--scale", fmt.Sprintf("%s=%d", t.Service, t.Replicas))
+		names = append(names, t.Service)
+	}
+	return dcm.compose(append(args, names...)...)
+}
+
+// checkScalable checks a service is defined in the compose files and can run
+// the requested number of containers
+func (dcm *Manager) checkScalable(t ScaleTarget) error {
+	if err := dcm.checkService(t.Service); err != nil {
+		return err
+	}
+	if t.Replicas < 0 {
+		return NewError(ErrUsage, "replica count of %s must not be negative", t.Service)
+	}
+	project, err := dcm.composeProject()
+	if err != nil {
+		return NewError(ErrConfig, "%w", err)
+	}
+	if len(project.Names) == 0 {
+		// Nothing to check against, let docker-compose decide
+		return nil
+	}
+	service, ok := project.Services[t.Service]
+	if !ok {
+		return NewError(ErrServiceNotFound, "unknown service %q%s (defined services: %s)",
+			t.Service, DidYouMean(t.Service, project.Names), strings.Join(project.Names, ", "))
+	}
+	if t.Replicas <= 1 {
+		return nil
+	}
+	if service.ContainerName != "" {
+		return NewError(ErrConfig, "cannot scale %s: it sets container_name %q, and container names must be unique; remove container_name to run several replicas",
+			t.Service, service.ContainerName)
+	}
+	if port := service.fixedHostPort(); port != "" {
+		return NewError(ErrConfig, "cannot scale %s: it publishes host port %s, which only one container can bind; publish a port range or let docker pick the host port",
+			t.Service, port)
+	}
+	return nil
+}
+
+// restartPreservingScale restarts a service and re-applies its replica count
+// afterwards, so a service scaled with --scale does not fall back to a single
+// container.
+func (dcm *Manager) restartPreservingScale(service string) error {
+	replicas, err := dcm.replicaCount(service)
+	if err != nil {
+		return fmt.Errorf("reading replica count of %s: %w", service, err)
+	}
+
+	if _, err := dcm.Restart(service); err != nil {
+		return err
+	}
+	if replicas <= 1 {
+		return nil
+	}
+
+	dcm.Infof("Restoring %s to %d replicas...\n", service, replicas)
+	_, err = dcm.compose(rescaleArgs(service, replicas)...)
+	return err
+}
+
+// rescaleArgs brings a service back to a replica count without recreating
+// the containers it has
+func rescaleArgs(service string, replicas int) []string {
+	return []string{"up", "-d", "--no-recreate", "--scale", fmt.Sprintf("%s=%d", service, replicas), service}
+}
+
+// RestartService restarts one service, or every service when serviceName is
+// empty, preserving the replica count of a single service when the
+// restart_preserves_scale feature is on.
+func (dcm *Manager) RestartService(serviceName string) (string, error) {
+	if serviceName == "" || !dcm.FeatureEnabled("restart_preserves_scale") {
+		return dcm.Restart(serviceName)
+	}
+	err := dcm.restartPreservingScale(serviceName)
+	if err != nil {
+		dcm.PrintError(err)
+	}
+	return "", err
+}
+
+// composeStatus prints the status table of compose
+func (dcm *Manager) composeStatus() (string, error) {
+	dcm.Infof("Checking service status...\n")
+	return dcm.compose("ps")
+}
+
+// ServiceInfo is a service of the compose files with its current state
+type ServiceInfo struct {
+	Name  string `json:"name"`
+	State string `json:"state"`
+}
+
+// ListServices returns the services defined in the compose files in the
+// order they are declared, each marked running or stopped. When the config
+// lists services only those are returned.
+func (dcm *Manager) ListServices() ([]ServiceInfo, error) {
+	project, err := dcm.composeProject()
+	if err != nil {
+		return nil, err
+	}
+	running, err := dcm.composeOutput("ps", "--services", "--filter", "status=running")
+	if err != nil {
+		return nil, err
+	}
+	isRunning := make(map[string]bool)
+	for _, name := range strings.Fields(running) {
+		isRunning[name] = true
+	}
+
+	names := project.Names
+	if scope := dcm.scopedServices(); len(scope) > 0 {
+		names = filterServices(names, scope)
+	}
+	services := make([]ServiceInfo, 0, len(names))
+	for _, name := range names {
+		state := "stopped"
+		if isRunning[name] {
+			state = "running"
+		}
+		services = append(services, ServiceInfo{Name: name, State: state})
+	}
+	return services, nil
+}
+
+// LogOptions selects which log lines Logs fetches
+type LogOptions struct {
+	// Services are the services whose logs Logs reads, those in scope
+	// when empty; the other log methods take their services separately
+	Services []string
+	Follow   bool
+	// Tail limits output to the last lines of each container, negative
+	// means all lines
+	Tail int
+	// Since is passed through to docker-compose, e.g. "10m" or a timestamp
+	Since string
+	// NoColor leaves the service prefixes uncolored, which they also are
+	// when the output is not a terminal
+	NoColor bool
+}
+
+// Logs returns a reader of the logs of services, their lines interleaved
+// and each prefixed with its service. When following, the reader ends once
+// ctx is done. Closing the reader stops the commands reading the logs.
+func (dcm *Manager) Logs(ctx context.Context, opts LogOptions) (io.ReadCloser, error) {
+	for _, name := range opts.Services {
+		if err := dcm.checkService(name); err != nil {
+			return nil, err
+		}
+	}
+	backend, err := dcm.backend()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(backend.Logs(ctx, opts.Services, opts, w))
+	}()
+	return &logReader{PipeReader: r, cancel: cancel}, nil
+}
+
+// logReader is the reader of Logs, stopping the logs when closed
+type logReader struct {
+	*io.PipeReader
+	cancel context.CancelFunc
+}
+
+func (r *logReader) Close() error {
+	r.cancel()
+	return r.PipeReader.Close()
+}
+
+// LogsWithOptions retrieves logs from Docker Compose services. When following,
+// or with the api backend, lines are written to out as they arrive until ctx
+// is cancelled, and the returned string is empty.
+func (dcm *Manager) LogsWithOptions(ctx context.Context, serviceName string, opts LogOptions, out io.Writer) (string, error) {
+	backend, err := dcm.backend()
+	if err != nil {
+		dcm.PrintError(err)
+		return "", err
+	}
+	if _, shell := backend.(*ShellBackend); shell && !opts.Follow {
+		dcm.Infof("Fetching logs...\n")
+		args := []string{"logs"}
+		if opts.Tail >= 0 {
+			args = append(args, "--tail", strconv.Itoa(opts.Tail))
+		}
+		if opts.Since != "" {
+			args = append(args, "--since", opts.Since)
+		}
+		return dcm.serviceCommand(serviceName, args...)
+	}
+
+	var services []string
+	if serviceName != "" {
+		services = []string{serviceName}
+	}
+	return "", dcm.ServiceLogs(ctx, services, opts, out)
+}
+
+// ServiceLogs writes the logs of services, those in scope when none are
+// given, to out as they arrive, until ctx is cancelled when following. The
+// lines of the services are interleaved, each prefixed with its service.
+func (dcm *Manager) ServiceLogs(ctx context.Context, services []string, opts LogOptions, out io.Writer) error {
+	dcm.Infof("Fetching logs...\n")
+	for _, name := range services {
+		if err := dcm.checkService(name); err != nil {
+			dcm.PrintError(err)
+			return err
+		}
+	}
+	backend, err := dcm.backend()
+	if err == nil {
+		err = backend.Logs(ctx, services, opts, out)
+	}
+	if err != nil {
+		dcm.PrintError(err)
+	}
+	return err
+}
+
+// Remove removes Docker Compose services
+func (dcm *Manager) Remove(serviceName string) (string, error) {
+	dcm.Infof("Removing services...\n")
+	return dcm.serviceCommand(serviceName, "rm", "-f")
+}
+
+// Down stops and removes the containers and networks of the project, and its
+// named volumes when removeVolumes is set. Unlike Stop and Remove it always
+// acts on the whole project.
+func (dcm *Manager) Down(removeVolumes, removeOrphans bool) (string, error) {
+	dcm.Infof("Tearing down services...\n")
+	args := []string{"down"}
+	if removeVolumes {
+		args = append(args, "-v")
+	}
+	if removeOrphans {
+		args = append(args, "--remove-orphans")
+	}
+	return dcm.compose(args...)
+}
+
+// Build builds Docker Compose services. When services build FROM images
+// produced by other services they are built one at a time in dependency
+// order, and building a base also rebuilds the services built on top of it.
+// Progress is shown as it happens, so the returned string is empty.
+func (dcm *Manager) Build(serviceName string) (string, error) {
+	dcm.Infof("Building services...\n")
+	if serviceName != "" {
+		if err := dcm.checkService(serviceName); err != nil {
+			dcm.PrintError(err)
+			return "", err
+		}
+	}
+
+	plan, ordered, err := dcm.buildPlan(serviceName)
+	if err != nil {
+		dcm.PrintError(err)
+		return "", err
+	}
+	if !ordered {
+		return "", dcm.serviceStreaming(serviceName, "build")
+	}
+	if scope := dcm.scopedServices(); serviceName == "" && len(scope) > 0 {
+		plan = filterServices(plan, scope)
+	}
+
+	dcm.Infof("Build order: %s\n", strings.Join(plan, ", "))
+	for _, name := range plan {
+		if err := dcm.composeStreaming("build", name); err != nil {
+			return "", fmt.Errorf("building %s: %w", name, err)
+		}
+	}
+	return "", nil
+}
+
+// filterServices returns the names of names that are also in keep, in the
+// order of names
+func filterServices(names, keep []string) []string {
+	wanted := make(map[string]bool)
+	for _, name := range keep {
+		wanted[name] = true
+	}
+	var filtered []string
+	for _, name := range names {
+		if wanted[name] {
+			filtered = append(filtered, name)
+		}
+	}
+	return filtered
+}
+
+// Pull pulls Docker images, showing progress as it happens. The returned
+// string is empty.
+func (dcm *Manager) Pull(serviceName string) (string, error) {
+	dcm.Infof("Pulling images...\n")
+	return "", dcm.serviceStreaming(serviceName, "pull")
+}
+
+// DisplayMenu displays the interactive menu
+func (dcm *Manager) DisplayMenu() {
+	dcm.printActiveOperations()
+	fmt.Println("\n=== Docker Compose Manager (Go) ===")
+	fmt.Println("1. Start services")
+	fmt.Println("2. Stop services")
+	fmt.Println("3. Restart services")
+	fmt.Println("4. Check status")
+	fmt.Println("5. View logs")
+	fmt.Println("6. Remove services")
+	fmt.Println("7. Build services")
+	fmt.Println("8. Pull images")
+	fmt.Println("9. Tear down (down)")
+	fmt.Println("0. Exit")
+	fmt.Println("====================================")
+	fmt.Println()
+}
+
+// menuAction is an operation selectable from the interactive menu
+type menuAction struct {
+	verb        string
+	needService bool
+	mutating    bool
+	run         func(service string) (string, error)
+}
+
+// menuActions maps menu choices to manager operations
+func (dcm *Manager) menuActions() map[string]menuAction {
+	return map[string]menuAction{
+		"1": {"start", true, true, dcm.startService},
+		"2": {"stop", true, true, dcm.Stop},
+		"3": {"restart", true, true, dcm.RestartService},
+		"4": {"status", false, false, func(string) (string, error) { return dcm.composeStatus() }},
+		"5": {"logs", true, false, dcm.menuLogs},
+		"6": {"remove", true, true, dcm.Remove},
+		"7": {"build", true, true, dcm.Build},
+		"8": {"pull", true, true, dcm.Pull},
+		"9": {"down", false, true, func(string) (string, error) {
+			volumes := dcm.confirm("Also remove volumes? Their data will be lost")
+			return dcm.Down(volumes, dcm.FeatureEnabled("remove_orphans"))
+		}},
+	}
+}
+
+// menuLogs shows logs from the menu. When following, Ctrl-C stops following
+// and returns to the menu instead of exiting.
+func (dcm *Manager) menuLogs(service string) (string, error) {
+	if !dcm.confirm("Follow the logs?") {
+		return dcm.LogsWithOptions(context.Background(), service, LogOptions{Tail: -1}, os.Stdout)
+	}
+	fmt.Println("Press Ctrl-C to stop following and return to the menu")
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	return dcm.LogsWithOptions(ctx, service, LogOptions{Follow: true, Tail: -1}, os.Stdout)
+}
+
+// confirm asks a yes/no question in interactive mode, defaulting to no
+func (dcm *Manager) confirm(question string) bool {
+	fmt.Printf("%s [y/N]: ", question)
+	if !dcm.input.Scan() {
+		fmt.Println()
+		return false
+	}
+	answer := strings.ToLower(strings.TrimSpace(dcm.input.Text()))
+	return answer == "y" || answer == "yes"
+}
+
+// RunInteractive shows the menu and dispatches choices read from in until
+// the user picks 0 or the input ends.
+func (dcm *Manager) RunInteractive(in io.Reader) {
+	dcm.input = bufio.NewScanner(in)
+	actions := dcm.menuActions()
+
+	for {
+		dcm.DisplayMenu()
+		fmt.Print("Select an option: ")
+		if !dcm.input.Scan() {
+			fmt.Println()
+			return
+		}
+
+		choice := strings.TrimSpace(dcm.input.Text())
+		if choice == "0" {
+			return
+		}
+		action, ok := actions[choice]
+		if !ok {
+			fmt.Printf("Invalid choice %q, please pick a number from the menu\n", choice)
+			continue
+		}
+
+		var service string
+		if action.needService {
+			var err error
+			if service, err = dcm.promptService(); err == io.EOF {
+				fmt.Println()
+				return
+			} else if err != nil {
+				dcm.PrintError(err)
+				continue
+			}
+		}
+
+		if !action.mutating {
+			action.run(service)
+			continue
+		}
+		var services []string
+		if service != "" {
+			services = []string{service}
+		}
+		dcm.Track(action.verb, services, func() error {
+			_, err := action.run(service)
+			return err
+		})
+	}
+}
+
+// promptService asks the user to pick a service by number from the services
+// of the compose files, or `a` for all of them. An empty answer also means
+// all. It falls back to asking for a name when no service can be listed.
+func (dcm *Manager) promptService() (string, error) {
+	services, err := dcm.ListServices()
+	if err != nil || len(services) == 0 {
+		if err != nil {
+			fmt.Printf("Could not list services: %v\n", err)
+		} else {
+			fmt.Println("No services found in the compose files")
+		}
+		fmt.Print("Service name (leave empty for all): ")
+		if !dcm.input.Scan() {
+			return "", io.EOF
+		}
+		return strings.TrimSpace(dcm.input.Text()), nil
+	}
+
+	fmt.Println("Services:")
+	for i, s := range services {
+		fmt.Printf("  %d. %-20s %s\n", i+1, s.Name, s.State)
+	}
+	fmt.Println("  a. all services")
+
+	for {
+		fmt.Print("Pick a service: ")
+		if !dcm.input.Scan() {
+			return "", io.EOF
+		}
+		answer := strings.TrimSpace(dcm.input.Text())
+		if answer == "" || strings.EqualFold(answer, "a") {
+			return "", nil
+		}
+		if n, err := strconv.Atoi(answer); err == nil && n >= 1 && n <= len(services) {
+			return services[n-1].Name, nil
+		}
+		fmt.Printf("Invalid choice %q, pick 1-%d or a\n", answer, len(services))
+	}
+}
@@ -0,0 +1,258 @@
+package manager
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStartAppliesConfiguredScale(t *testing.T) {
+	runner := &fakeRunner{}
+	dcm := newTestManager(t, "scale:\n  worker: 3\n", "", runner)
+
+	if err := dcm.Start(context.Background(), StartOptions{}); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	ups := runner.ran(" up ")
+	if len(ups) != 1 {
+		t.Fatalf("compose up ran %d times: %q", len(ups), runner.commands())
+	}
+	if !strings.Contains(ups[0], "up -d") || !strings.Contains(ups[0], "--scale worker=3") {
+		t.Errorf("up command = %q, want -d and --scale worker=3", ups[0])
+	}
+}
+
+func TestStartOnlyScalesTheGivenServices(t *testing.T) {
+	runner := &fakeRunner{}
+	dcm := newTestManager(t, "scale:\n  worker: 3\n", "", runner)
+
+	if err := dcm.Start(context.Background(), StartOptions{Services: []string{"web"}}); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	ups := runner.ran(" up ")
+	if len(ups) != 1 || strings.Contains(ups[0], "--scale") || !strings.HasSuffix(ups[0], " web") {
+		t.Errorf("up commands = %q, want web alone without --scale", ups)
+	}
+}
+
+func TestStartRejectsUnknownService(t *testing.T) {
+	runner := &fakeRunner{}
+	dcm := newTestManager(t, "", "", runner)
+
+	err := dcm.Start(context.Background(), StartOptions{Services: []string{"nope"}})
+	if TypeOf(err) != ErrServiceNotFound {
+		t.Errorf("error = %v, want %s", err, ErrServiceNotFound)
+	}
+	if ups := runner.ran(" up "); len(ups) > 0 {
+		t.Errorf("compose up ran for an unknown service: %q", ups)
+	}
+}
+
+func TestStartDryRunRunsNothing(t *testing.T) {
+	runner := &fakeRunner{}
+	dcm := newTestManager(t, "", "", runner, WithDryRun())
+
+	if err := dcm.Start(context.Background(), StartOptions{}); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if ups := runner.ran(" up "); len(ups) > 0 {
+		t.Errorf("compose up ran under --dry-run: %q", ups)
+	}
+}
+
+func TestStartReportsCommandFailure(t *testing.T) {
+	runner := &fakeRunner{}
+	runner.fail(" up ", 17, "port is already allocated")
+	dcm := newTestManager(t, "", "", runner, WithOutput("json"))
+
+	err := dcm.Start(context.Background(), StartOptions{})
+	if err == nil {
+		t.Fatal("Start succeeded")
+	}
+	if code := ExitCode(err); code != 17 {
+		t.Errorf("exit code = %d, want 17", code)
+	}
+}
+
+func TestStatusParsesPsAndAddsMissingServices(t *testing.T) {
+	runner := &fakeRunner{}
+	runner.stdout("ps -a --format json",
+		`{"Name":"test-web-1","Service":"web","ID":"0123456789abcdef","State":"running","Health":"healthy","Status":"Up 5 minutes"}
+{"Name":"test-worker-1","Service":"worker","State":"exited","ExitCode":2,"Status":"Exited (2) 1 minute ago"}
+`)
+	dcm := newTestManager(t, "", "", runner)
+
+	statuses, err := dcm.Status(context.Background())
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	byService := make(map[string]ServiceStatus)
+	for _, s := range statuses {
+		byService[s.Service] = s
+	}
+	if len(statuses) != 3 {
+		t.Fatalf("got %d statuses, want 3: %+v", len(statuses), statuses)
+	}
+	if web := byService["web"]; web.State != "running" || web.Health != "healthy" || web.Name != "test-web-1" {
+		t.Errorf("web = %+v", web)
+	}
+	if worker := byService["worker"]; worker.State != "exited" || worker.ExitCode != 2 {
+		t.Errorf("worker = %+v", worker)
+	}
+	if db := byService["db"]; db.State != stateNotCreated || db.Name != "" {
+		t.Errorf("db = %+v, want a %q entry", db, stateNotCreated)
+	}
+}
+
+func TestStatusReturnsCommandErrors(t *testing.T) {
+	runner := &fakeRunner{}
+	runner.fail(" ps ", 1, "Cannot connect to the Docker daemon")
+	dcm := newTestManager(t, "", "", runner)
+
+	if _, err := dcm.Status(context.Background()); err == nil {
+		t.Error("Status succeeded without the daemon")
+	}
+}
+
+func TestLogsReadsServiceOutput(t *testing.T) {
+	runner := &fakeRunner{}
+	runner.stdout("version --short", "2.24.0\n")
+	runner.stdout("logs", "listening on :80\n")
+	dcm := newTestManager(t, "", "", runner)
+
+	r, err := dcm.Logs(context.Background(), LogOptions{Services: []string{"web"}, Tail: -1, NoColor: true})
+	if err != nil {
+		t.Fatalf("Logs: %v", err)
+	}
+	defer r.Close()
+	output, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("reading logs: %v", err)
+	}
+	if !strings.Contains(string(output), "listening on :80") {
+		t.Errorf("logs = %q", output)
+	}
+	logs := runner.ran(" logs ")
+	if len(logs) != 1 || !strings.HasSuffix(logs[0], " web") {
+		t.Errorf("logs commands = %q, want one for web", logs)
+	}
+}
+
+func TestLogsCloseStopsFollowing(t *testing.T) {
+	runner := &fakeRunner{}
+	runner.stdout("version --short", "2.24.0\n")
+	stopped := make(chan struct{})
+	runner.handle("logs", func(ctx context.Context, cmd *Cmd) error {
+		io.WriteString(cmd.Stdout, "first line\n")
+		<-ctx.Done()
+		close(stopped)
+		return ctx.Err()
+	})
+	dcm := newTestManager(t, "", "", runner)
+
+	r, err := dcm.Logs(context.Background(), LogOptions{Services: []string{"web"}, Follow: true, Tail: -1, NoColor: true})
+	if err != nil {
+		t.Fatalf("Logs: %v", err)
+	}
+	buf := make([]byte, 64)
+	if n, err := r.Read(buf); err != nil || !strings.Contains(string(buf[:n]), "first line") {
+		t.Fatalf("read %q, %v", buf[:n], err)
+	}
+	r.Close()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("following the logs went on after Close")
+	}
+}
+
+func TestLogsRejectsUnknownService(t *testing.T) {
+	dcm := newTestManager(t, "", "", &fakeRunner{})
+
+	if _, err := dcm.Logs(context.Background(), LogOptions{Services: []string{"nope"}}); TypeOf(err) != ErrServiceNotFound {
+		t.Errorf("error = %v, want %s", err, ErrServiceNotFound)
+	}
+}
+
+// chdir changes the working directory for the rest of the test
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(cwd) })
+}
+
+func TestFindConfigFromNestedDir(t *testing.T) {
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	nested := filepath.Join(root, "a", "b", "c")
+	if err := os.MkdirAll(nested, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := findConfig(nested); err == nil {
+		t.Skip("a dcm.config.yml above the temp dir is in the way")
+	}
+
+	rootConfig := filepath.Join(root, "dcm.config.yml")
+	if err := os.WriteFile(rootConfig, []byte("services: []\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := findConfig(nested); err != nil || got != rootConfig {
+		t.Errorf("findConfig(%s) = %q, %v, want %q", nested, got, err, rootConfig)
+	}
+
+	// The nearest config wins
+	nearer := filepath.Join(root, "a", "dcm.config.yml")
+	if err := os.WriteFile(nearer, []byte("services: []\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := findConfig(nested); err != nil || got != nearer {
+		t.Errorf("findConfig(%s) = %q, %v, want %q", nested, got, err, nearer)
+	}
+	if got, err := findConfig(root); err != nil || got != rootConfig {
+		t.Errorf("findConfig(%s) = %q, %v, want %q", root, got, err, rootConfig)
+	}
+}
+
+func TestLocateConfigFromSubdirectory(t *testing.T) {
+	runner := &fakeRunner{}
+	dcm := newTestManager(t, "", "", runner)
+	root, err := filepath.EvalSymlinks(filepath.Dir(dcm.ConfigPath()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	nested := filepath.Join(root, "src", "cmd")
+	if err := os.MkdirAll(nested, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	chdir(t, nested)
+	path := LocateConfig()
+	if path != filepath.Join(root, "dcm.config.yml") {
+		t.Fatalf("LocateConfig() = %q from %s", path, nested)
+	}
+	// Relative paths of the config are resolved from its directory
+	found, err := New(path, WithRunner(runner), WithComposeCommand("docker compose"), WithQuiet())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if files := found.Config().composeFiles(); len(files) != 1 || files[0] != filepath.Join(root, "compose.yaml") {
+		t.Errorf("compose files = %q, want %s", files, filepath.Join(root, "compose.yaml"))
+	}
+
+	chdir(t, root)
+	if path := LocateConfig(); path != "dcm.config.yml" {
+		t.Errorf("LocateConfig() = %q in the config dir, want dcm.config.yml", path)
+	}
+}
@@ -0,0 +1,294 @@
+package manager
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// DefaultMetricsAddr keeps the metrics endpoint on the local machine unless
+// --listen says otherwise
+const DefaultMetricsAddr = "127.0.0.1:9120"
+
+// operationBuckets are the upper bounds, in seconds, of the buckets of the
+// operation duration histogram
+var operationBuckets = []float64{0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600}
+
+// histogram is a cumulative Prometheus histogram
+type histogram struct {
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+func (h *histogram) observe(v float64) {
+	if h.buckets == nil {
+		h.buckets = make([]uint64, len(operationBuckets))
+	}
+	for i, le := range operationBuckets {
+		if v <= le {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// operationKey labels an operation duration
+type operationKey struct {
+	verb   string
+	result string
+}
+
+// metricsCollector serves the metrics of a project in the Prometheus text
+// format. Service states are read at every scrape; operation durations come
+// from the activity log, so the operations of every dcm process on the
+// project count, from the moment the collector starts.
+type metricsCollector struct {
+	dcm *Manager
+	// mu serializes scrapes, and with the operations of the API server when
+	// shared with it
+	mu         *sync.Mutex
+	offset     int64
+	operations map[operationKey]*histogram
+}
+
+// newMetricsCollector returns a collector counting the operations logged
+// from now on, locking mu around its use of the manager
+func (dcm *Manager) newMetricsCollector(mu *sync.Mutex) *metricsCollector {
+	c := &metricsCollector{dcm: dcm, mu: mu, operations: make(map[operationKey]*histogram)}
+	if info, err := os.Stat(dcm.activityLogPath()); err == nil {
+		c.offset = info.Size()
+	}
+	return c
+}
+
+// readActivity adds the operations logged since the last scrape to the
+// duration histogram
+func (c *metricsCollector) readActivity() {
+	f, err := os.Open(c.dcm.activityLogPath())
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err == nil && info.Size() < c.offset {
+		// The log was truncated or replaced
+		c.offset = 0
+	}
+	if _, err := f.Seek(c.offset, io.SeekStart); err != nil {
+		return
+	}
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			// A line without its newline is still being written
+			return
+		}
+		c.offset += int64(len(line))
+		var a Activity
+		if json.Unmarshal(line, &a) != nil {
+			continue
+		}
+		key := operationKey{a.Verb, "success"}
+		if a.Error != "" {
+			key.result = "failure"
+		}
+		if c.operations[key] == nil {
+			c.operations[key] = &histogram{}
+		}
+		c.operations[key].observe(a.FinishedAt.Sub(a.StartedAt).Seconds())
+	}
+}
+
+// restartCounts returns how many times docker restarted each container
+func (dcm *Manager) restartCounts(containers []string) map[string]int {
+	counts := make(map[string]int)
+	if len(containers) == 0 {
+		return counts
+	}
+	output, err := dcm.dockerOutput(append([]string{"inspect", "--format", "{{.Name}} {{.RestartCount}}"}, containers...)...)
+	if err != nil {
+		return counts
+	}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if n, err := strconv.Atoi(fields[1]); err == nil {
+			counts[strings.TrimPrefix(fields[0], "/")] = n
+		}
+	}
+	return counts
+}
+
+// promLabels renders label pairs, escaping their values
+func promLabels(pairs ...string) string {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(pairs[i+1])
+		fmt.Fprintf(&b, `%s="%s"`, pairs[i], value)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// metricHeader writes the HELP and TYPE lines of a metric
+func metricHeader(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func boolValue(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// ServeHTTP writes the metrics of the project
+func (c *metricsCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readActivity()
+	project := c.dcm.ProjectName()
+	statuses, err := c.dcm.Status(r.Context())
+	if err != nil {
+		c.dcm.Warnf("metrics: could not read the service states: %v\n", err)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	metricHeader(w, "dcm_up", "gauge", "Whether the state of the services could be read.")
+	fmt.Fprintf(w, "dcm_up%s %d\n", promLabels("project", project), boolValue(err == nil))
+
+	var services []string
+	running := make(map[string]int)
+	states := make(map[string]map[string]int)
+	var containers []string
+	for _, s := range statuses {
+		if states[s.Service] == nil {
+			services = append(services, s.Service)
+			states[s.Service] = make(map[string]int)
+		}
+		if s.Name == "" {
+			continue
+		}
+		containers = append(containers, s.Name)
+		states[s.Service][s.State]++
+		if s.State == "running" {
+			running[s.Service]++
+		}
+	}
+	sort.Strings(services)
+
+	metricHeader(w, "dcm_service_up", "gauge", "Whether a service has a running container.")
+	for _, service := range services {
+		fmt.Fprintf(w, "dcm_service_up%s %d\n", promLabels("project", project, "service", service), boolValue(running[service] > 0))
+	}
+	metricHeader(w, "dcm_service_containers", "gauge", "Containers of a service by state.")
+	for _, service := range services {
+		names := make([]string, 0, len(states[service]))
+		for state := range states[service] {
+			names = append(names, state)
+		}
+		sort.Strings(names)
+		for _, state := range names {
+			fmt.Fprintf(w, "dcm_service_containers%s %d\n",
+				promLabels("project", project, "service", service, "state", state), states[service][state])
+		}
+	}
+	metricHeader(w, "dcm_container_healthy", "gauge", "Whether a container with a healthcheck is healthy.")
+	for _, s := range statuses {
+		if s.Name != "" && s.Health != "" {
+			fmt.Fprintf(w, "dcm_container_healthy%s %d\n",
+				promLabels("project", project, "service", s.Service, "container", s.Name), boolValue(s.Health == "healthy"))
+		}
+	}
+	metricHeader(w, "dcm_container_restarts", "gauge", "Times docker restarted a container since it was created.")
+	restarts := c.dcm.restartCounts(containers)
+	for _, s := range statuses {
+		if n, ok := restarts[s.Name]; ok && s.Name != "" {
+			fmt.Fprintf(w, "dcm_container_restarts%s %d\n",
+				promLabels("project", project, "service", s.Service, "container", s.Name), n)
+		}
+	}
+
+	metricHeader(w, "dcm_operation_duration_seconds", "histogram", "Duration of the dcm operations on the project.")
+	keys := make([]operationKey, 0, len(c.operations))
+	for key := range c.operations {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].verb != keys[j].verb {
+			return keys[i].verb < keys[j].verb
+		}
+		return keys[i].result < keys[j].result
+	})
+	for _, key := range keys {
+		h := c.operations[key]
+		labels := []string{"project", project, "verb", key.verb, "result", key.result}
+		for i, le := range operationBuckets {
+			fmt.Fprintf(w, "dcm_operation_duration_seconds_bucket%s %d\n",
+				promLabels(append(labels, "le", strconv.FormatFloat(le, 'g', -1, 64))...), h.buckets[i])
+		}
+		fmt.Fprintf(w, "dcm_operation_duration_seconds_bucket%s %d\n", promLabels(append(labels, "le", "+Inf")...), h.count)
+		fmt.Fprintf(w, "dcm_operation_duration_seconds_sum%s %g\n", promLabels(labels...), h.sum)
+		fmt.Fprintf(w, "dcm_operation_duration_seconds_count%s %d\n", promLabels(labels...), h.count)
+	}
+}
+
+// metricsServer returns an HTTP server exposing the collector on /metrics
+func metricsServer(addr string, c *metricsCollector) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", c)
+	return &http.Server{Addr: addr, Handler: mux}
+}
+
+// ServeMetrics runs a Prometheus metrics endpoint on addr until interrupted
+func (dcm *Manager) ServeMetrics(addr string) error {
+	if addr == "" {
+		addr = DefaultMetricsAddr
+	}
+	server := metricsServer(addr, dcm.newMetricsCollector(&sync.Mutex{}))
+	dcm.Infof("Serving the metrics of %s on http://%s/metrics, press Ctrl-C to stop\n", dcm.ProjectName(), addr)
+	return dcm.runServers(server)
+}
+
+// runServers serves HTTP until one of the servers fails or the process is
+// interrupted, then shuts them all down
+func (dcm *Manager) runServers(servers ...*http.Server) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	done := make(chan error, len(servers))
+	for _, server := range servers {
+		go func(server *http.Server) { done <- server.ListenAndServe() }(server)
+	}
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		dcm.Infof("Shutting down...\n")
+	}
+	shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, server := range servers {
+		server.Shutdown(shutdown)
+	}
+	return err
+}
@@ -0,0 +1,33 @@
+package manager
+
+// Operation describes an operation passed down a middleware chain
+type Operation struct {
+	// Verb is the command, such as "start" or "down"
+	Verb string
+	// Services are the services named for the operation, empty when it acts
+	// on every service in scope
+	Services []string
+}
+
+// OperationFunc runs an operation
+type OperationFunc func(op Operation) error
+
+// Middleware wraps the run of every operation, to add logging, metrics,
+// authorization or retries without changing the manager. It calls next to
+// run the operation, or returns an error without calling it to refuse it.
+type Middleware func(next OperationFunc) OperationFunc
+
+// Use adds a middleware to the chain operations run through. The first one
+// added is the outermost.
+func (dcm *Manager) Use(mw Middleware) {
+	dcm.middleware = append(dcm.middleware, mw)
+}
+
+// runOperation runs fn through the middleware chain
+func (dcm *Manager) runOperation(op Operation, fn func() error) error {
+	run := func(Operation) error { return fn() }
+	for i := len(dcm.middleware) - 1; i >= 0; i-- {
+		run = dcm.middleware[i](run)
+	}
+	return run(op)
+}
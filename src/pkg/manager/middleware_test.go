@@ -0,0 +1,104 @@
+package manager
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// countingMiddleware counts the operations run through it by verb
+func countingMiddleware(counts map[string]int) Middleware {
+	return func(next OperationFunc) OperationFunc {
+		return func(op Operation) error {
+			counts[op.Verb]++
+			return next(op)
+		}
+	}
+}
+
+func TestCountingMiddleware(t *testing.T) {
+	counts := make(map[string]int)
+	runner := &fakeRunner{}
+	dcm := newTestManager(t, "", "", runner, WithMiddleware(countingMiddleware(counts)))
+
+	for _, service := range []string{"web", "db"} {
+		err := dcm.Track("stop", []string{service}, func() error {
+			_, err := dcm.Stop(service)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := dcm.Track("restart", nil, func() error {
+		_, err := dcm.Restart("")
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := map[string]int{"stop": 2, "restart": 1}; !reflect.DeepEqual(counts, want) {
+		t.Errorf("counts = %v, want %v", counts, want)
+	}
+	if n := len(runner.ran("compose ")); n != 3 {
+		t.Errorf("%d compose commands ran, want 3", n)
+	}
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	var calls []string
+	trace := func(name string) Middleware {
+		return func(next OperationFunc) OperationFunc {
+			return func(op Operation) error {
+				calls = append(calls, name+" before")
+				err := next(op)
+				calls = append(calls, name+" after")
+				return err
+			}
+		}
+	}
+	dcm := newTestManager(t, "", "", &fakeRunner{}, WithMiddleware(trace("option")))
+	dcm.Use(trace("use"))
+
+	if err := dcm.Track("pull", nil, func() error {
+		calls = append(calls, "operation")
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"option before", "use before", "operation", "use after", "option after"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %q, want %q", calls, want)
+	}
+}
+
+func TestMiddlewareRefusesOperation(t *testing.T) {
+	denied := errors.New("stop is not allowed on db")
+	refuse := func(next OperationFunc) OperationFunc {
+		return func(op Operation) error {
+			if op.Verb == "stop" && len(op.Services) == 1 && op.Services[0] == "db" {
+				return denied
+			}
+			return next(op)
+		}
+	}
+	runner := &fakeRunner{}
+	dcm := newTestManager(t, "", "", runner, WithMiddleware(refuse))
+
+	ran := false
+	err := dcm.Track("stop", []string{"db"}, func() error {
+		ran = true
+		return nil
+	})
+	if err != denied || ran {
+		t.Errorf("Track = %v with the operation run %v, want it refused", err, ran)
+	}
+
+	// The failure is in the activity log like any other
+	c := dcm.newMetricsCollector(nil)
+	c.offset = 0
+	c.readActivity()
+	if h := c.operations[operationKey{"stop", "failure"}]; h == nil || h.count != 1 {
+		t.Errorf("activity of the refused stop = %+v", c.operations)
+	}
+}
@@ -0,0 +1,177 @@
+package manager
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ANSI sequences used by the monitor view
+const (
+	ansiAltScreen  = "\033[?1049h"
+	ansiMainScreen = "\033[?1049l"
+	ansiHideCursor = "\033[?25l"
+	ansiShowCursor = "\033[?25h"
+	ANSIHome       = "\033[H"
+	ansiClearLine  = "\033[K"
+	ANSIClearBelow = "\033[J"
+)
+
+// IsTerminal reports whether f is connected to a terminal
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// terminalSize returns the terminal size from the LINES and COLUMNS
+// variables most shells export, or 24x80
+func terminalSize() (rows, cols int) {
+	rows, cols = 24, 80
+	if n, err := strconv.Atoi(os.Getenv("LINES")); err == nil && n > 0 {
+		rows = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 0 {
+		cols = n
+	}
+	return rows, cols
+}
+
+// logTail keeps the last lines written to it
+type logTail struct {
+	mu    sync.Mutex
+	lines []string
+	max   int
+}
+
+func (t *logTail) add(line string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lines = append(t.lines, line)
+	if len(t.lines) > t.max {
+		t.lines = t.lines[len(t.lines)-t.max:]
+	}
+}
+
+// last returns up to n of the most recent lines
+func (t *logTail) last(n int) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if n > len(t.lines) {
+		n = len(t.lines)
+	}
+	return append([]string(nil), t.lines[len(t.lines)-n:]...)
+}
+
+// followLogs streams the logs of services into tail until ctx is done
+func (dcm *Manager) followLogs(ctx context.Context, services []string, tail *logTail) error {
+	argv, err := dcm.composeArgs(append([]string{"logs", "-f", "--no-color", "--tail", "20"}, services...)...)
+	if err != nil {
+		return err
+	}
+	cmd := dcm.command(ctx, argv)
+	pr, pw := io.Pipe()
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	started := time.Now()
+	go func() {
+		err := cmd.Wait()
+		dcm.logCommand(argv, started, err)
+		pw.CloseWithError(err)
+	}()
+
+	scanner := bufio.NewScanner(pr)
+	for scanner.Scan() {
+		tail.add(scanner.Text())
+	}
+	return nil
+}
+
+// Monitor shows a live view of the services in the terminal: their status
+// on top and their latest log lines below, refreshed every interval until
+// Ctrl-C.
+func (dcm *Manager) Monitor(services []string, interval time.Duration) error {
+	if !IsTerminal(os.Stdout) {
+		return NewError(ErrUsage, "monitor --tui needs a terminal")
+	}
+	if len(services) == 0 {
+		services = dcm.scopedServices()
+	}
+	for _, service := range services {
+		if err := dcm.checkService(service); err != nil {
+			return err
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	tail := &logTail{max: 500}
+	go dcm.followLogs(ctx, services, tail)
+
+	fmt.Print(ansiAltScreen + ansiHideCursor)
+	defer fmt.Print(ansiShowCursor + ansiMainScreen)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		dcm.renderMonitor(services, tail)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// renderMonitor draws one frame of the monitor view
+func (dcm *Manager) renderMonitor(services []string, tail *logTail) {
+	rows, cols := terminalSize()
+	var frame []string
+	fit := func(line string) string {
+		if len(line) > cols {
+			line = line[:cols]
+		}
+		return line + ansiClearLine
+	}
+
+	frame = append(frame, fit(fmt.Sprintf("dcm monitor - %s - %s (Ctrl-C to quit)",
+		dcm.ProjectName(), time.Now().Format("15:04:05"))))
+	statuses, err := dcm.StatusDetailed(context.Background())
+	if err != nil {
+		frame = append(frame, fit("status unavailable: "+err.Error()))
+	}
+	wanted := make(map[string]bool)
+	for _, s := range services {
+		wanted[s] = true
+	}
+	frame = append(frame, fit(fmt.Sprintf("%-30s %-12s %-10s %s", "CONTAINER", "STATE", "HEALTH", "PORTS")))
+	for _, s := range statuses {
+		if len(wanted) > 0 && !wanted[s.Service] {
+			continue
+		}
+		frame = append(frame, fit(fmt.Sprintf("%-30s %-12s %-10s %s", s.Name, s.State, s.Health, strings.Join(s.Ports, ", "))))
+	}
+	frame = append(frame, fit(strings.Repeat("-", cols)))
+
+	// The logs get whatever room the status table leaves
+	if room := rows - len(frame) - 1; room > 0 {
+		for _, line := range tail.last(room) {
+			frame = append(frame, fit(line))
+		}
+	}
+	if len(frame) > rows-1 {
+		frame = frame[:rows-1]
+	}
+	fmt.Print(ANSIHome + strings.Join(frame, "\n") + "\n" + ANSIClearBelow)
+}
@@ -0,0 +1,117 @@
+package manager
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// externalNetwork is the external key of a compose network: true, or in the
+// legacy syntax a mapping naming the network
+type externalNetwork struct {
+	External bool
+	Name     string
+}
+
+// UnmarshalYAML accepts both a boolean and the legacy {name: ...} mapping
+func (e *externalNetwork) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&e.External); err == nil {
+		return nil
+	}
+	var legacy struct {
+		Name string `yaml:"name"`
+	}
+	if err := unmarshal(&legacy); err != nil {
+		return err
+	}
+	e.External, e.Name = true, legacy.Name
+	return nil
+}
+
+// composeNetwork is a network declared at the top level of a compose file
+type composeNetwork struct {
+	Name     string          `yaml:"name"`
+	External externalNetwork `yaml:"external"`
+}
+
+// parseExternalNetworks returns the docker names of the external networks
+// declared in a compose config
+func parseExternalNetworks(config []byte) ([]string, error) {
+	var file struct {
+		Networks map[string]composeNetwork `yaml:"networks"`
+	}
+	if err := yaml.Unmarshal(config, &file); err != nil {
+		return nil, err
+	}
+	var names []string
+	for key, network := range file.Networks {
+		if !network.External.External {
+			continue
+		}
+		switch {
+		case network.External.Name != "":
+			names = append(names, network.External.Name)
+		case network.Name != "":
+			names = append(names, network.Name)
+		default:
+			names = append(names, key)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// CheckNetworks checks that the external networks the project uses exist,
+// since up fails confusingly on a missing one. With create, the missing
+// networks are created and their names returned; without, they are an
+// error.
+func (dcm *Manager) CheckNetworks(create bool) ([]string, error) {
+	// The rendered config has the networks of every compose file merged,
+	// with variables substituted
+	config, err := dcm.composeOutput("config")
+	if err != nil {
+		// Leave reporting a broken config to the command itself
+		dcm.verbosef("Skipping the network check, compose config failed: %v\n", err)
+		return nil, nil
+	}
+	external, err := parseExternalNetworks([]byte(config))
+	if err != nil || len(external) == 0 {
+		return nil, nil
+	}
+
+	existing, err := dcm.dockerLines("network", "ls", "--format", "{{.Name}}")
+	if err != nil {
+		return nil, fmt.Errorf("listing docker networks: %w", err)
+	}
+	present := make(map[string]bool)
+	for _, name := range existing {
+		present[name] = true
+	}
+	var missing []string
+	for _, name := range external {
+		if !present[name] {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) == 0 {
+		return nil, nil
+	}
+	if !create {
+		return nil, NewError(ErrConfig, "external networks not found: %s; create them with docker network create or pass --create-networks",
+			strings.Join(missing, ", "))
+	}
+
+	var created []string
+	for _, name := range missing {
+		if _, err := dcm.dockerCommand("network", "create", name); err != nil {
+			return created, fmt.Errorf("creating network %s: %w", name, err)
+		}
+		created = append(created, name)
+		if dcm.output != "json" {
+			fmt.Printf("Created network %s\n", name)
+		}
+	}
+	return created, nil
+}
@@ -0,0 +1,60 @@
+package manager
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseExternalNetworks(t *testing.T) {
+	config, err := os.ReadFile("testdata/external-networks.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names, err := parseExternalNetworks(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"old_network", "shared", "traefik_proxy"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("external networks = %q, want %q", names, want)
+	}
+}
+
+func TestCheckNetworks(t *testing.T) {
+	config, err := os.ReadFile("testdata/external-networks.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newRunner := func() *fakeRunner {
+		runner := &fakeRunner{}
+		runner.stdout("compose.yaml config", string(config))
+		runner.stdout("network ls", "bridge\nhost\nshared\n")
+		return runner
+	}
+
+	runner := newRunner()
+	dcm := newTestManager(t, "", string(config), runner)
+	_, err = dcm.CheckNetworks(false)
+	if TypeOf(err) != ErrConfig || !strings.Contains(err.Error(), "old_network, traefik_proxy") {
+		t.Errorf("CheckNetworks = %v, want the missing networks reported", err)
+	}
+	if creates := runner.ran("network create"); len(creates) != 0 {
+		t.Errorf("ran %q without --create-networks", creates)
+	}
+
+	runner = newRunner()
+	dcm = newTestManager(t, "", string(config), runner)
+	created, err := dcm.CheckNetworks(true)
+	if err != nil {
+		t.Fatalf("CheckNetworks: %v", err)
+	}
+	if want := []string{"old_network", "traefik_proxy"}; !reflect.DeepEqual(created, want) {
+		t.Errorf("created %q, want %q", created, want)
+	}
+	want := []string{"docker network create old_network", "docker network create traefik_proxy"}
+	if creates := runner.ran("network create"); !reflect.DeepEqual(creates, want) {
+		t.Errorf("ran %q, want %q", creates, want)
+	}
+}
@@ -0,0 +1,24 @@
+//go:build !windows
+// +build !windows
+
+package manager
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// pidAlive reports whether a process with the given PID exists
+func pidAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+// detach starts a command in its own session, so it outlives the manager
+// and is not interrupted by Ctrl-C in the terminal that started it
+func detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+}
@@ -0,0 +1,27 @@
+package manager
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// pidAlive reports whether a process with the given PID exists. On Windows
+// FindProcess only succeeds for running processes.
+func pidAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
+
+// detach starts a command in its own process group, so it outlives the
+// manager and is not interrupted by Ctrl-C in the console that started it
+func detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
@@ -0,0 +1,169 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// pinLockHeader starts every lock file written by PinImages
+const pinLockHeader = "# Image digests pinned by dcm start --pin. Refresh with --pull always.\n"
+
+// pinLock is an image lock file. It is a compose file overriding the image of
+// each pinned service with its digest, so it is applied with a plain -f.
+type pinLock struct {
+	Services map[string]pinnedService `yaml:"services"`
+}
+
+// pinnedService is the lock entry of one service
+type pinnedService struct {
+	Image string `yaml:"image"`
+}
+
+// readPinLock loads a lock file and checks every image is pinned to a digest
+func readPinLock(path string) (*pinLock, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var lock pinLock
+	if err := yaml.Unmarshal(data, &lock); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	for name, service := range lock.Services {
+		if !strings.Contains(service.Image, "@") {
+			return nil, fmt.Errorf("%s: image of %s is not pinned to a digest", path, name)
+		}
+	}
+	return &lock, nil
+}
+
+// writePinLock writes a lock file, listing the services in name order
+func writePinLock(path string, lock *pinLock) error {
+	data, err := yaml.Marshal(lock)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append([]byte(pinLockHeader), data...), 0664)
+}
+
+// imageRepository returns the repository of an image reference without its
+// tag, digest and default registry, e.g. "nginx" for docker.io/library/nginx:1
+func imageRepository(ref string) string {
+	if i := strings.Index(ref, "@"); i >= 0 {
+		ref = ref[:i]
+	}
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		ref = ref[:i]
+	}
+	ref = strings.TrimPrefix(ref, "docker.io/")
+	return strings.TrimPrefix(ref, "library/")
+}
+
+// imageDigest returns the repo@digest reference of a local image
+func (dcm *Manager) imageDigest(image string) (string, error) {
+	output, err := dcm.dockerOutput("image", "inspect", "--format", "{{json .RepoDigests}}", image)
+	if err != nil {
+		return "", err
+	}
+	var digests []string
+	if err := json.Unmarshal([]byte(output), &digests); err != nil {
+		return "", fmt.Errorf("parsing digests of %s: %v", image, err)
+	}
+	if len(digests) == 0 {
+		return "", fmt.Errorf("image %s has no registry digest, it was probably built locally", image)
+	}
+	for _, digest := range digests {
+		if imageRepository(digest) == imageRepository(image) {
+			return digest, nil
+		}
+	}
+	return digests[0], nil
+}
+
+// PinImages makes the following compose commands use the image digests of
+// the lock file pin. With pull set to "always" the images are pulled first
+// and the lock is refreshed; otherwise an existing lock is reused as is, and
+// a missing one is created by pulling. Services without an image, such as
+// those that are only built, are not pinned.
+func (dcm *Manager) PinImages(pull, pin string, services []string) error {
+	if pull != "" && pull != "always" && pull != "missing" {
+		return fmt.Errorf("unknown pull policy %q, expected always or missing", pull)
+	}
+	if pin == "" {
+		if pull == "always" {
+			return dcm.pullServices(services)
+		}
+		return nil
+	}
+	if len(dcm.config.composeFiles()) == 0 {
+		return fmt.Errorf("--pin needs compose_file set in %s", dcm.configPath)
+	}
+
+	lock, err := readPinLock(pin)
+	switch {
+	case err == nil && pull != "always":
+		dcm.Infof("Using images pinned in %s\n", pin)
+		dcm.overlays = append(dcm.overlays, pin)
+		return nil
+	case err != nil && !os.IsNotExist(err):
+		return err
+	case lock == nil:
+		lock = &pinLock{}
+	}
+	if lock.Services == nil {
+		lock.Services = make(map[string]pinnedService)
+	}
+
+	if err := dcm.pullServices(services); err != nil {
+		return err
+	}
+	project, err := dcm.composeProject()
+	if err != nil {
+		return err
+	}
+	if len(services) == 0 {
+		services = dcm.scopedServices()
+	}
+	if len(services) == 0 {
+		services = project.Names
+	}
+	for _, name := range services {
+		service, ok := project.Services[name]
+		if !ok || service.Image == "" {
+			dcm.verbosef("Not pinning %s, it has no image\n", name)
+			continue
+		}
+		digest, err := dcm.imageDigest(service.Image)
+		if err != nil {
+			return fmt.Errorf("pinning %s: %v", name, err)
+		}
+		lock.Services[name] = pinnedService{Image: digest}
+		dcm.Infof("Pinned %s to %s\n", name, digest)
+	}
+	if err := writePinLock(pin, lock); err != nil {
+		return err
+	}
+	dcm.overlays = append(dcm.overlays, pin)
+	return nil
+}
+
+// pullServices pulls the images of the given services, or of the configured
+// services when none are given
+func (dcm *Manager) pullServices(services []string) error {
+	if len(services) == 0 {
+		_, err := dcm.Pull("")
+		return err
+	}
+	for _, service := range services {
+		if err := dcm.checkService(service); err != nil {
+			return err
+		}
+	}
+	dcm.Infof("Pulling images...\n")
+	return dcm.composeStreaming(append([]string{"pull"}, services...)...)
+}
@@ -0,0 +1,139 @@
+package manager
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeDigests answers docker image inspect with a digest for each image
+func fakeDigests(runner *fakeRunner, digests map[string]string) {
+	for image, digest := range digests {
+		runner.stdout("{{json .RepoDigests}} "+image, `["`+digest+`"]`)
+	}
+}
+
+var testDigests = map[string]string{
+	"nginx":    "nginx@sha256:1111",
+	"busybox":  "busybox@sha256:2222",
+	"postgres": "postgres@sha256:3333",
+}
+
+func TestPinImagesWritesLock(t *testing.T) {
+	runner := &fakeRunner{}
+	fakeDigests(runner, testDigests)
+	dcm := newTestManager(t, "", "", runner)
+	lockPath := filepath.Join(t.TempDir(), "images.lock.yml")
+
+	if err := dcm.PinImages("always", lockPath, nil); err != nil {
+		t.Fatalf("PinImages: %v", err)
+	}
+	if len(runner.ran(" pull")) == 0 {
+		t.Errorf("images were not pulled: %q", runner.commands())
+	}
+	data, err := os.ReadFile(lockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), pinLockHeader) {
+		t.Errorf("lock file does not start with its header:\n%s", data)
+	}
+	lock, err := readPinLock(lockPath)
+	if err != nil {
+		t.Fatalf("reading the lock: %v", err)
+	}
+	for service, image := range map[string]string{"web": "nginx", "worker": "busybox", "db": "postgres"} {
+		if got := lock.Services[service].Image; got != testDigests[image] {
+			t.Errorf("%s pinned to %q, want %q", service, got, testDigests[image])
+		}
+	}
+
+	if err := dcm.Start(context.Background(), StartOptions{}); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if ups := runner.ran(" up "); len(ups) != 1 || !strings.Contains(ups[0], "-f "+lockPath) {
+		t.Errorf("up commands = %q, want the lock applied with -f", ups)
+	}
+}
+
+func TestPinImagesReusesLock(t *testing.T) {
+	lockPath := filepath.Join(t.TempDir(), "images.lock.yml")
+	lock := &pinLock{Services: map[string]pinnedService{"web": {Image: "nginx@sha256:0000"}}}
+	if err := writePinLock(lockPath, lock); err != nil {
+		t.Fatal(err)
+	}
+	runner := &fakeRunner{}
+	fakeDigests(runner, testDigests)
+	dcm := newTestManager(t, "", "", runner)
+
+	if err := dcm.PinImages("", lockPath, nil); err != nil {
+		t.Fatalf("PinImages: %v", err)
+	}
+	if commands := runner.commands(); len(commands) > 0 {
+		t.Errorf("reusing the lock ran %q", commands)
+	}
+	reread, err := readPinLock(lockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reread.Services["web"].Image; got != "nginx@sha256:0000" {
+		t.Errorf("the lock was rewritten, web is pinned to %q", got)
+	}
+	if err := dcm.Start(context.Background(), StartOptions{}); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if ups := runner.ran(" up "); len(ups) != 1 || !strings.Contains(ups[0], "-f "+lockPath) {
+		t.Errorf("up commands = %q, want the lock applied with -f", ups)
+	}
+}
+
+func TestPinImagesRefreshesLockOnPullAlways(t *testing.T) {
+	lockPath := filepath.Join(t.TempDir(), "images.lock.yml")
+	lock := &pinLock{Services: map[string]pinnedService{"web": {Image: "nginx@sha256:0000"}}}
+	if err := writePinLock(lockPath, lock); err != nil {
+		t.Fatal(err)
+	}
+	runner := &fakeRunner{}
+	fakeDigests(runner, testDigests)
+	dcm := newTestManager(t, "", "", runner)
+
+	if err := dcm.PinImages("always", lockPath, []string{"web"}); err != nil {
+		t.Fatalf("PinImages: %v", err)
+	}
+	reread, err := readPinLock(lockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reread.Services["web"].Image; got != testDigests["nginx"] {
+		t.Errorf("web is pinned to %q after --pull always, want %q", got, testDigests["nginx"])
+	}
+}
+
+func TestReadPinLockRejectsTags(t *testing.T) {
+	lockPath := filepath.Join(t.TempDir(), "images.lock.yml")
+	if err := os.WriteFile(lockPath, []byte("services:\n  web:\n    image: nginx:latest\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readPinLock(lockPath); err == nil || !strings.Contains(err.Error(), "not pinned") {
+		t.Errorf("readPinLock = %v, want an error for the unpinned image", err)
+	}
+}
+
+func TestImageRepository(t *testing.T) {
+	tests := map[string]string{
+		"nginx":                       "nginx",
+		"nginx:1.25":                  "nginx",
+		"docker.io/library/nginx:1":   "nginx",
+		"nginx@sha256:1111":           "nginx",
+		"registry:5000/team/app:v2":   "registry:5000/team/app",
+		"ghcr.io/org/app@sha256:abcd": "ghcr.io/org/app",
+		"localhost:5000/app":          "localhost:5000/app",
+	}
+	for ref, want := range tests {
+		if got := imageRepository(ref); got != want {
+			t.Errorf("imageRepository(%q) = %q, want %q", ref, got, want)
+		}
+	}
+}
@@ -0,0 +1,396 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"gopkg.in/yaml.v2"
+)
+
+// maxPortSearch bounds how far above a port in use --auto-ports looks for a
+// free one
+const maxPortSearch = 1000
+
+// PortMapping is a host port a service publishes
+type PortMapping struct {
+	Service   string `json:"service"`
+	HostIP    string `json:"host_ip,omitempty"`
+	Published int    `json:"published"`
+	Target    string `json:"target"`
+	Protocol  string `json:"protocol"`
+	// Requested is the port of the compose files when it was remapped
+	Requested int `json:"requested,omitempty"`
+}
+
+func (m PortMapping) String() string {
+	host := strconv.Itoa(m.Published)
+	if m.HostIP != "" {
+		host = net.JoinHostPort(m.HostIP, host)
+	}
+	return fmt.Sprintf("%s->%s/%s", host, m.Target, m.Protocol)
+}
+
+// boundPort is a host port published by a running container
+type boundPort struct {
+	HostIP    string
+	Port      int
+	Protocol  string
+	Container string
+	Project   string
+}
+
+// portsOverridePath is the override file remapping the ports of the project
+// that were in use, passed to every compose command while it exists
+func (dcm *Manager) portsOverridePath() string {
+	return filepath.Join(dcm.stateDir(), "ports-"+dcm.ProjectName()+".yml")
+}
+
+// parseDockerPorts parses the published ports of docker ps, such as
+// "0.0.0.0:8080->80/tcp, [::]:9000-9001->9000-9001/udp, 5432/tcp"
+func parseDockerPorts(s string) []boundPort {
+	var ports []boundPort
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		arrow := strings.Index(entry, "->")
+		if arrow < 0 {
+			continue
+		}
+		host, target := entry[:arrow], entry[arrow+2:]
+		protocol := "tcp"
+		if i := strings.Index(target, "/"); i >= 0 {
+			protocol = target[i+1:]
+		}
+		colon := strings.LastIndex(host, ":")
+		if colon < 0 {
+			continue
+		}
+		ip := strings.Trim(host[:colon], "[]")
+		first, last := host[colon+1:], host[colon+1:]
+		if i := strings.Index(first, "-"); i >= 0 {
+			first, last = first[:i], first[i+1:]
+		}
+		from, err1 := strconv.Atoi(first)
+		to, err2 := strconv.Atoi(last)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		for port := from; port <= to; port++ {
+			ports = append(ports, boundPort{HostIP: ip, Port: port, Protocol: protocol})
+		}
+	}
+	return ports
+}
+
+// boundPorts lists the host ports published by the running containers
+func (dcm *Manager) boundPorts() ([]boundPort, error) {
+	output, err := dcm.dockerOutput("ps", "--format", `{{.Names}}	{{.Label "com.docker.compose.project"}}	{{.Ports}}`)
+	if err != nil {
+		return nil, err
+	}
+	var ports []boundPort
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		for _, p := range parseDockerPorts(fields[2]) {
+			p.Container, p.Project = fields[0], fields[1]
+			ports = append(ports, p)
+		}
+	}
+	return ports, nil
+}
+
+// hostIPsOverlap reports whether ports published on two addresses clash,
+// an empty or unspecified address meaning every address
+func hostIPsOverlap(a, b string) bool {
+	unspecified := func(ip string) bool { return ip == "" || ip == "0.0.0.0" || ip == "::" }
+	return unspecified(a) || unspecified(b) || a == b
+}
+
+// hostPortFree reports whether a port can be bound on this machine. Only an
+// address in use counts as taken: a privileged port denied to the user is
+// for docker to bind.
+func hostPortFree(hostIP, protocol string, port int) bool {
+	addr := net.JoinHostPort(hostIP, strconv.Itoa(port))
+	var err error
+	if protocol == "udp" {
+		var conn net.PacketConn
+		if conn, err = net.ListenPacket("udp", addr); err == nil {
+			conn.Close()
+		}
+	} else {
+		var listener net.Listener
+		if listener, err = net.Listen("tcp", addr); err == nil {
+			listener.Close()
+		}
+	}
+	return !errors.Is(err, syscall.EADDRINUSE)
+}
+
+// localEngine reports whether the Docker engine runs on this machine, so
+// that the ports it is to publish can be probed by binding them
+func (dcm *Manager) localEngine() bool {
+	host, context := dcm.config.Endpoint.Host, dcm.config.Endpoint.Context
+	if host == "" && context == "" {
+		host, context = os.Getenv("DOCKER_HOST"), os.Getenv("DOCKER_CONTEXT")
+	}
+	if context != "" && context != "default" {
+		return false
+	}
+	return host == "" || strings.HasPrefix(host, "unix://") || strings.HasPrefix(host, "npipe://")
+}
+
+// startedServices returns the services up starts for the given ones: them
+// and the services they depend on, every service when none are given
+func startedServices(services map[string]composeService, requested []string) []string {
+	if len(requested) == 0 {
+		for name := range services {
+			requested = append(requested, name)
+		}
+	}
+	seen := make(map[string]bool)
+	var visit func(name string)
+	visit = func(name string) {
+		if seen[name] {
+			return
+		}
+		seen[name] = true
+		for _, dep := range services[name].DependsOn {
+			visit(dep)
+		}
+	}
+	for _, name := range requested {
+		visit(name)
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		if _, ok := services[name]; ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// portChecker finds out whether host ports are free for the project
+type portChecker struct {
+	project string
+	bound   []boundPort
+	probe   bool
+	// assigned are the ports of the project checked so far
+	assigned []PortMapping
+}
+
+// conflict returns why m cannot publish port, empty when it can
+func (c *portChecker) conflict(m PortMapping, port int) string {
+	ownBound := false
+	for _, b := range c.bound {
+		if b.Port != port || b.Protocol != m.Protocol || !hostIPsOverlap(b.HostIP, m.HostIP) {
+			continue
+		}
+		// compose replaces the containers of the project itself
+		if b.Project == c.project {
+			ownBound = true
+			continue
+		}
+		if b.Project != "" {
+			return fmt.Sprintf("bound by container %s of project %s", b.Container, b.Project)
+		}
+		return fmt.Sprintf("bound by container %s", b.Container)
+	}
+	for _, a := range c.assigned {
+		if a.Published == port && a.Protocol == m.Protocol && hostIPsOverlap(a.HostIP, m.HostIP) {
+			return fmt.Sprintf("also published by service %s", a.Service)
+		}
+	}
+	if c.probe && !ownBound && !hostPortFree(m.HostIP, m.Protocol, port) {
+		return "in use by another process on the host"
+	}
+	return ""
+}
+
+// CheckPorts checks that the host ports the services to start publish are
+// free: not bound by containers of other projects, by other processes when
+// the engine is local, or twice within the project. Without autoPorts a
+// conflict is an error; with it, the ports in use are remapped to free ones
+// through an override file and the final mapping is printed. services are
+// those given to start, every service when empty.
+func (dcm *Manager) CheckPorts(services []string, autoPorts bool) ([]PortMapping, error) {
+	// The ports are checked as the compose files publish them, without the
+	// remapping of an earlier --auto-ports
+	path := dcm.portsOverridePath()
+	dcm.portsOverride = ""
+	if !dcm.dryRun {
+		if err := os.Remove(path); err == nil {
+			dcm.verbosef("Removed the port remapping of %s\n", path)
+		}
+	}
+	if len(services) == 0 {
+		services = dcm.scopedServices()
+	}
+
+	config, err := dcm.composeOutput("config")
+	if err != nil {
+		// Leave reporting a broken config to the command itself
+		dcm.verbosef("Skipping the port check, compose config failed: %v\n", err)
+		return nil, nil
+	}
+	var rendered struct {
+		Services map[string]composeService `yaml:"services"`
+	}
+	if err := yaml.Unmarshal([]byte(config), &rendered); err != nil {
+		dcm.verbosef("Skipping the port check, could not parse the compose config: %v\n", err)
+		return nil, nil
+	}
+
+	var mappings []PortMapping
+	for _, name := range startedServices(rendered.Services, services) {
+		for _, p := range rendered.Services[name].Ports {
+			published, err := strconv.Atoi(p.Published)
+			if err != nil {
+				// Unpublished ports and port ranges are left to docker
+				continue
+			}
+			mappings = append(mappings, PortMapping{Service: name, HostIP: p.HostIP, Published: published, Target: p.Target, Protocol: p.Protocol})
+		}
+	}
+	if len(mappings) == 0 {
+		return nil, nil
+	}
+	bound, err := dcm.boundPorts()
+	if err != nil {
+		return nil, fmt.Errorf("listing published ports: %w", err)
+	}
+	checker := &portChecker{project: dcm.ProjectName(), bound: bound, probe: dcm.localEngine()}
+	if !checker.probe {
+		dcm.verbosef("The Docker engine is remote, only the ports of its containers are checked\n")
+	}
+
+	var conflicts []string
+	remapped := make(map[string]bool)
+	for i := range mappings {
+		m := &mappings[i]
+		reason := checker.conflict(*m, m.Published)
+		if reason != "" && !autoPorts {
+			conflicts = append(conflicts, fmt.Sprintf("%s: host port %d/%s is %s", m.Service, m.Published, m.Protocol, reason))
+		} else if reason != "" {
+			free := 0
+			for port := m.Published + 1; port <= 65535 && port <= m.Published+maxPortSearch; port++ {
+				if checker.conflict(*m, port) == "" {
+					free = port
+					break
+				}
+			}
+			if free == 0 {
+				return nil, NewError(ErrConfig, "%s: host port %d/%s is %s, and no free port was found above it",
+					m.Service, m.Published, m.Protocol, reason)
+			}
+			dcm.Infof("%s: host port %d/%s is %s, using %d\n", m.Service, m.Published, m.Protocol, reason, free)
+			m.Requested, m.Published = m.Published, free
+			remapped[m.Service] = true
+		}
+		checker.assigned = append(checker.assigned, *m)
+	}
+	if len(conflicts) > 0 {
+		return nil, NewError(ErrConfig, "ports in use:\n  %s\nfree them, change the ports in the compose files or pass --auto-ports to remap them",
+			strings.Join(conflicts, "\n  "))
+	}
+	if !autoPorts {
+		return mappings, nil
+	}
+
+	if len(remapped) > 0 {
+		if err := dcm.writePortsOverride(path, rendered.Services, mappings, remapped); err != nil {
+			return nil, err
+		}
+	}
+	if dcm.output != "json" {
+		printPortMappings(mappings)
+	}
+	return mappings, nil
+}
+
+// overridePort is a port in the long syntax of compose files
+type overridePort struct {
+	Target    interface{} `yaml:"target"`
+	Published string      `yaml:"published,omitempty"`
+	HostIP    string      `yaml:"host_ip,omitempty"`
+	Protocol  string      `yaml:"protocol,omitempty"`
+}
+
+// writePortsOverride writes the override file replacing the ports of the
+// remapped services. The whole list of each service is replaced with the
+// !override tag of Compose 2.24, since compose adds the ports of override
+// files to the ones defined.
+func (dcm *Manager) writePortsOverride(path string, services map[string]composeService, mappings []PortMapping, remapped map[string]bool) error {
+	names := make([]string, 0, len(remapped))
+	for name := range remapped {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString("# Written by dcm start --auto-ports to remap the host ports in use\nservices:\n")
+	for _, name := range names {
+		remaps := make(map[string]int)
+		for _, m := range mappings {
+			if m.Service == name && m.Requested != 0 {
+				remaps[fmt.Sprintf("%s/%d/%s/%s", m.HostIP, m.Requested, m.Target, m.Protocol)] = m.Published
+			}
+		}
+		var ports []overridePort
+		for _, p := range services[name].Ports {
+			port := overridePort{Target: p.Target, Published: p.Published, HostIP: p.HostIP, Protocol: p.Protocol}
+			if n, err := strconv.Atoi(p.Target); err == nil {
+				port.Target = n
+			}
+			if published, ok := remaps[fmt.Sprintf("%s/%s/%s/%s", p.HostIP, p.Published, p.Target, p.Protocol)]; ok {
+				port.Published = strconv.Itoa(published)
+			}
+			ports = append(ports, port)
+		}
+		key, _ := yaml.Marshal(name)
+		list, err := yaml.Marshal(ports)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&b, "  %s:\n    ports: !override\n", strings.TrimSpace(string(key)))
+		for _, line := range strings.Split(strings.TrimRight(string(list), "\n"), "\n") {
+			b.WriteString("      " + line + "\n")
+		}
+	}
+
+	if dcm.dryRun {
+		fmt.Printf("Would write %s:\n%s", path, b.String())
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0775); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, []byte(b.String()), 0664); err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	dcm.portsOverride = path
+	return nil
+}
+
+// printPortMappings prints the host ports of the services
+func printPortMappings(mappings []PortMapping) {
+	fmt.Printf("%-20s %-30s %s\n", "SERVICE", "PORT", "NOTE")
+	for _, m := range mappings {
+		note := ""
+		if m.Requested != 0 {
+			note = fmt.Sprintf("remapped from %d", m.Requested)
+		}
+		fmt.Printf("%-20s %-30s %s\n", m.Service, m, note)
+	}
+}
@@ -0,0 +1,255 @@
+package manager
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// stateDirName is the directory, next to the config file, where the
+	// manager keeps runtime state shared between invocations
+	stateDirName = ".dcm"
+	// foreignIntentMaxAge bounds how long an intent from another host is
+	// trusted, since its PID cannot be checked from here
+	foreignIntentMaxAge = time.Hour
+)
+
+// Intent records a mutating operation that is currently in progress
+type Intent struct {
+	User     string   `json:"user"`
+	Host     string   `json:"host"`
+	PID      int      `json:"pid"`
+	Verb     string   `json:"verb"`
+	Services []string `json:"services,omitempty"`
+	// Project is the project of the config operated on, if any
+	Project   string    `json:"project,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// Activity is a finished operation as recorded in the activity log
+type Activity struct {
+	Intent
+	FinishedAt time.Time `json:"finished_at"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// stateDir returns the manager's state directory
+func (dcm *Manager) stateDir() string {
+	return filepath.Join(filepath.Dir(dcm.configPath), stateDirName)
+}
+
+func (dcm *Manager) intentsDir() string {
+	return filepath.Join(dcm.stateDir(), "intents")
+}
+
+func (dcm *Manager) activityLogPath() string {
+	return filepath.Join(dcm.stateDir(), "activity.jsonl")
+}
+
+// currentUser returns the name of the user running the manager
+func currentUser() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	return "unknown"
+}
+
+// Track runs a mutating operation through the middleware chain while an
+// intent record announces it to other users, and appends the outcome to the
+// activity log. A dry run changes nothing, so it is neither announced nor
+// logged.
+func (dcm *Manager) Track(verb string, services []string, op func() error) error {
+	if dcm.dryRun {
+		return dcm.runOperation(Operation{Verb: verb, Services: services}, op)
+	}
+	host, _ := os.Hostname()
+	intent := Intent{
+		User:      currentUser(),
+		Host:      host,
+		PID:       os.Getpid(),
+		Verb:      verb,
+		Services:  services,
+		Project:   dcm.project,
+		StartedAt: time.Now(),
+	}
+
+	path := filepath.Join(dcm.intentsDir(), fmt.Sprintf("%s-%d.json", host, intent.PID))
+	if err := writeJSONFile(path, intent); err != nil {
+		// Presence is advisory, never block the operation on it
+		dcm.Warnf("could not record operation intent: %v\n", err)
+	}
+	defer os.Remove(path)
+
+	err := dcm.runOperation(Operation{Verb: verb, Services: services}, op)
+
+	activity := Activity{Intent: intent, FinishedAt: time.Now()}
+	if err != nil {
+		activity.Error = err.Error()
+	}
+	if logErr := appendJSONLine(dcm.activityLogPath(), activity); logErr != nil {
+		dcm.Warnf("could not write activity log: %v\n", logErr)
+	}
+	return err
+}
+
+// activeIntents returns the operations currently in progress, removing the
+// records of processes that died without cleaning up.
+func (dcm *Manager) activeIntents() ([]Intent, error) {
+	entries, err := ioutil.ReadDir(dcm.intentsDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	host, _ := os.Hostname()
+	var intents []Intent
+	for _, entry := range entries {
+		path := filepath.Join(dcm.intentsDir(), entry.Name())
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var intent Intent
+		if err := json.Unmarshal(data, &intent); err != nil {
+			os.Remove(path)
+			continue
+		}
+
+		stale := false
+		if intent.Host == host {
+			stale = !pidAlive(intent.PID)
+		} else {
+			stale = time.Since(intent.StartedAt) > foreignIntentMaxAge
+		}
+		if stale {
+			os.Remove(path)
+			continue
+		}
+		intents = append(intents, intent)
+	}
+
+	sort.Slice(intents, func(i, j int) bool {
+		return intents[i].StartedAt.Before(intents[j].StartedAt)
+	})
+	return intents, nil
+}
+
+// printActiveOperations announces operations other processes are running
+func (dcm *Manager) printActiveOperations() {
+	intents, err := dcm.activeIntents()
+	if err != nil {
+		return
+	}
+	for _, intent := range intents {
+		if intent.PID == os.Getpid() {
+			continue
+		}
+		target := ""
+		if len(intent.Services) > 0 {
+			target = " on " + strings.Join(intent.Services, ", ")
+		}
+		fmt.Printf("Note: %s is running '%s'%s since %s ago\n",
+			intent.User, intent.Verb, target, time.Since(intent.StartedAt).Round(time.Second))
+	}
+}
+
+// Who prints the activity recorded since the given time, grouped by user
+func (dcm *Manager) Who(since time.Duration) error {
+	dcm.printActiveOperations()
+
+	f, err := os.Open(dcm.activityLogPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			fmt.Println("No recorded activity")
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	cutoff := time.Now().Add(-since)
+	byUser := make(map[string][]Activity)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var activity Activity
+		if err := json.Unmarshal(scanner.Bytes(), &activity); err != nil {
+			continue
+		}
+		if activity.StartedAt.Before(cutoff) {
+			continue
+		}
+		byUser[activity.User] = append(byUser[activity.User], activity)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if len(byUser) == 0 {
+		fmt.Printf("No activity in the last %s\n", since)
+		return nil
+	}
+
+	users := make([]string, 0, len(byUser))
+	for name := range byUser {
+		users = append(users, name)
+	}
+	sort.Strings(users)
+
+	for _, name := range users {
+		activities := byUser[name]
+		fmt.Printf("%s (%d operations)\n", name, len(activities))
+		for _, a := range activities {
+			result := "ok"
+			if a.Error != "" {
+				result = "failed: " + a.Error
+			}
+			fmt.Printf("  %s  %-10s %-20s %s\n",
+				a.StartedAt.Format("2006-01-02 15:04:05"), a.Verb, strings.Join(a.Services, ","), result)
+		}
+	}
+	return nil
+}
+
+// writeJSONFile writes v as JSON to path, creating parent directories. State
+// files are group-writable so users sharing a host can update them.
+func writeJSONFile(path string, v interface{}) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0775); err != nil {
+		return err
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0664)
+}
+
+// appendJSONLine appends v as a single JSON line to path
+func appendJSONLine(path string, v interface{}) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0775); err != nil {
+		return err
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0664)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
@@ -0,0 +1,49 @@
+package manager
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestTrackLogsActivity(t *testing.T) {
+	dcm := newTestManager(t, "", "", &fakeRunner{})
+
+	var intents []Intent
+	err := dcm.Track("stop", []string{"web"}, func() error {
+		var err error
+		intents, err = dcm.activeIntents()
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(intents) != 1 || intents[0].Verb != "stop" {
+		t.Errorf("intents during the operation = %+v, want the stop", intents)
+	}
+	data, err := os.ReadFile(dcm.activityLogPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 1 || !strings.Contains(lines[0], `"verb":"stop"`) {
+		t.Errorf("activity log = %q, want the stop", data)
+	}
+	if intents, _ := dcm.activeIntents(); len(intents) != 0 {
+		t.Errorf("intents after the operation = %+v, want none", intents)
+	}
+}
+
+func TestTrackDryRunLeavesNoTrace(t *testing.T) {
+	dcm := newTestManager(t, "", "", &fakeRunner{}, WithDryRun())
+
+	ran := false
+	if err := dcm.Track("stop", []string{"web"}, func() error {
+		ran = true
+		return nil
+	}); err != nil || !ran {
+		t.Fatalf("Track = %v with the operation run %v", err, ran)
+	}
+	if _, err := os.Stat(dcm.stateDir()); !os.IsNotExist(err) {
+		t.Errorf("a dry run wrote %s", dcm.stateDir())
+	}
+}
@@ -0,0 +1,88 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// logRetainingDrivers are the logging drivers `docker logs` can read back
+var logRetainingDrivers = map[string]bool{
+	"json-file": true,
+	"local":     true,
+	"journald":  true,
+}
+
+// dockerOutput runs a docker CLI command and returns its standard output
+// without echoing anything
+func (dcm *Manager) dockerOutput(args ...string) (string, error) {
+	output, err := dcm.hostCommand(context.Background(), append([]string{"docker"}, args...)...).Output()
+	var exitErr *exitError
+	if errors.As(err, &exitErr) {
+		return "", fmt.Errorf("docker %s: %v: %s", args[0], err, firstLine(string(exitErr.Stderr)))
+	}
+	return string(output), err
+}
+
+// previousContainer finds the most recently created container of a service
+// that is not one of its current containers, i.e. the instance replaced by
+// the last recreate.
+func (dcm *Manager) previousContainer(service string) (string, error) {
+	current, err := dcm.composeOutput("ps", "-q", service)
+	if err != nil {
+		return "", err
+	}
+	isCurrent := make(map[string]bool)
+	for _, id := range strings.Fields(current) {
+		isCurrent[id] = true
+	}
+
+	// docker ps lists the newest containers first
+	all, err := dcm.dockerOutput("ps", "-a", "--no-trunc", "--format", "{{.ID}}",
+		"--filter", "label=com.docker.compose.project="+dcm.ProjectName(),
+		"--filter", "label=com.docker.compose.service="+service)
+	if err != nil {
+		return "", err
+	}
+	for _, id := range strings.Fields(all) {
+		if !isCurrent[id] {
+			return id, nil
+		}
+	}
+	return "", fmt.Errorf("no previous container of %s is left: compose removes the old "+
+		"container when it recreates a service, so its logs are gone", service)
+}
+
+// PreviousLogs prints the logs of the container a service ran in before it
+// was last recreated.
+func (dcm *Manager) PreviousLogs(service string, opts LogOptions) (string, error) {
+	if err := dcm.checkService(service); err != nil {
+		return "", err
+	}
+
+	id, err := dcm.previousContainer(service)
+	if err != nil {
+		return "", err
+	}
+
+	driver, err := dcm.dockerOutput("inspect", "--format", "{{.HostConfig.LogConfig.Type}}", id)
+	if err != nil {
+		return "", err
+	}
+	driver = strings.TrimSpace(driver)
+	if !logRetainingDrivers[driver] {
+		return "", fmt.Errorf("previous container %.12s of %s uses the %s logging driver, "+
+			"which does not keep logs docker can read back", id, service, driver)
+	}
+
+	dcm.Infof("Fetching logs of previous %s container %.12s...\n", service, id)
+	args := []string{"docker", "logs"}
+	if opts.Tail >= 0 {
+		args = append(args, "--tail", fmt.Sprint(opts.Tail))
+	}
+	if opts.Since != "" {
+		args = append(args, "--since", opts.Since)
+	}
+	return dcm.executeCommand(append(args, id))
+}
@@ -0,0 +1,189 @@
+package manager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ProjectConfig is one stack defined under projects in the config. Its
+// fields replace the top-level ones of the same name when it is selected.
+type ProjectConfig struct {
+	ComposeFile  string     `yaml:"compose_file"`
+	ComposeFiles stringList `yaml:"compose_files"`
+	ProjectName  string     `yaml:"project_name"`
+	WorkingDir   string     `yaml:"working_dir"`
+	EnvFile      string     `yaml:"env_file"`
+	Services     []string   `yaml:"services"`
+	// Endpoint is the Docker engine of the project, replacing the
+	// top-level one when set
+	Endpoint DockerEndpoint `yaml:",inline"`
+}
+
+// projectNames returns the names of the projects in the config, sorted
+func (c Config) projectNames() []string {
+	names := make([]string, 0, len(c.Projects))
+	for name := range c.Projects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// applyConfig finishes loading the config read from the file: it applies the
+// named project, or default_project when name is empty, resolves paths
+// relative to working_dir, itself relative to the config file, and checks
+// the compose files exist.
+func (dcm *Manager) applyConfig(name string) error {
+	dcm.config = dcm.fileConfig
+	if name == "" {
+		name = dcm.config.DefaultProject
+	}
+	if name != "" {
+		project, ok := dcm.config.Projects[name]
+		if !ok {
+			defined := "none are defined"
+			if len(dcm.config.Projects) > 0 {
+				defined = "defined projects: " + strings.Join(dcm.config.projectNames(), ", ")
+			}
+			return NewError(ErrConfig, "project %q is not defined in %s (%s)", name, dcm.configPath, defined)
+		}
+		dcm.project = name
+		dcm.config.ComposeFile = project.ComposeFile
+		dcm.config.ComposeFiles = project.ComposeFiles
+		dcm.config.WorkingDir = project.WorkingDir
+		dcm.config.EnvFile = project.EnvFile
+		dcm.config.Services = project.Services
+		dcm.config.ProjectName = project.ProjectName
+		if dcm.config.ProjectName == "" {
+			dcm.config.ProjectName = name
+		}
+		if !project.Endpoint.empty() {
+			dcm.config.Endpoint = project.Endpoint
+		}
+	}
+
+	// Files from the command line are relative to the current directory
+	if len(dcm.fileOverride) > 0 {
+		dcm.config.ComposeFile, dcm.config.ComposeFiles = "", nil
+		for _, f := range dcm.fileOverride {
+			abs, err := filepath.Abs(f)
+			if err != nil {
+				return NewError(ErrConfig, "--file %s: %v", f, err)
+			}
+			dcm.config.ComposeFiles = append(dcm.config.ComposeFiles, abs)
+		}
+	}
+	if dcm.envFileOverride != "" {
+		abs, err := filepath.Abs(dcm.envFileOverride)
+		if err != nil {
+			return NewError(ErrConfig, "--env-file %s: %v", dcm.envFileOverride, err)
+		}
+		dcm.config.EnvFile = abs
+	}
+
+	// Relative paths are written from the config file's point of view, which
+	// is not the current directory when the config was found in a parent
+	base := filepath.Dir(dcm.configPath)
+	resolveIn := func(dir, path string) string {
+		if path == "" || filepath.IsAbs(path) {
+			return path
+		}
+		return filepath.Join(dir, path)
+	}
+	dir := base
+	if dcm.config.WorkingDir != "" {
+		dir = resolveIn(base, dcm.config.WorkingDir)
+		dcm.config.WorkingDir = dir
+	}
+	if len(dcm.config.composeFiles()) == 0 {
+		files, err := discoverComposeFiles(dir)
+		if err != nil {
+			return NewError(ErrConfig, "looking for compose files: %w", err)
+		}
+		dcm.config.ComposeFiles = files
+		if _, err := os.Stat(dcm.configPath); len(files) == 0 && os.IsNotExist(err) {
+			// Without a config file either, fail on the conventional name
+			// rather than let compose search the current directory
+			dcm.config.ComposeFile = "docker-compose.yml"
+		}
+	}
+	if dir != "." {
+		resolve := func(path string) string { return resolveIn(dir, path) }
+		dcm.config.ComposeFile = resolve(dcm.config.ComposeFile)
+		files := make([]string, len(dcm.config.ComposeFiles))
+		for i, f := range dcm.config.ComposeFiles {
+			files[i] = resolve(f)
+		}
+		dcm.config.ComposeFiles = files
+		dcm.config.EnvFile = resolve(dcm.config.EnvFile)
+	}
+	dcm.setProjectName(dcm.config.ProjectName)
+	dcm.portsOverride = ""
+	if _, err := os.Stat(dcm.portsOverridePath()); err == nil {
+		dcm.portsOverride = dcm.portsOverridePath()
+	}
+
+	// cert_path is relative to the config file, and without an endpoint in
+	// the config the context picked with `dcm context use` applies
+	dcm.config.Endpoint.CertPath = resolveIn(base, dcm.config.Endpoint.CertPath)
+	if dcm.config.Endpoint.empty() {
+		dcm.config.Endpoint.Context = dcm.selectedContexts()[dcm.ProjectName()]
+	}
+
+	env, err := dcm.childEnv()
+	if err != nil {
+		return err
+	}
+	dcm.env = dcm.endpointEnv(env)
+
+	return nil
+}
+
+// forProject returns a copy of the manager operating on another project
+func (dcm *Manager) forProject(name string) (*Manager, error) {
+	project := *dcm
+	project.overlays = nil
+	if err := project.applyConfig(name); err != nil {
+		return nil, err
+	}
+	return &project, nil
+}
+
+// PrintProjects lists the projects defined in the config and how many of
+// their services are running. The selected project is marked with a *.
+func (dcm *Manager) PrintProjects() error {
+	names := dcm.fileConfig.projectNames()
+	if len(names) == 0 {
+		fmt.Printf("No projects are defined in %s\n", dcm.configPath)
+		return nil
+	}
+
+	fmt.Printf("  %-20s %-10s %s\n", "PROJECT", "RUNNING", "COMPOSE FILES")
+	for _, name := range names {
+		project, err := dcm.forProject(name)
+		if err != nil {
+			return err
+		}
+		running := "?"
+		if services, err := project.ListServices(); err == nil {
+			up := 0
+			for _, s := range services {
+				if s.State == "running" {
+					up++
+				}
+			}
+			running = fmt.Sprintf("%d/%d", up, len(services))
+		} else {
+			dcm.verbosef("Could not list services of %s: %v\n", name, err)
+		}
+		marker := " "
+		if name == dcm.project {
+			marker = "*"
+		}
+		fmt.Printf("%s %-20s %-10s %s\n", marker, name, running, strings.Join(project.config.composeFiles(), ", "))
+	}
+	return nil
+}
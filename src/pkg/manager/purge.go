@@ -0,0 +1,175 @@
+package manager
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// PurgeOptions controls Purge
+type PurgeOptions struct {
+	// DryRun only reports what would be removed
+	DryRun bool
+	// Yes skips the confirmation, for scripts
+	Yes bool
+}
+
+// PurgeReport lists what Purge removed, or would remove on a dry run
+type PurgeReport struct {
+	Project    string   `json:"project"`
+	Containers []string `json:"containers"`
+	Images     []string `json:"images"`
+	Volumes    []string `json:"volumes"`
+	// ImageBytes is the size of the listed images
+	ImageBytes int64 `json:"image_bytes"`
+	// BuildCache is the space docker builder prune reports as reclaimed
+	BuildCache string `json:"build_cache,omitempty"`
+	DryRun     bool   `json:"dry_run"`
+}
+
+// projectFilter selects the docker objects compose created for the project
+func (dcm *Manager) projectFilter() string {
+	return "label=com.docker.compose.project=" + dcm.ProjectName()
+}
+
+// dockerLines runs a docker command and returns its non-empty output lines
+func (dcm *Manager) dockerLines(args ...string) ([]string, error) {
+	output, err := dcm.dockerOutput(args...)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(output), nil
+}
+
+// purgePlan finds the containers, volumes and images of the project
+func (dcm *Manager) purgePlan() (*PurgeReport, error) {
+	report := &PurgeReport{Project: dcm.ProjectName()}
+	var err error
+	if report.Containers, err = dcm.dockerLines("ps", "-a", "--filter", dcm.projectFilter(), "--format", "{{.Names}}"); err != nil {
+		return nil, err
+	}
+	if report.Volumes, err = dcm.dockerLines("volume", "ls", "--filter", dcm.projectFilter(), "--format", "{{.Name}}"); err != nil {
+		return nil, err
+	}
+
+	project, err := dcm.composeProject()
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	for _, name := range project.Names {
+		for _, image := range dcm.serviceImages(project, name) {
+			size, err := dcm.dockerOutput("image", "inspect", "--format", "{{.Size}}", image)
+			if err != nil || seen[image] {
+				// Not present locally, nothing to reclaim
+				continue
+			}
+			seen[image] = true
+			report.Images = append(report.Images, image)
+			if n, err := strconv.ParseInt(strings.TrimSpace(size), 10, 64); err == nil {
+				report.ImageBytes += n
+			}
+		}
+	}
+	return report, nil
+}
+
+// reclaimedSpace extracts the total from the output of docker builder prune
+func reclaimedSpace(output string) string {
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(line, "Total") {
+			if i := strings.Index(line, ":"); i >= 0 {
+				return strings.TrimSpace(line[i+1:])
+			}
+		}
+	}
+	return ""
+}
+
+// Purge removes everything of the project to reclaim space: its
+// containers, networks, volumes and images with down --rmi all --volumes
+// --remove-orphans, then the build cache of the project. It shows what will
+// be removed and asks the user to confirm by typing the project name,
+// unless opts.Yes is set.
+func (dcm *Manager) Purge(opts PurgeOptions) (PurgeReport, error) {
+	plan, err := dcm.purgePlan()
+	if err != nil {
+		return PurgeReport{}, err
+	}
+	plan.DryRun = opts.DryRun
+	if dcm.output != "json" {
+		printPurgePlan(plan)
+	}
+	if opts.DryRun {
+		return *plan, nil
+	}
+
+	if !opts.Yes {
+		if !IsTerminal(os.Stdin) {
+			return *plan, NewError(ErrUsage, "purge needs confirmation, pass --yes to run it without a terminal")
+		}
+		if dcm.input == nil {
+			dcm.input = bufio.NewScanner(os.Stdin)
+		}
+		fmt.Printf("This cannot be undone. Type the project name (%s) to confirm: ", plan.Project)
+		if !dcm.input.Scan() || strings.TrimSpace(dcm.input.Text()) != plan.Project {
+			return *plan, NewError(ErrUsage, "purge cancelled")
+		}
+	}
+
+	dcm.Infof("Purging project %s...\n", plan.Project)
+	if err := dcm.composeStreaming("down", "--rmi", "all", "--volumes", "--remove-orphans"); err != nil {
+		return *plan, err
+	}
+	// Build cache records carry the labels of the build, so only cache
+	// from builds of this project is removed where the builder keeps them
+	output, err := dcm.dockerOutput("builder", "prune", "--force", "--filter", dcm.projectFilter())
+	if err != nil {
+		dcm.Warnf("could not prune the build cache: %v\n", err)
+	}
+	plan.BuildCache = reclaimedSpace(output)
+
+	if dcm.output != "json" {
+		fmt.Printf("Reclaimed %s of images", formatBytes(plan.ImageBytes))
+		if plan.BuildCache != "" {
+			fmt.Printf(" and %s of build cache", plan.BuildCache)
+		}
+		fmt.Println()
+	}
+	return *plan, nil
+}
+
+// printPurgePlan lists what a purge removes
+func printPurgePlan(plan *PurgeReport) {
+	verb := "Will remove"
+	if plan.DryRun {
+		verb = "Would remove"
+	}
+	fmt.Printf("%s from project %s:\n", verb, plan.Project)
+	list := func(kind string, items []string) {
+		fmt.Printf("  %s (%d)\n", kind, len(items))
+		for _, item := range items {
+			fmt.Printf("    %s\n", item)
+		}
+	}
+	list("containers", plan.Containers)
+	list("volumes", plan.Volumes)
+	list("images, "+formatBytes(plan.ImageBytes), plan.Images)
+	fmt.Println("  networks and the project's build cache")
+}
+
+// formatBytes renders a size in bytes with a binary unit
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
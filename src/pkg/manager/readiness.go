@@ -0,0 +1,202 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// probeTimeout bounds one run of a readiness probe
+const probeTimeout = 5 * time.Second
+
+// ReadinessProbe is a check, from the readiness section of the config, that
+// a service must pass before a wait counts it as ready, on top of its
+// container running and being healthy. Exactly one kind is set.
+type ReadinessProbe struct {
+	// TCP is a host:port that must accept connections
+	TCP string `yaml:"tcp"`
+	// HTTP is a URL that must answer with a status below 400
+	HTTP string `yaml:"http"`
+	// Command is run in the service container and must exit 0; a single
+	// string is run by sh -c
+	Command stringList `yaml:"command"`
+}
+
+// checkReadiness validates the readiness section of the config
+func (dcm *Manager) checkReadiness() error {
+	names := make([]string, 0, len(dcm.config.Readiness))
+	for name := range dcm.config.Readiness {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		probe := dcm.config.Readiness[name]
+		kinds := 0
+		for _, set := range []bool{probe.TCP != "", probe.HTTP != "", len(probe.Command) > 0} {
+			if set {
+				kinds++
+			}
+		}
+		if kinds != 1 {
+			return NewError(ErrConfig, "%s: readiness.%s: set exactly one of tcp, http and command", dcm.configPath, name)
+		}
+		if probe.TCP != "" {
+			if _, _, err := net.SplitHostPort(probe.TCP); err != nil {
+				return NewError(ErrConfig, "%s: readiness.%s.tcp: %v", dcm.configPath, name, err)
+			}
+		}
+		if probe.HTTP != "" && !strings.HasPrefix(probe.HTTP, "http://") && !strings.HasPrefix(probe.HTTP, "https://") {
+			return NewError(ErrConfig, "%s: readiness.%s.http: expected an http:// or https:// URL, got %q", dcm.configPath, name, probe.HTTP)
+		}
+	}
+	return nil
+}
+
+// probeReadiness runs the readiness probe of a service, returning why it is
+// not ready, or "" when it passed or has no probe
+func (dcm *Manager) probeReadiness(service string) string {
+	probe, ok := dcm.config.Readiness[service]
+	if !ok {
+		return ""
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+
+	switch {
+	case probe.TCP != "":
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", probe.TCP)
+		if err != nil {
+			return fmt.Sprintf("tcp probe %s: %v", probe.TCP, err)
+		}
+		conn.Close()
+	case probe.HTTP != "":
+		req, err := http.NewRequest("GET", probe.HTTP, nil)
+		if err != nil {
+			return fmt.Sprintf("http probe %s: %v", probe.HTTP, err)
+		}
+		resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+		if err != nil {
+			return fmt.Sprintf("http probe %s: %v", probe.HTTP, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			return fmt.Sprintf("http probe %s: %s", probe.HTTP, resp.Status)
+		}
+	default:
+		command := []string(probe.Command)
+		if len(command) == 1 {
+			command = []string{"sh", "-c", command[0]}
+		}
+		argv, err := dcm.composeArgs(append([]string{"exec", "-T", service}, command...)...)
+		if err != nil {
+			return err.Error()
+		}
+		started := time.Now()
+		err = dcm.command(ctx, argv).Run()
+		dcm.logCommand(argv, started, err)
+		if err != nil {
+			return fmt.Sprintf("command probe %s: %v", strings.Join(probe.Command, " "), err)
+		}
+	}
+	return ""
+}
+
+// dependencyLayers groups services, with the services they transitively
+// depend on, so that every service comes in a later layer than its
+// dependencies
+func dependencyLayers(project *composeProject, services []string) ([][]string, error) {
+	deps := make(map[string][]string)
+	for name, service := range project.Services {
+		deps[name] = service.DependsOn
+	}
+	// Compose starts the dependencies of a service with it, so they are
+	// waited for too
+	wanted := make(map[string]bool)
+	var add func(name string)
+	add = func(name string) {
+		if wanted[name] {
+			return
+		}
+		wanted[name] = true
+		for _, dep := range deps[name] {
+			add(dep)
+		}
+	}
+	for _, name := range services {
+		add(name)
+	}
+	var all []string
+	for name := range wanted {
+		all = append(all, name)
+	}
+	sort.Strings(all)
+	order, err := topoSort(all, deps)
+	if err != nil {
+		return nil, NewError(ErrConfig, "%w", err)
+	}
+
+	depth := make(map[string]int)
+	var layers [][]string
+	for _, name := range filterServices(order, all) {
+		d := 0
+		for _, dep := range deps[name] {
+			if depth[dep]+1 > d {
+				d = depth[dep] + 1
+			}
+		}
+		depth[name] = d
+		if d == len(layers) {
+			layers = append(layers, nil)
+		}
+		layers[d] = append(layers[d], name)
+	}
+	return layers, nil
+}
+
+// StartOrdered starts services, those in scope when none are given, in
+// dependency order: a layer of services is started only once every service
+// it depends on is running, healthy and passes its readiness probe. It stops
+// at the first layer that does not become ready.
+func (dcm *Manager) StartOrdered(services []string, opts WaitOptions) error {
+	project, err := dcm.composeProject()
+	if err != nil {
+		return err
+	}
+	for _, name := range services {
+		if err := dcm.checkService(name); err != nil {
+			return err
+		}
+	}
+	if len(services) == 0 {
+		services = dcm.scopedServices()
+	}
+	if len(services) == 0 {
+		services = project.Names
+	}
+	layers, err := dependencyLayers(project, services)
+	if err != nil {
+		return err
+	}
+
+	for i, layer := range layers {
+		dcm.Infof("[%d/%d] Starting %s...\n", i+1, len(layers), strings.Join(layer, ", "))
+		args := append(dcm.upArgs(), "--no-deps")
+		for _, name := range layer {
+			if replicas, ok := dcm.config.Scale[name]; ok {
+				args = append(args, "--scale", fmt.Sprintf("%s=%d", name, replicas))
+			}
+		}
+		if err := dcm.composeStreaming(append(args, layer...)...); err != nil {
+			return fmt.Errorf("starting %s: %w", strings.Join(layer, ", "), err)
+		}
+		if err := dcm.WaitReady(layer, opts); err != nil {
+			return fmt.Errorf("ordered start stopped at layer %d of %d: %w", i+1, len(layers), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package manager
+
+import (
+	"fmt"
+)
+
+// ScaleChange is a service whose running replica count differs from the
+// scale section of the config
+type ScaleChange struct {
+	Service string `json:"service"`
+	Running int    `json:"running"`
+	Desired int    `json:"desired"`
+}
+
+// ServiceReplicas is the number of containers of a service, with the count
+// the scale section of the config asks for
+type ServiceReplicas struct {
+	Service string `json:"service" yaml:"service"`
+	Running int    `json:"running" yaml:"running"`
+	// Total counts the containers in any state
+	Total int `json:"total" yaml:"total"`
+	// Desired is the configured scale, zero when the config sets none
+	Desired int `json:"desired,omitempty" yaml:"desired,omitempty"`
+	// Below is set when fewer containers run than the configured scale
+	Below bool `json:"below_desired" yaml:"below_desired"`
+}
+
+// replicaSummary groups the containers of statuses by service, in the
+// order the services first appear
+func (dcm *Manager) replicaSummary(statuses []ServiceStatus) []ServiceReplicas {
+	index := make(map[string]int)
+	var replicas []ServiceReplicas
+	for _, s := range statuses {
+		i, ok := index[s.Service]
+		if !ok {
+			i = len(replicas)
+			index[s.Service] = i
+			replicas = append(replicas, ServiceReplicas{Service: s.Service, Desired: dcm.config.Scale[s.Service]})
+		}
+		if s.Name == "" {
+			// A service without containers
+			continue
+		}
+		replicas[i].Total++
+		if s.State == "running" {
+			replicas[i].Running++
+		}
+	}
+	for i := range replicas {
+		replicas[i].Below = replicas[i].Running < replicas[i].Desired
+	}
+	return replicas
+}
+
+// warnBelowScale warns about the services running fewer containers than
+// their configured scale
+func (dcm *Manager) warnBelowScale(replicas []ServiceReplicas) {
+	for _, r := range replicas {
+		if r.Below {
+			dcm.Warnf("%s is running %d of %d replicas, run 'dcm scale --apply' to restore them\n", r.Service, r.Running, r.Desired)
+		}
+	}
+}
+
+// printReplicasTable prints the replica counts of services one per line
+func printReplicasTable(replicas []ServiceReplicas) {
+	fmt.Printf("%-20s %-8s %-8s %s\n", "SERVICE", "RUNNING", "DESIRED", "TOTAL")
+	for _, r := range replicas {
+		desired := "-"
+		if r.Desired > 0 {
+			desired = fmt.Sprint(r.Desired)
+		}
+		line := fmt.Sprintf("%-20s %-8d %-8s %d", r.Service, r.Running, desired, r.Total)
+		if r.Below {
+			line += "  below desired"
+		}
+		fmt.Println(line)
+	}
+}
+
+// PlanScale compares the running replica count of each service in scope
+// with a configured scale to the desired one, and returns the drifting ones
+func (dcm *Manager) PlanScale() ([]ScaleChange, error) {
+	targets, err := dcm.configuredScale(nil)
+	if err != nil {
+		return nil, err
+	}
+	var changes []ScaleChange
+	for _, t := range targets {
+		running, err := dcm.replicaCount(t.Service)
+		if err != nil {
+			return nil, fmt.Errorf("reading replica count of %s: %w", t.Service, err)
+		}
+		if running != t.Replicas {
+			changes = append(changes, ScaleChange{t.Service, running, t.Replicas})
+		}
+	}
+	return changes, nil
+}
+
+// ReconcileScale scales the services whose running replica count drifted
+// from the scale section of the config back to it, leaving the others
+// untouched, and returns the changes applied
+func (dcm *Manager) ReconcileScale() ([]ScaleChange, error) {
+	changes, err := dcm.PlanScale()
+	if err != nil || len(changes) == 0 {
+		return changes, err
+	}
+	targets := make([]ScaleTarget, len(changes))
+	for i, c := range changes {
+		targets[i] = ScaleTarget{c.Service, c.Desired}
+	}
+	if _, err := dcm.ScaleServices(targets); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// PrintScaleChanges prints the changes of a reconcile
+func PrintScaleChanges(changes []ScaleChange, dryRun bool) {
+	if len(changes) == 0 {
+		fmt.Println("All services match the configured scale")
+		return
+	}
+	verb := "scaled"
+	if dryRun {
+		verb = "would scale"
+	}
+	for _, c := range changes {
+		fmt.Printf("  %-20s %s %d -> %d\n", c.Service, verb, c.Running, c.Desired)
+	}
+}
@@ -0,0 +1,54 @@
+package manager
+
+import (
+	"strings"
+)
+
+// defaultReloadSignal is sent by reload to services without a reload signal
+// in the config
+const defaultReloadSignal = "HUP"
+
+// normalizeSignal checks a signal name or number and returns it in the form
+// docker expects, e.g. "SIGHUP" for "hup"
+func normalizeSignal(signal string) (string, error) {
+	name := strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(signal)), "SIG")
+	// + and - only appear inside names such as RTMIN+3
+	if name == "" || strings.Trim(name, "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789+-") != "" || strings.ContainsAny(name[:1], "+-") {
+		return "", NewError(ErrUsage, "invalid signal %q", signal)
+	}
+	if strings.Trim(name, "0123456789") == "" {
+		return name, nil
+	}
+	return "SIG" + name, nil
+}
+
+// Kill sends a signal to the containers of a service, or of every service in
+// scope when serviceName is empty
+func (dcm *Manager) Kill(serviceName, signal string) (string, error) {
+	signal, err := normalizeSignal(signal)
+	if err != nil {
+		dcm.PrintError(err)
+		return "", err
+	}
+	dcm.Infof("Sending %s to services...\n", signal)
+	return dcm.serviceCommand(serviceName, "kill", "-s", signal)
+}
+
+// reloadSignal returns the signal that makes a service reload its config
+func (dcm *Manager) reloadSignal(service string) string {
+	if signal := dcm.config.ReloadSignals[service]; signal != "" {
+		return signal
+	}
+	return defaultReloadSignal
+}
+
+// Reload asks a service to reload its config without restarting, by sending
+// its reload signal, SIGHUP unless reload_signals in the config says otherwise
+func (dcm *Manager) Reload(serviceName string) (string, error) {
+	if serviceName == "" {
+		err := NewError(ErrUsage, "usage: reload <service>")
+		dcm.PrintError(err)
+		return "", err
+	}
+	return dcm.Kill(serviceName, dcm.reloadSignal(serviceName))
+}
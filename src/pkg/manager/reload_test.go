@@ -0,0 +1,58 @@
+package manager
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeSignal(t *testing.T) {
+	tests := map[string]string{
+		"HUP":     "SIGHUP",
+		"hup":     "SIGHUP",
+		"SIGUSR1": "SIGUSR1",
+		" usr2 ":  "SIGUSR2",
+		"sigterm": "SIGTERM",
+		"9":       "9",
+		"RTMIN+3": "SIGRTMIN+3",
+	}
+	for signal, want := range tests {
+		if got, err := normalizeSignal(signal); err != nil || got != want {
+			t.Errorf("normalizeSignal(%q) = %q, %v, want %q", signal, got, err, want)
+		}
+	}
+	for _, signal := range []string{"", "SIG", "HUP;rm", "-9", "HUP TERM"} {
+		if _, err := normalizeSignal(signal); TypeOf(err) != ErrUsage {
+			t.Errorf("normalizeSignal(%q) = %v, want a %s error", signal, err, ErrUsage)
+		}
+	}
+}
+
+func TestReloadSendsConfiguredSignal(t *testing.T) {
+	runner := &fakeRunner{}
+	dcm := newTestManager(t, "reload_signals:\n  worker: usr1\n", "", runner)
+
+	for service, signal := range map[string]string{"web": "SIGHUP", "worker": "SIGUSR1"} {
+		if _, err := dcm.Reload(service); err != nil {
+			t.Fatalf("Reload(%s): %v", service, err)
+		}
+		kills := runner.ran("kill -s " + signal + " " + service)
+		if len(kills) != 1 || !strings.HasSuffix(kills[0], " "+service) {
+			t.Errorf("Reload(%s) ran %q, want compose kill -s %s", service, runner.commands(), signal)
+		}
+	}
+}
+
+func TestReloadNeedsService(t *testing.T) {
+	runner := &fakeRunner{}
+	dcm := newTestManager(t, "", "", runner)
+
+	if _, err := dcm.Reload(""); TypeOf(err) != ErrUsage {
+		t.Errorf("Reload without a service = %v, want a %s error", err, ErrUsage)
+	}
+	if _, err := dcm.Reload("api"); TypeOf(err) != ErrServiceNotFound {
+		t.Errorf("Reload(api) = %v, want a %s error", err, ErrServiceNotFound)
+	}
+	if len(runner.calls) > 0 {
+		t.Errorf("ran %q", runner.commands())
+	}
+}
@@ -0,0 +1,284 @@
+package manager
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// dockerEndpointVars are the variables through which the docker CLI and
+// compose pick the engine they talk to
+var dockerEndpointVars = []string{"DOCKER_HOST", "DOCKER_CONTEXT", "DOCKER_TLS_VERIFY", "DOCKER_CERT_PATH"}
+
+// DockerEndpoint is the Docker engine a project runs on, the local one when
+// empty. It is set either as a host or as a docker context.
+type DockerEndpoint struct {
+	// Host is a DOCKER_HOST, such as ssh://user@host or tcp://host:2376
+	Host string `yaml:"docker_host"`
+	// Context is the name of a docker context, see `dcm context list`
+	Context string `yaml:"docker_context"`
+	// TLSVerify and CertPath secure a tcp:// host; CertPath is the
+	// directory of ca.pem, cert.pem and key.pem, relative to the config file
+	TLSVerify bool   `yaml:"tls_verify"`
+	CertPath  string `yaml:"cert_path"`
+}
+
+func (e DockerEndpoint) empty() bool {
+	return e.Host == "" && e.Context == "" && !e.TLSVerify && e.CertPath == ""
+}
+
+// String describes the endpoint for listings
+func (e DockerEndpoint) String() string {
+	switch {
+	case e.Host != "":
+		return e.Host
+	case e.Context != "":
+		return "context " + e.Context
+	}
+	return "local"
+}
+
+// checkEndpoint validates the docker endpoint of the project
+func (dcm *Manager) checkEndpoint() error {
+	e := dcm.config.Endpoint
+	if e.Host != "" && e.Context != "" {
+		return NewError(ErrConfig, "%s: set either docker_host or docker_context, not both", dcm.configPath)
+	}
+	if e.Host != "" {
+		u, err := url.Parse(e.Host)
+		if err != nil {
+			return NewError(ErrConfig, "%s: docker_host: %v", dcm.configPath, err)
+		}
+		switch u.Scheme {
+		case "unix", "tcp", "ssh", "npipe":
+		default:
+			return NewError(ErrConfig, "%s: docker_host %s: expected a unix://, tcp://, ssh:// or npipe:// address",
+				dcm.configPath, e.Host)
+		}
+		if (e.TLSVerify || e.CertPath != "") && u.Scheme != "tcp" {
+			return NewError(ErrConfig, "%s: tls_verify and cert_path apply to tcp:// hosts only", dcm.configPath)
+		}
+	} else if e.TLSVerify || e.CertPath != "" {
+		return NewError(ErrConfig, "%s: tls_verify and cert_path need a tcp:// docker_host", dcm.configPath)
+	}
+	if e.CertPath != "" {
+		if info, err := os.Stat(e.CertPath); err != nil || !info.IsDir() {
+			return NewError(ErrConfig, "%s: cert_path %s is not a directory", dcm.configPath, e.CertPath)
+		}
+	}
+	return nil
+}
+
+// endpointVars returns the values of dockerEndpointVars for the endpoint of
+// the project, empty for the variables it leaves unset, or nil when the
+// project uses the endpoint of the environment
+func (dcm *Manager) endpointVars() map[string]string {
+	e := dcm.config.Endpoint
+	if e.Host == "" && e.Context == "" {
+		return nil
+	}
+	vars := map[string]string{"DOCKER_HOST": e.Host, "DOCKER_CONTEXT": e.Context}
+	if e.TLSVerify {
+		vars["DOCKER_TLS_VERIFY"] = "1"
+	} else {
+		vars["DOCKER_TLS_VERIFY"] = ""
+	}
+	vars["DOCKER_CERT_PATH"] = e.CertPath
+	return vars
+}
+
+// endpointEnv returns env, or the host environment when nil, with the
+// endpoint of the project in place of the one it names
+func (dcm *Manager) endpointEnv(env []string) []string {
+	vars := dcm.endpointVars()
+	if vars == nil {
+		return env
+	}
+	if env == nil {
+		env = os.Environ()
+	}
+	out := make([]string, 0, len(env)+len(vars))
+	for _, kv := range env {
+		if _, ok := vars[strings.SplitN(kv, "=", 2)[0]]; !ok {
+			out = append(out, kv)
+		}
+	}
+	for _, name := range dockerEndpointVars {
+		if vars[name] != "" {
+			out = append(out, name+"="+vars[name])
+		}
+	}
+	return out
+}
+
+// ExportEndpoint points the environment of this process at the endpoint of
+// the project, so the docker commands run outside compose target it too
+func (dcm *Manager) ExportEndpoint() {
+	vars := dcm.endpointVars()
+	for _, name := range dockerEndpointVars {
+		if vars == nil {
+			return
+		}
+		if vars[name] == "" {
+			os.Unsetenv(name)
+		} else {
+			os.Setenv(name, vars[name])
+		}
+	}
+}
+
+// dockerHost returns the engine address of the project for the api backend
+func (dcm *Manager) dockerHost() (string, error) {
+	if dcm.config.Endpoint.Context != "" {
+		return "", NewError(ErrConfig, "docker_context %s: the api backend needs a docker_host", dcm.config.Endpoint.Context)
+	}
+	if dcm.config.Endpoint.Host != "" {
+		return dcm.config.Endpoint.Host, nil
+	}
+	return os.Getenv("DOCKER_HOST"), nil
+}
+
+// tlsConfig returns the TLS settings of a tcp:// host with tls_verify, from
+// the ca.pem, cert.pem and key.pem of cert_path, ~/.docker by default as
+// for the docker CLI
+func (e DockerEndpoint) tlsConfig() (*tls.Config, error) {
+	dir := e.CertPath
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, NewError(ErrConfig, "tls_verify: %v", err)
+		}
+		dir = filepath.Join(home, ".docker")
+	}
+	ca, err := ioutil.ReadFile(filepath.Join(dir, "ca.pem"))
+	if err != nil {
+		return nil, NewError(ErrConfig, "tls_verify: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, NewError(ErrConfig, "tls_verify: no certificate in %s", filepath.Join(dir, "ca.pem"))
+	}
+	cert, err := tls.LoadX509KeyPair(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"))
+	if err != nil {
+		return nil, NewError(ErrConfig, "tls_verify: %v", err)
+	}
+	return &tls.Config{RootCAs: pool, Certificates: []tls.Certificate{cert}}, nil
+}
+
+// contextsPath is the file recording the context selected with
+// `dcm context use` for each project
+func (dcm *Manager) contextsPath() string {
+	return filepath.Join(dcm.stateDir(), "contexts.json")
+}
+
+// selectedContexts returns the contexts selected for the projects
+func (dcm *Manager) selectedContexts() map[string]string {
+	contexts := make(map[string]string)
+	readJSONFile(dcm.contextsPath(), &contexts)
+	return contexts
+}
+
+// DockerContext is a docker context as `docker context ls` lists it
+type DockerContext struct {
+	Name           string `json:"Name"`
+	Description    string `json:"Description"`
+	DockerEndpoint string `json:"DockerEndpoint"`
+	Current        bool   `json:"Current"`
+}
+
+// dockerContexts lists the docker contexts
+func (dcm *Manager) dockerContexts() ([]DockerContext, error) {
+	output, err := dcm.dockerOutput("context", "ls", "--format", "{{json .}}")
+	if err != nil {
+		return nil, err
+	}
+	var contexts []DockerContext
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		if line == "" {
+			continue
+		}
+		var c DockerContext
+		if err := json.Unmarshal([]byte(line), &c); err != nil {
+			return nil, fmt.Errorf("could not parse docker context ls output: %w", err)
+		}
+		contexts = append(contexts, c)
+	}
+	return contexts, nil
+}
+
+// PrintContexts lists the docker contexts, marking with a * the one the
+// project runs on
+func (dcm *Manager) PrintContexts() error {
+	contexts, err := dcm.dockerContexts()
+	if err != nil {
+		return err
+	}
+	using := dcm.config.Endpoint.Context
+	if dcm.config.Endpoint.Host != "" {
+		fmt.Printf("%s runs on %s from %s\n", dcm.ProjectName(), dcm.config.Endpoint.Host, dcm.configPath)
+	}
+	if using == "" && dcm.config.Endpoint.Host == "" {
+		using = os.Getenv("DOCKER_CONTEXT")
+	}
+	sort.Slice(contexts, func(i, j int) bool { return contexts[i].Name < contexts[j].Name })
+	fmt.Printf("  %-20s %-40s %s\n", "NAME", "DOCKER ENDPOINT", "DESCRIPTION")
+	for _, c := range contexts {
+		mark := " "
+		if dcm.config.Endpoint.Host == "" && (c.Name == using || (using == "" && c.Current)) {
+			mark = "*"
+		}
+		fmt.Printf("%s %-20s %-40s %s\n", mark, c.Name, c.DockerEndpoint, c.Description)
+	}
+	return nil
+}
+
+// UseContext selects the docker context the project runs on when its
+// config sets no endpoint; "default" goes back to the context of docker
+func (dcm *Manager) UseContext(name string) error {
+	contexts, err := dcm.dockerContexts()
+	if err != nil {
+		return err
+	}
+	var names []string
+	found := false
+	for _, c := range contexts {
+		names = append(names, c.Name)
+		found = found || c.Name == name
+	}
+	if !found {
+		return NewError(ErrUsage, "no docker context %q%s", name, DidYouMean(name, names))
+	}
+
+	selected := dcm.selectedContexts()
+	project := dcm.ProjectName()
+	if name == "default" {
+		delete(selected, project)
+	} else {
+		selected[project] = name
+	}
+	if err := writeJSONFile(dcm.contextsPath(), selected); err != nil {
+		return err
+	}
+	if configured := dcm.fileEndpoint(); !configured.empty() {
+		dcm.Warnf("%s sets %s for %s, which takes precedence over the selected context\n",
+			dcm.configPath, configured, project)
+	}
+	fmt.Printf("%s now runs on docker context %s\n", project, name)
+	return nil
+}
+
+// fileEndpoint returns the endpoint the config file sets for the project,
+// before the context selected with `dcm context use`
+func (dcm *Manager) fileEndpoint() DockerEndpoint {
+	if p, ok := dcm.fileConfig.Projects[dcm.project]; ok && !p.Endpoint.empty() {
+		return p.Endpoint
+	}
+	return dcm.fileConfig.Endpoint
+}
@@ -0,0 +1,55 @@
+package manager
+
+import (
+	"fmt"
+)
+
+// dependencyOrder returns services so that each comes after the services it
+// depends on through depends_on
+func dependencyOrder(project *composeProject, services []string) ([]string, error) {
+	deps := make(map[string][]string)
+	for name, service := range project.Services {
+		deps[name] = service.DependsOn
+	}
+	order, err := topoSort(services, deps)
+	if err != nil {
+		return nil, NewError(ErrConfig, "%w", err)
+	}
+	// Dependencies outside the services asked for are left alone
+	return filterServices(order, services), nil
+}
+
+// GracefulRestart restarts the services in scope one at a time in
+// dependency order, waiting for each to be running and healthy before
+// restarting the next, so a service never restarts while something it
+// depends on is down. It stops at the first service that does not become
+// ready.
+func (dcm *Manager) GracefulRestart(opts WaitOptions) error {
+	project, err := dcm.composeProject()
+	if err != nil {
+		return err
+	}
+	services := dcm.scopedServices()
+	if len(services) == 0 {
+		services = project.Names
+	}
+	if len(services) == 0 {
+		return NewError(ErrConfig, "no services to restart, set compose_file in %s", dcm.configPath)
+	}
+	order, err := dependencyOrder(project, services)
+	if err != nil {
+		return err
+	}
+
+	for i, name := range order {
+		dcm.Infof("[%d/%d] Restarting %s...\n", i+1, len(order), name)
+		if err := dcm.composeStreaming("restart", name); err != nil {
+			return fmt.Errorf("restarting %s: %w", name, err)
+		}
+		if err := dcm.WaitReady([]string{name}, opts); err != nil {
+			return fmt.Errorf("graceful restart stopped at %s, %d of %d services restarted: %w", name, i, len(order), err)
+		}
+	}
+	dcm.Infof("Restarted %d services\n", len(order))
+	return nil
+}
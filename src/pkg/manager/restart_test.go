@@ -0,0 +1,78 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// fakeScale simulates the containers of a scaled service: compose restart
+// leaves a single one running, as compose did when it recreated a service,
+// and up --scale sets their number.
+func fakeScale(runner *fakeRunner, service string, replicas *int) {
+	runner.handle("ps -q "+service, func(_ context.Context, cmd *Cmd) error {
+		for i := 1; i <= *replicas; i++ {
+			fmt.Fprintf(cmd.Stdout, "%s%d\n", service, i)
+		}
+		return nil
+	})
+	runner.handle("restart "+service, func(context.Context, *Cmd) error {
+		*replicas = 1
+		return nil
+	})
+	runner.handle("--scale "+service+"=", func(_ context.Context, cmd *Cmd) error {
+		for i, arg := range cmd.Args {
+			if arg == "--scale" && i+1 < len(cmd.Args) {
+				fmt.Sscanf(strings.TrimPrefix(cmd.Args[i+1], service+"="), "%d", replicas)
+			}
+		}
+		return nil
+	})
+}
+
+func TestRestartPreservesScale(t *testing.T) {
+	runner := &fakeRunner{}
+	replicas := 3
+	fakeScale(runner, "worker", &replicas)
+	dcm := newTestManager(t, "", "", runner)
+
+	if _, err := dcm.RestartService("worker"); err != nil {
+		t.Fatalf("RestartService: %v", err)
+	}
+	if replicas != 3 {
+		t.Errorf("worker has %d replicas after the restart, want 3 (commands: %q)", replicas, runner.commands())
+	}
+	ups := runner.ran(" up ")
+	if len(ups) != 1 || !strings.Contains(ups[0], "--no-recreate --scale worker=3 worker") {
+		t.Errorf("up commands = %q, want one restoring worker=3", ups)
+	}
+}
+
+func TestRestartSingleReplicaIsNotRescaled(t *testing.T) {
+	runner := &fakeRunner{}
+	replicas := 1
+	fakeScale(runner, "web", &replicas)
+	dcm := newTestManager(t, "", "", runner)
+
+	if _, err := dcm.RestartService("web"); err != nil {
+		t.Fatalf("RestartService: %v", err)
+	}
+	if ups := runner.ran(" up "); len(ups) > 0 {
+		t.Errorf("a single replica service was scaled: %q", ups)
+	}
+}
+
+func TestRestartWithoutPreserveScaleFeature(t *testing.T) {
+	runner := &fakeRunner{}
+	replicas := 3
+	fakeScale(runner, "worker", &replicas)
+	dcm := newTestManager(t, "features:\n  restart_preserves_scale: false\n", "", runner)
+
+	if _, err := dcm.RestartService("worker"); err != nil {
+		t.Fatalf("RestartService: %v", err)
+	}
+	if replicas != 1 {
+		t.Errorf("worker has %d replicas, want the plain restart to leave 1", replicas)
+	}
+}
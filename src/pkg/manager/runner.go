@@ -0,0 +1,152 @@
+package manager
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os/exec"
+)
+
+// Cmd is a command for a Runner to run
+type Cmd struct {
+	// Args is the program and its arguments
+	Args []string
+	// Env is the environment of the command, nil for that of the process
+	Env []string
+	// Dir is the working directory of the command, empty for that of the
+	// process
+	Dir    string
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Runner runs the compose and docker commands of a Manager. ExecRunner runs
+// them as processes; WithRunner swaps it, e.g. for a fake in tests.
+type Runner interface {
+	// Run runs cmd until it exits or ctx is done. A command exiting
+	// non-zero returns an error with an ExitCode() int method, like
+	// *exec.ExitError.
+	Run(ctx context.Context, cmd *Cmd) error
+}
+
+// ExecRunner is the Runner starting commands as processes
+type ExecRunner struct{}
+
+// Run runs cmd as a process, killed when ctx is done
+func (ExecRunner) Run(ctx context.Context, c *Cmd) error {
+	cmd := exec.CommandContext(ctx, c.Args[0], c.Args[1:]...)
+	cmd.Env = c.Env
+	cmd.Dir = c.Dir
+	cmd.Stdin = c.Stdin
+	cmd.Stdout = c.Stdout
+	cmd.Stderr = c.Stderr
+	return cmd.Run()
+}
+
+// command is a command of the manager run through its Runner, with the
+// methods of exec.Cmd the manager uses
+type command struct {
+	Cmd
+	ctx    context.Context
+	runner Runner
+
+	// pipe and done are set by StdoutPipe and Start
+	pipe *io.PipeReader
+	done chan error
+}
+
+// command prepares a compose command with the environment of the config
+func (dcm *Manager) command(ctx context.Context, argv []string) *command {
+	return &command{Cmd: Cmd{Args: argv, Env: dcm.env}, ctx: ctx, runner: dcm.runner}
+}
+
+// hostCommand prepares a command run with the environment of the process,
+// such as the docker CLI, which ExportEndpoint points at the configured
+// daemon
+func (dcm *Manager) hostCommand(ctx context.Context, argv ...string) *command {
+	return &command{Cmd: Cmd{Args: argv}, ctx: ctx, runner: dcm.runner}
+}
+
+// Run runs the command until it exits
+func (c *command) Run() error {
+	return c.runner.Run(c.ctx, &c.Cmd)
+}
+
+// exitError is a command run by Output that exited non-zero. Like
+// *exec.ExitError, it keeps the standard error of the command, whatever the
+// Runner.
+type exitError struct {
+	exitCoder
+	Stderr []byte
+}
+
+func (e *exitError) Unwrap() error { return e.exitCoder }
+
+// Output runs the command and returns its standard output. Like exec.Cmd,
+// the standard error of a command exiting non-zero is kept in its error, an
+// *exitError, unless Stderr was set.
+func (c *command) Output() ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	c.Stdout = &stdout
+	if c.Stderr == nil {
+		c.Stderr = &stderr
+	}
+	err := c.Run()
+	var coded exitCoder
+	if errors.As(err, &coded) {
+		err = &exitError{exitCoder: coded, Stderr: stderr.Bytes()}
+	}
+	return stdout.Bytes(), err
+}
+
+// CombinedOutput runs the command and returns its standard output and
+// standard error together
+func (c *command) CombinedOutput() ([]byte, error) {
+	var output bytes.Buffer
+	c.Stdout = &output
+	c.Stderr = &output
+	err := c.Run()
+	return output.Bytes(), err
+}
+
+// StdoutPipe returns a reader of the standard output of the command once
+// started with Start
+func (c *command) StdoutPipe() (io.ReadCloser, error) {
+	if c.Stdout != nil {
+		return nil, errors.New("Stdout already set")
+	}
+	r, w := io.Pipe()
+	c.pipe = r
+	c.Stdout = w
+	return r, nil
+}
+
+// Start runs the command in the background, for Wait to collect
+func (c *command) Start() error {
+	if c.done != nil {
+		return errors.New("already started")
+	}
+	c.done = make(chan error, 1)
+	go func() {
+		err := c.Run()
+		if w, ok := c.Stdout.(*io.PipeWriter); ok && c.pipe != nil {
+			w.CloseWithError(err)
+		}
+		c.done <- err
+	}()
+	return nil
+}
+
+// Wait waits for a started command to exit. Like exec.Cmd, it closes the
+// reader of StdoutPipe, so output nobody reads does not block the command.
+func (c *command) Wait() error {
+	if c.done == nil {
+		return errors.New("not started")
+	}
+	if c.pipe != nil {
+		c.pipe.Close()
+	}
+	return <-c.done
+}
@@ -0,0 +1,186 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeExit is the error of a fake command exiting non-zero
+type fakeExit int
+
+func (e fakeExit) Error() string { return fmt.Sprintf("exit status %d", int(e)) }
+
+func (e fakeExit) ExitCode() int { return int(e) }
+
+// fakeResponse answers the commands whose argv contains key
+type fakeResponse struct {
+	key string
+	run func(ctx context.Context, cmd *Cmd) error
+}
+
+// fakeRunner is a Runner recording the commands it is given and answering
+// them from canned responses. Commands without a response succeed silently.
+type fakeRunner struct {
+	mu        sync.Mutex
+	calls     [][]string
+	responses []fakeResponse
+}
+
+func (r *fakeRunner) Run(ctx context.Context, cmd *Cmd) error {
+	r.mu.Lock()
+	r.calls = append(r.calls, append([]string(nil), cmd.Args...))
+	argv := strings.Join(cmd.Args, " ")
+	var run func(context.Context, *Cmd) error
+	for _, resp := range r.responses {
+		if strings.Contains(argv, resp.key) {
+			run = resp.run
+			break
+		}
+	}
+	r.mu.Unlock()
+	if run == nil {
+		return nil
+	}
+	return run(ctx, cmd)
+}
+
+// handle answers the commands containing key with fn
+func (r *fakeRunner) handle(key string, fn func(ctx context.Context, cmd *Cmd) error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.responses = append(r.responses, fakeResponse{key, fn})
+}
+
+// stdout answers the commands containing key with output
+func (r *fakeRunner) stdout(key, output string) {
+	r.handle(key, func(_ context.Context, cmd *Cmd) error {
+		if cmd.Stdout != nil {
+			io.WriteString(cmd.Stdout, output)
+		}
+		return nil
+	})
+}
+
+// fail makes the commands containing key exit with code, writing stderr
+func (r *fakeRunner) fail(key string, code int, stderr string) {
+	r.handle(key, func(_ context.Context, cmd *Cmd) error {
+		if cmd.Stderr != nil {
+			io.WriteString(cmd.Stderr, stderr)
+		}
+		return fakeExit(code)
+	})
+}
+
+// commands returns the argv of the commands run so far, joined by spaces
+func (r *fakeRunner) commands() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var commands []string
+	for _, argv := range r.calls {
+		commands = append(commands, strings.Join(argv, " "))
+	}
+	return commands
+}
+
+// ran returns the commands run so far that contain key
+func (r *fakeRunner) ran(key string) []string {
+	var matching []string
+	for _, command := range r.commands() {
+		if strings.Contains(command, key) {
+			matching = append(matching, command)
+		}
+	}
+	return matching
+}
+
+// testCompose is the compose file of the tests unless they bring their own
+const testCompose = `services:
+  web:
+    image: nginx
+  worker:
+    image: busybox
+  db:
+    image: postgres
+`
+
+// newTestManager writes config and compose files to a temp dir and returns a
+// manager for them running its commands with runner
+func newTestManager(t *testing.T, config, compose string, runner Runner, opts ...Option) *Manager {
+	t.Helper()
+	dir := t.TempDir()
+	if compose == "" {
+		compose = testCompose
+	}
+	if err := os.WriteFile(filepath.Join(dir, "compose.yaml"), []byte(compose), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(config, "compose_file") {
+		config = "compose_file: compose.yaml\n" + config
+	}
+	if !strings.Contains(config, "project_name") {
+		config = "project_name: test\n" + config
+	}
+	path := filepath.Join(dir, "dcm.config.yml")
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	opts = append([]Option{WithRunner(runner), WithComposeCommand("docker compose"), WithQuiet()}, opts...)
+	dcm, err := New(path, opts...)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return dcm
+}
+
+func TestCommandOutputKeepsStderrOfFailures(t *testing.T) {
+	runner := &fakeRunner{}
+	runner.fail("inspect", 3, "no such container")
+	dcm := newTestManager(t, "", "", runner)
+
+	_, err := dcm.hostCommand(context.Background(), "docker", "inspect", "x").Output()
+	if code := ExitCode(err); code != 3 {
+		t.Errorf("exit code = %d, want 3", code)
+	}
+	if typ := TypeOf(err); typ != ErrCommandFailed {
+		t.Errorf("error type = %s, want %s", typ, ErrCommandFailed)
+	}
+	var exitErr *exitError
+	if !errors.As(err, &exitErr) || string(exitErr.Stderr) != "no such container" {
+		t.Errorf("error = %#v, want the standard error kept", err)
+	}
+	if _, err := dcm.dockerOutput("inspect", "x"); err == nil || !strings.Contains(err.Error(), "no such container") {
+		t.Errorf("dockerOutput = %v, want the standard error in the message", err)
+	}
+}
+
+func TestCommandStdoutPipe(t *testing.T) {
+	runner := &fakeRunner{}
+	runner.stdout("logs", "one\ntwo\n")
+	dcm := newTestManager(t, "", "", runner)
+
+	cmd := dcm.command(context.Background(), []string{"docker", "compose", "logs"})
+	pipe, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	output, err := io.ReadAll(pipe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if string(output) != "one\ntwo\n" {
+		t.Errorf("output = %q", output)
+	}
+}
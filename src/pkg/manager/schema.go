@@ -0,0 +1,402 @@
+package manager
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// configVersion is the version of the config format written by this dcm.
+// A config without a version key is version 1, the format shared with the
+// Python and Node tools: its unknown keys are reported as warnings and its
+// environments section is read as projects. From version 2 on, unknown keys
+// are errors.
+const configVersion = 2
+
+var (
+	unknownFieldError = regexp.MustCompile(`^line (\d+): field (.+) not found in type (\S+)$`)
+	typeError         = regexp.MustCompile("^line (\\d+): cannot unmarshal !!(\\w+) `(.*)` into (\\S+)$")
+	lineError         = regexp.MustCompile(`^line (\d+): (.*)$`)
+)
+
+// sharedSections are the top-level sections of the format shared with the
+// Python and Node tools that only those tools read. dcm leaves them alone in
+// every version, so that one config file serves all the tools.
+var sharedSections = map[string]bool{
+	"environments": true,
+	"project":      true,
+	"monitoring":   true,
+	"deployment":   true,
+	"backup":       true,
+	"logging":      true,
+	"resources":    true,
+}
+
+// configProblem is a key or value of the config file that does not fit the
+// schema
+type configProblem struct {
+	Line    int
+	Message string
+	// Key and Type are set for a key the schema does not define: the key
+	// and the Go type of the section it is in
+	Key  string
+	Type string
+}
+
+// configKeys maps the Go types of the config to the keys they accept
+var configKeys = func() map[string][]string {
+	keys := make(map[string][]string)
+	var walk func(t reflect.Type)
+	walk = func(t reflect.Type) {
+		for t.Kind() == reflect.Map || t.Kind() == reflect.Slice || t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct {
+			return
+		}
+		if _, ok := keys[t.String()]; ok {
+			return
+		}
+		keys[t.String()] = yamlKeys(t)
+		for i := 0; i < t.NumField(); i++ {
+			walk(t.Field(i).Type)
+		}
+	}
+	walk(reflect.TypeOf(Config{}))
+	return keys
+}()
+
+// yamlKeys returns the keys of a struct as yaml reads them, those of inline
+// structs included
+func yamlKeys(t reflect.Type) []string {
+	var keys []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		tag := strings.Split(f.Tag.Get("yaml"), ",")
+		if tag[0] == "-" {
+			continue
+		}
+		if len(tag) > 1 && tag[1] == "inline" {
+			if f.Type.Kind() == reflect.Struct {
+				keys = append(keys, yamlKeys(f.Type)...)
+			}
+			continue
+		}
+		if tag[0] == "" {
+			tag[0] = strings.ToLower(f.Name)
+		}
+		keys = append(keys, tag[0])
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// yamlKinds names the YAML tags in messages
+var yamlKinds = map[string]string{
+	"str":   "a string",
+	"int":   "a number",
+	"float": "a number",
+	"bool":  "a boolean",
+	"seq":   "a list",
+	"map":   "a mapping",
+	"null":  "nothing",
+}
+
+// describeGoType names what a Go type of the config expects in messages
+func describeGoType(t string) string {
+	switch {
+	case strings.HasPrefix(t, "int") || strings.HasPrefix(t, "uint") || strings.HasPrefix(t, "float"):
+		return "a number"
+	case t == "bool":
+		return "true or false"
+	case t == "string":
+		return "a string"
+	case strings.HasPrefix(t, "[]") || t == "manager.stringList":
+		return "a list"
+	}
+	return "a mapping"
+}
+
+// checkConfigSchema decodes the config text strictly and returns the keys
+// the schema does not define and the values of the wrong type, with their
+// line. Keys starting with x- are left for other tools, as in compose files,
+// as are the sharedSections, and values holding ${...} placeholders are
+// checked once resolved.
+func checkConfigSchema(data []byte) ([]configProblem, error) {
+	var config Config
+	err := yaml.UnmarshalStrict(data, &config)
+	if err == nil {
+		return nil, nil
+	}
+	typeErr, ok := err.(*yaml.TypeError)
+	if !ok {
+		return nil, err
+	}
+	var problems []configProblem
+	for _, msg := range typeErr.Errors {
+		var p configProblem
+		if m := unknownFieldError.FindStringSubmatch(msg); m != nil {
+			if strings.HasPrefix(m[2], "x-") || (m[3] == "manager.Config" && sharedSections[m[2]]) {
+				continue
+			}
+			fmt.Sscan(m[1], &p.Line)
+			p.Key, p.Type = m[2], m[3]
+			p.Message = fmt.Sprintf("unknown key %q%s", m[2], DidYouMean(m[2], configKeys[m[3]]))
+		} else if m := typeError.FindStringSubmatch(msg); m != nil {
+			if strings.Contains(m[3], "${") {
+				continue
+			}
+			fmt.Sscan(m[1], &p.Line)
+			got := yamlKinds[m[2]]
+			if got == "" {
+				got = m[2]
+			}
+			p.Message = fmt.Sprintf("expected %s, got %s %q", describeGoType(m[4]), got, m[3])
+		} else if m := lineError.FindStringSubmatch(msg); m != nil {
+			fmt.Sscan(m[1], &p.Line)
+			p.Message = m[2]
+		} else {
+			p.Message = msg
+		}
+		problems = append(problems, p)
+	}
+	return problems, nil
+}
+
+// formatConfigProblem prefixes a problem with the file and line it is on
+func formatConfigProblem(path string, p configProblem) string {
+	if p.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", path, p.Line, p.Message)
+	}
+	return fmt.Sprintf("%s: %s", path, p.Message)
+}
+
+// configFileVersion returns the version key of the config text, 1 without
+// one
+func configFileVersion(path string, data []byte) (int, error) {
+	var doc struct {
+		Version interface{} `yaml:"version"`
+	}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return 0, NewError(ErrConfig, "parsing config file %s: %w", path, err)
+	}
+	if doc.Version == nil {
+		return 1, nil
+	}
+	version, ok := doc.Version.(int)
+	if !ok || version < 1 {
+		return 0, NewError(ErrConfig, "%s: version: expected a number such as %d, got %v", path, configVersion, doc.Version)
+	}
+	if version > configVersion {
+		return 0, NewError(ErrConfig, "%s: version %d is newer than this dcm supports (%d), upgrade dcm", path, version, configVersion)
+	}
+	return version, nil
+}
+
+// checkConfigFile reports the problems of the config text against the
+// schema: all are errors from version 2, while version 1 only fails on
+// values of the wrong type and warns about unknown keys
+func (dcm *Manager) checkConfigFile(data []byte, version int) error {
+	problems, err := checkConfigSchema(data)
+	if err != nil {
+		return NewError(ErrConfig, "parsing config file %s: %w", dcm.configPath, err)
+	}
+	var errs []string
+	warned := false
+	for _, p := range problems {
+		if p.Key != "" && version < 2 {
+			dcm.Warnf("%s\n", formatConfigProblem(dcm.configPath, p))
+			warned = true
+			continue
+		}
+		errs = append(errs, formatConfigProblem(dcm.configPath, p))
+	}
+	if warned {
+		dcm.Warnf("%s has no version, so unknown keys are ignored; run `dcm config migrate` to upgrade it to version %d\n",
+			dcm.configPath, configVersion)
+	}
+	if len(errs) > 0 {
+		return NewError(ErrConfig, "invalid config:\n  %s", strings.Join(errs, "\n  "))
+	}
+	return nil
+}
+
+// configMigrations upgrade a config document: the one at index i from
+// version i+1 to i+2. They return notes on what they changed.
+var configMigrations = []func(doc yaml.MapSlice, dir string) (yaml.MapSlice, []string){
+	migrateConfigV1,
+}
+
+// mapItem returns the value of a key of a document and its index, -1 when
+// the key is missing
+func mapItem(doc yaml.MapSlice, key string) (interface{}, int) {
+	for i, item := range doc {
+		if fmt.Sprint(item.Key) == key {
+			return item.Value, i
+		}
+	}
+	return nil, -1
+}
+
+// migrateConfigV1 copies the environments of the format shared with the
+// Python and Node tools to projects, leaving the environments for those
+// tools. They run compose without a project name, so a project keeps the
+// name of the directory unless its environment sets one. The keys dcm has
+// no use for are kept with an x- prefix.
+func migrateConfigV1(doc yaml.MapSlice, dir string) (yaml.MapSlice, []string) {
+	value, index := mapItem(doc, "environments")
+	environments, ok := value.(yaml.MapSlice)
+	if index < 0 || !ok {
+		return doc, nil
+	}
+	var notes []string
+	known := make(map[string]bool)
+	for _, key := range configKeys["manager.ProjectConfig"] {
+		known[key] = true
+	}
+	projectsValue, projectsIndex := mapItem(doc, "projects")
+	projects, _ := projectsValue.(yaml.MapSlice)
+	for _, env := range environments {
+		name := fmt.Sprint(env.Key)
+		if _, i := mapItem(projects, name); i >= 0 {
+			notes = append(notes, fmt.Sprintf("environments.%s: projects.%s is already defined, keeping it", name, name))
+			continue
+		}
+		settings, _ := env.Value.(yaml.MapSlice)
+		var project yaml.MapSlice
+		if _, i := mapItem(settings, "project_name"); i < 0 {
+			project = append(project, yaml.MapItem{Key: "project_name", Value: sanitizeProjectName(filepath.Base(dir))})
+		}
+		for _, item := range settings {
+			key := fmt.Sprint(item.Key)
+			if !known[key] {
+				notes = append(notes, fmt.Sprintf("environments.%s.%s: not used by dcm, kept as x-%s", name, key, key))
+				key = "x-" + key
+			}
+			project = append(project, yaml.MapItem{Key: key, Value: item.Value})
+		}
+		projects = append(projects, yaml.MapItem{Key: name, Value: project})
+		notes = append(notes, fmt.Sprintf("environments.%s: copied to projects.%s", name, name))
+	}
+
+	migrated := append(yaml.MapSlice(nil), doc...)
+	if projectsIndex >= 0 {
+		migrated[projectsIndex].Value = projects
+	} else {
+		migrated = append(migrated, yaml.MapItem{Key: "projects", Value: projects})
+	}
+	return migrated, notes
+}
+
+// migrateDocument upgrades a config document from version to the current
+// one
+func migrateDocument(doc yaml.MapSlice, version int, dir string) (yaml.MapSlice, []string) {
+	var notes []string
+	for v := version; v < configVersion; v++ {
+		var changes []string
+		doc, changes = configMigrations[v-1](doc, dir)
+		notes = append(notes, changes...)
+	}
+	return doc, notes
+}
+
+// migrateConfig upgrades the config text read from the file to the current
+// version for decoding, leaving the file as it is
+func (dcm *Manager) migrateConfig(data []byte, version int) ([]byte, error) {
+	var doc yaml.MapSlice
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, NewError(ErrConfig, "parsing config file %s: %w", dcm.configPath, err)
+	}
+	doc, notes := migrateDocument(doc, version, filepath.Dir(dcm.configPath))
+	for _, note := range notes {
+		dcm.verbosef("Config version %d: %s\n", version, note)
+	}
+	return yaml.Marshal(doc)
+}
+
+// MigrateConfig rewrites the config file in the current version of the
+// format, keeping the original next to it with a .bak suffix. The top-level
+// keys dcm does not know, other than the sharedSections, are kept with an
+// x- prefix; unknown keys left
+// elsewhere are reported and the file is not changed. With --dry-run the
+// migrated config is printed instead.
+func (dcm *Manager) MigrateConfig() error {
+	data, err := ioutil.ReadFile(dcm.configPath)
+	if err != nil {
+		return NewError(ErrConfig, "reading config file: %w", err)
+	}
+	version, err := configFileVersion(dcm.configPath, data)
+	if err != nil {
+		return err
+	}
+	if version == configVersion {
+		fmt.Printf("%s is already at version %d\n", dcm.configPath, configVersion)
+		return nil
+	}
+
+	var doc yaml.MapSlice
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return NewError(ErrConfig, "parsing config file %s: %w", dcm.configPath, err)
+	}
+	doc, notes := migrateDocument(doc, version, filepath.Dir(dcm.configPath))
+	topLevel := configKeys["manager.Config"]
+	migrated := yaml.MapSlice{{Key: "version", Value: configVersion}}
+	for _, item := range doc {
+		key := fmt.Sprint(item.Key)
+		if key == "version" {
+			continue
+		}
+		if i := sort.SearchStrings(topLevel, key); (i == len(topLevel) || topLevel[i] != key) && !strings.HasPrefix(key, "x-") && !sharedSections[key] {
+			notes = append(notes, fmt.Sprintf("%s: not used by dcm, kept as x-%s%s", key, key, DidYouMean(key, topLevel)))
+			item.Key = "x-" + key
+		}
+		migrated = append(migrated, item)
+	}
+	out, err := yaml.Marshal(migrated)
+	if err != nil {
+		return err
+	}
+
+	problems, err := checkConfigSchema(out)
+	if err != nil {
+		return NewError(ErrConfig, "%s: %w", dcm.configPath, err)
+	}
+	if len(problems) > 0 {
+		errs := make([]string, len(problems))
+		for i, p := range problems {
+			// Lines refer to the migrated text, which the user does not see
+			p.Line = 0
+			errs[i] = formatConfigProblem(dcm.configPath, p)
+		}
+		return NewError(ErrConfig, "cannot migrate %s, fix these first:\n  %s", dcm.configPath, strings.Join(errs, "\n  "))
+	}
+
+	for _, note := range notes {
+		fmt.Println(note)
+	}
+	if dcm.dryRun {
+		fmt.Printf("Would write %s:\n", dcm.configPath)
+		os.Stdout.Write(out)
+		return nil
+	}
+	backup := dcm.configPath + ".bak"
+	if err := ioutil.WriteFile(backup, data, 0644); err != nil {
+		return NewError(ErrConfig, "writing %s: %w", backup, err)
+	}
+	if err := ioutil.WriteFile(dcm.configPath, out, 0644); err != nil {
+		return NewError(ErrConfig, "writing %s: %w", dcm.configPath, err)
+	}
+	fmt.Printf("Migrated %s from version %d to %d; comments are not kept, the original is %s\n",
+		dcm.configPath, version, configVersion, backup)
+	return nil
+}
@@ -0,0 +1,97 @@
+package manager
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestMigrateConfigKeepsKnownKeys(t *testing.T) {
+	config := `compose_file: compose.yaml
+project_name: test
+scale:
+  worker: 2
+wait_timeout: 90s
+colour: blue
+monitoring:
+  interval: 60
+environments:
+  staging:
+    compose_file: compose.staging.yaml
+`
+	dcm := newTestManager(t, config, "", &fakeRunner{}, WithYes())
+	if err := dcm.MigrateConfig(); err != nil {
+		t.Fatalf("MigrateConfig: %v", err)
+	}
+	data, err := os.ReadFile(dcm.ConfigPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var migrated map[string]interface{}
+	if err := yaml.Unmarshal(data, &migrated); err != nil {
+		t.Fatal(err)
+	}
+	if migrated["version"] != configVersion {
+		t.Errorf("version = %v, want %d", migrated["version"], configVersion)
+	}
+	for _, key := range []string{"compose_file", "project_name", "scale", "wait_timeout", "projects"} {
+		if _, ok := migrated[key]; !ok {
+			t.Errorf("%s is missing from the migrated config:\n%s", key, data)
+		}
+	}
+	if _, ok := migrated["x-colour"]; !ok || migrated["colour"] != nil {
+		t.Errorf("the unknown key colour was not kept as x-colour:\n%s", data)
+	}
+	projects, _ := migrated["projects"].(map[interface{}]interface{})
+	if projects["staging"] == nil || migrated["environments"] == nil {
+		t.Errorf("environments were not copied to projects and kept for the other tools:\n%s", data)
+	}
+	if migrated["monitoring"] == nil {
+		t.Errorf("the monitoring section of the other tools was not kept as it is:\n%s", data)
+	}
+}
+
+func TestMigrateConfigKeepsProjectNames(t *testing.T) {
+	config := `compose_file: compose.yaml
+environments:
+  prod:
+    project_name: shop
+    compose_file: compose.yaml
+`
+	dcm := newTestManager(t, config, "", &fakeRunner{}, WithProject("prod"))
+	if got := dcm.ProjectName(); got != "shop" {
+		t.Errorf("ProjectName() = %q, want the project_name of the environment", got)
+	}
+}
+
+func TestCheckConfigSchema(t *testing.T) {
+	problems, err := checkConfigSchema([]byte("version: 2\nscal:\n  web: 2\nservices: web\nx-notes: kept\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 2 {
+		t.Fatalf("problems = %+v, want the unknown key and the mistyped services", problems)
+	}
+	if p := problems[0]; p.Key != "scal" || p.Line != 2 || !strings.Contains(p.Message, `"scale"`) {
+		t.Errorf("unknown key problem = %+v, want scal on line 2 suggesting scale", p)
+	}
+	if p := problems[1]; p.Line != 4 || !strings.Contains(p.Message, "expected a list") {
+		t.Errorf("type problem = %+v, want services on line 4 expecting a list", p)
+	}
+}
+
+func TestExampleConfigMatchesSchema(t *testing.T) {
+	data, err := os.ReadFile("../../../dcm.config.yml.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version, err := configFileVersion("dcm.config.yml.example", data); err != nil || version != configVersion {
+		t.Errorf("example version = %d, %v, want %d", version, err, configVersion)
+	}
+	problems, err := checkConfigSchema(data)
+	if err != nil || len(problems) > 0 {
+		t.Errorf("example config problems = %+v, %v", problems, err)
+	}
+}
@@ -0,0 +1,337 @@
+package manager
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultServeAddr keeps the API on the local machine unless --listen
+	// says otherwise
+	DefaultServeAddr = "127.0.0.1:8700"
+	// ServeTokenEnv holds the API token when --token-file is not given
+	ServeTokenEnv = "DCM_SERVE_TOKEN"
+)
+
+// errorStatus maps error types to the HTTP status of API responses
+var errorStatus = map[ErrorType]int{
+	ErrUsage:                http.StatusBadRequest,
+	ErrServiceNotFound:      http.StatusNotFound,
+	ErrServiceNotConfigured: http.StatusForbidden,
+	ErrServicesNotRunning:   http.StatusConflict,
+	ErrServicesNotReady:     http.StatusConflict,
+}
+
+// ServeOptions configures the API server
+type ServeOptions struct {
+	// Addr is the host:port to listen on
+	Addr string
+	// Token authenticates requests as "Authorization: Bearer <token>"
+	Token string
+	// Metrics is the host:port of a Prometheus metrics endpoint served
+	// alongside the API, none when empty; see metrics.go
+	Metrics string
+}
+
+// apiServer serves the HTTP API of a manager. Operations run one at a time,
+// as they would from several terminals; log streams run alongside them.
+type apiServer struct {
+	dcm   *Manager
+	token string
+	mu    sync.Mutex
+}
+
+// OperationResult is the response to an operation
+type OperationResult struct {
+	Verb     string   `json:"verb"`
+	Services []string `json:"services"`
+	Output   string   `json:"output"`
+}
+
+// ServeToken returns the API token: the content of tokenFile, or of
+// DCM_SERVE_TOKEN, or a random one that is printed once
+func (dcm *Manager) ServeToken(tokenFile string) (string, error) {
+	if tokenFile != "" {
+		data, err := ioutil.ReadFile(tokenFile)
+		if err != nil {
+			return "", NewError(ErrUsage, "--token-file: %w", err)
+		}
+		token := strings.TrimSpace(string(data))
+		if token == "" {
+			return "", NewError(ErrUsage, "--token-file: %s is empty", tokenFile)
+		}
+		return token, nil
+	}
+	if token := os.Getenv(ServeTokenEnv); token != "" {
+		return token, nil
+	}
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(buf)
+	fmt.Fprintf(os.Stderr, "No token given with --token-file or %s, using: %s\n", ServeTokenEnv, token)
+	return token, nil
+}
+
+// Serve runs the HTTP API until interrupted:
+//
+//	GET  /v1/health                    liveness, without authentication
+//	GET  /v1/status                    the service containers, as status --output json
+//	POST /v1/{start,stop,restart}      operate on the services in scope
+//	POST /v1/services/{name}/{start,stop,restart}
+//	GET  /v1/services/{name}/logs      logs as server-sent events, ?tail=n&follow=1
+//
+// Every other request needs the token. Errors are reported with the JSON
+// envelope of --output json. With opts.Metrics, /metrics is served there
+// without authentication, like `dcm metrics`.
+func (dcm *Manager) Serve(opts ServeOptions) error {
+	if opts.Addr == "" {
+		opts.Addr = DefaultServeAddr
+	}
+	s := &apiServer{dcm: dcm, token: opts.Token}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/health", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	mux.Handle("/v1/", s.authenticate(http.HandlerFunc(s.route)))
+	servers := []*http.Server{{Addr: opts.Addr, Handler: mux}}
+	dcm.Infof("Serving the API of %s on http://%s, press Ctrl-C to stop\n", dcm.ProjectName(), opts.Addr)
+	if opts.Metrics != "" {
+		servers = append(servers, metricsServer(opts.Metrics, dcm.newMetricsCollector(&s.mu)))
+		dcm.Infof("Serving metrics on http://%s/metrics\n", opts.Metrics)
+	}
+	return dcm.runServers(servers...)
+}
+
+// authenticate refuses requests without the bearer token
+func (s *apiServer) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(given), []byte(s.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeAPIError(w, NewError(ErrUsage, "missing or wrong token"), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// route dispatches the authenticated requests
+func (s *apiServer) route(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/v1/"), "/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "status":
+		if r.Method != http.MethodGet {
+			writeAPIError(w, NewError(ErrUsage, "use GET"), http.StatusMethodNotAllowed)
+			return
+		}
+		s.mu.Lock()
+		statuses, err := s.dcm.Status(r.Context())
+		s.mu.Unlock()
+		if err != nil {
+			writeAPIError(w, err, 0)
+			return
+		}
+		if statuses == nil {
+			statuses = []ServiceStatus{}
+		}
+		writeJSON(w, http.StatusOK, statuses)
+	case len(parts) == 1:
+		s.operate(w, r, parts[0], nil)
+	case len(parts) == 3 && parts[0] == "services" && parts[2] == "logs":
+		s.logs(w, r, parts[1])
+	case len(parts) == 3 && parts[0] == "services":
+		s.operate(w, r, parts[2], []string{parts[1]})
+	default:
+		writeAPIError(w, NewError(ErrUsage, "no such endpoint %s", r.URL.Path), http.StatusNotFound)
+	}
+}
+
+// operate runs start, stop or restart, tracked like the same command run
+// from the command line, and returns the output of compose
+func (s *apiServer) operate(w http.ResponseWriter, r *http.Request, verb string, services []string) {
+	switch verb {
+	case "start", "stop", "restart":
+	default:
+		writeAPIError(w, NewError(ErrUsage, "no such endpoint %s", r.URL.Path), http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeAPIError(w, NewError(ErrUsage, "use POST"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, name := range services {
+		if err := s.dcm.checkService(name); err != nil {
+			writeAPIError(w, err, 0)
+			return
+		}
+	}
+	single := len(services) == 1
+	if len(services) == 0 {
+		services = s.dcm.scopedServices()
+	}
+	var output []byte
+	err := s.dcm.Track(verb, services, func() error {
+		commands, err := s.operationCommands(verb, services, single)
+		if err != nil {
+			return err
+		}
+		for _, argv := range commands {
+			if s.dcm.dryRunSkip(argv) {
+				output = append(output, "Would run: "+quoteArgs(argv)+"\n"...)
+				continue
+			}
+			s.dcm.Infof("API %s: executing %s\n", r.RemoteAddr, quoteArgs(argv))
+			started := time.Now()
+			out, err := s.dcm.command(r.Context(), argv).CombinedOutput()
+			s.dcm.logCommand(argv, started, err)
+			output = append(output, out...)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		writeAPIError(w, fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output))), 0)
+		return
+	}
+	if services == nil {
+		services = []string{}
+	}
+	writeJSON(w, http.StatusOK, OperationResult{Verb: verb, Services: services, Output: string(output)})
+}
+
+// operationCommands returns the compose commands of an operation, built as
+// the command line builds them: start applies the scale section of the
+// config and the restart of a single service keeps its replica count, as
+// RestartService does
+func (s *apiServer) operationCommands(verb string, services []string, single bool) ([][]string, error) {
+	if verb == "start" {
+		argv, err := s.dcm.upCommand(services)
+		if err != nil {
+			return nil, err
+		}
+		return [][]string{argv}, nil
+	}
+	argv, err := s.dcm.composeArgs(append([]string{verb}, services...)...)
+	if err != nil {
+		return nil, err
+	}
+	commands := [][]string{argv}
+	if verb != "restart" || !single || !s.dcm.FeatureEnabled("restart_preserves_scale") {
+		return commands, nil
+	}
+	replicas, err := s.dcm.replicaCount(services[0])
+	if err != nil {
+		return nil, fmt.Errorf("reading replica count of %s: %w", services[0], err)
+	}
+	if replicas > 1 {
+		rescale, err := s.dcm.composeArgs(rescaleArgs(services[0], replicas)...)
+		if err != nil {
+			return nil, err
+		}
+		commands = append(commands, rescale)
+	}
+	return commands, nil
+}
+
+// sseWriter turns the lines written to it into server-sent events
+type sseWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	partial []byte
+}
+
+func (e *sseWriter) Write(p []byte) (int, error) {
+	e.partial = append(e.partial, p...)
+	for {
+		i := bytes.IndexByte(e.partial, '\n')
+		if i < 0 {
+			break
+		}
+		if _, err := fmt.Fprintf(e.w, "data: %s\n\n", e.partial[:i]); err != nil {
+			return 0, err
+		}
+		e.partial = e.partial[i+1:]
+	}
+	e.flusher.Flush()
+	return len(p), nil
+}
+
+// logs streams the logs of a service as server-sent events until the
+// client goes away or, without follow, the logs end
+func (s *apiServer) logs(w http.ResponseWriter, r *http.Request, service string) {
+	if r.Method != http.MethodGet {
+		writeAPIError(w, NewError(ErrUsage, "use GET"), http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeAPIError(w, fmt.Errorf("streaming is not supported"), http.StatusInternalServerError)
+		return
+	}
+	opts := LogOptions{Tail: 100, NoColor: true}
+	if tail := r.URL.Query().Get("tail"); tail != "" {
+		n, err := strconv.Atoi(tail)
+		if err != nil {
+			writeAPIError(w, NewError(ErrUsage, "tail: expected a number of lines, got %q", tail), 0)
+			return
+		}
+		opts.Tail = n
+	}
+	opts.Follow, _ = strconv.ParseBool(r.URL.Query().Get("follow"))
+	if err := s.dcm.checkService(service); err != nil {
+		writeAPIError(w, err, 0)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	out := &sseWriter{w: w, flusher: flusher}
+	if err := s.dcm.ServiceLogs(r.Context(), []string{service}, opts, out); err != nil && r.Context().Err() == nil {
+		fmt.Fprintf(w, "event: error\ndata: %s\n\n", err)
+	}
+	fmt.Fprint(w, "event: end\ndata:\n\n")
+	flusher.Flush()
+}
+
+// writeJSON writes a JSON response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(v)
+}
+
+// writeAPIError writes the JSON error envelope, with the status of the error
+// type when status is 0
+func writeAPIError(w http.ResponseWriter, err error, status int) {
+	if status == 0 {
+		status = http.StatusInternalServerError
+		if s, ok := errorStatus[TypeOf(err)]; ok {
+			status = s
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	WriteErrorJSON(w, err)
+}
@@ -0,0 +1,46 @@
+package manager
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// post sends an authenticated operation to the API of dcm
+func post(t *testing.T, dcm *Manager, path string) *httptest.ResponseRecorder {
+	t.Helper()
+	s := &apiServer{dcm: dcm, token: "secret"}
+	r := httptest.NewRequest(http.MethodPost, path, nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	s.authenticate(http.HandlerFunc(s.route)).ServeHTTP(w, r)
+	return w
+}
+
+func TestAPIStartAppliesScale(t *testing.T) {
+	runner := &fakeRunner{}
+	dcm := newTestManager(t, "scale:\n  worker: 3\n", "", runner)
+
+	if w := post(t, dcm, "/v1/start"); w.Code != http.StatusOK {
+		t.Fatalf("POST /v1/start = %d %s", w.Code, w.Body)
+	}
+	ups := runner.ran(" up ")
+	if len(ups) != 1 || !strings.HasSuffix(ups[0], " up -d --scale worker=3") {
+		t.Errorf("ran %q, want compose up scaling worker to 3", ups)
+	}
+}
+
+func TestAPIRestartPreservesScale(t *testing.T) {
+	runner := &fakeRunner{}
+	replicas := 3
+	fakeScale(runner, "worker", &replicas)
+	dcm := newTestManager(t, "", "", runner)
+
+	if w := post(t, dcm, "/v1/services/worker/restart"); w.Code != http.StatusOK {
+		t.Fatalf("POST restart = %d %s", w.Code, w.Body)
+	}
+	if replicas != 3 {
+		t.Errorf("worker has %d replicas after the restart, want 3 (commands: %q)", replicas, runner.commands())
+	}
+}
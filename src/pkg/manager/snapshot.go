@@ -0,0 +1,522 @@
+package manager
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// snapshotHelperImage runs tar against volumes, which docker cannot
+	// read or write without a container
+	snapshotHelperImage  = "busybox:stable"
+	snapshotManifestFile = "manifest.json"
+	snapshotLockFile     = "images.lock.yml"
+	snapshotSuffix       = ".tar.gz"
+)
+
+// snapshotName matches the names of snapshots kept in the state directory
+var snapshotName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// SnapshotManifest describes the content of a snapshot, the first entry of
+// its tarball
+type SnapshotManifest struct {
+	Name    string    `json:"name"`
+	Created time.Time `json:"created"`
+	Project string    `json:"project"`
+	// Files are the compose files and the env file, relative to the
+	// directory of the config, archived under files/
+	Files []string `json:"files"`
+	// EnvFile is the one of Files that is the env file
+	EnvFile string `json:"env_file,omitempty"`
+	// Images maps services to the digests of their images, also archived
+	// as a pin lock
+	Images  map[string]string `json:"images,omitempty"`
+	Volumes []SnapshotVolume  `json:"volumes,omitempty"`
+}
+
+// SnapshotVolume is a named volume archived under volumes/
+type SnapshotVolume struct {
+	// Name is the docker volume name, Volume its name in the compose file
+	Name   string `json:"name"`
+	Volume string `json:"volume"`
+	File   string `json:"file"`
+}
+
+// snapshotsDir is where snapshots given by name are kept
+func (dcm *Manager) snapshotsDir() string {
+	return filepath.Join(dcm.stateDir(), "snapshots")
+}
+
+// snapshotPath returns the tarball of a snapshot: a name is kept in the
+// state directory, anything that looks like a path is taken as is, so a
+// snapshot copied from another machine can be restored
+func (dcm *Manager) snapshotPath(name string) (string, error) {
+	if strings.ContainsRune(name, filepath.Separator) || strings.HasSuffix(name, snapshotSuffix) {
+		return name, nil
+	}
+	if !snapshotName.MatchString(name) {
+		return "", NewError(ErrUsage, "invalid snapshot name %q, use letters, digits, '.', '_' and '-'", name)
+	}
+	return filepath.Join(dcm.snapshotsDir(), name+snapshotSuffix), nil
+}
+
+// snapshotFiles returns the compose files and the env file with the names
+// they are archived under, relative to the config directory when they are
+// below it and by their base name otherwise. The env file comes last.
+func (dcm *Manager) snapshotFiles() (map[string]string, []string) {
+	base, _ := filepath.Abs(filepath.Dir(dcm.configPath))
+	files := append([]string(nil), dcm.config.composeFiles()...)
+	if dcm.config.EnvFile != "" {
+		files = append(files, dcm.config.EnvFile)
+	}
+	paths := make(map[string]string)
+	var names []string
+	for _, f := range files {
+		abs, _ := filepath.Abs(f)
+		rel, err := filepath.Rel(base, abs)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			rel = filepath.Base(abs)
+		}
+		rel = filepath.ToSlash(rel)
+		paths[rel] = f
+		names = append(names, rel)
+	}
+	return paths, names
+}
+
+// runPiped runs a command with its input and output connected to the given
+// reader and writer, for the data of volumes, honouring --dry-run
+func (dcm *Manager) runPiped(argv []string, stdin io.Reader, stdout io.Writer) error {
+	if dcm.dryRunSkip(argv) {
+		return nil
+	}
+	dcm.Infof("Executing: %s\n", quoteArgs(argv))
+	started := time.Now()
+	cmd := dcm.command(context.Background(), argv)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	dcm.logCommand(argv, started, err)
+	if err != nil {
+		return fmt.Errorf("%s: %v: %s", quoteArgs(argv[:2]), err, firstLine(stderr.String()))
+	}
+	return nil
+}
+
+// tarAdd writes one file entry to a tarball
+func tarAdd(tw *tar.Writer, name string, size int64, r io.Reader) error {
+	header := &tar.Header{Name: name, Mode: 0644, Size: size, ModTime: time.Now()}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := io.Copy(tw, r)
+	return err
+}
+
+// CreateSnapshot archives the stack to a tarball: its compose and env
+// files, the digests of its images and the content of its named volumes.
+// Running services are stopped while the volumes are read, so they are
+// consistent, and started again afterwards; live reads them while running.
+// It returns the path of the snapshot.
+func (dcm *Manager) CreateSnapshot(name string, live bool) (string, error) {
+	target, err := dcm.snapshotPath(name)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(target); err == nil {
+		return "", NewError(ErrUsage, "snapshot %s already exists", target)
+	}
+	project, err := dcm.composeProject()
+	if err != nil {
+		return "", err
+	}
+	paths, files := dcm.snapshotFiles()
+	if len(dcm.config.composeFiles()) == 0 {
+		return "", NewError(ErrConfig, "no compose files to snapshot")
+	}
+
+	manifest := SnapshotManifest{
+		Name:    strings.TrimSuffix(filepath.Base(target), snapshotSuffix),
+		Created: time.Now().UTC(),
+		Project: dcm.ProjectName(),
+		Files:   files,
+		Images:  make(map[string]string),
+	}
+	if dcm.config.EnvFile != "" {
+		manifest.EnvFile = files[len(files)-1]
+	}
+	lock := &pinLock{Services: make(map[string]pinnedService)}
+	for _, service := range project.Names {
+		image := project.Services[service].Image
+		if image == "" {
+			continue
+		}
+		digest, err := dcm.imageDigest(image)
+		if err != nil {
+			dcm.Warnf("not recording the image of %s: %v\n", service, err)
+			continue
+		}
+		manifest.Images[service] = digest
+		lock.Services[service] = pinnedService{Image: digest}
+	}
+	volumes, err := dcm.dockerLines("volume", "ls", "--filter", dcm.projectFilter(), "--format", "{{.Name}}")
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(volumes)
+	for _, volume := range volumes {
+		short, _ := dcm.dockerOutput("volume", "inspect", "--format", `{{index .Labels "com.docker.compose.volume"}}`, volume)
+		short = strings.TrimSpace(short)
+		if short == "" {
+			short = strings.TrimPrefix(volume, dcm.ProjectName()+"_")
+		}
+		manifest.Volumes = append(manifest.Volumes, SnapshotVolume{
+			Name: volume, Volume: short, File: "volumes/" + short + ".tar",
+		})
+	}
+
+	if dcm.dryRun {
+		for _, v := range manifest.Volumes {
+			dcm.dryRunSkip(dockerArgs("run", "--rm", "-v", v.Name+":/volume:ro", snapshotHelperImage, "tar", "-C", "/volume", "-cf", "-", "."))
+		}
+		fmt.Printf("Would write snapshot %s\n", target)
+		return target, nil
+	}
+
+	if !live && len(manifest.Volumes) > 0 {
+		statuses, err := dcm.Status(context.Background())
+		if err != nil {
+			return "", err
+		}
+		var running []string
+		seen := make(map[string]bool)
+		for _, s := range statuses {
+			if s.State == "running" && !seen[s.Service] {
+				seen[s.Service] = true
+				running = append(running, s.Service)
+			}
+		}
+		if len(running) > 0 {
+			dcm.Infof("Stopping %s while the volumes are read...\n", strings.Join(running, ", "))
+			if err := dcm.composeStreaming(append([]string{"stop"}, running...)...); err != nil {
+				return "", err
+			}
+			defer func() {
+				dcm.Infof("Starting %s again...\n", strings.Join(running, ", "))
+				dcm.composeStreaming(append([]string{"start"}, running...)...)
+			}()
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0775); err != nil {
+		return "", err
+	}
+	// Written aside and renamed, so a failed snapshot leaves nothing behind
+	partial := target + ".partial"
+	err = dcm.writeSnapshot(partial, manifest, paths, lock)
+	if err == nil {
+		err = os.Rename(partial, target)
+	}
+	if err != nil {
+		os.Remove(partial)
+		return "", err
+	}
+	return target, nil
+}
+
+// writeSnapshot writes the tarball of a snapshot, the manifest first so
+// restore knows what follows
+func (dcm *Manager) writeSnapshot(target string, manifest SnapshotManifest, paths map[string]string, lock *pinLock) error {
+	out, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := tarAdd(tw, snapshotManifestFile, int64(len(data)), bytes.NewReader(data)); err != nil {
+		return err
+	}
+	for _, name := range manifest.Files {
+		data, err := ioutil.ReadFile(paths[name])
+		if err != nil {
+			return err
+		}
+		if err := tarAdd(tw, "files/"+name, int64(len(data)), bytes.NewReader(data)); err != nil {
+			return err
+		}
+	}
+	if len(lock.Services) > 0 {
+		lockData, err := yaml.Marshal(lock)
+		if err != nil {
+			return err
+		}
+		lockData = append([]byte(pinLockHeader), lockData...)
+		if err := tarAdd(tw, snapshotLockFile, int64(len(lockData)), bytes.NewReader(lockData)); err != nil {
+			return err
+		}
+	}
+	for _, v := range manifest.Volumes {
+		dcm.Infof("Archiving volume %s...\n", v.Name)
+		// The size goes in the tar header, so the volume is read into a
+		// temporary file first
+		tmp, err := ioutil.TempFile("", "dcm-volume-*.tar")
+		if err != nil {
+			return err
+		}
+		err = dcm.runPiped(dockerArgs("run", "--rm", "-v", v.Name+":/volume:ro", snapshotHelperImage, "tar", "-C", "/volume", "-cf", "-", "."), nil, tmp)
+		if err == nil {
+			var info os.FileInfo
+			if info, err = tmp.Stat(); err == nil {
+				if _, err = tmp.Seek(0, io.SeekStart); err == nil {
+					err = tarAdd(tw, v.File, info.Size(), tmp)
+				}
+			}
+		}
+		tmp.Close()
+		os.Remove(tmp.Name())
+		if err != nil {
+			return fmt.Errorf("archiving volume %s: %w", v.Name, err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// RestoreSnapshot recreates the stack from a snapshot: it takes the stack
+// down, writes back the compose and env files next to the config, replaces
+// the named volumes with their archived content and starts the stack on
+// the archived image digests. Volumes are given the names of the current
+// project, so a snapshot can be restored under another project name.
+func (dcm *Manager) RestoreSnapshot(name string) error {
+	source, err := dcm.snapshotPath(name)
+	if err != nil {
+		return err
+	}
+	in, err := os.Open(source)
+	if err != nil {
+		return NewError(ErrUsage, "snapshot: %w", err)
+	}
+	defer in.Close()
+	gz, err := gzip.NewReader(in)
+	if err != nil {
+		return fmt.Errorf("reading snapshot %s: %v", source, err)
+	}
+	tr := tar.NewReader(gz)
+
+	header, err := tr.Next()
+	if err != nil || header.Name != snapshotManifestFile {
+		return fmt.Errorf("%s is not a dcm snapshot", source)
+	}
+	var manifest SnapshotManifest
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return fmt.Errorf("reading the manifest of %s: %v", source, err)
+	}
+	// The name comes from the tarball and names the restored pin lock
+	if !snapshotName.MatchString(manifest.Name) {
+		return fmt.Errorf("snapshot %s: invalid snapshot name %q in the manifest", source, manifest.Name)
+	}
+
+	base := filepath.Dir(dcm.configPath)
+	volumeNames := make([]string, len(manifest.Volumes))
+	for i, v := range manifest.Volumes {
+		volumeNames[i] = dcm.ProjectName() + "_" + v.Volume
+	}
+	question := fmt.Sprintf("Restore snapshot %s from %s? This takes %s down and overwrites %s",
+		manifest.Name, manifest.Created.Local().Format("2006-01-02 15:04"), dcm.ProjectName(), strings.Join(manifest.Files, ", "))
+	if len(volumeNames) > 0 {
+		question += " and volumes " + strings.Join(volumeNames, ", ")
+	}
+	if err := dcm.ConfirmDestructive(question); err != nil {
+		return err
+	}
+
+	// Restoring on another machine, there is no stack to take down yet
+	existing := len(dcm.config.composeFiles()) > 0 && dcm.CheckConfigFiles() == nil
+	if existing {
+		dcm.Infof("Taking the stack down...\n")
+		if err := dcm.composeStreaming("down"); err != nil {
+			return err
+		}
+	}
+
+	volumes := make(map[string]int)
+	for i, v := range manifest.Volumes {
+		volumes[v.File] = i
+	}
+	var restored []string
+	lockPath := ""
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("reading snapshot %s: %v", source, err)
+		}
+		switch {
+		case strings.HasPrefix(header.Name, "files/"):
+			rel := strings.TrimPrefix(header.Name, "files/")
+			if path.IsAbs(rel) || strings.HasPrefix(path.Clean(rel), "..") {
+				return fmt.Errorf("snapshot %s: refusing to write %s outside %s", source, rel, base)
+			}
+			target := filepath.Join(base, filepath.FromSlash(rel))
+			data, err := ioutil.ReadAll(tr)
+			if err != nil {
+				return err
+			}
+			if dcm.dryRun {
+				fmt.Printf("Would write %s\n", target)
+				continue
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0775); err != nil {
+				return err
+			}
+			if err := ioutil.WriteFile(target, data, 0664); err != nil {
+				return err
+			}
+			dcm.Infof("Restored %s\n", target)
+			if rel != manifest.EnvFile {
+				restored = append(restored, target)
+			}
+		case header.Name == snapshotLockFile:
+			lockPath = filepath.Join(dcm.snapshotsDir(), manifest.Name+".lock.yml")
+			data, err := ioutil.ReadAll(tr)
+			if err != nil {
+				return err
+			}
+			if dcm.dryRun {
+				continue
+			}
+			if err := os.MkdirAll(filepath.Dir(lockPath), 0775); err != nil {
+				return err
+			}
+			if err := ioutil.WriteFile(lockPath, data, 0664); err != nil {
+				return err
+			}
+		default:
+			i, ok := volumes[header.Name]
+			if !ok {
+				dcm.verbosef("Skipping unknown snapshot entry %s\n", header.Name)
+				continue
+			}
+			if err := dcm.restoreVolume(volumeNames[i], manifest.Volumes[i].Volume, tr); err != nil {
+				return fmt.Errorf("restoring volume %s: %w", volumeNames[i], err)
+			}
+		}
+	}
+
+	// Without compose files of its own the manager runs the restored ones
+	if !existing {
+		dcm.config.ComposeFile, dcm.config.ComposeFiles = "", restored
+	}
+	if lockPath != "" && !dcm.dryRun {
+		dcm.overlays = append(dcm.overlays, lockPath)
+	}
+	dcm.Infof("Starting the stack...\n")
+	return dcm.composeStreaming(dcm.upArgs()...)
+}
+
+// restoreVolume replaces a volume with the content of a tar stream, labelled
+// as compose labels the volumes it creates so compose adopts it
+func (dcm *Manager) restoreVolume(name, volume string, content io.Reader) error {
+	dcm.Infof("Restoring volume %s...\n", name)
+	if _, err := dcm.dockerOutput("volume", "inspect", name); err == nil {
+		if err := dcm.runPiped(dockerArgs("volume", "rm", name), nil, ioutil.Discard); err != nil {
+			return err
+		}
+	}
+	create := dockerArgs("volume", "create",
+		"--label", "com.docker.compose.project="+dcm.ProjectName(),
+		"--label", "com.docker.compose.volume="+volume, name)
+	if err := dcm.runPiped(create, nil, ioutil.Discard); err != nil {
+		return err
+	}
+	return dcm.runPiped(dockerArgs("run", "--rm", "-i", "-v", name+":/volume", snapshotHelperImage, "tar", "-C", "/volume", "-xf", "-"), content, ioutil.Discard)
+}
+
+// SnapshotInfo is a snapshot kept in the state directory
+type SnapshotInfo struct {
+	Name    string    `json:"name"`
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	Created time.Time `json:"created"`
+}
+
+// Snapshots lists the snapshots kept in the state directory, oldest first
+func (dcm *Manager) Snapshots() ([]SnapshotInfo, error) {
+	entries, err := ioutil.ReadDir(dcm.snapshotsDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var snapshots []SnapshotInfo
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), snapshotSuffix) {
+			continue
+		}
+		snapshots = append(snapshots, SnapshotInfo{
+			Name:    strings.TrimSuffix(e.Name(), snapshotSuffix),
+			Path:    filepath.Join(dcm.snapshotsDir(), e.Name()),
+			Size:    e.Size(),
+			Created: e.ModTime(),
+		})
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Created.Before(snapshots[j].Created) })
+	return snapshots, nil
+}
+
+// PrintSnapshots lists the snapshots kept in the state directory
+func (dcm *Manager) PrintSnapshots() error {
+	snapshots, err := dcm.Snapshots()
+	if err != nil {
+		return err
+	}
+	if dcm.output == "json" {
+		if snapshots == nil {
+			snapshots = []SnapshotInfo{}
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(snapshots)
+	}
+	if len(snapshots) == 0 {
+		fmt.Printf("No snapshots in %s\n", dcm.snapshotsDir())
+		return nil
+	}
+	fmt.Printf("%-24s %-18s %s\n", "SNAPSHOT", "CREATED", "SIZE")
+	for _, s := range snapshots {
+		fmt.Printf("%-24s %-18s %s\n", s.Name, s.Created.Local().Format("2006-01-02 15:04"), formatBytes(s.Size))
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package manager
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRestoreSnapshotRejectsManifestNames(t *testing.T) {
+	dcm := newTestManager(t, "", "", &fakeRunner{}, WithYes())
+
+	source := filepath.Join(t.TempDir(), "evil"+snapshotSuffix)
+	out, err := os.Create(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+	manifest := `{"name":"../../evil","files":[]}`
+	if err := tarAdd(tw, snapshotManifestFile, int64(len(manifest)), strings.NewReader(manifest)); err != nil {
+		t.Fatal(err)
+	}
+	lock := "services: {}\n"
+	if err := tarAdd(tw, snapshotLockFile, int64(len(lock)), strings.NewReader(lock)); err != nil {
+		t.Fatal(err)
+	}
+	tw.Close()
+	gz.Close()
+	out.Close()
+
+	err = dcm.RestoreSnapshot(source)
+	if err == nil || !strings.Contains(err.Error(), "invalid snapshot name") {
+		t.Fatalf("RestoreSnapshot = %v, want the manifest name refused", err)
+	}
+	if _, err := os.Stat(filepath.Join(dcm.snapshotsDir(), "..", "..", "evil.lock.yml")); !os.IsNotExist(err) {
+		t.Errorf("the pin lock was written outside the snapshots directory")
+	}
+}
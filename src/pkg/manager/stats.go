@@ -0,0 +1,244 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ServiceStats is the resource usage of a service, summed over its running
+// containers
+type ServiceStats struct {
+	Service string `json:"service"`
+	// Containers counts the running containers the usage is summed over
+	Containers  int     `json:"containers"`
+	CPUPercent  float64 `json:"cpu_percent"`
+	MemoryBytes int64   `json:"memory_bytes"`
+	// MemoryLimitBytes sums the memory limits of the containers, the memory
+	// of the host for a container without a limit
+	MemoryLimitBytes int64 `json:"memory_limit_bytes"`
+	// MemoryPercent is the memory usage against the limit
+	MemoryPercent   float64 `json:"memory_percent"`
+	NetRxBytes      int64   `json:"net_rx_bytes"`
+	NetTxBytes      int64   `json:"net_tx_bytes"`
+	BlockReadBytes  int64   `json:"block_read_bytes"`
+	BlockWriteBytes int64   `json:"block_write_bytes"`
+}
+
+// containerStats is a line of docker stats --format '{{json .}}'
+type containerStats struct {
+	Name     string
+	CPUPerc  string
+	MemUsage string
+	NetIO    string
+	BlockIO  string
+}
+
+// sizeUnits are the units docker stats prints sizes with: decimal ones for
+// I/O and binary ones for memory
+var sizeUnits = map[string]float64{
+	"b":   1,
+	"kb":  1e3,
+	"mb":  1e6,
+	"gb":  1e9,
+	"tb":  1e12,
+	"kib": 1 << 10,
+	"mib": 1 << 20,
+	"gib": 1 << 30,
+	"tib": 1 << 40,
+}
+
+// parseSize parses a size such as "1.5MiB" or "12kB"
+func parseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if i < 0 {
+		i = len(s)
+	}
+	n, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	unit, ok := sizeUnits[strings.ToLower(strings.TrimSpace(s[i:]))]
+	if !ok && s[i:] != "" {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	if !ok {
+		unit = 1
+	}
+	return int64(math.Round(n * unit)), nil
+}
+
+// parseSizePair parses the "used / limit" and "in / out" pairs of docker stats
+func parseSizePair(s string) (int64, int64, error) {
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid size pair %q", s)
+	}
+	a, err := parseSize(parts[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	b, err := parseSize(parts[1])
+	return a, b, err
+}
+
+// addTo adds the usage of a container to the stats of its service
+func (c containerStats) addTo(s *ServiceStats) error {
+	cpu, err := strconv.ParseFloat(strings.TrimSuffix(c.CPUPerc, "%"), 64)
+	if err != nil {
+		return fmt.Errorf("invalid CPU usage %q", c.CPUPerc)
+	}
+	mem, limit, err := parseSizePair(c.MemUsage)
+	if err != nil {
+		return err
+	}
+	rx, tx, err := parseSizePair(c.NetIO)
+	if err != nil {
+		return err
+	}
+	read, write, err := parseSizePair(c.BlockIO)
+	if err != nil {
+		return err
+	}
+	s.Containers++
+	s.CPUPercent += cpu
+	s.MemoryBytes += mem
+	s.MemoryLimitBytes += limit
+	s.NetRxBytes += rx
+	s.NetTxBytes += tx
+	s.BlockReadBytes += read
+	s.BlockWriteBytes += write
+	return nil
+}
+
+// SampleStats samples the resource usage of the running containers of
+// services, all services in scope when none are given, and sums it per
+// service. Services without running containers are listed with no usage.
+func (dcm *Manager) SampleStats(services []string) ([]ServiceStats, error) {
+	statuses, err := dcm.Status(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	wanted := make(map[string]bool)
+	for _, name := range services {
+		wanted[name] = true
+	}
+	byService := make(map[string]*ServiceStats)
+	var order []string
+	serviceOf := make(map[string]string)
+	var running []string
+	for _, s := range statuses {
+		if len(wanted) > 0 && !wanted[s.Service] {
+			continue
+		}
+		if byService[s.Service] == nil {
+			byService[s.Service] = &ServiceStats{Service: s.Service}
+			order = append(order, s.Service)
+		}
+		if s.State == "running" && s.Name != "" {
+			serviceOf[s.Name] = s.Service
+			running = append(running, s.Name)
+		}
+	}
+
+	if len(running) > 0 {
+		args := append([]string{"stats", "--no-stream", "--format", "{{json .}}"}, running...)
+		dcm.verbosef("Executing: docker %s\n", strings.Join(args, " "))
+		output, err := dcm.dockerOutput(args...)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+			if line == "" {
+				continue
+			}
+			var c containerStats
+			if err := json.Unmarshal([]byte(line), &c); err != nil {
+				return nil, fmt.Errorf("could not parse docker stats output: %w", err)
+			}
+			service, ok := serviceOf[c.Name]
+			if !ok {
+				continue
+			}
+			if err := c.addTo(byService[service]); err != nil {
+				return nil, fmt.Errorf("docker stats of %s: %w", c.Name, err)
+			}
+		}
+	}
+
+	sort.Strings(order)
+	stats := make([]ServiceStats, 0, len(order))
+	for _, name := range order {
+		s := byService[name]
+		if s.MemoryLimitBytes > 0 {
+			s.MemoryPercent = float64(s.MemoryBytes) / float64(s.MemoryLimitBytes) * 100
+		}
+		stats = append(stats, *s)
+	}
+	return stats, nil
+}
+
+// Stats shows the resource usage of services once, or with watch every
+// interval until interrupted
+func (dcm *Manager) Stats(services []string, watch bool, interval time.Duration, show func([]ServiceStats) error) error {
+	for _, service := range services {
+		if err := dcm.checkService(service); err != nil {
+			return err
+		}
+	}
+	if !watch {
+		stats, err := dcm.SampleStats(services)
+		if err != nil {
+			return err
+		}
+		return show(stats)
+	}
+	if interval <= 0 {
+		return NewError(ErrUsage, "--interval must be positive")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		stats, err := dcm.SampleStats(services)
+		if err != nil {
+			return err
+		}
+		if err := show(stats); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// PrintStats prints the resource usage of each service as a table
+func PrintStats(stats []ServiceStats) {
+	fmt.Printf("%-20s %-10s %-8s %-24s %-8s %-22s %s\n", "SERVICE", "CONTAINERS", "CPU %", "MEM USAGE / LIMIT", "MEM %", "NET I/O", "BLOCK I/O")
+	for _, s := range stats {
+		if s.Containers == 0 {
+			fmt.Printf("%-20s %-10d %s\n", s.Service, 0, "not running")
+			continue
+		}
+		fmt.Printf("%-20s %-10d %-8s %-24s %-8s %-22s %s\n", s.Service, s.Containers,
+			fmt.Sprintf("%.2f%%", s.CPUPercent),
+			formatBytes(s.MemoryBytes)+" / "+formatBytes(s.MemoryLimitBytes),
+			fmt.Sprintf("%.2f%%", s.MemoryPercent),
+			formatBytes(s.NetRxBytes)+" / "+formatBytes(s.NetTxBytes),
+			formatBytes(s.BlockReadBytes)+" / "+formatBytes(s.BlockWriteBytes))
+	}
+}
@@ -0,0 +1,452 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ServiceStatus is the state of one service container
+type ServiceStatus struct {
+	Name    string `json:"name,omitempty" yaml:"name,omitempty"`
+	Service string `json:"service" yaml:"service"`
+	// ID is the short container ID
+	ID       string   `json:"id,omitempty" yaml:"id,omitempty"`
+	Image    string   `json:"image,omitempty" yaml:"image,omitempty"`
+	State    string   `json:"state" yaml:"state"`
+	Health   string   `json:"health,omitempty" yaml:"health,omitempty"`
+	Ports    []string `json:"ports,omitempty" yaml:"ports,omitempty"`
+	ExitCode int      `json:"exit_code" yaml:"exit_code"`
+	// Uptime is how long a running container has been up, as docker
+	// reports it, e.g. "2 hours"
+	Uptime string `json:"uptime,omitempty" yaml:"uptime,omitempty"`
+}
+
+// shortID shortens a container ID the way docker ps does
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
+// uptimeFromStatus extracts the uptime from a docker status such as
+// "Up 2 hours (healthy)", empty when the container is not up
+func uptimeFromStatus(status string) string {
+	if !strings.HasPrefix(status, "Up ") {
+		return ""
+	}
+	uptime := strings.TrimPrefix(status, "Up ")
+	if i := strings.Index(uptime, " ("); i >= 0 {
+		uptime = uptime[:i]
+	}
+	return uptime
+}
+
+// composePsEntry is a container as reported by `docker compose ps --format json`
+type composePsEntry struct {
+	ID         string `json:"ID"`
+	Image      string `json:"Image"`
+	Status     string `json:"Status"`
+	Name       string `json:"Name"`
+	Service    string `json:"Service"`
+	State      string `json:"State"`
+	Health     string `json:"Health"`
+	ExitCode   int    `json:"ExitCode"`
+	Publishers []struct {
+		URL           string `json:"URL"`
+		TargetPort    int    `json:"TargetPort"`
+		PublishedPort int    `json:"PublishedPort"`
+		Protocol      string `json:"Protocol"`
+	} `json:"Publishers"`
+}
+
+// StatusDetailed returns the state of every service container, as reported
+// by the selected backend
+func (dcm *Manager) StatusDetailed(ctx context.Context) ([]ServiceStatus, error) {
+	backend, err := dcm.backend()
+	if err != nil {
+		return nil, err
+	}
+	return backend.Status(ctx)
+}
+
+// stateNotCreated is the state reported for a service without any container
+const stateNotCreated = "not created"
+
+// Status returns the state of every service container, plus an entry in
+// state "not created" for each service that has no container at all, so
+// every service in scope appears in the result.
+func (dcm *Manager) Status(ctx context.Context) ([]ServiceStatus, error) {
+	statuses, err := dcm.StatusDetailed(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	services := dcm.scopedServices()
+	if len(services) == 0 {
+		if services, err = dcm.composeServices(); err != nil {
+			return nil, err
+		}
+	}
+	seen := make(map[string]bool)
+	for _, s := range statuses {
+		seen[s.Service] = true
+	}
+	for _, name := range services {
+		if !seen[name] {
+			statuses = append(statuses, ServiceStatus{Service: name, State: stateNotCreated})
+		}
+	}
+	return statuses, nil
+}
+
+// Sort orders of service listings
+const (
+	SortByFile  = "file"
+	SortByName  = "name"
+	SortByState = "state"
+)
+
+// checkSortOrder rejects an unknown --sort value
+func checkSortOrder(order string) error {
+	switch order {
+	case SortByFile, SortByName, SortByState:
+		return nil
+	}
+	return NewError(ErrUsage, "invalid sort order %q, expected name, file or state", order)
+}
+
+// serviceLess returns the comparison of services, given by name and state,
+// for a sort order. File order follows the compose files, services they do
+// not define go last by name; state order falls back to file order.
+func (dcm *Manager) serviceLess(order string) (func(nameA, stateA, nameB, stateB string) bool, error) {
+	project, err := dcm.composeProject()
+	if err != nil {
+		return nil, err
+	}
+	index := make(map[string]int)
+	for i, name := range project.Names {
+		index[name] = i
+	}
+	byFile := func(a, b string) bool {
+		ia, okA := index[a]
+		ib, okB := index[b]
+		switch {
+		case okA != okB:
+			return okA
+		case ia != ib:
+			return ia < ib
+		}
+		return a < b
+	}
+
+	switch order {
+	case SortByName:
+		return func(a, _, b, _ string) bool { return a < b }, nil
+	case SortByState:
+		return func(a, stateA, b, stateB string) bool {
+			if stateA != stateB {
+				return stateA < stateB
+			}
+			return byFile(a, b)
+		}, nil
+	}
+	return func(a, _, b, _ string) bool { return byFile(a, b) }, nil
+}
+
+// sortStatuses sorts service containers in place, keeping the order compose
+// reported for the containers of one service
+func (dcm *Manager) sortStatuses(statuses []ServiceStatus, order string) error {
+	less, err := dcm.serviceLess(order)
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(statuses, func(i, j int) bool {
+		return less(statuses[i].Service, statuses[i].State, statuses[j].Service, statuses[j].State)
+	})
+	return nil
+}
+
+// sortServices sorts services in place
+func (dcm *Manager) sortServices(services []ServiceInfo, order string) error {
+	less, err := dcm.serviceLess(order)
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(services, func(i, j int) bool {
+		return less(services[i].Name, services[i].State, services[j].Name, services[j].State)
+	})
+	return nil
+}
+
+// printStatusTable prints service containers one per line
+func printStatusTable(statuses []ServiceStatus) {
+	fmt.Printf("%-20s %-30s %-12s %-25s %-12s %-10s %-15s %s\n", "SERVICE", "NAME", "ID", "IMAGE", "STATE", "HEALTH", "UPTIME", "PORTS")
+	for _, s := range statuses {
+		fmt.Printf("%-20s %-30s %-12s %-25s %-12s %-10s %-15s %s\n", s.Service, s.Name, s.ID, s.Image, s.State, s.Health, s.Uptime, strings.Join(s.Ports, ", "))
+	}
+}
+
+// Formats of status --output
+const (
+	statusFormatTable = "table"
+	statusFormatJSON  = "json"
+	statusFormatYAML  = "yaml"
+)
+
+// checkStatusFormat rejects an unknown status --output value
+func checkStatusFormat(format string) error {
+	switch format {
+	case "", statusFormatTable, statusFormatJSON, statusFormatYAML:
+		return nil
+	}
+	return NewError(ErrUsage, "invalid status output %q, expected table, json or yaml", format)
+}
+
+// StatusOptions selects how PrintStatus shows the state of the services
+type StatusOptions struct {
+	// Format is table, json or yaml. Without a format, sort order or
+	// replicas, the table compose prints is shown, or JSON with --output
+	// json.
+	Format string
+	// Sort orders the containers by name, file or state
+	Sort string
+	// Replicas shows the replica counts of each service instead of its
+	// containers
+	Replicas bool
+}
+
+// PrintStatus prints the state of the service containers and warns about
+// services running fewer replicas than their configured scale. As JSON or
+// YAML it fails when a configured service is not running. Like the
+// operations, it prints its errors.
+func (dcm *Manager) PrintStatus(opts StatusOptions) error {
+	err := checkStatusFormat(opts.Format)
+	if err == nil && opts.Sort != "" {
+		err = checkSortOrder(opts.Sort)
+	}
+	if err != nil {
+		dcm.PrintError(err)
+		return err
+	}
+	format := opts.Format
+	if format == "" && dcm.output == "json" {
+		format = statusFormatJSON
+	}
+	if format == "" && opts.Sort == "" && !opts.Replicas {
+		dcm.printActiveOperations()
+		if _, err := dcm.composeStatus(); err != nil {
+			return err
+		}
+		if len(dcm.config.Scale) > 0 {
+			if statuses, err := dcm.Status(context.Background()); err == nil {
+				dcm.warnBelowScale(dcm.replicaSummary(statuses))
+			}
+		}
+		return nil
+	}
+
+	order := opts.Sort
+	if order == "" {
+		order = SortByFile
+	}
+	statuses, err := dcm.Status(context.Background())
+	if err == nil {
+		err = dcm.sortStatuses(statuses, order)
+	}
+	if err != nil {
+		dcm.PrintError(err)
+		return err
+	}
+	replicas := dcm.replicaSummary(statuses)
+	var document interface{} = statuses
+	if opts.Replicas {
+		document = replicas
+	}
+	switch format {
+	case "", statusFormatTable:
+		dcm.printActiveOperations()
+		if opts.Replicas {
+			printReplicasTable(replicas)
+		} else {
+			printStatusTable(statuses)
+		}
+		dcm.warnBelowScale(replicas)
+		return nil
+	case statusFormatYAML:
+		data, err := yaml.Marshal(document)
+		if err != nil {
+			return err
+		}
+		os.Stdout.Write(data)
+	default:
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.SetEscapeHTML(false)
+		if err := encoder.Encode(document); err != nil {
+			return err
+		}
+	}
+	dcm.warnBelowScale(replicas)
+	if missing := notRunning(statuses, dcm.config.Services); len(missing) > 0 {
+		err := NewError(ErrServicesNotRunning, "configured services not running: %s", strings.Join(missing, ", "))
+		dcm.PrintError(err)
+		return err
+	}
+	return nil
+}
+
+// PrintServices prints the services of the compose files with their state,
+// in the given sort order
+func (dcm *Manager) PrintServices(order string) error {
+	if err := checkSortOrder(order); err != nil {
+		return err
+	}
+	services, err := dcm.ListServices()
+	if err == nil {
+		err = dcm.sortServices(services, order)
+	}
+	if err != nil {
+		return err
+	}
+	if dcm.output == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(services)
+	}
+	for _, s := range services {
+		fmt.Printf("%-20s %s\n", s.Name, s.State)
+	}
+	return nil
+}
+
+// parseComposePsJSON parses `ps --format json` output, which is a JSON array
+// in early Compose V2 releases and one JSON object per line in later ones.
+func parseComposePsJSON(output string) ([]ServiceStatus, error) {
+	output = strings.TrimSpace(output)
+	var entries []composePsEntry
+	if strings.HasPrefix(output, "[") {
+		if err := json.Unmarshal([]byte(output), &entries); err != nil {
+			return nil, fmt.Errorf("parsing ps output: %v", err)
+		}
+	} else {
+		for _, line := range strings.Split(output, "\n") {
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			var entry composePsEntry
+			if err := json.Unmarshal([]byte(line), &entry); err != nil {
+				return nil, fmt.Errorf("parsing ps output: %v", err)
+			}
+			entries = append(entries, entry)
+		}
+	}
+
+	statuses := make([]ServiceStatus, 0, len(entries))
+	for _, e := range entries {
+		status := ServiceStatus{
+			Name:     e.Name,
+			Service:  e.Service,
+			ID:       shortID(e.ID),
+			Image:    e.Image,
+			State:    e.State,
+			Health:   e.Health,
+			ExitCode: e.ExitCode,
+			Uptime:   uptimeFromStatus(e.Status),
+		}
+		for _, p := range e.Publishers {
+			if p.PublishedPort == 0 {
+				status.Ports = append(status.Ports, fmt.Sprintf("%d/%s", p.TargetPort, p.Protocol))
+				continue
+			}
+			status.Ports = append(status.Ports, fmt.Sprintf("%s:%d->%d/%s", p.URL, p.PublishedPort, p.TargetPort, p.Protocol))
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+var (
+	tableColumnSep = regexp.MustCompile(`\s{2,}`)
+	exitStateRe    = regexp.MustCompile(`^Exit (-?\d+)`)
+)
+
+// parseComposePsTable parses the table printed by docker-compose v1:
+//
+//	Name    Command    State    Ports
+//	---------------------------------
+//	app_web_1   nginx   Up (healthy)   0.0.0.0:80->80/tcp
+func parseComposePsTable(output, project string) []ServiceStatus {
+	var statuses []ServiceStatus
+	started := false
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(line, "---") {
+			started = true
+			continue
+		}
+		if !started || strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		cols := tableColumnSep.Split(strings.TrimSpace(line), -1)
+		if len(cols) < 3 {
+			continue
+		}
+		status := ServiceStatus{Name: cols[0]}
+		state := cols[2]
+		switch {
+		case strings.HasPrefix(state, "Up"):
+			status.State = "running"
+		case exitStateRe.MatchString(state):
+			status.State = "exited"
+			status.ExitCode, _ = strconv.Atoi(exitStateRe.FindStringSubmatch(state)[1])
+		default:
+			status.State = strings.ToLower(state)
+		}
+		for _, health := range []string{"unhealthy", "healthy", "health: starting"} {
+			if strings.Contains(state, "("+health+")") {
+				status.Health = strings.TrimPrefix(health, "health: ")
+				break
+			}
+		}
+		if len(cols) > 3 {
+			for _, port := range strings.Split(cols[3], ",") {
+				status.Ports = append(status.Ports, strings.TrimSpace(port))
+			}
+		}
+
+		// v1 names containers <project>_<service>_<index>
+		name := strings.TrimPrefix(status.Name, project+"_")
+		if i := strings.LastIndex(name, "_"); i > 0 {
+			name = name[:i]
+		}
+		status.Service = name
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// notRunning returns the services of want that have no running container
+func notRunning(statuses []ServiceStatus, want []string) []string {
+	running := make(map[string]bool)
+	for _, s := range statuses {
+		if s.State == "running" {
+			running[s.Service] = true
+		}
+	}
+	var missing []string
+	for _, name := range want {
+		if !running[name] {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,86 @@
+package manager
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSortStatuses(t *testing.T) {
+	dcm := newTestManager(t, "", "", &fakeRunner{})
+	// The compose file defines web, worker and db in that order
+	statuses := func() []ServiceStatus {
+		return []ServiceStatus{
+			{Service: "db", Name: "test-db-1", State: "running"},
+			{Service: "orphan", Name: "test-orphan-1", State: "running"},
+			{Service: "worker", Name: "test-worker-2", State: "running"},
+			{Service: "web", Name: "test-web-1", State: "exited"},
+			{Service: "worker", Name: "test-worker-1", State: "running"},
+		}
+	}
+	tests := []struct {
+		order string
+		want  []string
+	}{
+		{SortByFile, []string{"test-web-1", "test-worker-2", "test-worker-1", "test-db-1", "test-orphan-1"}},
+		{SortByName, []string{"test-db-1", "test-orphan-1", "test-web-1", "test-worker-2", "test-worker-1"}},
+		{SortByState, []string{"test-web-1", "test-worker-2", "test-worker-1", "test-db-1", "test-orphan-1"}},
+	}
+	for _, tt := range tests {
+		sorted := statuses()
+		if err := dcm.sortStatuses(sorted, tt.order); err != nil {
+			t.Fatalf("sort by %s: %v", tt.order, err)
+		}
+		var names []string
+		for _, s := range sorted {
+			names = append(names, s.Name)
+		}
+		if !reflect.DeepEqual(names, tt.want) {
+			t.Errorf("sort by %s = %q, want %q", tt.order, names, tt.want)
+		}
+	}
+}
+
+func TestSortServices(t *testing.T) {
+	dcm := newTestManager(t, "", "", &fakeRunner{})
+	services := func() []ServiceInfo {
+		return []ServiceInfo{
+			{Name: "db", State: "stopped"},
+			{Name: "web", State: "running"},
+			{Name: "worker", State: "stopped"},
+		}
+	}
+	tests := []struct {
+		order string
+		want  []string
+	}{
+		{SortByFile, []string{"web", "worker", "db"}},
+		{SortByName, []string{"db", "web", "worker"}},
+		{SortByState, []string{"web", "worker", "db"}},
+	}
+	for _, tt := range tests {
+		sorted := services()
+		if err := dcm.sortServices(sorted, tt.order); err != nil {
+			t.Fatalf("sort by %s: %v", tt.order, err)
+		}
+		var names []string
+		for _, s := range sorted {
+			names = append(names, s.Name)
+		}
+		if !reflect.DeepEqual(names, tt.want) {
+			t.Errorf("sort by %s = %q, want %q", tt.order, names, tt.want)
+		}
+	}
+}
+
+func TestCheckSortOrder(t *testing.T) {
+	for _, order := range []string{SortByFile, SortByName, SortByState} {
+		if err := checkSortOrder(order); err != nil {
+			t.Errorf("checkSortOrder(%q) = %v", order, err)
+		}
+	}
+	for _, order := range []string{"", "Name", "created"} {
+		if err := checkSortOrder(order); TypeOf(err) != ErrUsage {
+			t.Errorf("checkSortOrder(%q) = %v, want a %s error", order, err, ErrUsage)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Outcomes of a service in a summarized build or pull
+const (
+	stepOK      = "ok"
+	stepFailed  = "failed"
+	stepSkipped = "skipped"
+)
+
+// StepResult is the outcome of building or pulling one service
+type StepResult struct {
+	Service string `json:"service"`
+	Status  string `json:"status"`
+	// Duration is in seconds
+	Duration float64 `json:"duration_seconds"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// SummaryOptions controls RunSummarized
+type SummaryOptions struct {
+	// VerboseOnError prints the full output of the services that failed
+	VerboseOnError bool
+}
+
+// summaryTargets returns the services a summarized verb runs on, one at a
+// time: the build plans of the services in build order for build, the given
+// services or those in scope for pull
+func (dcm *Manager) summaryTargets(verb string, services []string) ([]string, error) {
+	for _, name := range services {
+		if err := dcm.checkService(name); err != nil {
+			return nil, err
+		}
+	}
+	if verb == "build" {
+		if len(services) > 1 {
+			// The plans of a group merged, in the order of the whole plan
+			full, _, err := dcm.buildPlan("")
+			if err != nil {
+				return nil, err
+			}
+			var wanted []string
+			for _, name := range services {
+				plan, _, err := dcm.buildPlan(name)
+				if err != nil {
+					return nil, err
+				}
+				wanted = append(wanted, plan...)
+			}
+			return filterServices(full, wanted), nil
+		}
+		serviceName := ""
+		if len(services) == 1 {
+			serviceName = services[0]
+		}
+		plan, _, err := dcm.buildPlan(serviceName)
+		if err != nil {
+			return nil, err
+		}
+		if scope := dcm.scopedServices(); serviceName == "" && len(scope) > 0 {
+			plan = filterServices(plan, scope)
+		}
+		return plan, nil
+	}
+	if len(services) > 0 {
+		return services, nil
+	}
+	if scope := dcm.scopedServices(); len(scope) > 0 {
+		return scope, nil
+	}
+	return dcm.composeServices()
+}
+
+// RunSummarized builds or pulls services one at a time, keeping their output
+// out of the terminal, and prints one line per service with its outcome and
+// duration, for CI logs. A failed build skips the services after it, which
+// may depend on it; pulls go on.
+func (dcm *Manager) RunSummarized(verb string, services []string, opts SummaryOptions) ([]StepResult, error) {
+	targets, err := dcm.summaryTargets(verb, services)
+	if err != nil {
+		return nil, err
+	}
+	if len(targets) == 0 {
+		dcm.Infof("No services to %s\n", verb)
+	}
+
+	var results []StepResult
+	var failed []string
+	for _, name := range targets {
+		if verb == "build" && len(failed) > 0 {
+			results = append(results, StepResult{Service: name, Status: stepSkipped})
+			dcm.printStepResult(verb, results[len(results)-1])
+			continue
+		}
+		argv, err := dcm.composeArgs(verb, name)
+		if err != nil {
+			return results, err
+		}
+		if dcm.dryRunSkip(argv) {
+			continue
+		}
+		started := time.Now()
+		output, err := dcm.command(context.Background(), argv).CombinedOutput()
+		dcm.logCommand(argv, started, err)
+		result := StepResult{Service: name, Status: stepOK, Duration: time.Since(started).Seconds()}
+		if err != nil {
+			result.Status, result.Error = stepFailed, err.Error()
+			failed = append(failed, name)
+		}
+		results = append(results, result)
+		dcm.printStepResult(verb, result)
+		if err != nil && opts.VerboseOnError {
+			fmt.Fprintf(os.Stderr, "--- %s output of %s ---\n%s", verb, name, output)
+			if len(output) > 0 && output[len(output)-1] != '\n' {
+				fmt.Fprintln(os.Stderr)
+			}
+			fmt.Fprintf(os.Stderr, "--- end of %s output of %s ---\n", verb, name)
+		}
+	}
+	if len(failed) > 0 {
+		return results, fmt.Errorf("%s failed for: %s", verb, strings.Join(failed, ", "))
+	}
+	return results, nil
+}
+
+// printStepResult prints the summary line of one service
+func (dcm *Manager) printStepResult(verb string, r StepResult) {
+	if dcm.output == "json" {
+		return
+	}
+	line := fmt.Sprintf("%s %-20s %-8s", verb, r.Service, r.Status)
+	if r.Status != stepSkipped {
+		line += " " + time.Duration(r.Duration*float64(time.Second)).Round(100*time.Millisecond).String()
+	}
+	fmt.Println(line)
+}
+
+// RunSummary runs build or pull --summary-only for the command line
+func (dcm *Manager) RunSummary(verb string, services []string, summaryOnly, verboseOnError bool) error {
+	if !summaryOnly {
+		return NewError(ErrUsage, "--verbose-on-error needs --summary-only")
+	}
+	var results []StepResult
+	err := dcm.Track(verb, services, func() (err error) {
+		results, err = dcm.RunSummarized(verb, services, SummaryOptions{VerboseOnError: verboseOnError})
+		return err
+	})
+	if dcm.output == "json" && results != nil {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(results)
+	}
+	return err
+}
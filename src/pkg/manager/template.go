@@ -0,0 +1,272 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// secretTimeout bounds the command of a secret provider
+	secretTimeout = 30 * time.Second
+	// secretMask replaces secret values in config render
+	secretMask = "********"
+)
+
+// placeholder matches the ${NAME} references of the config, with an
+// optional :-default, -default, :?message or ?message, and $$, an escaped
+// dollar
+var placeholder = regexp.MustCompile(`\$(\$|\{([A-Za-z_][A-Za-z0-9_]*)(?:(:?[-?])([^}]*))?\})`)
+
+// SecretSource is how a secret of the config is read: a command printing it,
+// such as "op read op://vault/db/password" or
+// "vault kv get -field=password secret/db"
+type SecretSource struct {
+	Command string `yaml:"command"`
+}
+
+// configTemplate resolves the placeholders of the config file from the
+// environment of the process, the .env file next to the config and the
+// secrets section, in that order
+type configTemplate struct {
+	dir     string
+	env     map[string]string
+	dotEnv  map[string]string
+	secrets map[string]SecretSource
+	// resolved caches the value of each secret read, so every provider runs
+	// once
+	resolved map[string]string
+	// unresolved lists the placeholders nothing defines, left as written
+	unresolved []string
+}
+
+// newConfigTemplate prepares the resolution of the placeholders of the config
+// text in data, read from configPath
+func newConfigTemplate(configPath string, data []byte) (*configTemplate, error) {
+	var section struct {
+		Secrets map[string]SecretSource `yaml:"secrets"`
+	}
+	if err := yaml.Unmarshal(data, &section); err != nil {
+		return nil, NewError(ErrConfig, "parsing config file %s: %w", configPath, err)
+	}
+	t := &configTemplate{
+		dir:      filepath.Dir(configPath),
+		env:      hostEnv(),
+		secrets:  section.Secrets,
+		resolved: make(map[string]string),
+	}
+	if vars, err := readEnvFile(filepath.Join(t.dir, ".env")); err == nil {
+		t.dotEnv = vars
+	} else if !os.IsNotExist(err) {
+		return nil, NewError(ErrConfig, "%v", err)
+	}
+	for name, s := range t.secrets {
+		if strings.TrimSpace(s.Command) == "" {
+			return nil, NewError(ErrConfig, "%s: secrets.%s: command must be set", configPath, name)
+		}
+	}
+	return t, nil
+}
+
+// lookup returns the value of a variable and whether it is a secret
+func (t *configTemplate) lookup(name string) (value string, secret, ok bool, err error) {
+	if v, ok := t.env[name]; ok {
+		return v, false, true, nil
+	}
+	if v, ok := t.dotEnv[name]; ok {
+		return v, false, true, nil
+	}
+	source, ok := t.secrets[name]
+	if !ok {
+		return "", false, false, nil
+	}
+	if v, ok := t.resolved[name]; ok {
+		return v, true, true, nil
+	}
+	v, err := t.readSecret(name, source)
+	if err != nil {
+		return "", true, false, err
+	}
+	t.resolved[name] = v
+	return v, true, true, nil
+}
+
+// readSecret runs the command of a secret provider from the directory of the
+// config. The placeholders of the command resolve from the environment only.
+func (t *configTemplate) readSecret(name string, source SecretSource) (string, error) {
+	command := placeholder.ReplaceAllStringFunc(source.Command, func(m string) string {
+		sub := placeholder.FindStringSubmatch(m)
+		if v, ok := t.env[sub[2]]; ok {
+			return v
+		}
+		if v, ok := t.dotEnv[sub[2]]; ok {
+			return v
+		}
+		return m
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), secretTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Dir = t.dir
+	output, err := cmd.Output()
+	var exitErr *exec.ExitError
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		return "", NewError(ErrConfig, "secret %s: %s timed out after %s", name, command, secretTimeout)
+	case errors.As(err, &exitErr):
+		return "", NewError(ErrConfig, "secret %s: %s: %v: %s", name, command, err, firstLine(string(exitErr.Stderr)))
+	case err != nil:
+		return "", NewError(ErrConfig, "secret %s: %s: %v", name, command, err)
+	}
+	return strings.TrimRight(string(output), "\r\n"), nil
+}
+
+// expand replaces the placeholders of text. With mask, secret values are
+// replaced by a mask. A placeholder nothing defines and without a default is
+// left as written, so that the commands of hooks can still reference
+// variables set when they run.
+func (t *configTemplate) expand(text string, mask bool) (string, error) {
+	var failed error
+	seen := make(map[string]bool)
+	expanded := placeholder.ReplaceAllStringFunc(text, func(m string) string {
+		sub := placeholder.FindStringSubmatch(m)
+		if sub[1] == "$" {
+			return "$"
+		}
+		name, modifier, arg := sub[2], sub[3], sub[4]
+		value, secret, ok, err := t.lookup(name)
+		if err != nil {
+			if failed == nil {
+				failed = err
+			}
+			return m
+		}
+		empty := !ok || (strings.HasPrefix(modifier, ":") && value == "")
+		switch {
+		case empty && strings.HasSuffix(modifier, "-"):
+			return arg
+		case empty && strings.HasSuffix(modifier, "?"):
+			if failed == nil {
+				if arg == "" {
+					arg = "is not set"
+				}
+				failed = NewError(ErrConfig, "${%s}: %s", name, arg)
+			}
+			return m
+		case !ok:
+			if !seen[name] {
+				seen[name] = true
+				t.unresolved = append(t.unresolved, name)
+			}
+			return m
+		case secret && mask:
+			return secretMask
+		}
+		return value
+	})
+	return expanded, failed
+}
+
+// expandValue replaces the placeholders of the string values of a parsed
+// YAML document, leaving the keys and the secrets section as written, so
+// that a value containing YAML syntax stays a plain string
+func (t *configTemplate) expandValue(v interface{}, mask bool) (interface{}, error) {
+	switch v := v.(type) {
+	case string:
+		expanded, err := t.expand(v, mask)
+		if err != nil || expanded == v {
+			return expanded, err
+		}
+		// A placeholder standing for a number or a boolean, such as a
+		// replica count, keeps its type when written back
+		var scalar interface{}
+		if yaml.Unmarshal([]byte(expanded), &scalar) == nil {
+			switch scalar.(type) {
+			case int, float64, bool:
+				if out, err := yaml.Marshal(scalar); err == nil && strings.TrimSpace(string(out)) == expanded {
+					return scalar, nil
+				}
+			}
+		}
+		return expanded, nil
+	case yaml.MapSlice:
+		for i, item := range v {
+			if item.Key == "secrets" {
+				continue
+			}
+			value, err := t.expandValue(item.Value, mask)
+			if err != nil {
+				return nil, err
+			}
+			v[i].Value = value
+		}
+	case []interface{}:
+		for i, item := range v {
+			value, err := t.expandValue(item, mask)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = value
+		}
+	}
+	return v, nil
+}
+
+// render parses the config text in data and returns it as YAML with its
+// placeholders resolved
+func (t *configTemplate) render(configPath string, data []byte, mask bool) ([]byte, error) {
+	var document yaml.MapSlice
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, NewError(ErrConfig, "parsing config file %s: %w", configPath, err)
+	}
+	if _, err := t.expandValue(document, mask); err != nil {
+		return nil, NewError(ErrConfig, "%s: %w", configPath, err)
+	}
+	return yaml.Marshal(document)
+}
+
+// templateConfig resolves the placeholders of the config file text
+func (dcm *Manager) templateConfig(data []byte) ([]byte, error) {
+	if !placeholder.Match(data) {
+		return data, nil
+	}
+	t, err := newConfigTemplate(dcm.configPath, data)
+	if err != nil {
+		return nil, err
+	}
+	return t.render(dcm.configPath, data, false)
+}
+
+// RenderConfig prints the config file with its placeholders resolved and
+// the values of secrets masked, and warns about the placeholders nothing
+// defines
+func (dcm *Manager) RenderConfig() error {
+	data, err := ioutil.ReadFile(dcm.configPath)
+	if err != nil {
+		return NewError(ErrConfig, "reading config file: %w", err)
+	}
+	t, err := newConfigTemplate(dcm.configPath, data)
+	if err != nil {
+		return err
+	}
+	rendered, err := t.render(dcm.configPath, data, true)
+	if err != nil {
+		return err
+	}
+	os.Stdout.Write(rendered)
+	sort.Strings(t.unresolved)
+	for _, name := range t.unresolved {
+		dcm.Warnf("${%s} is not defined in the environment, %s or the secrets section, it is left as written\n",
+			name, filepath.Join(t.dir, ".env"))
+	}
+	return nil
+}
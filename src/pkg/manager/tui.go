@@ -0,0 +1,353 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	ansiReverse = "\033[7m"
+	// tuiRefresh is how often the TUI refreshes the service list
+	tuiRefresh = 2 * time.Second
+)
+
+// tuiAction is an operation bound to a key of the TUI, run on the selected
+// service
+type tuiAction struct {
+	verb string
+	args func() []string
+	// confirm asks for y before running, for operations losing state
+	confirm bool
+}
+
+// tuiActions maps keys to operations
+func (dcm *Manager) tuiActions() map[byte]tuiAction {
+	return map[byte]tuiAction{
+		's': {verb: "start", args: dcm.upArgs},
+		'x': {verb: "stop", args: func() []string { return []string{"stop"} }},
+		'r': {verb: "restart", args: func() []string { return []string{"restart"} }},
+		'd': {verb: "remove", args: func() []string { return []string{"rm", "-f", "-s"} }, confirm: true},
+	}
+}
+
+// tuiRow is one service of the TUI list, with its containers summed up
+type tuiRow struct {
+	Service string
+	State   string
+	Health  string
+	Running int
+	Total   int
+}
+
+// tuiRows groups service containers into one row per service, keeping their
+// order
+func tuiRows(statuses []ServiceStatus) []tuiRow {
+	var rows []tuiRow
+	index := make(map[string]int)
+	for _, s := range statuses {
+		i, ok := index[s.Service]
+		if !ok {
+			i = len(rows)
+			index[s.Service] = i
+			rows = append(rows, tuiRow{Service: s.Service, State: s.State, Health: s.Health})
+		}
+		if s.State == stateNotCreated {
+			continue
+		}
+		rows[i].Total++
+		if s.State == "running" {
+			rows[i].Running++
+		}
+		if s.Health == "unhealthy" || rows[i].Health == "" {
+			rows[i].Health = s.Health
+		}
+	}
+	return rows
+}
+
+// rawTerminal turns off line buffering and echo of the terminal on stdin
+// with stty, so keys are read as they are pressed, and returns the function
+// restoring it. Ctrl-C still interrupts.
+func rawTerminal() (func(), error) {
+	stty := func(args ...string) (string, error) {
+		cmd := exec.Command("stty", args...)
+		cmd.Stdin = os.Stdin
+		output, err := cmd.Output()
+		return strings.TrimSpace(string(output)), err
+	}
+	saved, err := stty("-g")
+	if err != nil {
+		return nil, fmt.Errorf("reading terminal settings: %v", err)
+	}
+	if _, err := stty("-icanon", "-echo", "min", "1"); err != nil {
+		return nil, fmt.Errorf("setting terminal to raw mode: %v", err)
+	}
+	return func() { stty(saved) }, nil
+}
+
+// tui is the state of a running TUI
+type tui struct {
+	dcm      *Manager
+	rows     []tuiRow
+	selected int
+	// following is the service whose logs fill the log pane
+	following  string
+	stopFollow context.CancelFunc
+	tail       *logTail
+	message    string
+	busy       bool
+	// pending is an action waiting for y
+	pending *tuiAction
+}
+
+// RunTUI runs the full screen interactive mode: a live list of the services
+// with their state, the logs of the selected service below it, and keys to
+// start, stop, restart and remove the selected service. It returns when the
+// user presses q or Ctrl-C.
+func (dcm *Manager) RunTUI() error {
+	if !IsTerminal(os.Stdin) || !IsTerminal(os.Stdout) {
+		return NewError(ErrUsage, "tui needs a terminal")
+	}
+	restore, err := rawTerminal()
+	if err != nil {
+		return err
+	}
+	defer restore()
+	// Operations run from the TUI must not write over the screen
+	quiet := dcm.quiet
+	dcm.quiet = true
+	defer func() { dcm.quiet = quiet }()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Print(ansiAltScreen + ansiHideCursor)
+	defer fmt.Print(ansiShowCursor + ansiMainScreen)
+
+	keys := make(chan byte)
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			if _, err := os.Stdin.Read(buf); err != nil {
+				close(keys)
+				return
+			}
+			keys <- buf[0]
+		}
+	}()
+	// Buffered so an action finishing after the user quit does not block
+	done := make(chan string, 1)
+
+	t := &tui{dcm: dcm}
+	defer t.follow("")
+	t.refresh()
+	ticker := time.NewTicker(tuiRefresh)
+	defer ticker.Stop()
+	// escape tracks an arrow key sequence, ESC [ A or ESC [ B
+	escape := 0
+	for {
+		t.render()
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			t.refresh()
+		case msg := <-done:
+			t.busy, t.message = false, msg
+			t.refresh()
+		case key, ok := <-keys:
+			if !ok {
+				return nil
+			}
+			switch {
+			case key == 27:
+				escape = 1
+				continue
+			case escape == 1 && key == '[':
+				escape = 2
+				continue
+			case escape == 2 && key == 'A':
+				key = 'k'
+			case escape == 2 && key == 'B':
+				key = 'j'
+			}
+			escape = 0
+			if t.pending != nil {
+				action := *t.pending
+				t.pending = nil
+				if key == 'y' || key == 'Y' {
+					t.run(action, done)
+				} else {
+					t.message = "Cancelled"
+				}
+				continue
+			}
+			if quit := t.key(key, done); quit {
+				return nil
+			}
+		}
+	}
+}
+
+// key handles a key press, reporting whether it quits
+func (t *tui) key(key byte, done chan<- string) bool {
+	switch key {
+	case 'q':
+		return true
+	case 'k':
+		if t.selected > 0 {
+			t.selected--
+		}
+		t.follow(t.selectedService())
+	case 'j':
+		if t.selected < len(t.rows)-1 {
+			t.selected++
+		}
+		t.follow(t.selectedService())
+	case 'f':
+		t.refresh()
+		t.message = "Refreshed"
+	default:
+		action, ok := t.dcm.tuiActions()[key]
+		if !ok {
+			return false
+		}
+		service := t.selectedService()
+		switch {
+		case service == "":
+			t.message = "No service selected"
+		case t.busy:
+			t.message = "Wait for the running operation to finish"
+		case action.confirm:
+			t.pending = &action
+			t.message = fmt.Sprintf("%s %s? [y/N]", action.verb, service)
+		default:
+			t.run(action, done)
+		}
+	}
+	return false
+}
+
+// selectedService returns the service under the cursor, empty when there
+// is none
+func (t *tui) selectedService() string {
+	if t.selected < len(t.rows) {
+		return t.rows[t.selected].Service
+	}
+	return ""
+}
+
+// run starts an action on the selected service in the background, its
+// outcome is sent to done. One action runs at a time.
+func (t *tui) run(action tuiAction, done chan<- string) {
+	service := t.selectedService()
+	t.busy = true
+	t.message = fmt.Sprintf("Running %s on %s...", action.verb, service)
+	go func() {
+		var output []byte
+		err := t.dcm.Track(action.verb, []string{service}, func() error {
+			argv, err := t.dcm.composeArgs(append(action.args(), service)...)
+			if err != nil {
+				return err
+			}
+			started := time.Now()
+			output, err = t.dcm.command(context.Background(), argv).CombinedOutput()
+			t.dcm.logCommand(argv, started, err)
+			return err
+		})
+		if err != nil {
+			lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+			done <- fmt.Sprintf("%s %s failed: %v: %s", action.verb, service, err, lines[len(lines)-1])
+			return
+		}
+		done <- fmt.Sprintf("%s %s done", action.verb, service)
+	}()
+}
+
+// refresh reloads the service list, keeping the selection on the same
+// service
+func (t *tui) refresh() {
+	statuses, err := t.dcm.Status(context.Background())
+	if err == nil {
+		err = t.dcm.sortStatuses(statuses, SortByFile)
+	}
+	if err != nil {
+		t.message = "Status unavailable: " + err.Error()
+		return
+	}
+	selected := t.selectedService()
+	t.rows = tuiRows(statuses)
+	t.selected = 0
+	for i, row := range t.rows {
+		if row.Service == selected {
+			t.selected = i
+		}
+	}
+	t.follow(t.selectedService())
+}
+
+// follow switches the log pane to the logs of service, or stops it when
+// service is empty
+func (t *tui) follow(service string) {
+	if service == t.following {
+		return
+	}
+	if t.stopFollow != nil {
+		t.stopFollow()
+		t.stopFollow = nil
+	}
+	t.following = service
+	t.tail = &logTail{max: 500}
+	if service == "" {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.stopFollow = cancel
+	go t.dcm.followLogs(ctx, []string{service}, t.tail)
+}
+
+// render draws one frame: the service list, the log pane and a status line
+func (t *tui) render() {
+	rows, cols := terminalSize()
+	fit := func(line string) string {
+		if len(line) > cols {
+			line = line[:cols]
+		}
+		return line + ansiClearLine
+	}
+
+	frame := []string{
+		fit(fmt.Sprintf("dcm - %s - %s", t.dcm.ProjectName(), time.Now().Format("15:04:05"))),
+		fit("up/down or j/k select  s start  x stop  r restart  d remove  f refresh  q quit"),
+		fit(fmt.Sprintf("  %-24s %-12s %-10s %s", "SERVICE", "STATE", "HEALTH", "RUNNING")),
+	}
+	for i, row := range t.rows {
+		line := fit(fmt.Sprintf("  %-24s %-12s %-10s %d/%d", row.Service, row.State, row.Health, row.Running, row.Total))
+		if i == t.selected {
+			line = ansiReverse + line + ansiReset
+		}
+		frame = append(frame, line)
+	}
+	frame = append(frame, fit(fmt.Sprintf("--- logs of %s %s", t.following, strings.Repeat("-", cols))))
+
+	// The log pane gets whatever room the list leaves above the status line
+	if room := rows - len(frame) - 2; room > 0 && t.tail != nil {
+		for _, line := range t.tail.last(room) {
+			frame = append(frame, fit(line))
+		}
+	}
+	for len(frame) < rows-1 {
+		frame = append(frame, ansiClearLine)
+	}
+	if len(frame) > rows-1 {
+		frame = frame[:rows-1]
+	}
+	frame = append(frame, fit(t.message))
+	fmt.Print(ANSIHome + strings.Join(frame, "\n") + ANSIClearBelow)
+}
@@ -0,0 +1,331 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// UpdateOptions controls what Update does besides pulling, building and
+// recreating
+type UpdateOptions struct {
+	// Prune removes the images replaced by the update
+	Prune bool
+	// DryRun prints the steps of the update without running them
+	DryRun bool
+	// NoRollback leaves a service that fails its health check after the
+	// update on its new image
+	NoRollback bool
+	// HealthTimeout bounds the health check of each service after it is
+	// recreated, zero means wait_timeout from the config
+	HealthTimeout time.Duration
+}
+
+// UpdateRecord is the images an update replaced, kept so the update can be
+// rolled back to the exact images it started from
+type UpdateRecord struct {
+	At       time.Time      `json:"at"`
+	Services []UpdatedImage `json:"services"`
+}
+
+// UpdatedImage is the image of a service before and after an update
+type UpdatedImage struct {
+	Service string `json:"service"`
+	// Image is the reference compose runs the service from
+	Image string `json:"image"`
+	// Previous and Current are image IDs
+	Previous        string   `json:"previous"`
+	PreviousDigests []string `json:"previous_digests,omitempty"`
+	Current         string   `json:"current"`
+}
+
+func (dcm *Manager) updateRecordPath() string {
+	return filepath.Join(dcm.stateDir(), "update.json")
+}
+
+// updateResult is the outcome of updating one service
+type updateResult struct {
+	service  string
+	oldImage string
+	newImage string
+	// ref is the reference of the image the service runs from
+	ref        string
+	rolledBack bool
+	err        error
+}
+
+func (r updateResult) changed() bool {
+	return r.err == nil && r.newImage != "" && r.newImage != r.oldImage
+}
+
+// localImage returns the ID and the reference of the first of the image
+// references that exists locally, or empty strings when none does
+func (dcm *Manager) localImage(refs []string) (id, ref string) {
+	for _, ref := range refs {
+		output, err := dcm.dockerOutput("image", "inspect", "--format", "{{.Id}}", ref)
+		if err == nil {
+			return strings.TrimSpace(output), ref
+		}
+	}
+	return "", ""
+}
+
+// imageDigests returns the registry digests of a local image
+func (dcm *Manager) imageDigests(id string) []string {
+	output, err := dcm.dockerOutput("image", "inspect", "--format", "{{json .RepoDigests}}", id)
+	if err != nil {
+		return nil
+	}
+	var digests []string
+	json.Unmarshal([]byte(output), &digests)
+	return digests
+}
+
+// shortImageID shortens an image ID the way docker images does
+func shortImageID(id string) string {
+	return shortID(strings.TrimPrefix(id, "sha256:"))
+}
+
+// recreateArgs recreates a single service on its current image, keeping the
+// replica count the scale section of the config gives it
+func (dcm *Manager) recreateArgs(service string) ([]string, error) {
+	args := []string{"up", "-d", "--no-deps"}
+	scaled, err := dcm.configuredScale([]string{service})
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range scaled {
+		args = append(args, "--scale", fmt.Sprintf("%s=%d", t.Service, t.Replicas))
+	}
+	return append(args, service), nil
+}
+
+// rollbackImage points the image reference of a service back at a previous
+// image and recreates the service from it
+func (dcm *Manager) rollbackImage(service, ref, id string, healthTimeout time.Duration) error {
+	dcm.Infof("Rolling %s back to image %s...\n", service, shortImageID(id))
+	if _, err := dcm.dockerOutput("tag", id, ref); err != nil {
+		return err
+	}
+	args, err := dcm.recreateArgs(service)
+	if err != nil {
+		return err
+	}
+	if err := dcm.composeStreaming(args...); err != nil {
+		return err
+	}
+	if err := dcm.WaitReady([]string{service}, WaitOptions{Timeout: healthTimeout, FailFast: true}); err != nil {
+		dcm.Warnf("%s is still not ready on its previous image: %v\n", service, err)
+	}
+	return nil
+}
+
+// Update pulls the images of a service, or of the services in scope when
+// serviceName is empty, rebuilds those with a build section and recreates
+// the ones whose image changed one at a time, waiting for each to be ready.
+// A service that is not ready in time is rolled back to its previous image,
+// unless opts.NoRollback. The images replaced are recorded, see
+// RollbackUpdate. A service that fails does not stop the others; the
+// failures are listed once all are processed.
+func (dcm *Manager) Update(serviceName string, opts UpdateOptions) error {
+	if serviceName != "" {
+		if err := dcm.checkService(serviceName); err != nil {
+			return err
+		}
+	}
+	project, err := dcm.composeProject()
+	if err != nil {
+		return err
+	}
+	var services []string
+	switch {
+	case serviceName != "":
+		services = []string{serviceName}
+	case len(dcm.scopedServices()) > 0:
+		services = dcm.scopedServices()
+	default:
+		services = project.Names
+	}
+	if len(services) == 0 {
+		return NewError(ErrConfig, "no services to update, set compose_file in %s", dcm.configPath)
+	}
+
+	// Built services go in build order, so a service built FROM another one
+	// uses its fresh image
+	order, _, err := dcm.buildOrder()
+	if err != nil {
+		return err
+	}
+	var pulled, built []string
+	for _, name := range services {
+		if service, ok := project.Services[name]; !ok || service.Build == nil {
+			pulled = append(pulled, name)
+		}
+	}
+	built = filterServices(order, services)
+
+	if opts.DryRun {
+		for _, name := range pulled {
+			fmt.Printf("would pull      %s\n", name)
+		}
+		for _, name := range built {
+			fmt.Printf("would build     %s\n", name)
+		}
+		fmt.Printf("would recreate  the services whose image changes, one at a time\n")
+		if !opts.NoRollback {
+			fmt.Printf("would roll back the services not ready after it\n")
+		}
+		if opts.Prune {
+			fmt.Printf("would remove    the images they replace\n")
+		}
+		return nil
+	}
+
+	results := make(map[string]*updateResult)
+	for _, name := range services {
+		if _, ok := project.Services[name]; !ok {
+			results[name] = &updateResult{service: name, err: fmt.Errorf("not defined in the compose files")}
+			continue
+		}
+		id, ref := dcm.localImage(dcm.serviceImages(project, name))
+		results[name] = &updateResult{service: name, oldImage: id, ref: ref}
+	}
+
+	dcm.Infof("Pulling images...\n")
+	for _, name := range pulled {
+		if results[name].err != nil {
+			continue
+		}
+		if err := dcm.composeStreaming("pull", name); err != nil {
+			results[name].err = fmt.Errorf("pull: %v", err)
+		}
+	}
+	dcm.Infof("Building services...\n")
+	for _, name := range built {
+		if err := dcm.composeStreaming("build", "--pull", name); err != nil {
+			results[name].err = fmt.Errorf("build: %v", err)
+		}
+	}
+
+	var changed []string
+	for _, name := range services {
+		r := results[name]
+		if r.err != nil {
+			continue
+		}
+		r.newImage, r.ref = dcm.localImage(dcm.serviceImages(project, name))
+		if r.changed() {
+			changed = append(changed, name)
+		}
+	}
+	if len(changed) > 0 {
+		record := UpdateRecord{At: time.Now().UTC()}
+		for _, name := range changed {
+			r := results[name]
+			record.Services = append(record.Services, UpdatedImage{
+				Service:         name,
+				Image:           r.ref,
+				Previous:        r.oldImage,
+				PreviousDigests: dcm.imageDigests(r.oldImage),
+				Current:         r.newImage,
+			})
+		}
+		// Written before any service is recreated, so an interrupted update
+		// can still be rolled back
+		if err := writeJSONFile(dcm.updateRecordPath(), record); err != nil {
+			dcm.Warnf("could not record the images replaced: %v\n", err)
+		}
+	}
+	for _, name := range changed {
+		r := results[name]
+		dcm.Infof("Recreating %s...\n", name)
+		args, err := dcm.recreateArgs(name)
+		if err == nil {
+			err = dcm.composeStreaming(args...)
+		}
+		if err != nil {
+			r.err = fmt.Errorf("recreate: %v", err)
+		} else if err := dcm.WaitReady([]string{name}, WaitOptions{Timeout: opts.HealthTimeout, FailFast: true}); err != nil {
+			r.err = fmt.Errorf("health check: %v", err)
+		}
+		if r.err == nil || opts.NoRollback || r.oldImage == "" {
+			continue
+		}
+		if err := dcm.rollbackImage(name, r.ref, r.oldImage, opts.HealthTimeout); err != nil {
+			r.err = fmt.Errorf("%v; rollback: %v", r.err, err)
+			continue
+		}
+		r.rolledBack = true
+	}
+	if opts.Prune {
+		for _, name := range changed {
+			r := results[name]
+			if r.err != nil || r.oldImage == "" {
+				continue
+			}
+			if _, err := dcm.dockerOutput("image", "rm", r.oldImage); err != nil {
+				dcm.Warnf("could not remove the old image of %s: %v\n", name, err)
+			}
+		}
+	}
+
+	var failed []string
+	for _, name := range services {
+		r := results[name]
+		switch {
+		case r.rolledBack:
+			fmt.Printf("  %-20s rolled back to %s: %v\n", name, shortImageID(r.oldImage), r.err)
+			failed = append(failed, name)
+		case r.err != nil:
+			fmt.Printf("  %-20s failed: %v\n", name, r.err)
+			failed = append(failed, name)
+		case r.changed():
+			fmt.Printf("  %-20s updated\n", name)
+		default:
+			fmt.Printf("  %-20s already current\n", name)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("update failed for %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// RollbackUpdate recreates the services of the last update, or only
+// serviceName, from the images they ran before it, as recorded by Update
+func (dcm *Manager) RollbackUpdate(serviceName string, healthTimeout time.Duration) error {
+	var record UpdateRecord
+	if err := readJSONFile(dcm.updateRecordPath(), &record); err != nil {
+		return NewError(ErrUsage, "no update to roll back: %v", err)
+	}
+	var failed []string
+	found := false
+	for _, u := range record.Services {
+		if serviceName != "" && u.Service != serviceName {
+			continue
+		}
+		found = true
+		if u.Previous == "" || u.Image == "" {
+			continue
+		}
+		if dcm.dryRun {
+			fmt.Printf("Would roll %s back to image %s\n", u.Service, shortImageID(u.Previous))
+			continue
+		}
+		if err := dcm.rollbackImage(u.Service, u.Image, u.Previous, healthTimeout); err != nil {
+			fmt.Printf("  %-20s failed: %v\n", u.Service, err)
+			failed = append(failed, u.Service)
+			continue
+		}
+		fmt.Printf("  %-20s rolled back to %s\n", u.Service, shortImageID(u.Previous))
+	}
+	if !found {
+		return NewError(ErrUsage, "the update of %s did not change %s", record.At.Local().Format(time.RFC3339), serviceName)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("rollback failed for %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package manager
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakePull makes the image of a service change when compose pulls it
+func fakePull(runner *fakeRunner, service string) {
+	pulled := false
+	runner.handle("pull "+service, func(context.Context, *Cmd) error {
+		pulled = true
+		return nil
+	})
+	runner.handle("{{.Id}}", func(_ context.Context, cmd *Cmd) error {
+		id := "sha256:old"
+		if pulled {
+			id = "sha256:new"
+		}
+		io.WriteString(cmd.Stdout, id+"\n")
+		return nil
+	})
+}
+
+func TestUpdateKeepsScale(t *testing.T) {
+	runner := &fakeRunner{}
+	fakePull(runner, "worker")
+	runner.stdout("ps -a --format json", `{"Name":"test-worker-1","Service":"worker","State":"running"}
+{"Name":"test-worker-2","Service":"worker","State":"running"}
+{"Name":"test-worker-3","Service":"worker","State":"running"}
+`)
+	dcm := newTestManager(t, "scale:\n  worker: 3\n", "", runner)
+
+	if err := dcm.Update("worker", UpdateOptions{HealthTimeout: time.Minute}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	ups := runner.ran(" up ")
+	if len(ups) != 1 || !strings.HasSuffix(ups[0], " up -d --no-deps --scale worker=3 worker") {
+		t.Errorf("ran %q, want worker recreated with its 3 replicas", ups)
+	}
+}
+
+func TestUpdateRollbackKeepsScale(t *testing.T) {
+	runner := &fakeRunner{}
+	fakePull(runner, "worker")
+	runner.stdout("ps -a --format json", `{"Name":"test-worker-1","Service":"worker","State":"exited","ExitCode":1}`+"\n")
+	dcm := newTestManager(t, "scale:\n  worker: 3\n", "", runner)
+
+	var err error
+	captureStderr(t, func() { err = dcm.Update("worker", UpdateOptions{HealthTimeout: time.Minute}) })
+	if err == nil {
+		t.Fatal("Update succeeded with worker failing")
+	}
+	ups := runner.ran(" up ")
+	if len(ups) != 2 {
+		t.Fatalf("ran %q, want the update and the rollback", ups)
+	}
+	for _, up := range ups {
+		if !strings.HasSuffix(up, " up -d --no-deps --scale worker=3 worker") {
+			t.Errorf("ran %q, want worker recreated with its 3 replicas", up)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
+)
+
+// maxUptimeSamples bounds the memory of a sampler whatever its window
+const maxUptimeSamples = 10000
+
+// statusSample is the state of every container at one point in time, keyed
+// by container name, or service name for a service without containers
+type statusSample struct {
+	At     time.Time
+	States map[string]string
+	// Services maps each key to its service
+	Services map[string]string
+}
+
+// sampleRing keeps the latest samples, dropping the oldest when full
+type sampleRing struct {
+	samples []statusSample
+	next    int
+	full    bool
+}
+
+func newSampleRing(size int) *sampleRing {
+	if size < 2 {
+		size = 2
+	}
+	return &sampleRing{samples: make([]statusSample, size)}
+}
+
+func (r *sampleRing) add(s statusSample) {
+	r.samples[r.next] = s
+	r.next = (r.next + 1) % len(r.samples)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// all returns the samples from oldest to newest
+func (r *sampleRing) all() []statusSample {
+	if !r.full {
+		return append([]statusSample(nil), r.samples[:r.next]...)
+	}
+	return append(append([]statusSample(nil), r.samples[r.next:]...), r.samples[:r.next]...)
+}
+
+// ServiceUptime is how long a container has been in its current state and
+// how often its state changed within the sampling window
+type ServiceUptime struct {
+	Name    string    `json:"name"`
+	Service string    `json:"service"`
+	State   string    `json:"state"`
+	Since   time.Time `json:"since"`
+	// Duration is the time spent in the current state, in seconds
+	Duration float64 `json:"duration_seconds"`
+	// AtLeast is set when the state has not changed since the oldest sample,
+	// so it started some time before Since
+	AtLeast bool `json:"at_least"`
+	// Flaps counts the state changes within the window
+	Flaps int `json:"flaps"`
+}
+
+// uptimeSampler records the state of the services at regular intervals in a
+// ring buffer covering a window of time
+type uptimeSampler struct {
+	dcm      *Manager
+	services []string
+	window   time.Duration
+	ring     *sampleRing
+}
+
+func (dcm *Manager) newUptimeSampler(services []string, window, interval time.Duration) *uptimeSampler {
+	size := maxUptimeSamples
+	if interval > 0 && int(window/interval)+1 < size {
+		size = int(window/interval) + 1
+	}
+	return &uptimeSampler{dcm: dcm, services: services, window: window, ring: newSampleRing(size)}
+}
+
+// sampleState labels a container state, with its health when it has a
+// healthcheck so that a container turning unhealthy counts as a change
+func sampleState(s ServiceStatus) string {
+	if s.Health != "" {
+		return s.State + " (" + s.Health + ")"
+	}
+	return s.State
+}
+
+// sample records the current state of the services
+func (u *uptimeSampler) sample() error {
+	statuses, err := u.dcm.Status(context.Background())
+	if err != nil {
+		return err
+	}
+	wanted := make(map[string]bool)
+	for _, name := range u.services {
+		wanted[name] = true
+	}
+	s := statusSample{At: time.Now(), States: make(map[string]string), Services: make(map[string]string)}
+	for _, status := range statuses {
+		if len(wanted) > 0 && !wanted[status.Service] {
+			continue
+		}
+		key := status.Name
+		if key == "" {
+			key = status.Service
+		}
+		s.States[key] = sampleState(status)
+		s.Services[key] = status.Service
+	}
+	u.ring.add(s)
+	return nil
+}
+
+// run samples every interval until ctx is done. Failed samples are skipped
+// with a warning, a compose hiccup should not end a long sampling run.
+func (u *uptimeSampler) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := u.sample(); err != nil {
+			u.dcm.Warnf("could not sample service states: %v\n", err)
+		}
+	}
+}
+
+// report returns the uptime of every container of the latest sample
+func (u *uptimeSampler) report() []ServiceUptime {
+	samples := u.ring.all()
+	if len(samples) == 0 {
+		return nil
+	}
+	latest := samples[len(samples)-1]
+	windowStart := latest.At.Add(-u.window)
+
+	var uptimes []ServiceUptime
+	for key, state := range latest.States {
+		up := ServiceUptime{Name: key, Service: latest.Services[key], State: state, Since: samples[0].At, AtLeast: true}
+		previous, seen := "", false
+		for _, s := range samples {
+			current, ok := s.States[key]
+			if seen && ok && current != previous {
+				if !s.At.Before(windowStart) {
+					up.Flaps++
+				}
+				up.Since, up.AtLeast = s.At, false
+			}
+			if ok {
+				previous, seen = current, true
+			}
+		}
+		up.Duration = latest.At.Sub(up.Since).Seconds()
+		uptimes = append(uptimes, up)
+	}
+	sort.Slice(uptimes, func(i, j int) bool {
+		if uptimes[i].Service != uptimes[j].Service {
+			return uptimes[i].Service < uptimes[j].Service
+		}
+		return uptimes[i].Name < uptimes[j].Name
+	})
+	return uptimes
+}
+
+// UptimeOptions controls Uptime
+type UptimeOptions struct {
+	// Window is how far back state changes are counted as flaps
+	Window time.Duration
+	// Interval is the time between samples
+	Interval time.Duration
+	// For stops sampling after that long, zero samples until interrupted
+	For time.Duration
+	// Watch calls the report function after every sample instead of once
+	// at the end
+	Watch bool
+}
+
+// Uptime samples the state of services every interval, keeping the samples
+// of the last window in memory, and passes the uptime of each container to
+// show when sampling stops, or after every sample with opts.Watch. Sampling
+// stops on Ctrl-C or after opts.For.
+func (dcm *Manager) Uptime(services []string, opts UptimeOptions, show func([]ServiceUptime) error) error {
+	if len(services) == 0 {
+		services = dcm.scopedServices()
+	}
+	for _, service := range services {
+		if err := dcm.checkService(service); err != nil {
+			return err
+		}
+	}
+	if opts.Interval <= 0 {
+		return NewError(ErrUsage, "--interval must be positive")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if opts.For > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.For)
+		defer cancel()
+	}
+
+	sampler := dcm.newUptimeSampler(services, opts.Window, opts.Interval)
+	if err := sampler.sample(); err != nil {
+		return err
+	}
+	if opts.For > 0 {
+		dcm.Infof("Sampling service states every %s for %s...\n", opts.Interval, opts.For)
+	} else {
+		dcm.Infof("Sampling service states every %s, Ctrl-C to stop...\n", opts.Interval)
+	}
+	if !opts.Watch {
+		sampler.run(ctx, opts.Interval)
+		return show(sampler.report())
+	}
+
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+	for {
+		if err := show(sampler.report()); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		if err := sampler.sample(); err != nil {
+			dcm.Warnf("could not sample service states: %v\n", err)
+		}
+	}
+}
+
+// PrintUptimes prints the uptime of each container as a table
+func PrintUptimes(uptimes []ServiceUptime) {
+	fmt.Printf("%-30s %-20s %-12s %s\n", "CONTAINER", "STATE", "FOR", "FLAPS")
+	for _, u := range uptimes {
+		duration := time.Duration(u.Duration * float64(time.Second)).Round(time.Second).String()
+		if u.AtLeast {
+			duration = ">" + duration
+		}
+		fmt.Printf("%-30s %-20s %-12s %d\n", u.Name, u.State, duration, u.Flaps)
+	}
+}
@@ -0,0 +1,203 @@
+package manager
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// editDistance returns the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// suggestName returns the candidate closest to name when it is close enough
+// to be a likely typo, or an empty string
+func suggestName(name string, candidates []string) string {
+	best, bestDistance := "", len(name)/3+1
+	for _, c := range candidates {
+		if d := editDistance(name, c); d <= bestDistance && (best == "" || d < editDistance(name, best)) {
+			best, bestDistance = c, d
+		}
+	}
+	return best
+}
+
+// DidYouMean formats a suggestion for an unknown name, if there is one
+func DidYouMean(name string, candidates []string) string {
+	if s := suggestName(name, candidates); s != "" {
+		return fmt.Sprintf(", did you mean %q?", s)
+	}
+	return ""
+}
+
+// unknownConfiguredServices checks the services listed in the config exist
+// in the compose files, with a suggestion for each one that does not
+func (dcm *Manager) unknownConfiguredServices() ([]string, error) {
+	if len(dcm.config.Services) == 0 {
+		return nil, nil
+	}
+	defined, err := dcm.composeServices()
+	if err != nil || len(defined) == 0 {
+		return nil, err
+	}
+	isDefined := make(map[string]bool)
+	for _, name := range defined {
+		isDefined[name] = true
+	}
+	var problems []string
+	for _, name := range dcm.config.Services {
+		if !isDefined[name] {
+			problems = append(problems, fmt.Sprintf("service %q is not defined in the compose files%s", name, DidYouMean(name, defined)))
+		}
+	}
+	return problems, nil
+}
+
+// CheckConfiguredServices is the light check run before commands, so a typo
+// in the services list fails early with a clear message
+func (dcm *Manager) CheckConfiguredServices() error {
+	problems, err := dcm.unknownConfiguredServices()
+	if err != nil {
+		// Left for the command or dcm validate to report
+		return nil
+	}
+	if len(problems) > 0 {
+		return NewError(ErrConfig, "%s: %s", dcm.configPath, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// CheckConfigFiles checks that the compose files and the env file passed to
+// compose exist, so a wrong path fails before any command runs rather than
+// deep inside compose, naming where the path came from
+func (dcm *Manager) CheckConfigFiles() error {
+	source := dcm.configPath
+	if len(dcm.fileOverride) > 0 {
+		source = "--file"
+	}
+	for _, f := range dcm.config.composeFiles() {
+		if _, err := os.Stat(f); err != nil {
+			return NewError(ErrConfig, "compose file %s (from %s) not found", f, source)
+		}
+	}
+	if f := dcm.config.EnvFile; f != "" {
+		source = dcm.configPath
+		if dcm.envFileOverride != "" {
+			source = "--env-file"
+		}
+		if _, err := os.Stat(f); err != nil {
+			return NewError(ErrConfig, "env file %s (from %s) not found", f, source)
+		}
+	}
+	return nil
+}
+
+// Validate checks the config against the compose files: the compose files
+// exist and parse, the configured services and build_order entries are
+// defined in them, for the selected project and every other project in the
+// config. It returns every problem found.
+func (dcm *Manager) Validate() []string {
+	var problems []string
+	if _, err := os.Stat(dcm.configPath); err != nil {
+		problems = append(problems, fmt.Sprintf("config file %s not found, the defaults apply", dcm.configPath))
+	}
+
+	managers := []*Manager{dcm}
+	for _, name := range dcm.fileConfig.projectNames() {
+		if name == dcm.project {
+			continue
+		}
+		project, err := dcm.forProject(name)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		managers = append(managers, project)
+	}
+
+	for _, m := range managers {
+		prefix := ""
+		if m.project != "" {
+			prefix = "project " + m.project + ": "
+		}
+		parsed := true
+		for _, f := range m.config.composeFiles() {
+			if _, err := os.Stat(f); err != nil {
+				problems = append(problems, prefix+fmt.Sprintf("compose file %s not found", f))
+				parsed = false
+			} else if _, err := parseComposeFile(f); err != nil {
+				problems = append(problems, prefix+err.Error())
+				parsed = false
+			}
+		}
+		if !parsed {
+			continue
+		}
+
+		unknown, err := m.unknownConfiguredServices()
+		if err != nil {
+			problems = append(problems, prefix+err.Error())
+			continue
+		}
+		for _, p := range unknown {
+			problems = append(problems, prefix+p)
+		}
+		if _, _, err := m.buildOrder(); err != nil {
+			problems = append(problems, prefix+err.Error())
+		}
+	}
+	return problems
+}
+
+// PrintValidate prints the problems Validate finds and fails if there are any.
+// With strict the warnings and errors of Lint count as problems too.
+func (dcm *Manager) PrintValidate(strict bool) error {
+	problems := dcm.Validate()
+	if strict && len(problems) == 0 {
+		findings, err := dcm.Lint()
+		if err != nil {
+			return err
+		}
+		for _, f := range findings {
+			if f.Severity == severityInfo {
+				continue
+			}
+			problems = append(problems, fmt.Sprintf("%s: %s", f.subject(), f.Message))
+		}
+	}
+	if len(problems) == 0 {
+		fmt.Println("Configuration is valid")
+		return nil
+	}
+	for _, p := range problems {
+		fmt.Printf("  - %s\n", p)
+	}
+	return NewError(ErrConfig, "found %d problems in the configuration", len(problems))
+}
@@ -0,0 +1,263 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultWaitTimeout bounds start --wait when neither the flag nor
+	// wait_timeout in the config set it
+	defaultWaitTimeout = 2 * time.Minute
+	waitPollInterval   = 2 * time.Second
+)
+
+// WaitOptions controls how WaitReady waits for services
+type WaitOptions struct {
+	// Timeout bounds the wait, zero means wait_timeout from the config
+	Timeout time.Duration
+	// LogLines is the number of log lines printed for each service that is
+	// not ready when the wait fails, zero prints none
+	LogLines int
+	// MaxRetries fails the wait once a service is seen unhealthy this many
+	// polls in a row, instead of waiting out the timeout; zero disables it
+	MaxRetries int
+	// FailFast ends the wait at the first service that fails, instead of
+	// waiting for the others and reporting every failure at the end
+	FailFast bool
+}
+
+// healthLog returns the output of the last healthcheck run in a container
+func (dcm *Manager) healthLog(container string) (string, error) {
+	output, err := dcm.dockerOutput("inspect", "--format", "{{json .State.Health}}", container)
+	if err != nil {
+		return "", err
+	}
+	var health struct {
+		Log []struct {
+			ExitCode int    `json:"ExitCode"`
+			Output   string `json:"Output"`
+		} `json:"Log"`
+	}
+	if err := json.Unmarshal([]byte(output), &health); err != nil {
+		return "", fmt.Errorf("parsing health of %s: %v", container, err)
+	}
+	if len(health.Log) == 0 {
+		return "", fmt.Errorf("%s has no healthcheck log", container)
+	}
+	last := health.Log[len(health.Log)-1]
+	return fmt.Sprintf("exit code %d: %s", last.ExitCode, strings.TrimSpace(last.Output)), nil
+}
+
+// unhealthyContainer returns the first unhealthy container of a service
+func unhealthyContainer(containers []ServiceStatus) string {
+	for _, c := range containers {
+		if c.Health == "unhealthy" {
+			return c.Name
+		}
+	}
+	return ""
+}
+
+// waitTimeout returns the timeout of a wait: the given one, wait_timeout
+// from the config, or the default
+func (dcm *Manager) waitTimeout(timeout time.Duration) (time.Duration, error) {
+	if timeout > 0 {
+		return timeout, nil
+	}
+	if dcm.config.WaitTimeout == "" {
+		return defaultWaitTimeout, nil
+	}
+	configured, err := time.ParseDuration(dcm.config.WaitTimeout)
+	if err != nil || configured <= 0 {
+		return 0, NewError(ErrConfig, "%s: wait_timeout: expected a duration such as 90s, got %q",
+			dcm.configPath, dcm.config.WaitTimeout)
+	}
+	return configured, nil
+}
+
+// serviceReadiness tells whether a service is ready from the states of its
+// containers. A service is ready when all its containers are running and
+// healthy, or have no healthcheck; a one-shot service is also ready once
+// they exited with code 0. failed is set when a container exited otherwise,
+// as waiting longer will not help.
+func serviceReadiness(containers []ServiceStatus, oneShot bool) (ready, failed bool, reason string) {
+	if len(containers) == 0 {
+		return false, false, "no container"
+	}
+	for _, c := range containers {
+		switch {
+		case c.State == "exited" && c.ExitCode != 0:
+			return false, true, fmt.Sprintf("%s exited with code %d", c.Name, c.ExitCode)
+		case c.State == "dead":
+			return false, true, fmt.Sprintf("%s is dead", c.Name)
+		case c.State == "exited" && oneShot:
+			// The job completed
+		case c.State == "exited":
+			return false, true, fmt.Sprintf("%s exited with code 0, list it under one_shot if it is meant to", c.Name)
+		case c.State != "running":
+			return false, false, fmt.Sprintf("%s is %s", c.Name, c.State)
+		case c.Health != "" && c.Health != "healthy":
+			return false, false, fmt.Sprintf("%s is %s", c.Name, c.Health)
+		}
+	}
+	return true, false, ""
+}
+
+// WaitReady polls the given services, or the configured ones when none are
+// given, until each is running, healthy and passes its readiness probe from
+// the config. A service whose container exits
+// with an error, or stays unhealthy for opts.MaxRetries polls, has failed:
+// with opts.FailFast the wait ends there, otherwise the other services are
+// still waited for and the error lists every service that is not ready.
+func (dcm *Manager) WaitReady(services []string, opts WaitOptions) error {
+	timeout, err := dcm.waitTimeout(opts.Timeout)
+	if err != nil {
+		return err
+	}
+	if len(services) == 0 {
+		services = dcm.scopedServices()
+	}
+	if len(services) == 0 {
+		if services, err = dcm.composeServices(); err != nil {
+			return err
+		}
+	}
+
+	if dcm.dryRun {
+		fmt.Printf("Would wait up to %s for %s to be ready\n", timeout, strings.Join(services, ", "))
+		return nil
+	}
+	dcm.Infof("Waiting up to %s for %s to be ready...\n", timeout, strings.Join(services, ", "))
+	deadline := time.Now().Add(timeout)
+	oneShot := make(map[string]bool)
+	for _, name := range dcm.config.OneShot {
+		oneShot[name] = true
+	}
+	// unhealthy counts the consecutive polls each service was seen unhealthy
+	unhealthy := make(map[string]int)
+	// failures holds why each failed service failed, the wait goes on
+	// without them
+	failures := make(map[string]string)
+	fail := func(name, reason string) error {
+		dcm.printWaitLogs([]string{name}, opts.LogLines)
+		if opts.FailFast {
+			return NewError(ErrServicesNotReady, "%s", reason)
+		}
+		failures[name] = reason
+		return nil
+	}
+	for {
+		statuses, err := dcm.StatusDetailed(context.Background())
+		if err != nil {
+			return err
+		}
+		byService := make(map[string][]ServiceStatus)
+		for _, s := range statuses {
+			byService[s.Service] = append(byService[s.Service], s)
+		}
+
+		pending := make(map[string]string)
+		for _, name := range services {
+			if _, ok := failures[name]; ok {
+				continue
+			}
+			ready, failed, reason := serviceReadiness(byService[name], oneShot[name])
+			if failed {
+				if err := fail(name, fmt.Sprintf("%s failed to start: %s", name, reason)); err != nil {
+					return err
+				}
+				continue
+			}
+			if !ready {
+				pending[name] = reason
+			} else if reason := dcm.probeReadiness(name); reason != "" {
+				pending[name] = reason
+			}
+
+			container := unhealthyContainer(byService[name])
+			if container == "" {
+				unhealthy[name] = 0
+				continue
+			}
+			unhealthy[name]++
+			if opts.MaxRetries > 0 && unhealthy[name] >= opts.MaxRetries {
+				check, err := dcm.healthLog(container)
+				if err != nil {
+					check = err.Error()
+				}
+				reason := fmt.Sprintf("%s is unhealthy after %d checks in a row, last healthcheck: %s", name, unhealthy[name], check)
+				if err := fail(name, reason); err != nil {
+					return err
+				}
+				delete(pending, name)
+			}
+		}
+		if len(pending) == 0 {
+			if len(failures) > 0 {
+				return waitFailures(failures, nil, timeout)
+			}
+			dcm.Infof("All services are ready\n")
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			names := make([]string, 0, len(pending))
+			for name := range pending {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			dcm.printWaitLogs(names, opts.LogLines)
+			return waitFailures(failures, pending, timeout)
+		}
+		time.Sleep(waitPollInterval)
+	}
+}
+
+// waitFailures reports every service a wait ended without: those that
+// failed and those still pending at the timeout
+func waitFailures(failures, pending map[string]string, timeout time.Duration) error {
+	var reasons []string
+	for _, reason := range failures {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	if len(pending) > 0 {
+		names := make([]string, 0, len(pending))
+		for name := range pending {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		var waiting []string
+		for _, name := range names {
+			waiting = append(waiting, fmt.Sprintf("%s (%s)", name, pending[name]))
+		}
+		reasons = append(reasons, fmt.Sprintf("services not ready after %s: %s", timeout, strings.Join(waiting, ", ")))
+	}
+	if len(reasons) == 1 {
+		return NewError(ErrServicesNotReady, "%s", reasons[0])
+	}
+	return NewError(ErrServicesNotReady, "%d services did not become ready: %s", len(failures)+len(pending), strings.Join(reasons, "; "))
+}
+
+// printWaitLogs prints the last log lines of services that failed to become
+// ready, to help debug the failure
+func (dcm *Manager) printWaitLogs(services []string, lines int) {
+	if lines <= 0 {
+		return
+	}
+	for _, name := range services {
+		output, err := dcm.composeOutput("logs", "--no-color", "--tail", strconv.Itoa(lines), name)
+		if err != nil {
+			dcm.Warnf("could not read logs of %s: %v\n", name, err)
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "--- last %d log lines of %s ---\n%s", lines, name, output)
+	}
+}
@@ -0,0 +1,130 @@
+package manager
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServiceReadiness(t *testing.T) {
+	tests := []struct {
+		name       string
+		containers []ServiceStatus
+		oneShot    bool
+		ready      bool
+		failed     bool
+	}{
+		{"no container", nil, false, false, false},
+		{"running", []ServiceStatus{{Name: "web-1", State: "running"}}, false, true, false},
+		{"healthy", []ServiceStatus{{Name: "web-1", State: "running", Health: "healthy"}}, false, true, false},
+		{"starting", []ServiceStatus{{Name: "web-1", State: "running", Health: "starting"}}, false, false, false},
+		{"unhealthy", []ServiceStatus{{Name: "web-1", State: "running", Health: "unhealthy"}}, false, false, false},
+		{"one replica created", []ServiceStatus{{Name: "web-1", State: "running"}, {Name: "web-2", State: "created"}}, false, false, false},
+		{"exited with an error", []ServiceStatus{{Name: "web-1", State: "exited", ExitCode: 1}}, false, false, true},
+		{"one-shot exited with an error", []ServiceStatus{{Name: "job-1", State: "exited", ExitCode: 1}}, true, false, true},
+		{"dead", []ServiceStatus{{Name: "web-1", State: "dead"}}, false, false, true},
+		{"one-shot completed", []ServiceStatus{{Name: "job-1", State: "exited"}}, true, true, false},
+		{"exited 0 without one_shot", []ServiceStatus{{Name: "web-1", State: "exited"}}, false, false, true},
+	}
+	for _, tt := range tests {
+		ready, failed, reason := serviceReadiness(tt.containers, tt.oneShot)
+		if ready != tt.ready || failed != tt.failed {
+			t.Errorf("%s: ready %v, failed %v (%s), want ready %v, failed %v", tt.name, ready, failed, reason, tt.ready, tt.failed)
+		}
+		if !ready && reason == "" {
+			t.Errorf("%s: no reason given", tt.name)
+		}
+	}
+}
+
+const unhealthyPs = `{"Name":"test-web-1","Service":"web","State":"running","Health":"unhealthy"}
+{"Name":"test-job-1","Service":"job","State":"exited","ExitCode":0}
+`
+
+func TestWaitReadyTimesOutOnUnhealthyService(t *testing.T) {
+	runner := &fakeRunner{}
+	runner.stdout("ps -a --format json", unhealthyPs)
+	dcm := newTestManager(t, "", "", runner)
+
+	err := dcm.WaitReady([]string{"web"}, WaitOptions{Timeout: time.Nanosecond})
+	if TypeOf(err) != ErrServicesNotReady {
+		t.Fatalf("WaitReady = %v, want a %s error", err, ErrServicesNotReady)
+	}
+	if ExitCode(err) != 6 {
+		t.Errorf("exit code = %d, want 6", ExitCode(err))
+	}
+	if msg := err.Error(); !strings.Contains(msg, "not ready after 1ns") || !strings.Contains(msg, "web (test-web-1 is unhealthy)") {
+		t.Errorf("error = %q, want the timeout and why web is not ready", msg)
+	}
+}
+
+func TestWaitReadyFailsAfterMaxRetries(t *testing.T) {
+	runner := &fakeRunner{}
+	runner.stdout("ps -a --format json", unhealthyPs)
+	runner.stdout("{{json .State.Health}} test-web-1", `{"Status":"unhealthy","Log":[{"ExitCode":1,"Output":"connection refused\n"}]}`)
+	dcm := newTestManager(t, "", "", runner)
+
+	err := dcm.WaitReady([]string{"web"}, WaitOptions{Timeout: time.Minute, MaxRetries: 1})
+	if TypeOf(err) != ErrServicesNotReady {
+		t.Fatalf("WaitReady = %v, want a %s error", err, ErrServicesNotReady)
+	}
+	if !strings.Contains(err.Error(), "exit code 1: connection refused") {
+		t.Errorf("error = %q, want the last healthcheck", err)
+	}
+}
+
+func TestWaitReadyOneShot(t *testing.T) {
+	compose := testCompose + "  job:\n    image: busybox\n"
+	runner := &fakeRunner{}
+	runner.stdout("ps -a --format json", unhealthyPs)
+
+	dcm := newTestManager(t, "one_shot: [job]\n", compose, runner)
+	if err := dcm.WaitReady([]string{"job"}, WaitOptions{Timeout: time.Nanosecond}); err != nil {
+		t.Errorf("a completed one_shot service is not ready: %v", err)
+	}
+
+	dcm = newTestManager(t, "", compose, runner)
+	err := dcm.WaitReady([]string{"job"}, WaitOptions{Timeout: time.Minute})
+	if TypeOf(err) != ErrServicesNotReady || !strings.Contains(err.Error(), "one_shot") {
+		t.Errorf("WaitReady = %v, want a failure pointing at one_shot", err)
+	}
+}
+
+const twoFailedPs = `{"Name":"test-web-1","Service":"web","State":"exited","ExitCode":1}
+{"Name":"test-worker-1","Service":"worker","State":"exited","ExitCode":2}
+{"Name":"test-db-1","Service":"db","State":"running"}
+`
+
+func TestWaitReadyReportsEveryFailedService(t *testing.T) {
+	runner := &fakeRunner{}
+	runner.stdout("ps -a --format json", twoFailedPs)
+	dcm := newTestManager(t, "", "", runner)
+
+	err := dcm.WaitReady([]string{"web", "worker", "db"}, WaitOptions{Timeout: time.Minute})
+	if TypeOf(err) != ErrServicesNotReady {
+		t.Fatalf("WaitReady = %v, want a %s error", err, ErrServicesNotReady)
+	}
+	msg := err.Error()
+	for _, want := range []string{"web failed to start", "worker failed to start"} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("error = %q, want it to contain %q", msg, want)
+		}
+	}
+	if strings.Contains(msg, "db") {
+		t.Errorf("error = %q, want the ready db left out", msg)
+	}
+}
+
+func TestWaitReadyFailFast(t *testing.T) {
+	runner := &fakeRunner{}
+	runner.stdout("ps -a --format json", twoFailedPs)
+	dcm := newTestManager(t, "", "", runner)
+
+	err := dcm.WaitReady([]string{"web", "worker", "db"}, WaitOptions{Timeout: time.Minute, FailFast: true})
+	if TypeOf(err) != ErrServicesNotReady {
+		t.Fatalf("WaitReady = %v, want a %s error", err, ErrServicesNotReady)
+	}
+	if msg := err.Error(); !strings.Contains(msg, "web failed to start") || strings.Contains(msg, "worker") {
+		t.Errorf("error = %q, want only the first failure", msg)
+	}
+}
@@ -0,0 +1,383 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// defaultWatchDebounce is how long watch waits for changes to settle
+	// when neither --debounce nor watch.debounce in the config set it
+	defaultWatchDebounce = 500 * time.Millisecond
+	// watchPollInterval is how often watch scans the watched files. Every
+	// scan stats all the files of the build contexts that are not excluded,
+	// so a large context costs CPU and disk reads for as long as watch runs;
+	// exclude what does not go into the image, as .dockerignore does.
+	watchPollInterval = 300 * time.Millisecond
+)
+
+// defaultWatchExclude are skipped unless the config lists its own excludes
+var defaultWatchExclude = []string{".git/**", "node_modules/**", "*.swp", "*~"}
+
+// WatchConfig is the watch section of the config
+type WatchConfig struct {
+	// Include restricts the build context files that trigger a rebuild,
+	// all files when empty
+	Include []string `yaml:"include"`
+	// Exclude lists build context files that never trigger a rebuild
+	Exclude []string `yaml:"exclude"`
+	// Debounce is how long changes must settle before acting, e.g. "1s"
+	Debounce string `yaml:"debounce"`
+}
+
+// checkWatch validates the watch section of the config
+func (dcm *Manager) checkWatch() error {
+	if _, err := dcm.watchDebounce(); err != nil {
+		return err
+	}
+	for _, pattern := range append(dcm.config.Watch.Include, dcm.config.Watch.Exclude...) {
+		if _, err := filepath.Match(strings.TrimSuffix(pattern, "/**"), ""); err != nil {
+			return NewError(ErrConfig, "%s: watch: bad pattern %q: %v", dcm.configPath, pattern, err)
+		}
+	}
+	return nil
+}
+
+// watchDebounce returns the debounce interval from the config
+func (dcm *Manager) watchDebounce() (time.Duration, error) {
+	if dcm.config.Watch.Debounce == "" {
+		return defaultWatchDebounce, nil
+	}
+	d, err := time.ParseDuration(dcm.config.Watch.Debounce)
+	if err != nil || d < 0 {
+		return 0, NewError(ErrConfig, "%s: watch.debounce: expected a duration such as 1s, got %q",
+			dcm.configPath, dcm.config.Watch.Debounce)
+	}
+	return d, nil
+}
+
+// watchMatch tells whether a slash separated path relative to a build
+// context matches a pattern. "dir/**" matches everything below dir, a
+// pattern without a slash matches the name of the file or of any directory
+// it is in, and other patterns match the whole path.
+func watchMatch(pattern, rel string) bool {
+	if prefix := strings.TrimSuffix(pattern, "/**"); prefix != pattern {
+		for dir := filepath.ToSlash(filepath.Dir(rel)); dir != "."; dir = filepath.ToSlash(filepath.Dir(dir)) {
+			if ok, _ := filepath.Match(prefix, dir); ok {
+				return true
+			}
+			if !strings.Contains(prefix, "/") {
+				if ok, _ := filepath.Match(prefix, filepath.Base(dir)); ok {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	if !strings.Contains(pattern, "/") {
+		for part := rel; part != "."; part = filepath.ToSlash(filepath.Dir(part)) {
+			if ok, _ := filepath.Match(pattern, filepath.Base(part)); ok {
+				return true
+			}
+		}
+		return false
+	}
+	ok, _ := filepath.Match(pattern, rel)
+	return ok
+}
+
+// watchExcluded reports whether a path relative to a build context matches
+// an exclude pattern of the config
+func (dcm *Manager) watchExcluded(rel string) bool {
+	exclude := dcm.config.Watch.Exclude
+	if len(exclude) == 0 {
+		exclude = defaultWatchExclude
+	}
+	for _, pattern := range exclude {
+		if watchMatch(pattern, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// watchedFile reports whether a build context file, given relative to the
+// context, is watched under the include and exclude patterns of the config
+func (dcm *Manager) watchedFile(rel string) bool {
+	if dcm.watchExcluded(rel) {
+		return false
+	}
+	if len(dcm.config.Watch.Include) == 0 {
+		return true
+	}
+	for _, pattern := range dcm.config.Watch.Include {
+		if watchMatch(pattern, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// fileStamp is what watch compares to notice a changed file
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// watchKey identifies a watched file: a path relative to the build context
+// of a target, or the path of a compose file when target is -1. A file in
+// two contexts counts for both.
+type watchKey struct {
+	target int
+	path   string
+}
+
+// watchTarget is a build context and the services built from it
+type watchTarget struct {
+	dir      string
+	services []string
+}
+
+// watchScan records the stamps of the compose files and of the watched files
+// of the targets
+func (dcm *Manager) watchScan(targets []watchTarget) map[watchKey]fileStamp {
+	stamps := make(map[watchKey]fileStamp)
+	for _, f := range dcm.config.composeFiles() {
+		if info, err := os.Stat(f); err == nil {
+			stamps[watchKey{-1, f}] = fileStamp{info.ModTime(), info.Size()}
+		}
+	}
+	for i, target := range targets {
+		filepath.Walk(target.dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				// A file removed while walking is picked up by the next scan
+				return nil
+			}
+			rel, err := filepath.Rel(target.dir, path)
+			if err != nil || rel == "." {
+				return nil
+			}
+			rel = filepath.ToSlash(rel)
+			if info.IsDir() {
+				// Excluded directories are not walked at all
+				if dcm.watchExcluded(rel + "/.") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if dcm.watchedFile(rel) {
+				stamps[watchKey{i, rel}] = fileStamp{info.ModTime(), info.Size()}
+			}
+			return nil
+		})
+	}
+	return stamps
+}
+
+// changedKeys returns the keys whose stamps differ between two scans,
+// including added and removed files
+func changedKeys(before, after map[watchKey]fileStamp) []watchKey {
+	var changed []watchKey
+	for key, stamp := range after {
+		if old, ok := before[key]; !ok || old != stamp {
+			changed = append(changed, key)
+		}
+	}
+	for key := range before {
+		if _, ok := after[key]; !ok {
+			changed = append(changed, key)
+		}
+	}
+	sort.Slice(changed, func(i, j int) bool {
+		if changed[i].target != changed[j].target {
+			return changed[i].target < changed[j].target
+		}
+		return changed[i].path < changed[j].path
+	})
+	return changed
+}
+
+// Watch rebuilds and restarts services, those in scope when none are given,
+// whenever files of their build context change, and recreates them when a
+// compose file changes. Changes are acted on once no more came for the
+// debounce interval, zero meaning watch.debounce from the config. It runs
+// until interrupted.
+//
+// The files are polled every watchPollInterval rather than followed with
+// filesystem notifications, which need a watch per directory and miss
+// changes made on the other side of bind mounts and network filesystems.
+func (dcm *Manager) Watch(services []string, debounce time.Duration) error {
+	if debounce == 0 {
+		var err error
+		if debounce, err = dcm.watchDebounce(); err != nil {
+			return err
+		}
+	}
+	project, err := dcm.composeProject()
+	if err != nil {
+		return err
+	}
+	for _, name := range services {
+		if err := dcm.checkService(name); err != nil {
+			return err
+		}
+	}
+	if len(services) == 0 {
+		services = dcm.scopedServices()
+	}
+	if len(services) == 0 {
+		services = project.Names
+	}
+	targets := watchTargets(project, services)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	for _, target := range targets {
+		dcm.Infof("Watching %s for %s\n", target.dir, strings.Join(target.services, ", "))
+	}
+	dcm.Infof("Watching %s, press Ctrl-C to stop\n", strings.Join(dcm.config.composeFiles(), ", "))
+
+	dcm.watchLoop(ctx, watcher{
+		names:    project.Names,
+		services: services,
+		targets:  targets,
+		poll:     watchPollInterval,
+		debounce: debounce,
+	}, dcm.watchScan(targets))
+	return nil
+}
+
+// watchTargets groups the services built from the same context, so a
+// change in it is scanned once
+func watchTargets(project *composeProject, services []string) []watchTarget {
+	var targets []watchTarget
+	byDir := make(map[string]int)
+	for _, name := range filterServices(project.Names, services) {
+		service := project.Services[name]
+		if service.Build == nil {
+			continue
+		}
+		dir := filepath.Clean(filepath.Join(service.dir, service.Build.Context))
+		if i, ok := byDir[dir]; ok {
+			targets[i].services = append(targets[i].services, name)
+			continue
+		}
+		byDir[dir] = len(targets)
+		targets = append(targets, watchTarget{dir: dir, services: []string{name}})
+	}
+	return targets
+}
+
+// watcher is what a watch loop watches and how often
+type watcher struct {
+	// names are the services of the compose files in declaration order,
+	// services those watched
+	names    []string
+	services []string
+	targets  []watchTarget
+	poll     time.Duration
+	debounce time.Duration
+}
+
+// watchLoop scans the watched files every poll interval, starting from the
+// stamps of a first scan, and applies the changes once none came for the
+// debounce interval, until ctx is done
+func (dcm *Manager) watchLoop(ctx context.Context, w watcher, stamps map[watchKey]fileStamp) {
+	var pending []watchKey
+	var lastChange time.Time
+	ticker := time.NewTicker(w.poll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		next := dcm.watchScan(w.targets)
+		if changed := changedKeys(stamps, next); len(changed) > 0 {
+			pending = append(pending, changed...)
+			lastChange = time.Now()
+		}
+		stamps = next
+		if len(pending) == 0 || time.Since(lastChange) < w.debounce {
+			continue
+		}
+
+		affected, rebuild := dcm.watchBatch(w, pending)
+		pending = nil
+		err := dcm.Track("watch", affected, func() error {
+			return dcm.watchApply(affected, rebuild)
+		})
+		if err != nil {
+			dcm.Warnf("%v, watching for the next change\n", err)
+			continue
+		}
+		dcm.Infof("Updated %s, watching for changes\n", strings.Join(affected, ", "))
+	}
+}
+
+// watchBatch coalesces the changes seen since the last update: each service
+// built from a changed context is rebuilt once, however many of its files
+// changed, and a changed compose file recreates every watched service. It
+// returns the services to recreate, in declaration order, and those to
+// rebuild.
+func (dcm *Manager) watchBatch(w watcher, pending []watchKey) ([]string, map[string]bool) {
+	rebuild := make(map[string]bool)
+	recreate := false
+	for _, key := range pending {
+		if key.target < 0 {
+			dcm.verbosef("Changed: %s\n", key.path)
+			recreate = true
+			continue
+		}
+		dcm.verbosef("Changed: %s\n", filepath.Join(w.targets[key.target].dir, filepath.FromSlash(key.path)))
+		for _, name := range w.targets[key.target].services {
+			rebuild[name] = true
+		}
+	}
+	if recreate {
+		return w.services, rebuild
+	}
+	var affected []string
+	for _, name := range w.names {
+		if rebuild[name] {
+			affected = append(affected, name)
+		}
+	}
+	return affected, rebuild
+}
+
+// watchApply rebuilds the services marked in rebuild and then recreates the
+// affected services without touching their dependencies
+func (dcm *Manager) watchApply(affected []string, rebuild map[string]bool) error {
+	for _, name := range affected {
+		if !rebuild[name] {
+			continue
+		}
+		dcm.Infof("Rebuilding %s...\n", name)
+		if err := dcm.composeStreaming("build", name); err != nil {
+			return fmt.Errorf("building %s: %w", name, err)
+		}
+	}
+	dcm.Infof("Restarting %s...\n", strings.Join(affected, ", "))
+	args := append(dcm.upArgs(), "--no-deps")
+	scaled, err := dcm.configuredScale(affected)
+	if err != nil {
+		return err
+	}
+	for _, t := range scaled {
+		args = append(args, "--scale", fmt.Sprintf("%s=%d", t.Service, t.Replicas))
+	}
+	if err := dcm.composeStreaming(append(args, affected...)...); err != nil {
+		return fmt.Errorf("restarting %s: %w", strings.Join(affected, ", "), err)
+	}
+	return nil
+}
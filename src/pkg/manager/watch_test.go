@@ -0,0 +1,163 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+const watchCompose = `services:
+  web:
+    build: ./web
+  worker:
+    build: ./web
+  api:
+    build: ./api
+  db:
+    image: postgres
+`
+
+// newWatcher returns a manager for watchCompose with its build contexts
+// created, and what watching every service of it polls every millisecond
+func newWatcher(t *testing.T, runner *fakeRunner) (*Manager, watcher) {
+	t.Helper()
+	dcm := newTestManager(t, "", watchCompose, runner)
+	for _, dir := range []string{"web", "api"} {
+		dir = filepath.Join(filepath.Dir(dcm.configPath), dir)
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM scratch\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	project, err := dcm.composeProject()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dcm, watcher{
+		names:    project.Names,
+		services: project.Names,
+		targets:  watchTargets(project, project.Names),
+		poll:     time.Millisecond,
+		debounce: defaultWatchDebounce,
+	}
+}
+
+func TestWatchDebouncesBursts(t *testing.T) {
+	runner := &fakeRunner{}
+	recreated := make(chan struct{}, 1)
+	runner.handle(" up ", func(context.Context, *Cmd) error {
+		select {
+		case recreated <- struct{}{}:
+		default:
+		}
+		return nil
+	})
+	dcm, w := newWatcher(t, runner)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	stamps := dcm.watchScan(w.targets)
+	go func() {
+		dcm.watchLoop(ctx, w, stamps)
+		close(done)
+	}()
+
+	// A burst of changes to the context of api, much shorter than the
+	// debounce interval: a file rewritten several times and new files
+	dir := filepath.Join(filepath.Dir(dcm.configPath), "api")
+	for i := 1; i <= 5; i++ {
+		if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte(strings.Repeat("x", i)), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("file%d.go", i)), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case <-recreated:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("nothing was recreated, ran %q", runner.commands())
+	}
+	cancel()
+	<-done
+
+	if builds := runner.ran(" build "); len(builds) != 1 || !strings.HasSuffix(builds[0], " build api") {
+		t.Errorf("builds = %q, want api built once", builds)
+	}
+	if ups := runner.ran(" up "); len(ups) != 1 || !strings.HasSuffix(ups[0], " up -d --no-deps api") {
+		t.Errorf("ups = %q, want api recreated once", ups)
+	}
+}
+
+func TestWatchBatchCoalescesServices(t *testing.T) {
+	dcm, w := newWatcher(t, &fakeRunner{})
+	web, api := -1, -1
+	for i, target := range w.targets {
+		switch filepath.Base(target.dir) {
+		case "web":
+			web = i
+		case "api":
+			api = i
+		}
+	}
+	if web < 0 || api < 0 || !reflect.DeepEqual(w.targets[web].services, []string{"web", "worker"}) {
+		t.Fatalf("targets = %+v, want web and worker sharing a context", w.targets)
+	}
+
+	tests := []struct {
+		name     string
+		pending  []watchKey
+		affected []string
+		rebuild  []string
+	}{
+		{
+			"files of a shared context",
+			[]watchKey{{web, "main.go"}, {web, "go.mod"}, {web, "main.go"}},
+			[]string{"web", "worker"},
+			[]string{"web", "worker"},
+		},
+		{
+			"files of two contexts",
+			[]watchKey{{api, "main.go"}, {web, "main.go"}, {api, "main.go"}},
+			[]string{"web", "worker", "api"},
+			[]string{"api", "web", "worker"},
+		},
+		{
+			"a compose file",
+			[]watchKey{{-1, dcm.config.composeFiles()[0]}, {api, "main.go"}},
+			[]string{"web", "worker", "api", "db"},
+			[]string{"api"},
+		},
+	}
+	for _, tt := range tests {
+		affected, rebuild := dcm.watchBatch(w, tt.pending)
+		var rebuilt []string
+		for name := range rebuild {
+			rebuilt = append(rebuilt, name)
+		}
+		sort.Strings(rebuilt)
+		if !reflect.DeepEqual(affected, tt.affected) || !reflect.DeepEqual(rebuilt, tt.rebuild) {
+			t.Errorf("%s: recreate %q, rebuild %q, want %q and %q", tt.name, affected, rebuilt, tt.affected, tt.rebuild)
+		}
+	}
+}
+
+func TestWatchApplyKeepsScale(t *testing.T) {
+	runner := &fakeRunner{}
+	dcm := newTestManager(t, "scale:\n  worker: 2\n", watchCompose, runner)
+
+	if err := dcm.watchApply([]string{"web", "worker"}, map[string]bool{"web": true, "worker": true}); err != nil {
+		t.Fatalf("watchApply: %v", err)
+	}
+	if ups := runner.ran(" up "); len(ups) != 1 || !strings.HasSuffix(ups[0], " up -d --no-deps --scale worker=2 web worker") {
+		t.Errorf("ups = %q, want web and worker recreated with the scale of worker", ups)
+	}
+}